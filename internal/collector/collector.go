@@ -24,6 +24,16 @@ type LogEntry struct {
 	ClientIP   string
 	UserAgent  string
 	Backend    string // Backend server that handled the request
+
+	// Tracing fields for correlating log rows with exported spans
+	TraceID string
+	SpanID  string
+
+	// Authentication fields for attributing traffic
+	APIKeyOwner string
+
+	// Backend group (e.g. stable or canary) the route's traffic split chose
+	BackendGroup string
 }
 
 type Collector interface {
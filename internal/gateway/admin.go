@@ -0,0 +1,748 @@
+/*
+internal/gateway/admin.go
+Package gateway provides the admin API endpoints of the API Gateway.
+*/
+
+package gateway
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/middleware"
+	"github.com/AndreaBozzo/go-lab/internal/proxy"
+	"github.com/AndreaBozzo/go-lab/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// setupAdminRoutes registers all admin endpoints on the given group. Endpoints
+// that change the gateway are only registered when admin.token is set: open, they
+// would let anyone redirect traffic or mint API keys.
+func (s *Server) setupAdminRoutes(gen *generation, admin *gin.RouterGroup) {
+	// Status endpoints
+	admin.GET("/backends", s.handleListBackends)
+	admin.GET("/splits", s.handleListSplits)
+	admin.GET("/config", s.handleReloadStatus)
+	if s.quotaStore != nil {
+		admin.GET("/quotas", s.handleListQuotas)
+	}
+
+	if gen.config.Admin.Token == "" {
+		log.Printf("WARNING: admin.token is not set, only read-only /admin endpoints are available, to local clients only")
+		return
+	}
+
+	// Backend changes, saved according to admin.persist_backends
+	admin.PUT("/backends/drain", s.handleDrainBackend)
+	admin.POST("/backends", s.handleAddBackend)
+	admin.PATCH("/backends", s.handleUpdateBackend)
+	admin.DELETE("/backends", s.handleRemoveBackend)
+
+	// Traffic split between backend groups
+	admin.PUT("/splits", s.handleSetSplit)
+
+	// Configuration reload trigger
+	admin.POST("/config/reload", s.handleReload)
+
+	// API key management (only when the store supports it)
+	if s.apiKeys != nil {
+		admin.GET("/apikeys", s.handleListAPIKeys)
+		admin.POST("/apikeys", s.handleCreateAPIKey)
+		admin.POST("/apikeys/:id/rotate", s.handleRotateAPIKey)
+		admin.DELETE("/apikeys/:id", s.handleRevokeAPIKey)
+	}
+
+	// Quota resets for the current period
+	if s.quotaStore != nil {
+		admin.DELETE("/quotas/:policy", s.handleResetQuota)
+	}
+}
+
+// handleListBackends returns the status of every backend, grouped by route
+func (s *Server) handleListBackends(c *gin.Context) {
+	gen := s.gen()
+	backends := make(map[string]interface{})
+	for i, rp := range gen.routeProxies {
+		routeBackends := []map[string]interface{}{}
+		for _, group := range rp.Groups() {
+			for _, backend := range group.Pool().GetAllBackends() {
+				routeBackends = append(routeBackends, backendInfo(group, backend))
+			}
+		}
+		backends[gen.routeLabel(i)] = routeBackends
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"backends": backends,
+	})
+}
+
+// backendInfo describes a backend's state for admin responses
+func backendInfo(group *proxy.BackendGroup, backend *proxy.Backend) map[string]interface{} {
+	info := map[string]interface{}{
+		"url":       backend.GetURL().String(),
+		"group":     group.Name(),
+		"healthy":   backend.IsHealthy(),
+		"weight":    backend.Weight(),
+		"circuit":   backend.CircuitState(),
+		"in_flight": backend.InFlight(),
+	}
+	if source := backend.Source(); source != "" {
+		info["source"] = source
+	}
+	if backend.Disabled() {
+		info["disabled"] = true
+	}
+	if backend.Draining() {
+		info["draining"] = true
+	}
+	if backend.Warming() {
+		info["warming"] = true
+	}
+	if until := backend.EjectedUntil(); !until.IsZero() {
+		info["ejected_until"] = until.Format(time.RFC3339)
+	}
+	if lastCheck, lastError := backend.HealthStatus(); !lastCheck.IsZero() {
+		info["last_check"] = lastCheck.Format(time.RFC3339)
+		if lastError != "" {
+			info["last_check_error"] = lastError
+		}
+	}
+	return info
+}
+
+// handleListSplits returns the current traffic split of every route with backend groups
+func (s *Server) handleListSplits(c *gin.Context) {
+	gen := s.gen()
+	splits := make(map[string]map[string]int)
+	for i, rp := range gen.routeProxies {
+		if split := rp.Split(); split != nil {
+			splits[gen.routeLabel(i)] = split.Weights()
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"splits": splits,
+	})
+}
+
+// setSplitRequest is the body of PUT /admin/splits
+type setSplitRequest struct {
+	Route   string         `json:"route" binding:"required"` // Route name, or path if unambiguous
+	Weights map[string]int `json:"weights" binding:"required"`
+}
+
+// handleSetSplit changes a route's traffic split until the next reload or restart
+func (s *Server) handleSetSplit(c *gin.Context) {
+	var req setSplitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	gen := s.gen()
+	route, ok := findRoute(c, gen, req.Route)
+	if !ok {
+		return
+	}
+
+	split := gen.routeProxies[route].Split()
+	if split == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Route has no backend groups",
+		})
+		return
+	}
+	if err := split.Set(req.Weights); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	log.Printf("Traffic split for route %s set to %v", req.Route, req.Weights)
+	c.JSON(http.StatusOK, gin.H{
+		"route":   gen.routeLabel(route),
+		"weights": split.Weights(),
+	})
+}
+
+// findRoute resolves a route name or label to its index, writing a 404 or 409
+// response and returning false if it does not identify exactly one route
+func findRoute(c *gin.Context, gen *generation, name string) (int, bool) {
+	var matched []int
+	for i, route := range gen.config.Routes {
+		if route.Name == name || gen.routeLabel(i) == name {
+			matched = append(matched, i)
+		}
+	}
+	if len(matched) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Route not found",
+		})
+		return 0, false
+	}
+	if len(matched) > 1 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Several routes match, give the route a name",
+		})
+		return 0, false
+	}
+	return matched[0], true
+}
+
+// drainBackendRequest is the body of PUT /admin/backends/drain
+type drainBackendRequest struct {
+	Route    string `json:"route" binding:"required"` // Route name, or path if unambiguous
+	URL      string `json:"url" binding:"required"`   // Backend URL as configured
+	Draining *bool  `json:"draining"`                 // Default true; false puts the backend back
+}
+
+// handleDrainBackend stops sending new requests to a backend (or resumes) until
+// the next reload or restart. In-flight requests complete normally.
+func (s *Server) handleDrainBackend(c *gin.Context) {
+	var req drainBackendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	gen := s.gen()
+	route, ok := findRoute(c, gen, req.Route)
+	if !ok {
+		return
+	}
+	group, backend := gen.routeProxies[route].FindBackend(canonicalURL(req.URL))
+	if backend == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Backend not found",
+		})
+		return
+	}
+
+	draining := req.Draining == nil || *req.Draining
+	backend.SetDraining(draining)
+	c.JSON(http.StatusOK, gin.H{
+		"route":     gen.routeLabel(route),
+		"group":     group.Name(),
+		"url":       backend.GetURL().String(),
+		"draining":  backend.Draining(),
+		"in_flight": backend.InFlight(),
+	})
+}
+
+// addBackendRequest is the body of POST /admin/backends
+type addBackendRequest struct {
+	Route    string `json:"route" binding:"required"` // Route name, or path if unambiguous
+	Group    string `json:"group"`                    // Backend group (default: the route's first group)
+	URL      string `json:"url" binding:"required"`
+	Weight   int    `json:"weight"` // Default 1
+	Disabled bool   `json:"disabled"`
+}
+
+// handleAddBackend adds a backend to a running route. It is health checked
+// right away and receives traffic like the configured backends.
+func (s *Server) handleAddBackend(c *gin.Context) {
+	var req addBackendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if req.Weight < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Weight must not be negative",
+		})
+		return
+	}
+	if req.Weight == 0 {
+		req.Weight = 1
+	}
+	if proxy.IsDiscoveryURL(req.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Discovery entries can only be added in the configuration file",
+		})
+		return
+	}
+	candidate, err := proxy.NewBackend(req.URL, req.Weight)
+	if err != nil || candidate.GetURL().Scheme == "" || candidate.GetURL().Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid backend URL",
+		})
+		return
+	}
+	backendURL := candidate.GetURL().String()
+
+	// Changes are serialized with reloads so a new generation cannot miss one
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	gen := s.gen()
+	route, ok := findRoute(c, gen, req.Route)
+	if !ok {
+		return
+	}
+	rp := gen.routeProxies[route]
+	group := rp.Groups()[0]
+	if req.Group != "" {
+		group = nil
+		for _, g := range rp.Groups() {
+			if g.Name() == req.Group {
+				group = g
+			}
+		}
+		if group == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Backend group not found",
+			})
+			return
+		}
+	}
+	if _, existing := rp.FindBackend(backendURL); existing != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Backend already exists",
+		})
+		return
+	}
+
+	change := backendChange{
+		route:    route,
+		label:    gen.routeLabel(route),
+		group:    group.Name(),
+		url:      backendURL,
+		weight:   req.Weight,
+		disabled: req.Disabled,
+		added:    true,
+	}
+	if !s.saveBackendChange(c, gen, change) {
+		return
+	}
+
+	backend, err := group.AddBackend(backendURL, req.Weight, req.Disabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, backendInfo(group, backend))
+}
+
+// updateBackendRequest is the body of PATCH /admin/backends; omitted fields are unchanged
+type updateBackendRequest struct {
+	Route    string `json:"route" binding:"required"` // Route name, or path if unambiguous
+	URL      string `json:"url" binding:"required"`   // Backend URL as configured
+	Weight   *int   `json:"weight"`
+	Disabled *bool  `json:"disabled"`
+	Draining *bool  `json:"draining"` // Not persisted, like PUT /admin/backends/drain
+}
+
+// handleUpdateBackend re-weights, disables or enables a backend of a running route
+func (s *Server) handleUpdateBackend(c *gin.Context) {
+	var req updateBackendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	// Balancers treat weights below 1 as 1, so a zero weight would keep full traffic
+	if req.Weight != nil && *req.Weight <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Weight must be positive, use disabled to take a backend out of rotation",
+		})
+		return
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	gen := s.gen()
+	route, ok := findRoute(c, gen, req.Route)
+	if !ok {
+		return
+	}
+	group, backend := gen.routeProxies[route].FindBackend(canonicalURL(req.URL))
+	if backend == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Backend not found",
+		})
+		return
+	}
+
+	persist := req.Weight != nil || req.Disabled != nil
+	if persist && backend.Source() != "" && gen.config.Admin.PersistBackends != persistNone {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Changes to discovered backends cannot be saved, they last until the backend disappears",
+		})
+		return
+	}
+
+	change := backendChange{
+		route:    route,
+		label:    gen.routeLabel(route),
+		group:    group.Name(),
+		url:      backend.GetURL().String(),
+		weight:   backend.Weight(),
+		disabled: backend.Disabled(),
+	}
+	if req.Weight != nil {
+		change.weight = *req.Weight
+	}
+	if req.Disabled != nil {
+		change.disabled = *req.Disabled
+	}
+	if persist && !s.saveBackendChange(c, gen, change) {
+		return
+	}
+
+	if req.Weight != nil && *req.Weight != backend.Weight() {
+		log.Printf("Backend %s weight set to %d", change.url, *req.Weight)
+		backend.SetWeight(*req.Weight)
+	}
+	backend.SetDisabled(change.disabled)
+	if req.Draining != nil {
+		backend.SetDraining(*req.Draining)
+	}
+	c.JSON(http.StatusOK, backendInfo(group, backend))
+}
+
+// removeBackendRequest is the body of DELETE /admin/backends
+type removeBackendRequest struct {
+	Route string `json:"route" binding:"required"` // Route name, or path if unambiguous
+	URL   string `json:"url" binding:"required"`   // Backend URL as configured
+}
+
+// handleRemoveBackend takes a backend out of a running route. Requests already
+// sent to it complete; drain it first to let them finish before removing it.
+func (s *Server) handleRemoveBackend(c *gin.Context) {
+	var req removeBackendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	gen := s.gen()
+	route, ok := findRoute(c, gen, req.Route)
+	if !ok {
+		return
+	}
+	group, backend := gen.routeProxies[route].FindBackend(canonicalURL(req.URL))
+	if backend == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Backend not found",
+		})
+		return
+	}
+	if backend.Source() != "" {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Backend comes from service discovery, remove it at the source",
+		})
+		return
+	}
+	if len(group.Pool().GetAllBackends()) == 1 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Cannot remove the last backend of a group",
+		})
+		return
+	}
+
+	change := backendChange{
+		route:   route,
+		label:   gen.routeLabel(route),
+		group:   group.Name(),
+		url:     backend.GetURL().String(),
+		weight:  backend.Weight(),
+		removed: true,
+	}
+	if !s.saveBackendChange(c, gen, change) {
+		return
+	}
+
+	if err := group.RemoveBackend(backend); err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"route":     change.label,
+		"group":     group.Name(),
+		"url":       change.url,
+		"removed":   true,
+		"in_flight": backend.InFlight(),
+	})
+}
+
+// saveBackendChange persists a change before it is applied, writing a 500
+// response and returning false if that fails
+func (s *Server) saveBackendChange(c *gin.Context, gen *generation, change backendChange) bool {
+	if err := s.persistBackendChange(gen, change); err != nil {
+		log.Printf("Failed to save change to backend %s: %v", change.url, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to save backend change: " + err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// createAPIKeyRequest is the body of POST /admin/apikeys
+type createAPIKeyRequest struct {
+	Owner     string     `json:"owner" binding:"required"`
+	Routes    []string   `json:"routes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	TTL       string     `json:"ttl"` // Alternative to expires_at, e.g. "720h"
+}
+
+// handleListAPIKeys lists all keys without their secrets
+func (s *Server) handleListAPIKeys(c *gin.Context) {
+	keys, err := s.apiKeys.ListAPIKeys()
+	if err != nil {
+		log.Printf("Failed to list API keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list API keys",
+		})
+		return
+	}
+	if keys == nil {
+		keys = []storage.APIKey{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": keys,
+	})
+}
+
+// handleCreateAPIKey issues a new key. The plain key is only returned in this response.
+func (s *Server) handleCreateAPIKey(c *gin.Context) {
+	var req createAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	expiresAt := req.ExpiresAt
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid ttl",
+			})
+			return
+		}
+		expiry := time.Now().Add(ttl)
+		expiresAt = &expiry
+	}
+
+	id, err := middleware.GenerateAPIKeyID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate API key",
+		})
+		return
+	}
+	plain, prefix, hash, err := middleware.GenerateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate API key",
+		})
+		return
+	}
+
+	key := storage.APIKey{
+		ID:        id,
+		Owner:     req.Owner,
+		Prefix:    prefix,
+		Routes:    req.Routes,
+		ExpiresAt: expiresAt,
+		Enabled:   true,
+		CreatedAt: time.Now(),
+	}
+	if err := s.apiKeys.CreateAPIKey(key, hash); err != nil {
+		log.Printf("Failed to create API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create API key",
+		})
+		return
+	}
+
+	log.Printf("Created API key %s for %s", key.ID, key.Owner)
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": key,
+		"key":     plain,
+	})
+}
+
+// handleRotateAPIKey replaces the secret of an existing key, invalidating the old one
+func (s *Server) handleRotateAPIKey(c *gin.Context) {
+	id := c.Param("id")
+
+	plain, prefix, hash, err := middleware.GenerateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate API key",
+		})
+		return
+	}
+
+	if err := s.apiKeys.RotateAPIKey(id, prefix, hash); err != nil {
+		s.apiKeyError(c, "rotate", err)
+		return
+	}
+	key, err := s.apiKeys.GetAPIKey(id)
+	if err != nil {
+		s.apiKeyError(c, "rotate", err)
+		return
+	}
+
+	log.Printf("Rotated API key %s for %s", key.ID, key.Owner)
+	c.JSON(http.StatusOK, gin.H{
+		"api_key": key,
+		"key":     plain,
+	})
+}
+
+// handleRevokeAPIKey disables a key; the row is kept so past traffic stays attributable
+func (s *Server) handleRevokeAPIKey(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := s.apiKeys.SetAPIKeyEnabled(id, false); err != nil {
+		s.apiKeyError(c, "revoke", err)
+		return
+	}
+
+	log.Printf("Revoked API key %s", id)
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": id,
+	})
+}
+
+// apiKeyError maps store errors to responses
+func (s *Server) apiKeyError(c *gin.Context, action string, err error) {
+	if errors.Is(err, storage.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "API key not found",
+		})
+		return
+	}
+	log.Printf("Failed to %s API key: %v", action, err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": "Failed to " + action + " API key",
+	})
+}
+
+// handleListQuotas returns every quota policy with its usage in the current period.
+// ?policy= and ?client= narrow the output.
+func (s *Server) handleListQuotas(c *gin.Context) {
+	gen := s.gen()
+	policyFilter, clientFilter := c.Query("policy"), c.Query("client")
+
+	// Requests counted since the last flush should show up too
+	if gen.quotaTracker != nil {
+		if err := gen.quotaTracker.Flush(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to save quota usage",
+			})
+			return
+		}
+	}
+
+	now := time.Now()
+	quotas := []map[string]interface{}{}
+	for i, quota := range gen.quotas {
+		if policyFilter != "" && quota.Name() != policyFilter {
+			continue
+		}
+		start, end := quota.Period(now)
+		usage, err := s.quotaStore.ListQuotaUsage(quota.Name(), start)
+		if err != nil {
+			log.Printf("Failed to list quota usage: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to list quota usage",
+			})
+			return
+		}
+
+		clients := []map[string]interface{}{}
+		for _, u := range usage {
+			if clientFilter != "" && u.Client != clientFilter {
+				continue
+			}
+			limit := quota.LimitFor(u.Client)
+			clients = append(clients, map[string]interface{}{
+				"client":     u.Client,
+				"used":       u.Used,
+				"limit":      limit,
+				"remaining":  max(limit-u.Used, 0),
+				"updated_at": u.UpdatedAt,
+			})
+		}
+		quotas = append(quotas, map[string]interface{}{
+			"policy":       quota.Name(),
+			"period":       gen.config.Quotas.Policies[i].Period,
+			"period_start": start,
+			"resets_at":    end,
+			"limit":        gen.config.Quotas.Policies[i].Limit,
+			"overrides":    gen.config.Quotas.Policies[i].Overrides,
+			"clients":      clients,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quotas": quotas,
+	})
+}
+
+// handleResetQuota clears the current period's usage of one client (?client=),
+// or of every client of the policy
+func (s *Server) handleResetQuota(c *gin.Context) {
+	gen := s.gen()
+	name, client := c.Param("policy"), c.Query("client")
+
+	var quota *middleware.Quota
+	for _, q := range gen.quotas {
+		if q.Name() == name {
+			quota = q
+		}
+	}
+	if quota == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Quota policy not found",
+		})
+		return
+	}
+
+	start, _ := quota.Period(time.Now())
+	reset, err := gen.quotaTracker.Reset(name, client, start)
+	if err != nil {
+		log.Printf("Failed to reset quota usage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to reset quota usage",
+		})
+		return
+	}
+
+	if client == "" {
+		log.Printf("Reset quota %s for all clients", name)
+	} else {
+		log.Printf("Reset quota %s for %s", name, client)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"policy":       name,
+		"client":       client,
+		"period_start": start,
+		"reset":        reset,
+	})
+}
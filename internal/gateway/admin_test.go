@@ -0,0 +1,267 @@
+/*
+internal/gateway/admin_test.go
+Package gateway tests the admin API for backends and the persistence of its changes.
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// adminToken is the admin.token of adminConfig
+const adminToken = "secret"
+
+// adminConfig returns a configuration with an admin token and one route on backend,
+// saving backend changes where persist says
+func adminConfig(backend, persist string) string {
+	return fmt.Sprintf(`
+logging:
+  database: "gateway.db"
+admin:
+  token: %q
+  persist_backends: %q
+routes:
+  - name: "users"
+    path: "/api/*filepath"
+    backends:
+      - url: %q
+`, adminToken, persist, backend)
+}
+
+// adminRequest sends an authenticated admin request and decodes the JSON answer
+func adminRequest(t *testing.T, ts *httptest.Server, method, path, body string) (int, map[string]any) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var decoded map[string]any
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp.StatusCode, decoded
+}
+
+func TestDrainBackendEndpoint(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	tests := []struct {
+		name         string
+		drained      bool // Draining before the request
+		url          string
+		draining     string // JSON value of "draining", "" to omit
+		wantStatus   int
+		wantDraining bool
+	}{
+		{"drain", false, backend.URL, "", http.StatusOK, true},
+		{"other spelling of the URL", false, strings.Replace(backend.URL, "http://", "HTTP://", 1), "true", http.StatusOK, true},
+		{"resume", true, backend.URL, "false", http.StatusOK, false},
+		{"unknown backend", false, "http://127.0.0.1:1", "", http.StatusNotFound, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ts := newTestServer(t, adminConfig(backend.URL, persistNone))
+			_, target := s.gen().routeProxies[0].FindBackend(backend.URL)
+			target.SetDraining(tt.drained)
+
+			body := fmt.Sprintf(`{"route": "users", "url": %q`, tt.url)
+			if tt.draining != "" {
+				body += `, "draining": ` + tt.draining
+			}
+			status, _ := adminRequest(t, ts, http.MethodPut, "/admin/backends/drain", body+"}")
+			if status != tt.wantStatus {
+				t.Fatalf("PUT /admin/backends/drain: status %d, want %d", status, tt.wantStatus)
+			}
+
+			if target.Draining() != tt.wantDraining {
+				t.Fatalf("draining = %v, want %v", target.Draining(), tt.wantDraining)
+			}
+			// A drained single-backend route has nothing left to serve
+			wantProxy := http.StatusOK
+			if tt.wantDraining {
+				wantProxy = http.StatusServiceUnavailable
+			}
+			if code, _ := get(t, ts.URL+"/api/users"); code != wantProxy {
+				t.Fatalf("GET /api/users: status %d, want %d", code, wantProxy)
+			}
+		})
+	}
+}
+
+// backendState returns the weight and disabled flag of a backend of the first
+// route, or ok false when the route has no such backend
+func backendState(s *Server, url string) (weight int, disabled, ok bool) {
+	_, backend := s.gen().routeProxies[0].FindBackend(url)
+	if backend == nil {
+		return 0, false, false
+	}
+	return backend.Weight(), backend.Disabled(), true
+}
+
+func TestBackendChangesPersistence(t *testing.T) {
+	first := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer first.Close()
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer second.Close()
+
+	tests := []struct {
+		persist    string
+		wantSaved  bool // Changes survive a reload
+		wantInFile bool // Changes are written to the configuration file
+	}{
+		{persistNone, false, false},
+		{persistConfig, true, true},
+		{persistSQLite, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("persist %q", tt.persist), func(t *testing.T) {
+			s, ts := newTestServer(t, adminConfig(first.URL, tt.persist))
+
+			steps := []struct {
+				method, body string
+				wantStatus   int
+			}{
+				{http.MethodPost, fmt.Sprintf(`{"route": "users", "url": %q, "weight": 3}`, second.URL), http.StatusCreated},
+				{http.MethodPatch, fmt.Sprintf(`{"route": "users", "url": %q, "weight": 2, "disabled": true}`, first.URL), http.StatusOK},
+			}
+			for _, step := range steps {
+				if status, body := adminRequest(t, ts, step.method, "/admin/backends", step.body); status != step.wantStatus {
+					t.Fatalf("%s /admin/backends: status %d (%v), want %d", step.method, status, body, step.wantStatus)
+				}
+			}
+
+			// Changes apply to the running route right away
+			if weight, _, ok := backendState(s, second.URL); !ok || weight != 3 {
+				t.Fatalf("added backend: present %v, weight %d, want weight 3", ok, weight)
+			}
+			if weight, disabled, _ := backendState(s, first.URL); weight != 2 || !disabled {
+				t.Fatalf("updated backend: weight %d, disabled %v, want 2 and disabled", weight, disabled)
+			}
+
+			data, err := os.ReadFile(s.configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(data), second.URL) != tt.wantInFile {
+				t.Fatalf("configuration file contains the added backend: %v, want %v\n%s", !tt.wantInFile, tt.wantInFile, data)
+			}
+
+			if err := s.Reload(); err != nil {
+				t.Fatal(err)
+			}
+			_, _, added := backendState(s, second.URL)
+			weight, disabled, _ := backendState(s, first.URL)
+			if added != tt.wantSaved {
+				t.Fatalf("after reload the added backend is present: %v, want %v", added, tt.wantSaved)
+			}
+			if tt.wantSaved && (weight != 2 || !disabled) || !tt.wantSaved && (weight != 1 || disabled) {
+				t.Fatalf("after reload the updated backend has weight %d, disabled %v", weight, disabled)
+			}
+			if !tt.wantSaved {
+				return
+			}
+
+			// Removal is saved too
+			body := fmt.Sprintf(`{"route": "users", "url": %q}`, second.URL)
+			if status, _ := adminRequest(t, ts, http.MethodDelete, "/admin/backends", body); status != http.StatusOK {
+				t.Fatalf("DELETE /admin/backends: status %d, want 200", status)
+			}
+			if err := s.Reload(); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, ok := backendState(s, second.URL); ok {
+				t.Fatal("removed backend is back after reload")
+			}
+		})
+	}
+}
+
+func TestBackendChangesValidation(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{"add without url", http.MethodPost, `{"route": "users"}`, http.StatusBadRequest},
+		{"add negative weight", http.MethodPost, `{"route": "users", "url": "http://127.0.0.1:1", "weight": -1}`, http.StatusBadRequest},
+		{"add relative url", http.MethodPost, `{"route": "users", "url": "backend:8080"}`, http.StatusBadRequest},
+		{"add to unknown route", http.MethodPost, `{"route": "orders", "url": "http://127.0.0.1:1"}`, http.StatusNotFound},
+		{"add to unknown group", http.MethodPost, `{"route": "users", "group": "canary", "url": "http://127.0.0.1:1"}`, http.StatusNotFound},
+		{"add existing backend", http.MethodPost, fmt.Sprintf(`{"route": "users", "url": %q}`, backend.URL), http.StatusConflict},
+		{"zero weight", http.MethodPatch, fmt.Sprintf(`{"route": "users", "url": %q, "weight": 0}`, backend.URL), http.StatusBadRequest},
+		{"update unknown backend", http.MethodPatch, `{"route": "users", "url": "http://127.0.0.1:1", "weight": 2}`, http.StatusNotFound},
+		{"remove last backend", http.MethodDelete, fmt.Sprintf(`{"route": "users", "url": %q}`, backend.URL), http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ts := newTestServer(t, adminConfig(backend.URL, persistNone))
+			if status, _ := adminRequest(t, ts, tt.method, "/admin/backends", tt.body); status != tt.wantStatus {
+				t.Fatalf("%s /admin/backends: status %d, want %d", tt.method, status, tt.wantStatus)
+			}
+			// Rejected changes leave the route as it was
+			if backends := s.gen().routeProxies[0].Groups()[0].Pool().GetAllBackends(); len(backends) != 1 || backends[0].Weight() != 1 {
+				t.Fatalf("route changed by a rejected request: %d backends", len(backends))
+			}
+		})
+	}
+}
+
+func TestAddDisabledBackendGetsNoTraffic(t *testing.T) {
+	var hits [2]atomic.Int32
+	backends := make([]*httptest.Server, 2)
+	for i := range backends {
+		backends[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/users" { // Not health checks
+				hits[i].Add(1)
+			}
+		}))
+		defer backends[i].Close()
+	}
+	_, ts := newTestServer(t, adminConfig(backends[0].URL, persistNone))
+
+	body := fmt.Sprintf(`{"route": "users", "url": %q, "disabled": true}`, backends[1].URL)
+	if status, info := adminRequest(t, ts, http.MethodPost, "/admin/backends", body); status != http.StatusCreated || info["disabled"] != true {
+		t.Fatalf("POST /admin/backends: status %d, %v, want 201 and disabled", status, info)
+	}
+	for i := 0; i < 20; i++ {
+		if code, _ := get(t, ts.URL+"/api/users"); code != http.StatusOK {
+			t.Fatalf("GET /api/users: status %d, want 200", code)
+		}
+	}
+	if hits[1].Load() != 0 || hits[0].Load() != 20 {
+		t.Fatalf("disabled backend got %d of 20 requests", hits[1].Load())
+	}
+
+	// Enabling it puts it in rotation
+	body = fmt.Sprintf(`{"route": "users", "url": %q, "disabled": false}`, backends[1].URL)
+	if status, _ := adminRequest(t, ts, http.MethodPatch, "/admin/backends", body); status != http.StatusOK {
+		t.Fatalf("PATCH /admin/backends: status %d, want 200", status)
+	}
+	for i := 0; i < 20; i++ {
+		get(t, ts.URL+"/api/users")
+	}
+	if hits[1].Load() == 0 {
+		t.Fatal("enabled backend got no requests")
+	}
+}
@@ -7,9 +7,14 @@ package gateway
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/AndreaBozzo/go-lab/internal/middleware"
+	"github.com/AndreaBozzo/go-lab/internal/proxy"
+	"github.com/AndreaBozzo/go-lab/internal/storage"
 	"gopkg.in/yaml.v3"
 )
 
@@ -18,7 +23,12 @@ type Config struct {
 	Server       ServerConfig       `yaml:"server"`
 	Logging      LoggingConfig      `yaml:"logging"`
 	RateLimiting RateLimitingConfig `yaml:"rate_limiting"`
+	Quotas       QuotasConfig       `yaml:"quotas"`
 	CORS         CORSConfig         `yaml:"cors"`
+	Metrics      MetricsConfig      `yaml:"metrics"`
+	Tracing      TracingConfig      `yaml:"tracing"`
+	Auth         AuthConfig         `yaml:"auth"`
+	Admin        AdminConfig        `yaml:"admin"`
 	Routes       []RouteConfig      `yaml:"routes"`
 }
 
@@ -29,6 +39,8 @@ type ServerConfig struct {
 	ReadTimeout     time.Duration `yaml:"read_timeout"`
 	WriteTimeout    time.Duration `yaml:"write_timeout"`
 	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	DrainTimeout    time.Duration `yaml:"drain_timeout"`   // How long a reload waits for the old configuration's requests (default 30s)
+	TrustedProxies  []string      `yaml:"trusted_proxies"` // IPs or CIDRs whose X-Forwarded-For sets the client IP (default: none)
 }
 
 // LoggingConfig contains logging settings
@@ -39,9 +51,211 @@ type LoggingConfig struct {
 
 // RateLimitingConfig contains rate limiting settings
 type RateLimitingConfig struct {
-	Enabled           bool `yaml:"enabled"`
-	RequestsPerSecond int  `yaml:"requests_per_second"`
-	Burst             int  `yaml:"burst"`
+	Enabled           bool                    `yaml:"enabled"`
+	RequestsPerSecond int                     `yaml:"requests_per_second"` // Shared by all clients (0 = no global limit)
+	Burst             int                     `yaml:"burst"`
+	Clients           []ClientRateLimitConfig `yaml:"clients"`  // Per-client policies, each client gets its own bucket
+	Response          RateLimitResponseConfig `yaml:"response"` // Body of 429 responses, for route limits too
+	Store             RateLimitStoreConfig    `yaml:"store"`    // Where requests are counted, for route limits too
+}
+
+// Rate limit stores and algorithms
+const (
+	rateLimitStoreMemory   = "memory"
+	rateLimitStoreSQLite   = "sqlite"
+	rateLimitStoreRedis    = "redis"
+	algorithmTokenBucket   = "token_bucket"
+	algorithmSlidingWindow = "sliding_window"
+)
+
+// RateLimitStoreConfig selects where rate limits are counted. Shared stores let
+// gateway replicas enforce limits together.
+type RateLimitStoreConfig struct {
+	Type         string        `yaml:"type"`          // memory (default), sqlite (the logging database) or redis
+	Algorithm    string        `yaml:"algorithm"`     // token_bucket (memory only, its default) or sliding_window
+	SyncInterval time.Duration `yaml:"sync_interval"` // How often local counts are synced with a shared store (default 100ms)
+	Address      string        `yaml:"address"`       // redis: host:port
+	Password     string        `yaml:"password"`      // redis: AUTH password
+	DB           int           `yaml:"db"`            // redis: database number
+	Timeout      time.Duration `yaml:"timeout"`       // redis: per sync (default 1s)
+	KeyPrefix    string        `yaml:"key_prefix"`    // redis: default "gateway:ratelimit:"
+}
+
+// shared reports whether limits are counted in a RateLimitStore rather than in token buckets
+func (sc RateLimitStoreConfig) shared() bool {
+	return sc.Type == rateLimitStoreSQLite || sc.Type == rateLimitStoreRedis || sc.Algorithm == algorithmSlidingWindow
+}
+
+// respConfig converts the redis settings for the storage package
+func (sc RateLimitStoreConfig) respConfig() storage.RESPConfig {
+	return storage.RESPConfig{
+		Address:   sc.Address,
+		Password:  sc.Password,
+		DB:        sc.DB,
+		Timeout:   sc.Timeout,
+		KeyPrefix: sc.KeyPrefix,
+	}
+}
+
+// Validate checks the store type and algorithm
+func (sc RateLimitStoreConfig) Validate() error {
+	switch sc.Type {
+	case "", rateLimitStoreMemory, rateLimitStoreSQLite:
+	case rateLimitStoreRedis:
+		if _, err := storage.NewRESPRateLimitStore(sc.respConfig()); err != nil {
+			return fmt.Errorf("store: %w", err)
+		}
+	default:
+		return fmt.Errorf("store: unknown type %q (use \"memory\", \"sqlite\" or \"redis\")", sc.Type)
+	}
+	switch sc.Algorithm {
+	case "", algorithmSlidingWindow:
+	case algorithmTokenBucket:
+		if sc.Type == rateLimitStoreSQLite || sc.Type == rateLimitStoreRedis {
+			return fmt.Errorf("store: token_bucket only works in memory, use sliding_window with %s", sc.Type)
+		}
+	default:
+		return fmt.Errorf("store: unknown algorithm %q (use \"token_bucket\" or \"sliding_window\")", sc.Algorithm)
+	}
+	if sc.SyncInterval < 0 {
+		return fmt.Errorf("store: sync_interval must not be negative")
+	}
+	return nil
+}
+
+// RateLimitResponseConfig contains the format of rate limited responses
+type RateLimitResponseConfig struct {
+	Format  string `yaml:"format"`  // json (default), problem (RFC 7807) or text
+	Message string `yaml:"message"` // Replaces the default error messages
+}
+
+// middlewareConfig converts the response settings for the middleware package
+func (rc RateLimitResponseConfig) middlewareConfig() middleware.RateLimitResponse {
+	return middleware.RateLimitResponse{
+		Format:  rc.Format,
+		Message: rc.Message,
+	}
+}
+
+// ClientRateLimitConfig contains a per-client rate limiting policy
+type ClientRateLimitConfig struct {
+	Name              string        `yaml:"name"`                // Label in metrics (default: the key)
+	Key               string        `yaml:"key"`                 // ip, cidr, header, api_key or jwt_subject
+	Header            string        `yaml:"header"`              // Header name for the header key
+	IPv4Prefix        int           `yaml:"ipv4_prefix"`         // Network size for the cidr key (default 24)
+	IPv6Prefix        int           `yaml:"ipv6_prefix"`         // Network size for the cidr key (default 64)
+	RequestsPerSecond float64       `yaml:"requests_per_second"` // Per client
+	Burst             int           `yaml:"burst"`               // Per client (default: requests_per_second)
+	MaxClients        int           `yaml:"max_clients"`         // Buckets kept in memory (default 10000)
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`        // Unused buckets are dropped after this (default 10m)
+}
+
+// middlewareConfig converts the policy for the middleware package
+func (cc ClientRateLimitConfig) middlewareConfig() middleware.ClientRateLimitConfig {
+	return middleware.ClientRateLimitConfig{
+		Name:              cc.Name,
+		Key:               cc.Key,
+		Header:            cc.Header,
+		IPv4Prefix:        cc.IPv4Prefix,
+		IPv6Prefix:        cc.IPv6Prefix,
+		RequestsPerSecond: cc.RequestsPerSecond,
+		Burst:             cc.Burst,
+		MaxClients:        cc.MaxClients,
+		IdleTimeout:       cc.IdleTimeout,
+	}
+}
+
+// RouteRateLimitConfig contains the rate limit of a route. It applies even when
+// rate_limiting is disabled, and is written either as a plain number of
+// requests per second or as a mapping.
+type RouteRateLimitConfig struct {
+	RequestsPerSecond float64                          `yaml:"requests_per_second"` // Shared by all clients of the route (0 = none)
+	Burst             int                              `yaml:"burst"`               // Default: requests_per_second
+	Methods           map[string]MethodRateLimitConfig `yaml:"methods"`             // Replace the route limit for these methods
+	Clients           []ClientRateLimitConfig          `yaml:"clients"`             // Per-client policies; replace global ones of the same name
+}
+
+// MethodRateLimitConfig contains the rate limit of one method on a route (0 = unlimited)
+type MethodRateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// UnmarshalYAML accepts both rate_limit: 50 and the mapping form
+func (rc *RouteRateLimitConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&rc.RequestsPerSecond)
+	}
+	type plain RouteRateLimitConfig
+	return node.Decode((*plain)(rc))
+}
+
+// enabled reports whether the route has any limit of its own
+func (rc RouteRateLimitConfig) enabled() bool {
+	return rc.RequestsPerSecond > 0 || len(rc.Methods) > 0 || len(rc.Clients) > 0
+}
+
+// middlewareConfig converts the route limit for the middleware package; clients
+// are the per-client limiters applying to the route
+func (rc RouteRateLimitConfig) middlewareConfig(name string, clients []*middleware.ClientRateLimiter) middleware.RouteRateLimitConfig {
+	methods := make(map[string]middleware.MethodRateLimit, len(rc.Methods))
+	for method, limit := range rc.Methods {
+		methods[method] = middleware.MethodRateLimit{
+			RequestsPerSecond: limit.RequestsPerSecond,
+			Burst:             limit.Burst,
+		}
+	}
+	return middleware.RouteRateLimitConfig{
+		Name:              name,
+		RequestsPerSecond: rc.RequestsPerSecond,
+		Burst:             rc.Burst,
+		Methods:           methods,
+		Clients:           clients,
+	}
+}
+
+// QuotasConfig contains usage quotas, counted in the SQLite database so they survive restarts
+type QuotasConfig struct {
+	FlushInterval time.Duration       `yaml:"flush_interval"` // How often counts are written (default 5s)
+	Policies      []QuotaPolicyConfig `yaml:"policies"`
+}
+
+// QuotaPolicyConfig contains one quota policy
+type QuotaPolicyConfig struct {
+	Name      string           `yaml:"name"`
+	Key       string           `yaml:"key"`       // api_key (default), jwt_subject, header or ip
+	Header    string           `yaml:"header"`    // Header name for the header key
+	Period    string           `yaml:"period"`    // daily or monthly, in UTC
+	Limit     int64            `yaml:"limit"`     // Requests per period
+	Overrides map[string]int64 `yaml:"overrides"` // Client (e.g. API key ID) -> limit
+	Status    int              `yaml:"status"`    // 429 (default) or 403
+	Routes    []string         `yaml:"routes"`    // Route names or paths (default: all routes)
+}
+
+// middlewareConfig converts the policy for the middleware package
+func (qc QuotaPolicyConfig) middlewareConfig() middleware.QuotaConfig {
+	return middleware.QuotaConfig{
+		Name:      qc.Name,
+		Key:       qc.Key,
+		Header:    qc.Header,
+		Period:    qc.Period,
+		Limit:     qc.Limit,
+		Overrides: qc.Overrides,
+		Status:    qc.Status,
+	}
+}
+
+// appliesTo reports whether the policy covers route
+func (qc QuotaPolicyConfig) appliesTo(route RouteConfig) bool {
+	if len(qc.Routes) == 0 {
+		return true
+	}
+	for _, name := range qc.Routes {
+		if name == route.displayName() || name == route.Path {
+			return true
+		}
+	}
+	return false
 }
 
 // CORSConfig contains CORS settings
@@ -52,18 +266,419 @@ type CORSConfig struct {
 	AllowedHeaders []string `yaml:"allowed_headers"`
 }
 
+// MetricsConfig contains Prometheus metrics settings
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"` // Scrape endpoint path
+}
+
+// TracingConfig contains distributed tracing settings
+type TracingConfig struct {
+	Enabled       bool              `yaml:"enabled"`
+	ServiceName   string            `yaml:"service_name"`
+	Exporter      string            `yaml:"exporter"`       // "otlp" or "file"
+	Endpoint      string            `yaml:"endpoint"`       // OTLP/HTTP traces endpoint
+	Headers       map[string]string `yaml:"headers"`        // Extra headers sent to the collector
+	File          string            `yaml:"file"`           // Output path for the file exporter
+	SampleRatio   float64           `yaml:"sample_ratio"`   // Fraction of new traces to sample (0-1)
+	BatchSize     int               `yaml:"batch_size"`     // Spans per export batch
+	FlushInterval time.Duration     `yaml:"flush_interval"` // Maximum delay before export
+}
+
+// AdminConfig contains admin API settings
+type AdminConfig struct {
+	Token           string `yaml:"token"`            // Bearer token required on /admin endpoints (empty: read-only endpoints, local clients only)
+	PersistBackends string `yaml:"persist_backends"` // Where backend changes made via the admin API are saved: "" (not saved), "config" or "sqlite"
+}
+
+// Values of admin.persist_backends
+const (
+	persistNone   = ""
+	persistConfig = "config"
+	persistSQLite = "sqlite"
+)
+
+// AuthConfig contains authentication settings shared by all routes
+type AuthConfig struct {
+	JWT JWTConfig `yaml:"jwt"`
+}
+
+// JWTConfig contains JWT validation settings
+type JWTConfig struct {
+	Issuer              string         `yaml:"issuer"`
+	Audience            []string       `yaml:"audience"`
+	Algorithms          []string       `yaml:"algorithms"`            // Allowed algorithms (HS256, RS256, ES256)
+	HMACSecret          string         `yaml:"hmac_secret"`           // Shared secret for HS256
+	PublicKeys          []JWTKeyConfig `yaml:"public_keys"`           // Static PEM keys for RS256/ES256
+	JWKSURL             string         `yaml:"jwks_url"`              // JWKS endpoint
+	JWKSFile            string         `yaml:"jwks_file"`             // JWKS document on disk
+	JWKSRefreshInterval time.Duration  `yaml:"jwks_refresh_interval"` // JWKS cache lifetime
+	ClockSkew           time.Duration  `yaml:"clock_skew"`            // Leeway for exp/nbf
+}
+
+// JWTKeyConfig represents a static JWT verification key
+type JWTKeyConfig struct {
+	KeyID string `yaml:"kid"`
+	File  string `yaml:"file"`
+	PEM   string `yaml:"pem"`
+}
+
+// enabled reports whether any JWT key source is configured
+func (j JWTConfig) enabled() bool {
+	return j.HMACSecret != "" || len(j.PublicKeys) > 0 || j.JWKSURL != "" || j.JWKSFile != ""
+}
+
+// RouteAuthConfig contains the authentication policy of a route
+type RouteAuthConfig struct {
+	Type           string            `yaml:"type"`            // "none" (default), "jwt" or "api_key"
+	RequiredClaims map[string]string `yaml:"required_claims"` // Claims that must have the given value
+	Scopes         []string          `yaml:"scopes"`          // Scopes that must all be granted
+	ForwardClaims  map[string]string `yaml:"forward_claims"`  // Claim -> header forwarded upstream
+	APIKeyHeader   string            `yaml:"api_key_header"`  // Header carrying the API key (default X-API-Key)
+}
+
 // RouteConfig represents a single route configuration
 type RouteConfig struct {
-	Path      string          `yaml:"path"`
-	Backends  []BackendConfig `yaml:"backends"`
-	Methods   []string        `yaml:"methods"`
-	RateLimit int             `yaml:"rate_limit"` // Per-route rate limit (requests per second)
+	Name      string               `yaml:"name"` // Optional, identifies the route in the admin API
+	Path      string               `yaml:"path"`
+	Backends  []BackendConfig      `yaml:"backends"`
+	Methods   []string             `yaml:"methods"`
+	RateLimit RouteRateLimitConfig `yaml:"rate_limit"` // Per-route rate limit, checked after the global one
+	Match     MatchConfig          `yaml:"match"`      // Extra conditions beyond path and method
+	Priority  int                  `yaml:"priority"`   // Higher wins when several routes match (ties: more conditions, then config order)
+
+	LoadBalancer   string               `yaml:"load_balancer"` // Backend selection strategy (default round_robin)
+	HashKey        HashKeyConfig        `yaml:"hash_key"`      // Key for the consistent_hash strategy
+	StickySession  StickySessionConfig  `yaml:"sticky_session"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	HealthCheck    HealthCheckConfig    `yaml:"health_check"`
+	Outlier        OutlierConfig        `yaml:"outlier_detection"` // Passive health checking on real traffic
+	SlowStart      SlowStartConfig      `yaml:"slow_start"`        // Weight ramp for recovering backends
+	Discovery      DiscoveryConfig      `yaml:"discovery"`         // Settings for dns:// and dns+srv:// backends
+	Retry          RetryConfig          `yaml:"retry"`
+	WebSocket      WebSocketConfig      `yaml:"websocket"`
+	Rewrite        RewriteConfig        `yaml:"rewrite"`
+	Mirror         MirrorConfig         `yaml:"mirror"`         // Copies a share of traffic to a shadow backend
+	Groups         []BackendGroupConfig `yaml:"groups"`         // Named backend groups, instead of backends
+	GroupOverride  GroupOverrideConfig  `yaml:"group_override"` // Lets testers force a group
+	Auth           RouteAuthConfig      `yaml:"auth"`
+}
+
+// MatchConfig contains request conditions a route requires in addition to its path.
+// Values of "*" only require presence.
+type MatchConfig struct {
+	Hosts   []string          `yaml:"hosts"`   // Exact hosts or wildcards like *.example.internal
+	Headers map[string]string `yaml:"headers"` // Header name -> value
+	Query   map[string]string `yaml:"query"`   // Query parameter -> value
+	Cookies map[string]string `yaml:"cookies"` // Cookie name -> value
+}
+
+// HashKeyConfig selects the request attribute hashed by the consistent_hash load balancer
+type HashKeyConfig struct {
+	Source  string `yaml:"source"`  // client_ip (default), header, cookie or path_segment
+	Name    string `yaml:"name"`    // Header or cookie name
+	Segment int    `yaml:"segment"` // Zero-based path segment index
+}
+
+// StickySessionConfig contains cookie-based session affinity settings
+type StickySessionConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	CookieName string        `yaml:"cookie_name"` // Default GW_BACKEND_<route>
+	TTL        time.Duration `yaml:"ttl"`         // Cookie lifetime (0 = session cookie)
+	Secure     bool          `yaml:"secure"`
+}
+
+// proxyConfig converts the sticky session settings for the proxy package. The
+// default cookie name includes the route so routes don't overwrite each other's pin.
+func (sc StickySessionConfig) proxyConfig(route string) proxy.StickySessionConfig {
+	name := sc.CookieName
+	if name == "" {
+		name = "GW_BACKEND"
+		if token := cookieToken(route); token != "" {
+			name += "_" + token
+		}
+	}
+	return proxy.StickySessionConfig{
+		Enabled:    sc.Enabled,
+		CookieName: name,
+		TTL:        sc.TTL,
+		Secure:     sc.Secure,
+	}
+}
+
+// cookieToken turns a route label into a cookie name part: letters, digits and
+// single underscores, e.g. "/api/users/*filepath" becomes "api_users_filepath"
+func cookieToken(label string) string {
+	token := make([]byte, 0, len(label))
+	for i := 0; i < len(label); i++ {
+		b := label[i]
+		if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' {
+			token = append(token, b)
+		} else if len(token) > 0 && token[len(token)-1] != '_' {
+			token = append(token, '_')
+		}
+	}
+	return strings.TrimSuffix(string(token), "_")
+}
+
+// CircuitBreakerConfig contains per-backend circuit breaker settings for a route
+type CircuitBreakerConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	FailureRatio   float64       `yaml:"failure_ratio"`    // Failure ratio (0-1) that opens the circuit
+	MinRequests    int           `yaml:"min_requests"`     // Minimum requests in the window before tripping
+	Window         time.Duration `yaml:"window"`           // Counting window while closed
+	OpenDuration   time.Duration `yaml:"open_duration"`    // Time to wait before probing again
+	HalfOpenProbes int           `yaml:"half_open_probes"` // Successful probes needed to close the circuit
+}
+
+// HealthCheckConfig contains per-route active health check settings
+type HealthCheckConfig struct {
+	Type               string            `yaml:"type"`                // http (default) or tcp
+	Path               string            `yaml:"path"`                // Default /health, relative to the backend base path
+	Method             string            `yaml:"method"`              // Default GET
+	Statuses           []int             `yaml:"statuses"`            // Healthy status codes (default 200)
+	Body               string            `yaml:"body"`                // Substring the body must contain
+	BodyRegex          string            `yaml:"body_regex"`          // Pattern the body must match
+	JSONField          string            `yaml:"json_field"`          // Dotted field that must exist in a JSON body
+	JSONValue          string            `yaml:"json_value"`          // Expected value of json_field
+	Headers            map[string]string `yaml:"headers"`             // Extra request headers (Host is honoured)
+	Interval           time.Duration     `yaml:"interval"`            // Default 10s
+	Timeout            time.Duration     `yaml:"timeout"`             // Default 3s
+	UnhealthyThreshold int               `yaml:"unhealthy_threshold"` // Failures before marking unhealthy (default 3)
+	HealthyThreshold   int               `yaml:"healthy_threshold"`   // Successes before marking healthy again (default 1)
+}
+
+// proxyConfig converts the health check settings for the proxy package
+func (hc HealthCheckConfig) proxyConfig() proxy.HealthCheckConfig {
+	return proxy.HealthCheckConfig{
+		Type:               hc.Type,
+		Path:               hc.Path,
+		Method:             hc.Method,
+		Statuses:           hc.Statuses,
+		Body:               hc.Body,
+		BodyRegex:          hc.BodyRegex,
+		JSONField:          hc.JSONField,
+		JSONValue:          hc.JSONValue,
+		Headers:            hc.Headers,
+		Interval:           hc.Interval,
+		Timeout:            hc.Timeout,
+		UnhealthyThreshold: hc.UnhealthyThreshold,
+		HealthyThreshold:   hc.HealthyThreshold,
+	}
+}
+
+// OutlierConfig contains per-route passive health check (outlier ejection) settings
+type OutlierConfig struct {
+	Enabled                bool          `yaml:"enabled"`
+	ConsecutiveErrors      int           `yaml:"consecutive_errors"`        // 5xx/gateway errors in a row (default 5)
+	Interval               time.Duration `yaml:"interval"`                  // Success rate analysis period (default 10s)
+	BaseEjectionTime       time.Duration `yaml:"base_ejection_time"`        // Doubled on every repeat ejection (default 30s)
+	MaxEjectionTime        time.Duration `yaml:"max_ejection_time"`         // Default 5m
+	MaxEjectionPercent     int           `yaml:"max_ejection_percent"`      // Share of the pool ejectable at once (default 50)
+	SuccessRateMinRequests int           `yaml:"success_rate_min_requests"` // Per backend per interval (default 20)
+	SuccessRateMinHosts    int           `yaml:"success_rate_min_hosts"`    // Backends needed for the analysis (default 3)
+	SuccessRateStdevFactor float64       `yaml:"success_rate_stdev_factor"` // Eject below mean - factor*stdev (default 1.9)
+}
+
+// proxyConfig converts the outlier detection settings for the proxy package
+func (oc OutlierConfig) proxyConfig() proxy.OutlierDetectionConfig {
+	return proxy.OutlierDetectionConfig{
+		Enabled:                oc.Enabled,
+		ConsecutiveErrors:      oc.ConsecutiveErrors,
+		Interval:               oc.Interval,
+		BaseEjectionTime:       oc.BaseEjectionTime,
+		MaxEjectionTime:        oc.MaxEjectionTime,
+		MaxEjectionPercent:     oc.MaxEjectionPercent,
+		SuccessRateMinRequests: oc.SuccessRateMinRequests,
+		SuccessRateMinHosts:    oc.SuccessRateMinHosts,
+		SuccessRateStdevFactor: oc.SuccessRateStdevFactor,
+	}
+}
+
+// SlowStartConfig contains the weight ramp for backends coming back from unhealthy
+type SlowStartConfig struct {
+	Window           time.Duration `yaml:"window"`             // Time to reach full weight (0 disables)
+	MinWeightPercent int           `yaml:"min_weight_percent"` // Starting share of the weight (default 10)
+}
+
+// DiscoveryConfig contains per-route service discovery settings
+type DiscoveryConfig struct {
+	Interval time.Duration `yaml:"interval"` // Re-resolution period (default 30s)
+	Timeout  time.Duration `yaml:"timeout"`  // Per resolution (default 5s)
+	Resolver string        `yaml:"resolver"` // DNS server as host:port (default: system resolver)
+	Scheme   string        `yaml:"scheme"`   // Scheme of discovered backends: http (default) or https
+}
+
+// proxyConfig converts the discovery settings for the proxy package
+func (dc DiscoveryConfig) proxyConfig() proxy.DiscoveryConfig {
+	return proxy.DiscoveryConfig{
+		Interval: dc.Interval,
+		Timeout:  dc.Timeout,
+		Resolver: dc.Resolver,
+		Scheme:   dc.Scheme,
+	}
+}
+
+// RetryConfig contains per-route retry settings
+type RetryConfig struct {
+	Attempts            int           `yaml:"attempts"`               // Retries after the first try (0 disables)
+	RetryOn             []string      `yaml:"retry_on"`               // connect_failure, timeout, reset
+	Statuses            []int         `yaml:"statuses"`               // Response codes to retry, e.g. 502, 503
+	Backoff             time.Duration `yaml:"backoff"`                // Base backoff, doubled per retry
+	MaxBackoff          time.Duration `yaml:"max_backoff"`            // Backoff cap
+	PerTryTimeout       time.Duration `yaml:"per_try_timeout"`        // Timeout for each attempt
+	BudgetPercent       *float64      `yaml:"budget_percent"`         // Max retries as % of requests (10s window, default 20)
+	MinRetriesPerSecond *int          `yaml:"min_retries_per_second"` // Retries allowed even at low traffic (default 3)
+	RetryNonIdempotent  bool          `yaml:"retry_non_idempotent"`   // Also retry POST and PATCH
+	MaxBodyBytes        int64         `yaml:"max_body_bytes"`         // Larger bodies are streamed, not retried
+}
+
+// proxyConfig converts the retry settings for the proxy package. The budget
+// settings are pointers so an explicit 0 is kept instead of getting the default.
+func (rc RetryConfig) proxyConfig() proxy.RetryConfig {
+	budgetPercent := float64(proxy.DefaultRetryBudgetPercent)
+	if rc.BudgetPercent != nil {
+		budgetPercent = *rc.BudgetPercent
+	}
+	minRetries := proxy.DefaultMinRetriesPerSecond
+	if rc.MinRetriesPerSecond != nil {
+		minRetries = *rc.MinRetriesPerSecond
+	}
+	return proxy.RetryConfig{
+		Attempts:            rc.Attempts,
+		RetryOn:             rc.RetryOn,
+		Statuses:            rc.Statuses,
+		Backoff:             rc.Backoff,
+		MaxBackoff:          rc.MaxBackoff,
+		PerTryTimeout:       rc.PerTryTimeout,
+		BudgetPercent:       budgetPercent,
+		MinRetriesPerSecond: minRetries,
+		RetryNonIdempotent:  rc.RetryNonIdempotent,
+		MaxBodyBytes:        rc.MaxBodyBytes,
+	}
+}
+
+// RewriteConfig contains per-route upstream path rewriting
+type RewriteConfig struct {
+	StripPrefix string `yaml:"strip_prefix"` // e.g. "/api" turns /api/users/1 into /users/1
+	Regex       string `yaml:"regex"`        // Applied after strip_prefix
+	Replacement string `yaml:"replacement"`  // May reference capture groups as $1 or ${name}
+	AddPrefix   string `yaml:"add_prefix"`   // Prepended after the regex
+}
+
+// proxyConfig converts the rewrite settings for the proxy package
+func (rc RewriteConfig) proxyConfig() proxy.RewriteConfig {
+	return proxy.RewriteConfig{
+		StripPrefix: rc.StripPrefix,
+		Regex:       rc.Regex,
+		Replacement: rc.Replacement,
+		AddPrefix:   rc.AddPrefix,
+	}
+}
+
+// MirrorConfig contains per-route traffic mirroring settings
+type MirrorConfig struct {
+	URL              string        `yaml:"url"`               // Shadow backend (empty disables mirroring)
+	Percentage       float64       `yaml:"percentage"`        // Share of requests copied, 0-100
+	Timeout          time.Duration `yaml:"timeout"`           // Per mirror request (default: server write_timeout)
+	MaxBodyBytes     int64         `yaml:"max_body_bytes"`    // Larger request bodies are not mirrored (default 1MB)
+	CompareResponses bool          `yaml:"compare_responses"` // Record whether the shadow's response differs
+	MaxConcurrent    int           `yaml:"max_concurrent"`    // In-flight copies before new ones are dropped (default 100)
+}
+
+// proxyConfig converts the mirror settings for the proxy package
+func (mc MirrorConfig) proxyConfig(route string) proxy.MirrorConfig {
+	return proxy.MirrorConfig{
+		Route:            route,
+		URL:              mc.URL,
+		Percentage:       mc.Percentage,
+		Timeout:          mc.Timeout,
+		MaxBodyBytes:     mc.MaxBodyBytes,
+		CompareResponses: mc.CompareResponses,
+		MaxConcurrent:    mc.MaxConcurrent,
+	}
+}
+
+// WebSocketConfig contains limits for WebSocket and other upgraded connections
+type WebSocketConfig struct {
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`    // Close after no traffic (default 5m)
+	MaxLifetime    time.Duration `yaml:"max_lifetime"`    // Close after this long (0 = no limit)
+	MaxConnections int           `yaml:"max_connections"` // Concurrent connections per route (0 = no limit)
+}
+
+// BackendGroupConfig is a named set of backends receiving a share of a route's traffic
+type BackendGroupConfig struct {
+	Name     string          `yaml:"name"`
+	Weight   int             `yaml:"weight"` // Percentage of traffic; all groups add up to 100
+	Backends []BackendConfig `yaml:"backends"`
+}
+
+// GroupOverrideConfig names the header and cookie that can force a backend group
+type GroupOverrideConfig struct {
+	Header string `yaml:"header"` // e.g. X-Backend-Group: canary
+	Cookie string `yaml:"cookie"`
+}
+
+// backendGroups returns the route's groups for the proxy package; plain
+// backends become a single group
+func (r RouteConfig) backendGroups() []proxy.BackendGroupConfig {
+	groups := r.Groups
+	if len(groups) == 0 {
+		groups = []BackendGroupConfig{{Name: proxy.DefaultGroupName, Weight: 100, Backends: r.Backends}}
+	}
+
+	var result []proxy.BackendGroupConfig
+	for _, group := range groups {
+		converted := proxy.BackendGroupConfig{Name: group.Name, Weight: group.Weight}
+		for _, backend := range group.Backends {
+			converted.URLs = append(converted.URLs, backend.URL)
+			converted.Weights = append(converted.Weights, backend.Weight)
+			converted.Disabled = append(converted.Disabled, backend.Disabled)
+			converted.Discovery = append(converted.Discovery, backend.Discover.proxyConfig())
+		}
+		result = append(result, converted)
+	}
+	return result
+}
+
+// displayName returns the route's name, or its path when unnamed
+func (r RouteConfig) displayName() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Path
 }
 
 // BackendConfig represents a backend server configuration
 type BackendConfig struct {
-	URL    string `yaml:"url"`
-	Weight int    `yaml:"weight"` // For weighted load balancing
+	URL      string         `yaml:"url"`
+	Weight   int            `yaml:"weight"`   // For weighted load balancing
+	Disabled bool           `yaml:"disabled"` // Kept out of rotation (can be enabled via the admin API)
+	Discover DiscoverConfig `yaml:"discover"` // Instead of url: backends reported by a discovery provider
+}
+
+// DiscoverConfig describes a discovery provider standing for a set of backends
+type DiscoverConfig struct {
+	Type     string            `yaml:"type"`     // file, http, or a provider registered with proxy.RegisterDiscovery
+	Path     string            `yaml:"path"`     // file: targets file (JSON or YAML, Prometheus file_sd format)
+	URL      string            `yaml:"url"`      // http: endpoint to poll
+	Format   string            `yaml:"format"`   // http: file_sd (default) or consul
+	Headers  map[string]string `yaml:"headers"`  // http: sent with every poll
+	Interval time.Duration     `yaml:"interval"` // Overrides discovery.interval of the route (file default 5s)
+	Options  map[string]string `yaml:"options"`  // Settings for custom providers
+}
+
+// proxyConfig converts the discovery provider settings for the proxy package
+func (dc DiscoverConfig) proxyConfig() proxy.DiscoveryProviderConfig {
+	return proxy.DiscoveryProviderConfig{
+		Type:     dc.Type,
+		Path:     dc.Path,
+		URL:      dc.URL,
+		Format:   dc.Format,
+		Headers:  dc.Headers,
+		Interval: dc.Interval,
+		Options:  dc.Options,
+	}
 }
 
 // LoadConfig loads configuration from a YAML file
@@ -94,37 +709,255 @@ func LoadConfig(filename string) (*Config, error) {
 	if config.Server.ShutdownTimeout == 0 {
 		config.Server.ShutdownTimeout = 10 * time.Second
 	}
+	if config.Server.DrainTimeout == 0 {
+		config.Server.DrainTimeout = 30 * time.Second
+	}
 	if config.Logging.Database == "" {
 		config.Logging.Database = "gateway.db"
 	}
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
 	}
+	if config.Metrics.Path == "" {
+		config.Metrics.Path = "/metrics"
+	}
+	if config.Tracing.ServiceName == "" {
+		config.Tracing.ServiceName = "api-gateway"
+	}
+	if config.Tracing.Exporter == "" {
+		config.Tracing.Exporter = "otlp"
+	}
+	if config.Tracing.Endpoint == "" {
+		config.Tracing.Endpoint = "http://localhost:4318/v1/traces"
+	}
+	if config.Tracing.File == "" {
+		config.Tracing.File = "traces.json"
+	}
+	if config.Tracing.SampleRatio == 0 {
+		config.Tracing.SampleRatio = 1
+	}
 
 	return &config, nil
 }
 
+// validateBackends checks a backend list and fills in default weights
+func validateBackends(backends []BackendConfig) error {
+	if len(backends) == 0 {
+		return fmt.Errorf("at least one backend is required")
+	}
+	for j, backend := range backends {
+		switch {
+		case backend.Discover.Type != "" && backend.URL != "":
+			return fmt.Errorf("backend %d: use either url or discover, not both", j)
+		case backend.Discover.Type != "":
+			if _, err := proxy.NewDiscovery(backend.Discover.proxyConfig()); err != nil {
+				return fmt.Errorf("backend %d: %w", j, err)
+			}
+		case backend.URL == "":
+			return fmt.Errorf("backend %d: URL is required", j)
+		}
+		if backend.Weight <= 0 {
+			backends[j].Weight = 1 // Default weight
+		}
+	}
+	return nil
+}
+
+// validateGroups checks backend group names, backends and the traffic split
+func validateGroups(groups []BackendGroupConfig) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	names := make(map[string]bool)
+	total := 0
+	for _, group := range groups {
+		if group.Name == "" {
+			return fmt.Errorf("every backend group needs a name")
+		}
+		if names[group.Name] {
+			return fmt.Errorf("duplicate backend group %q", group.Name)
+		}
+		names[group.Name] = true
+		if group.Weight < 0 || group.Weight > 100 {
+			return fmt.Errorf("group %q: weight must be between 0 and 100", group.Name)
+		}
+		total += group.Weight
+		if err := validateBackends(group.Backends); err != nil {
+			return fmt.Errorf("group %q: %w", group.Name, err)
+		}
+	}
+	if total != 100 {
+		return fmt.Errorf("backend group weights must add up to 100, got %d", total)
+	}
+	return nil
+}
+
+// validateRouteRateLimit checks a route's rate limit and its per-client policies
+func validateRouteRateLimit(rl RouteRateLimitConfig) error {
+	policies := make(map[string]bool)
+	var clients []*middleware.ClientRateLimiter
+	for i, policy := range rl.Clients {
+		limiter, err := middleware.NewClientRateLimiter(policy.middlewareConfig())
+		if err != nil {
+			return fmt.Errorf("client policy %d: %w", i, err)
+		}
+		if policies[limiter.Name()] {
+			return fmt.Errorf("duplicate client policy %q, give policies distinct names", limiter.Name())
+		}
+		policies[limiter.Name()] = true
+		clients = append(clients, limiter)
+	}
+	for method := range rl.Methods {
+		if method == "" {
+			return fmt.Errorf("methods: empty method name")
+		}
+	}
+	_, err := middleware.NewRouteRateLimiter(rl.middlewareConfig("", clients))
+	return err
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
 	if len(c.Routes) == 0 {
 		return fmt.Errorf("no routes configured")
 	}
 
+	if c.Server.DrainTimeout < 0 {
+		return fmt.Errorf("server: drain_timeout cannot be negative")
+	}
+
+	for _, proxy := range c.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("server: invalid trusted proxy %q, use an IP or CIDR", proxy)
+			}
+		}
+	}
+
+	switch c.Admin.PersistBackends {
+	case persistNone, persistConfig, persistSQLite:
+	default:
+		return fmt.Errorf("admin: unknown persist_backends %q (use \"config\" or \"sqlite\")", c.Admin.PersistBackends)
+	}
+
+	if err := c.RateLimiting.Response.middlewareConfig().Validate(); err != nil {
+		return fmt.Errorf("rate_limiting: %w", err)
+	}
+	if err := c.RateLimiting.Store.Validate(); err != nil {
+		return fmt.Errorf("rate_limiting: %w", err)
+	}
+	policies := make(map[string]bool)
+	for i, policy := range c.RateLimiting.Clients {
+		limiter, err := middleware.NewClientRateLimiter(policy.middlewareConfig())
+		if err != nil {
+			return fmt.Errorf("rate_limiting: client policy %d: %w", i, err)
+		}
+		if policies[limiter.Name()] {
+			return fmt.Errorf("rate_limiting: duplicate client policy %q, give policies distinct names", limiter.Name())
+		}
+		policies[limiter.Name()] = true
+	}
+
+	quotas := make(map[string]bool)
+	for i, policy := range c.Quotas.Policies {
+		if _, err := middleware.NewQuota(policy.middlewareConfig(), nil); err != nil {
+			return fmt.Errorf("quotas: policy %d: %w", i, err)
+		}
+		if quotas[policy.Name] {
+			return fmt.Errorf("quotas: duplicate policy %q", policy.Name)
+		}
+		quotas[policy.Name] = true
+		for _, name := range policy.Routes {
+			found := false
+			for _, route := range c.Routes {
+				found = found || name == route.displayName() || name == route.Path
+			}
+			if !found {
+				return fmt.Errorf("quotas: policy %q: unknown route %q", policy.Name, name)
+			}
+		}
+	}
+	if c.Quotas.FlushInterval < 0 {
+		return fmt.Errorf("quotas: flush_interval must not be negative")
+	}
+
+	if c.Tracing.Enabled {
+		if c.Tracing.Exporter != "otlp" && c.Tracing.Exporter != "file" {
+			return fmt.Errorf("tracing: unknown exporter %q (use \"otlp\" or \"file\")", c.Tracing.Exporter)
+		}
+		if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+			return fmt.Errorf("tracing: sample_ratio must be between 0 and 1")
+		}
+	}
+
 	for i, route := range c.Routes {
 		if route.Path == "" {
 			return fmt.Errorf("route %d: path is required", i)
 		}
-		if len(route.Backends) == 0 {
-			return fmt.Errorf("route %d: at least one backend is required", i)
+		if len(route.Backends) > 0 && len(route.Groups) > 0 {
+			return fmt.Errorf("route %d: use either backends or groups, not both", i)
 		}
-		for j, backend := range route.Backends {
-			if backend.URL == "" {
-				return fmt.Errorf("route %d, backend %d: URL is required", i, j)
+		if len(route.Groups) == 0 {
+			if err := validateBackends(route.Backends); err != nil {
+				return fmt.Errorf("route %d: %w", i, err)
 			}
-			if backend.Weight <= 0 {
-				c.Routes[i].Backends[j].Weight = 1 // Default weight
+		}
+		if err := validateGroups(route.Groups); err != nil {
+			return fmt.Errorf("route %d: %w", i, err)
+		}
+		if err := validateMatch(route.Match); err != nil {
+			return fmt.Errorf("route %d: %w", i, err)
+		}
+		if !proxy.ValidLoadBalancer(route.LoadBalancer) {
+			return fmt.Errorf("route %d: unknown load_balancer %q", i, route.LoadBalancer)
+		}
+		if route.LoadBalancer == proxy.StrategyConsistentHash {
+			hashKey := proxy.HashKeyConfig{Source: route.HashKey.Source, Name: route.HashKey.Name, Segment: route.HashKey.Segment}
+			if err := hashKey.Validate(); err != nil {
+				return fmt.Errorf("route %d: %w", i, err)
 			}
 		}
+		switch route.Auth.Type {
+		case "", "none", "api_key":
+		case "jwt":
+			if !c.Auth.JWT.enabled() {
+				return fmt.Errorf("route %d: auth type jwt requires auth.jwt keys to be configured", i)
+			}
+		default:
+			return fmt.Errorf("route %d: unknown auth type %q", i, route.Auth.Type)
+		}
+		if _, err := proxy.NewHealthChecker(route.HealthCheck.proxyConfig()); err != nil {
+			return fmt.Errorf("route %d: %w", i, err)
+		}
+		if err := route.Discovery.proxyConfig().Validate(); err != nil {
+			return fmt.Errorf("route %d: %w", i, err)
+		}
+		if err := validateRouteRateLimit(route.RateLimit); err != nil {
+			return fmt.Errorf("route %d: rate_limit: %w", i, err)
+		}
+		if err := route.Retry.proxyConfig().Validate(); err != nil {
+			return fmt.Errorf("route %d: %w", i, err)
+		}
+		if _, err := proxy.NewPathRewriter(route.Rewrite.proxyConfig()); err != nil {
+			return fmt.Errorf("route %d: %w", i, err)
+		}
+		if route.Mirror.URL != "" {
+			if _, err := proxy.NewMirror(route.Mirror.proxyConfig(""), nil); err != nil {
+				return fmt.Errorf("route %d: %w", i, err)
+			}
+		}
+		if route.WebSocket.IdleTimeout < 0 || route.WebSocket.MaxLifetime < 0 || route.WebSocket.MaxConnections < 0 {
+			return fmt.Errorf("route %d: websocket limits must not be negative", i)
+		}
+		if od := route.Outlier; od.Enabled && (od.MaxEjectionPercent < 0 || od.MaxEjectionPercent > 100) {
+			return fmt.Errorf("route %d: outlier_detection.max_ejection_percent must be between 0 and 100", i)
+		}
+		if ss := route.SlowStart; ss.Window < 0 || ss.MinWeightPercent < 0 || ss.MinWeightPercent > 100 {
+			return fmt.Errorf("route %d: slow_start.window must not be negative and min_weight_percent must be between 0 and 100", i)
+		}
+		if cb := route.CircuitBreaker; cb.Enabled && (cb.FailureRatio < 0 || cb.FailureRatio > 1) {
+			return fmt.Errorf("route %d: circuit_breaker.failure_ratio must be between 0 and 1", i)
+		}
 	}
 
 	return nil
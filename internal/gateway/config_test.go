@@ -0,0 +1,38 @@
+/*
+internal/gateway/config_test.go
+Package gateway tests the conversion of route settings from gateway.yaml.
+*/
+
+package gateway
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRetryConfigBudgetDefaults(t *testing.T) {
+	tests := []struct {
+		name        string
+		yaml        string
+		wantPercent float64
+		wantMin     int
+	}{
+		{"omitted", "attempts: 2", 20, 3},
+		{"explicit zeros", "attempts: 2\nbudget_percent: 0\nmin_retries_per_second: 0", 0, 0},
+		{"explicit values", "attempts: 2\nbudget_percent: 50\nmin_retries_per_second: 1", 50, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rc RetryConfig
+			if err := yaml.Unmarshal([]byte(tt.yaml), &rc); err != nil {
+				t.Fatal(err)
+			}
+			got := rc.proxyConfig()
+			if got.BudgetPercent != tt.wantPercent || got.MinRetriesPerSecond != tt.wantMin {
+				t.Fatalf("budget = %v%%, %d/s, want %v%%, %d/s", got.BudgetPercent, got.MinRetriesPerSecond, tt.wantPercent, tt.wantMin)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+/*
+internal/gateway/persist.go
+Package gateway provides persistence of backend changes made through the admin API.
+*/
+
+package gateway
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// backendChange is an admin change to one backend of a route
+type backendChange struct {
+	route    int    // Index of the route in the generation's config
+	label    string // Route label, identifies the route in SQLite
+	group    string // Backend group ("default" for routes without groups)
+	url      string
+	weight   int
+	disabled bool
+	added    bool
+	removed  bool
+}
+
+// persistBackendChange saves a change where admin.persist_backends says, before it is applied
+func (s *Server) persistBackendChange(gen *generation, change backendChange) error {
+	switch gen.config.Admin.PersistBackends {
+	case persistConfig:
+		return s.persistToConfigFile(gen, change)
+	case persistSQLite:
+		return s.backendStore.SaveBackendOverride(storage.BackendOverride{
+			Route:     change.label,
+			URL:       change.url,
+			Group:     change.group,
+			Weight:    change.weight,
+			Disabled:  change.disabled,
+			Removed:   change.removed,
+			UpdatedAt: time.Now(),
+		})
+	}
+	return nil
+}
+
+// persistToConfigFile edits the backend in the watched configuration file. The
+// watcher is told about the new contents so the edit does not trigger a reload
+// (must be called with reloadMu held).
+func (s *Server) persistToConfigFile(gen *generation, change backendChange) error {
+	if s.configPath == "" {
+		return fmt.Errorf("no configuration file to save to (WatchConfig was not called)")
+	}
+	data, err := os.ReadFile(s.configPath)
+	if err != nil {
+		return err
+	}
+	edited, err := editConfigBackends(data, change.route, gen.config.Routes[change.route], change)
+	if err != nil {
+		return err
+	}
+
+	// Write a temporary file and rename it, so readers never see a partial file
+	info, err := os.Stat(s.configPath)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.configPath), ".gateway-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(edited); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.configPath); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(edited)
+	s.persistedSum = sum[:]
+	return nil
+}
+
+// editConfigBackends applies a change to the YAML text of the configuration file.
+// Only the lines of the affected backend entry are touched, so comments and layout survive.
+func editConfigBackends(data []byte, routeIndex int, route RouteConfig, change backendChange) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("configuration file is empty")
+	}
+
+	changed := fmt.Errorf("configuration file changed since it was loaded, reload it first")
+	routes := mappingValue(doc.Content[0], "routes")
+	if routes == nil || routes.Kind != yaml.SequenceNode || routeIndex >= len(routes.Content) {
+		return nil, changed
+	}
+	routeNode := routes.Content[routeIndex]
+	if path := mappingValue(routeNode, "path"); path == nil || path.Value != route.Path {
+		return nil, changed
+	}
+
+	var backends *yaml.Node
+	if len(route.Groups) == 0 {
+		backends = mappingValue(routeNode, "backends")
+	} else if groups := mappingValue(routeNode, "groups"); groups != nil {
+		for _, group := range groups.Content {
+			if name := mappingValue(group, "name"); name != nil && name.Value == change.group {
+				backends = mappingValue(group, "backends")
+			}
+		}
+	}
+	if backends == nil || backends.Kind != yaml.SequenceNode || len(backends.Content) == 0 {
+		return nil, changed
+	}
+	if backends.Style&yaml.FlowStyle != 0 {
+		return nil, fmt.Errorf("backends written in flow style ([...]) cannot be edited, use one \"- url:\" entry per line")
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	entry := -1
+	for i, item := range backends.Content {
+		if value := mappingValue(item, "url"); value != nil && canonicalURL(value.Value) == change.url {
+			entry = i
+		}
+		if item.Kind != yaml.MappingNode || item.Style&yaml.FlowStyle != 0 {
+			return nil, fmt.Errorf("backend entries must be block mappings to be edited")
+		}
+	}
+
+	switch {
+	case change.added:
+		if entry >= 0 {
+			return nil, fmt.Errorf("backend %s is already in the configuration file", change.url)
+		}
+		last := backends.Content[len(backends.Content)-1]
+		keyIndent := strings.Repeat(" ", last.Column-1)
+		dashLine := lines[last.Line-1]
+		dashIndent := dashLine[:strings.IndexByte(dashLine, '-')]
+		added := []string{
+			dashIndent + "- url: " + strconv.Quote(change.url) + "\n",
+			keyIndent + "weight: " + strconv.Itoa(change.weight) + "\n",
+		}
+		if change.disabled {
+			added = append(added, keyIndent+"disabled: true\n")
+		}
+		lines = slices.Insert(lines, lastLine(last), added...)
+
+	case entry < 0:
+		return nil, fmt.Errorf("backend %s is not in the configuration file", change.url)
+
+	case change.removed:
+		item := backends.Content[entry]
+		lines = slices.Delete(lines, item.Line-1, lastLine(item))
+
+	default:
+		item := backends.Content[entry]
+		// Insert missing keys first, from the bottom, so the line numbers above stay valid
+		keyIndent := strings.Repeat(" ", item.Column-1)
+		var missing []string
+		for _, kv := range []struct{ key, value string }{
+			{"weight", strconv.Itoa(change.weight)},
+			{"disabled", strconv.FormatBool(change.disabled)},
+		} {
+			if value := mappingValue(item, kv.key); value != nil {
+				lines[value.Line-1] = replaceScalar(lines[value.Line-1], value.Column, kv.value)
+			} else if kv.key != "disabled" || change.disabled {
+				missing = append(missing, keyIndent+kv.key+": "+kv.value+"\n")
+			}
+		}
+		lines = slices.Insert(lines, lastLine(item), missing...)
+	}
+
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// canonicalURL returns a backend URL the way the proxy prints it, so that
+// spellings in the configuration file match URLs given to the admin API
+func canonicalURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return parsed.String()
+}
+
+// mappingValue returns the value of key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// lastLine returns the last line (1-based) used by a node and its children
+func lastLine(node *yaml.Node) int {
+	last := node.Line
+	for _, child := range node.Content {
+		last = max(last, lastLine(child))
+	}
+	return last
+}
+
+// replaceScalar replaces the scalar starting at column (1-based) of line with value
+func replaceScalar(line string, column int, value string) string {
+	start := column - 1
+	end := start
+	if quote := line[start]; quote == '"' || quote == '\'' {
+		end = start + 1 + strings.IndexByte(line[start+1:], quote) + 1
+	} else {
+		for end < len(line) && !strings.ContainsRune(" \t#\r\n", rune(line[end])) {
+			end++
+		}
+	}
+	return line[:start] + value + line[end:]
+}
+
+// applyBackendOverrides applies the backend changes saved in SQLite to a copy of
+// the generation's configuration, before its routes are built
+func (s *Server) applyBackendOverrides(gen *generation) {
+	if gen.config.Admin.PersistBackends != persistSQLite {
+		return
+	}
+	overrides, err := s.backendStore.ListBackendOverrides()
+	if err != nil {
+		log.Printf("Failed to load backend overrides, using the configuration file only: %v", err)
+		return
+	}
+	if len(overrides) == 0 {
+		return
+	}
+
+	// The backend lists are copied before editing, the caller keeps its config
+	config := *gen.config
+	config.Routes = slices.Clone(config.Routes)
+	gen.config = &config
+
+	for _, override := range overrides {
+		index := -1
+		for i := range gen.config.Routes {
+			if gen.routeLabel(i) == override.Route {
+				index = i
+			}
+		}
+		if index < 0 {
+			log.Printf("Ignoring backend override for unknown route %s", override.Route)
+			continue
+		}
+		route := &gen.config.Routes[index]
+
+		backends := &route.Backends
+		if len(route.Groups) > 0 {
+			route.Groups = slices.Clone(route.Groups)
+			backends = nil
+			for j := range route.Groups {
+				if route.Groups[j].Name == override.Group {
+					backends = &route.Groups[j].Backends
+				}
+			}
+			if backends == nil {
+				log.Printf("Ignoring backend override for unknown group %s of route %s", override.Group, override.Route)
+				continue
+			}
+		}
+		*backends = slices.Clone(*backends)
+
+		entry := slices.IndexFunc(*backends, func(b BackendConfig) bool { return canonicalURL(b.URL) == override.URL })
+		switch {
+		case override.Removed && entry >= 0:
+			if len(*backends) == 1 {
+				log.Printf("Ignoring removal of %s from route %s, it is the last backend", override.URL, override.Route)
+				continue
+			}
+			*backends = slices.Delete(*backends, entry, entry+1)
+		case override.Removed:
+		case entry >= 0:
+			(*backends)[entry].Weight = override.Weight
+			(*backends)[entry].Disabled = override.Disabled
+		default:
+			*backends = append(*backends, BackendConfig{URL: override.URL, Weight: override.Weight, Disabled: override.Disabled})
+		}
+	}
+}
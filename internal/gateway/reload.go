@@ -0,0 +1,246 @@
+/*
+internal/gateway/reload.go
+Package gateway provides hot reloading of the gateway configuration.
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReloadStatus describes the outcome of the most recent configuration reload
+type ReloadStatus struct {
+	Generation    int64     `json:"generation"`
+	LoadedAt      time.Time `json:"loaded_at"`
+	ConfigPath    string    `json:"config_path,omitempty"`
+	LastAttemptAt time.Time `json:"last_attempt_at,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	Reloads       int       `json:"reloads"`
+	Failures      int       `json:"failures"`
+}
+
+// WatchConfig reloads the configuration from path on SIGHUP and whenever the file changes.
+// The file is polled every interval (defaults to 2s).
+func (s *Server) WatchConfig(path string, interval time.Duration) {
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	s.reloadMu.Lock()
+	s.configPath = path
+	s.reloadMu.Unlock()
+
+	s.reloadStatusMu.Lock()
+	s.reloadStatus.ConfigPath = path
+	s.reloadStatusMu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopWatch = cancel
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(sighup)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		lastSum, _ := fileChecksum(path)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sighup:
+				log.Printf("Received SIGHUP, reloading %s", path)
+				s.Reload()
+				lastSum, _ = fileChecksum(path)
+			case <-ticker.C:
+				// Compare contents rather than mtime so editors that rewrite the
+				// file without changing it don't trigger a reload
+				sum, err := fileChecksum(path)
+				if err != nil || bytes.Equal(sum, lastSum) {
+					continue
+				}
+				lastSum = sum
+				if s.isPersistedWrite(sum) {
+					continue
+				}
+				log.Printf("Configuration file %s changed, reloading", path)
+				s.Reload()
+			}
+		}
+	}()
+
+	log.Printf("Watching %s for configuration changes (SIGHUP or file change)", path)
+}
+
+// Reload loads, validates and activates the configuration file. On failure the
+// current configuration stays live and the error is recorded in the reload status.
+func (s *Server) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if s.configPath == "" {
+		return fmt.Errorf("no configuration file to reload (WatchConfig was not called)")
+	}
+
+	err := s.reloadLocked()
+
+	s.reloadStatusMu.Lock()
+	s.reloadStatus.LastAttemptAt = time.Now()
+	if err != nil {
+		s.reloadStatus.Failures++
+		s.reloadStatus.LastError = err.Error()
+	} else {
+		gen := s.gen()
+		s.reloadStatus.Reloads++
+		s.reloadStatus.LastError = ""
+		s.reloadStatus.Generation = gen.id
+		s.reloadStatus.LoadedAt = gen.loadedAt
+	}
+	s.reloadStatusMu.Unlock()
+
+	if err != nil {
+		log.Printf("Configuration reload rejected, keeping current configuration: %v", err)
+	}
+	return err
+}
+
+// reloadLocked builds and swaps in a new generation (must be called with reloadMu held)
+func (s *Server) reloadLocked() error {
+	config, err := LoadConfig(s.configPath)
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	s.warnRestartRequired(config)
+
+	gen, err := s.buildGeneration(config)
+	if err != nil {
+		return err
+	}
+
+	// Health check the new backends before they receive traffic
+	gen.start()
+
+	// The new generation loads quota counts from the store, so it must see
+	// the requests the old one counted
+	if old := s.gen(); old.quotaTracker != nil {
+		old.quotaTracker.Flush()
+	}
+	old := s.current.Swap(gen)
+	log.Printf("Configuration generation %d is live (%d routes)", gen.id, len(config.Routes))
+
+	s.retiringMu.Lock()
+	s.retiring[old] = struct{}{}
+	s.retiringMu.Unlock()
+	go s.retireGeneration(old)
+	return nil
+}
+
+// retireGeneration stops the old generation's health checks once its in-flight requests
+// finish. Requests still running after the drain timeout (typically WebSocket tunnels)
+// are not waited for: stopping the generation closes its tunnels.
+func (s *Server) retireGeneration(old *generation) {
+	defer func() {
+		s.retiringMu.Lock()
+		delete(s.retiring, old)
+		s.retiringMu.Unlock()
+	}()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var deadline <-chan time.Time
+	if timeout := s.config.Server.DrainTimeout; timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+drain:
+	for old.inFlight.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			log.Printf("Configuration generation %d still has %d requests in flight after %s, closing its tunnels",
+				old.id, old.inFlight.Load(), s.config.Server.DrainTimeout)
+			break drain
+		}
+	}
+	old.stop()
+	log.Printf("Configuration generation %d retired", old.id)
+}
+
+// warnRestartRequired logs settings that cannot change without restarting the process
+func (s *Server) warnRestartRequired(config *Config) {
+	// Trusted proxies belong to the router, so they change with the generation
+	server, current := config.Server, s.config.Server
+	server.TrustedProxies, current.TrustedProxies = nil, nil
+	if !reflect.DeepEqual(server, current) {
+		log.Printf("WARNING: server settings changed; they take effect after a restart")
+	}
+	if config.Logging != s.config.Logging {
+		log.Printf("WARNING: logging settings changed; they take effect after a restart")
+	}
+	if config.Metrics != s.config.Metrics {
+		log.Printf("WARNING: metrics settings changed; they take effect after a restart")
+	}
+	if !reflect.DeepEqual(config.Tracing, s.config.Tracing) {
+		log.Printf("WARNING: tracing settings changed; they take effect after a restart")
+	}
+}
+
+// handleReloadStatus reports the active generation and the last reload outcome
+func (s *Server) handleReloadStatus(c *gin.Context) {
+	s.reloadStatusMu.RLock()
+	status := s.reloadStatus
+	s.reloadStatusMu.RUnlock()
+
+	c.JSON(http.StatusOK, status)
+}
+
+// handleReload triggers a reload from the watched configuration file
+func (s *Server) handleReload(c *gin.Context) {
+	if err := s.Reload(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	s.handleReloadStatus(c)
+}
+
+// isPersistedWrite reports whether the file contents were written by the gateway
+// itself when saving a backend change, which needs no reload
+func (s *Server) isPersistedWrite(sum []byte) bool {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	return s.persistedSum != nil && bytes.Equal(sum, s.persistedSum)
+}
+
+// fileChecksum returns the SHA-256 of a file's contents
+func fileChecksum(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
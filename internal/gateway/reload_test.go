@@ -0,0 +1,267 @@
+/*
+internal/gateway/reload_test.go
+Package gateway tests hot reloading and the draining of replaced configurations.
+*/
+
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/storage"
+)
+
+// newTestServer builds a Server from a YAML configuration written to a temporary
+// file, so Reload can read it again, and serves it over httptest
+func newTestServer(t *testing.T, yaml string) (*Server, *httptest.Server) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gateway.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewSQLiteStorage(filepath.Join(dir, "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(config, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.configPath = path
+	// Shutdown expects the server started by Start; an unstarted one stops at once
+	s.httpServer = &http.Server{}
+
+	ts := httptest.NewServer(s)
+	t.Cleanup(func() {
+		ts.Close()
+		s.Shutdown(context.Background())
+		store.Close()
+	})
+	return s, ts
+}
+
+// retiringCount returns the number of replaced generations still draining
+func retiringCount(s *Server) int {
+	s.retiringMu.Lock()
+	defer s.retiringMu.Unlock()
+	return len(s.retiring)
+}
+
+// waitFor polls cond until it holds or fails the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// reloadConfig returns a configuration proxying /api and /ws to backend
+func reloadConfig(backend string, drainTimeout time.Duration) string {
+	return fmt.Sprintf(`
+server:
+  drain_timeout: %s
+logging:
+  database: "gateway.db"
+routes:
+  - path: "/api/*filepath"
+    backends:
+      - url: %q
+  - path: "/ws/*filepath"
+    backends:
+      - url: %q
+`, drainTimeout, backend, backend)
+}
+
+func TestReloadWaitsForInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/slow" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	s, ts := newTestServer(t, reloadConfig(backend.URL, 5*time.Second))
+	old := s.gen()
+
+	done := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(ts.URL + "/api/slow")
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	<-started
+
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if s.gen() == old {
+		t.Fatal("Reload() did not swap the generation")
+	}
+	if retiringCount(s) != 1 {
+		t.Fatalf("%d generations retiring, want 1", retiringCount(s))
+	}
+
+	// New requests go to the new generation while the old one drains
+	resp, err := http.Get(ts.URL + "/api/fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("request during drain: status %d", resp.StatusCode)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if retiringCount(s) != 1 {
+		t.Fatal("old generation retired while a request was in flight")
+	}
+
+	close(release)
+	if status := <-done; status != http.StatusOK {
+		t.Fatalf("in-flight request finished with status %d, want 200", status)
+	}
+	waitFor(t, "the old generation to retire", func() bool { return retiringCount(s) == 0 })
+}
+
+func TestRetiringTunnelsAreClosed(t *testing.T) {
+	tests := []struct {
+		name         string
+		drainTimeout time.Duration
+		// after runs once the tunnel is open and the configuration was reloaded
+		after func(s *Server)
+	}{
+		{"drain timeout", 200 * time.Millisecond, func(*Server) {}},
+		{"shutdown", time.Hour, func(s *Server) { s.Shutdown(context.Background()) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The backend switches protocols and then holds the connection open
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, buf, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+				buf.Flush()
+				buf.ReadByte()
+			}))
+			defer backend.Close()
+
+			s, ts := newTestServer(t, reloadConfig(backend.URL, tt.drainTimeout))
+
+			conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			fmt.Fprintf(conn, "GET /ws/chat HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+			reader := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("handshake status %d, want 101", resp.StatusCode)
+			}
+
+			if err := s.Reload(); err != nil {
+				t.Fatal(err)
+			}
+			tt.after(s)
+
+			// The gateway closes the client side of the tunnel
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := reader.ReadByte(); err == nil {
+				t.Fatal("read data from a tunnel that should be closed")
+			} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("tunnel still open after the old generation should have stopped")
+			}
+			waitFor(t, "the old generation to retire", func() bool { return retiringCount(s) == 0 })
+		})
+	}
+}
+
+func TestReloadStatus(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	initial := reloadConfig(backend.URL, time.Second)
+
+	tests := []struct {
+		name   string
+		config string
+		// wantNew reports whether the reload must take effect
+		wantNew bool
+		// newRoute is served only once the new configuration is live
+		newRoute string
+	}{
+		{"route added", initial + fmt.Sprintf("  - path: \"/v2/*filepath\"\n    backends:\n      - url: %q\n", backend.URL), true, "/v2/users"},
+		{"invalid yaml", initial + "  - path: [\n", false, ""},
+		{"no routes", "server:\n  port: 8080\n", false, ""},
+		{"invalid trusted proxy", strings.Replace(initial, "server:\n", "server:\n  trusted_proxies: [\"proxy\"]\n", 1), false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ts := newTestServer(t, initial)
+			old := s.gen()
+			if err := os.WriteFile(s.configPath, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			reloadErr := s.Reload()
+
+			status := s.reloadStatus
+			if tt.wantNew {
+				if reloadErr != nil {
+					t.Fatalf("Reload() = %v", reloadErr)
+				}
+				if s.gen() == old || status.Reloads != 1 || status.LastError != "" {
+					t.Fatalf("reload not applied: %+v", status)
+				}
+				if code, _ := get(t, ts.URL+tt.newRoute); code != http.StatusOK {
+					t.Fatalf("GET %s after reload: status %d", tt.newRoute, code)
+				}
+				return
+			}
+			if reloadErr == nil {
+				t.Fatal("Reload() accepted an invalid configuration")
+			}
+			if s.gen() != old {
+				t.Fatal("rejected reload replaced the live configuration")
+			}
+			if status.Failures != 1 || status.LastError == "" {
+				t.Fatalf("reload status %+v, want one failure with its error", status)
+			}
+			if code, _ := get(t, ts.URL+"/api/users"); code != http.StatusOK {
+				t.Fatalf("GET /api/users after a rejected reload: status %d", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+/*
+internal/gateway/routing.go
+Package gateway provides host, header, query and cookie matching for routes sharing a path.
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routeMatcher checks a route's match conditions against a request
+type routeMatcher struct {
+	hosts   []string // Lowercased; "*.example.com" matches any subdomain
+	headers map[string]string
+	query   map[string]string
+	cookies map[string]string
+}
+
+// newRouteMatcher builds a matcher from the route's match block
+func newRouteMatcher(config MatchConfig) *routeMatcher {
+	m := &routeMatcher{
+		headers: config.Headers,
+		query:   config.Query,
+		cookies: config.Cookies,
+	}
+	for _, host := range config.Hosts {
+		m.hosts = append(m.hosts, strings.ToLower(host))
+	}
+	return m
+}
+
+// matches reports whether the request satisfies every condition.
+// A condition value of "*" only requires the header, parameter or cookie to be present.
+func (m *routeMatcher) matches(req *http.Request) bool {
+	if len(m.hosts) > 0 && !matchHost(m.hosts, requestHost(req)) {
+		return false
+	}
+	for name, expected := range m.headers {
+		values := req.Header.Values(name)
+		if len(values) == 0 || (expected != "*" && !containsString(values, expected)) {
+			return false
+		}
+	}
+	if len(m.query) > 0 {
+		query := req.URL.Query()
+		for name, expected := range m.query {
+			values, ok := query[name]
+			if !ok || (expected != "*" && !containsString(values, expected)) {
+				return false
+			}
+		}
+	}
+	for name, expected := range m.cookies {
+		cookie, err := req.Cookie(name)
+		if err != nil || (expected != "*" && cookie.Value != expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestHost returns the lowercased request host without port
+func requestHost(req *http.Request) string {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// matchHost reports whether host equals one of the patterns or is a subdomain of a wildcard pattern
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// specificity counts the conditions, so that at equal priority a route with
+// more conditions is tried before a catch-all
+func (m *routeMatcher) specificity() int {
+	n := len(m.headers) + len(m.query) + len(m.cookies)
+	if len(m.hosts) > 0 {
+		n++
+	}
+	return n
+}
+
+// containsString reports whether values contains s
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMatch checks a route's match block
+func validateMatch(config MatchConfig) error {
+	for _, host := range config.Hosts {
+		if host == "" {
+			return fmt.Errorf("match.hosts must not contain empty entries")
+		}
+		if strings.Contains(host, "*") && (!strings.HasPrefix(host, "*.") || strings.Count(host, "*") > 1) {
+			return fmt.Errorf("invalid host pattern %q (wildcards must look like *.example.com)", host)
+		}
+	}
+	return nil
+}
+
+// routeCandidate is one route registered on a method and path
+type routeCandidate struct {
+	matcher  *routeMatcher
+	priority int
+	order    int // Position in the config file, breaks remaining ties
+	handlers []gin.HandlerFunc
+}
+
+// routeDispatcher serves a single method and path for every route declaring it.
+// Gin allows one handler chain per method and path, so routes that share a path
+// but differ in host, headers, query or cookies are told apart here.
+type routeDispatcher struct {
+	candidates []*routeCandidate
+}
+
+// add registers a route, keeping candidates ordered by priority, specificity, then config order
+func (d *routeDispatcher) add(candidate *routeCandidate) {
+	d.candidates = append(d.candidates, candidate)
+	sort.SliceStable(d.candidates, func(i, j int) bool {
+		a, b := d.candidates[i], d.candidates[j]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if sa, sb := a.matcher.specificity(), b.matcher.specificity(); sa != sb {
+			return sa > sb
+		}
+		return a.order < b.order
+	})
+}
+
+// handle runs the handler chain of the first matching route.
+// Route handlers run in sequence and must not depend on c.Next.
+func (d *routeDispatcher) handle(c *gin.Context) {
+	for _, candidate := range d.candidates {
+		if !candidate.matcher.matches(c.Request) {
+			continue
+		}
+		for _, handler := range candidate.handlers {
+			handler(c)
+			if c.IsAborted() {
+				return
+			}
+		}
+		return
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{
+		"error": "No route matched",
+	})
+}
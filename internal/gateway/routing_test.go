@@ -0,0 +1,145 @@
+/*
+internal/gateway/routing_test.go
+Package gateway tests host, header, query and cookie route matching.
+*/
+
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRouteMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		match   MatchConfig
+		host    string
+		target  string
+		headers map[string][]string
+		want    bool
+	}{
+		{"no conditions", MatchConfig{}, "any.example.com", "/", nil, true},
+		{"exact host", MatchConfig{Hosts: []string{"api.example.com"}}, "api.example.com", "/", nil, true},
+		{"host is case insensitive", MatchConfig{Hosts: []string{"API.example.com"}}, "Api.Example.COM", "/", nil, true},
+		{"host with port", MatchConfig{Hosts: []string{"api.example.com"}}, "api.example.com:8080", "/", nil, true},
+		{"host with trailing dot", MatchConfig{Hosts: []string{"api.example.com"}}, "api.example.com.", "/", nil, true},
+		{"other host", MatchConfig{Hosts: []string{"api.example.com"}}, "www.example.com", "/", nil, false},
+		{"wildcard subdomain", MatchConfig{Hosts: []string{"*.example.com"}}, "tenant.example.com", "/", nil, true},
+		{"wildcard nested subdomain", MatchConfig{Hosts: []string{"*.example.com"}}, "a.b.example.com", "/", nil, true},
+		{"wildcard excludes apex", MatchConfig{Hosts: []string{"*.example.com"}}, "example.com", "/", nil, false},
+		{"wildcard excludes lookalike", MatchConfig{Hosts: []string{"*.example.com"}}, "badexample.com", "/", nil, false},
+		{"any of several hosts", MatchConfig{Hosts: []string{"a.example.com", "b.example.com"}}, "b.example.com", "/", nil, true},
+		{"header value", MatchConfig{Headers: map[string]string{"X-Version": "2"}}, "h", "/", map[string][]string{"X-Version": {"2"}}, true},
+		{"header name is case insensitive", MatchConfig{Headers: map[string]string{"x-version": "2"}}, "h", "/", map[string][]string{"X-Version": {"2"}}, true},
+		{"header value mismatch", MatchConfig{Headers: map[string]string{"X-Version": "2"}}, "h", "/", map[string][]string{"X-Version": {"1"}}, false},
+		{"one of repeated headers", MatchConfig{Headers: map[string]string{"X-Version": "2"}}, "h", "/", map[string][]string{"X-Version": {"1", "2"}}, true},
+		{"header presence", MatchConfig{Headers: map[string]string{"X-Beta": "*"}}, "h", "/", map[string][]string{"X-Beta": {"anything"}}, true},
+		{"header missing", MatchConfig{Headers: map[string]string{"X-Beta": "*"}}, "h", "/", nil, false},
+		{"query value", MatchConfig{Query: map[string]string{"version": "2"}}, "h", "/?version=2", nil, true},
+		{"query presence", MatchConfig{Query: map[string]string{"debug": "*"}}, "h", "/?debug", nil, true},
+		{"query missing", MatchConfig{Query: map[string]string{"debug": "*"}}, "h", "/?other=1", nil, false},
+		{"cookie value", MatchConfig{Cookies: map[string]string{"canary": "true"}}, "h", "/", map[string][]string{"Cookie": {"canary=true"}}, true},
+		{"cookie mismatch", MatchConfig{Cookies: map[string]string{"canary": "true"}}, "h", "/", map[string][]string{"Cookie": {"canary=false"}}, false},
+		{"cookie presence", MatchConfig{Cookies: map[string]string{"session": "*"}}, "h", "/", map[string][]string{"Cookie": {"session=abc"}}, true},
+		{"all conditions", MatchConfig{Hosts: []string{"api.example.com"}, Headers: map[string]string{"X-Version": "2"}, Query: map[string]string{"beta": "1"}},
+			"api.example.com", "/?beta=1", map[string][]string{"X-Version": {"2"}}, true},
+		{"all conditions but one", MatchConfig{Hosts: []string{"api.example.com"}, Headers: map[string]string{"X-Version": "2"}, Query: map[string]string{"beta": "1"}},
+			"api.example.com", "/?beta=0", map[string][]string{"X-Version": {"2"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Host = tt.host
+			for name, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+			if got := newRouteMatcher(tt.match).matches(req); got != tt.want {
+				t.Fatalf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMatch(t *testing.T) {
+	tests := []struct {
+		hosts   []string
+		wantErr bool
+	}{
+		{[]string{"api.example.com"}, false},
+		{[]string{"*.example.com"}, false},
+		{[]string{""}, true},
+		{[]string{"api.*.com"}, true},
+		{[]string{"*example.com"}, true},
+		{[]string{"*.*.example.com"}, true},
+	}
+
+	for _, tt := range tests {
+		if err := validateMatch(MatchConfig{Hosts: tt.hosts}); (err != nil) != tt.wantErr {
+			t.Errorf("validateMatch(%v) error = %v, wantErr %v", tt.hosts, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRouteDispatcherOrder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// respond returns a handler writing the route name
+	respond := func(name string) []gin.HandlerFunc {
+		return []gin.HandlerFunc{func(c *gin.Context) { c.String(http.StatusOK, name) }}
+	}
+
+	d := &routeDispatcher{}
+	d.add(&routeCandidate{matcher: newRouteMatcher(MatchConfig{}), order: 0, handlers: respond("catch-all")})
+	d.add(&routeCandidate{matcher: newRouteMatcher(MatchConfig{Hosts: []string{"*.example.com"}}), order: 1, handlers: respond("wildcard")})
+	d.add(&routeCandidate{matcher: newRouteMatcher(MatchConfig{Hosts: []string{"*.example.com"}, Headers: map[string]string{"X-Beta": "*"}}), order: 2, handlers: respond("beta")})
+	d.add(&routeCandidate{matcher: newRouteMatcher(MatchConfig{Query: map[string]string{"legacy": "1"}}), priority: 10, order: 3, handlers: respond("legacy")})
+	d.add(&routeCandidate{matcher: newRouteMatcher(MatchConfig{Hosts: []string{"*.example.com"}}), order: 4, handlers: respond("shadowed")})
+
+	tests := []struct {
+		name   string
+		host   string
+		target string
+		beta   bool
+		want   string
+	}{
+		{"catch-all", "other.org", "/", false, "catch-all"},
+		{"more specific route first", "a.example.com", "/", true, "beta"},
+		{"fewer conditions", "a.example.com", "/", false, "wildcard"},
+		{"priority beats specificity", "a.example.com", "/?legacy=1", true, "legacy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Host = tt.host
+			if tt.beta {
+				req.Header.Set("X-Beta", "1")
+			}
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = req
+			d.handle(c)
+			if got := rec.Body.String(); got != tt.want {
+				t.Fatalf("handled by %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Without a catch-all, an unmatched request gets a 404
+	d = &routeDispatcher{}
+	d.add(&routeCandidate{matcher: newRouteMatcher(MatchConfig{Hosts: []string{"api.example.com"}}), handlers: respond("api")})
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	d.handle(c)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+}
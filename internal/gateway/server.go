@@ -10,21 +10,66 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/AndreaBozzo/go-lab/internal/metrics"
 	"github.com/AndreaBozzo/go-lab/internal/middleware"
 	"github.com/AndreaBozzo/go-lab/internal/proxy"
 	"github.com/AndreaBozzo/go-lab/internal/storage"
+	"github.com/AndreaBozzo/go-lab/internal/tracing"
 	"github.com/gin-gonic/gin"
 )
 
 // Server represents the API Gateway server
 type Server struct {
-	config      *Config
-	router      *gin.Engine
-	httpServer  *http.Server
+	config       *Config // Configuration the server was started with (listener and telemetry settings)
+	httpServer   *http.Server
+	storage      storage.LogStorage
+	metrics      *metrics.GatewayMetrics
+	tracer       *tracing.Tracer
+	apiKeys      storage.APIKeyStore
+	mirrorStore  storage.MirrorStore    // nil when the storage cannot record mirror results
+	backendStore storage.BackendStore   // nil when the storage cannot persist backend changes
+	counterStore storage.RateLimitStore // nil when the storage cannot hold rate limit counters
+	quotaStore   storage.QuotaStore     // nil when the storage cannot hold quota usage
+
+	// current is the generation serving requests; reloads swap it atomically
+	current        atomic.Pointer[generation]
+	generationSeq  int64
+	reloadMu       sync.Mutex
+	configPath     string
+	reloadStatus   ReloadStatus
+	reloadStatusMu sync.RWMutex
+	stopWatch      context.CancelFunc
+	persistedSum   []byte // Checksum of the last backend change written to the config file
+
+	// retiring holds replaced generations until their requests drain, so
+	// Shutdown can stop them too
+	retiring   map[*generation]struct{}
+	retiringMu sync.Mutex
+}
+
+// generation is a router and its route proxies built from one configuration.
+// A generation is never modified after it starts serving; reloads build a new one.
+type generation struct {
+	id           int64
+	config       *Config
+	router       *gin.Engine
 	routeProxies []*proxy.RouteProxy
-	storage     storage.LogStorage
+	rateLimiter  *middleware.RateLimiter
+	clientLimits []*middleware.ClientRateLimiter // Per-client policies, applied after route auth
+	routeLimits  []*middleware.RouteRateLimiter  // One per route with a limit or client policies
+	sharedLimits *middleware.SharedRateLimits    // nil when limits are token buckets in memory
+	respStore    *storage.RESPRateLimitStore     // Closed with the generation
+	quotas       []*middleware.Quota             // In config order
+	quotaTracker *middleware.QuotaTracker        // nil without quota policies
+	jwtValidator *middleware.JWTValidator
+	loadedAt     time.Time
+	inFlight     atomic.Int64
+	stopOnce     sync.Once
 }
 
 // NewServer creates a new API Gateway server
@@ -37,6 +82,72 @@ func NewServer(config *Config, store storage.LogStorage) (*Server, error) {
 	// Set Gin mode
 	gin.SetMode(gin.ReleaseMode)
 
+	server := &Server{
+		config:   config,
+		storage:  store,
+		retiring: make(map[*generation]struct{}),
+	}
+
+	if config.Metrics.Enabled {
+		server.metrics = metrics.NewGatewayMetrics()
+		server.registerBackendMetrics()
+	}
+
+	if config.Tracing.Enabled {
+		tracer, err := newTracer(config.Tracing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up tracing: %w", err)
+		}
+		server.tracer = tracer
+	}
+
+	// API keys live in the same database as the logs when the store supports them.
+	// Lookups are cached; the admin API changes keys through the cache.
+	if keyStore, ok := store.(storage.APIKeyStore); ok {
+		server.apiKeys = middleware.NewAPIKeyCache(keyStore)
+	}
+	if mirrorStore, ok := store.(storage.MirrorStore); ok {
+		server.mirrorStore = mirrorStore
+	}
+	if backendStore, ok := store.(storage.BackendStore); ok {
+		server.backendStore = backendStore
+	}
+	if counterStore, ok := store.(storage.RateLimitStore); ok {
+		server.counterStore = counterStore
+	}
+	if quotaStore, ok := store.(storage.QuotaStore); ok {
+		server.quotaStore = quotaStore
+	}
+
+	// Build and activate the first generation
+	gen, err := server.buildGeneration(config)
+	if err != nil {
+		return nil, err
+	}
+	gen.start()
+	server.current.Store(gen)
+	server.reloadStatus = ReloadStatus{Generation: gen.id, LoadedAt: gen.loadedAt}
+
+	return server, nil
+}
+
+// buildGeneration creates a router and route proxies for config without starting health checks
+func (s *Server) buildGeneration(config *Config) (*generation, error) {
+	for _, route := range config.Routes {
+		if route.Auth.Type == "api_key" && s.apiKeys == nil {
+			return nil, fmt.Errorf("route %s: api_key auth requires a storage backend with API key support", route.Path)
+		}
+	}
+	if config.Admin.PersistBackends == persistSQLite && s.backendStore == nil {
+		return nil, fmt.Errorf("admin: persist_backends sqlite requires a storage backend with backend override support")
+	}
+	if config.RateLimiting.Store.Type == rateLimitStoreSQLite && s.counterStore == nil {
+		return nil, fmt.Errorf("rate_limiting: store sqlite requires a storage backend with rate limit counter support")
+	}
+	if len(config.Quotas.Policies) > 0 && s.quotaStore == nil {
+		return nil, fmt.Errorf("quotas: a storage backend with quota support is required")
+	}
+
 	// Create router
 	router := gin.New()
 
@@ -44,105 +155,351 @@ func NewServer(config *Config, store storage.LogStorage) (*Server, error) {
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	server := &Server{
-		config:  config,
-		router:  router,
-		storage: store,
+	// Clients can send any X-Forwarded-For, so it only counts from trusted
+	// proxies; otherwise the client IP is the connection's address
+	if err := router.SetTrustedProxies(config.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("server: trusted_proxies: %w", err)
+	}
+
+	s.generationSeq++
+	gen := &generation{
+		id:       s.generationSeq,
+		config:   config,
+		router:   router,
+		loadedAt: time.Now(),
+	}
+	s.applyBackendOverrides(gen)
+
+	if config.Auth.JWT.enabled() {
+		validator, err := newJWTValidator(config.Auth.JWT)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up JWT authentication: %w", err)
+		}
+		gen.jwtValidator = validator
 	}
 
 	// Setup middleware and routes
-	if err := server.setupMiddleware(); err != nil {
+	if err := s.setupMiddleware(gen); err != nil {
 		return nil, err
 	}
-	if err := server.setupRoutes(); err != nil {
+	if err := s.setupRoutes(gen); err != nil {
 		return nil, err
 	}
 
-	return server, nil
+	return gen, nil
+}
+
+// start begins health checking for all route proxies of the generation
+func (g *generation) start() {
+	for _, rp := range g.routeProxies {
+		rp.Start()
+	}
+	if g.sharedLimits != nil {
+		g.sharedLimits.Start()
+	}
+	if g.quotaTracker != nil {
+		g.quotaTracker.Start()
+	}
+}
+
+// stop stops health checking for all route proxies of the generation. Both a
+// drain timeout and Shutdown may stop a retiring generation; only the first call counts.
+func (g *generation) stop() {
+	g.stopOnce.Do(g.stopComponents)
+}
+
+// stopComponents closes the generation's tunnels, health checks and counters
+func (g *generation) stopComponents() {
+	for _, rp := range g.routeProxies {
+		rp.Stop()
+	}
+	if g.sharedLimits != nil {
+		g.sharedLimits.Stop()
+	}
+	if g.respStore != nil {
+		g.respStore.Close()
+	}
+	if g.quotaTracker != nil {
+		g.quotaTracker.Stop()
+	}
+}
+
+// ServeHTTP dispatches the request to the current generation's router
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gen := s.acquire()
+	defer gen.inFlight.Add(-1)
+	gen.router.ServeHTTP(w, r)
+}
+
+// acquire counts a request in the current generation's in-flight requests and
+// returns it. A reload may swap and retire the generation between loading it and
+// counting the request, so the request retries on the new one if it changed.
+func (s *Server) acquire() *generation {
+	for {
+		gen := s.current.Load()
+		gen.inFlight.Add(1)
+		if s.current.Load() == gen {
+			return gen
+		}
+		gen.inFlight.Add(-1)
+	}
+}
+
+// routeLabel identifies route i in admin output: its name or path, with the
+// index appended when several routes would otherwise share the label
+func (g *generation) routeLabel(i int) string {
+	label := g.config.Routes[i].displayName()
+	for j, route := range g.config.Routes {
+		if j != i && route.displayName() == label {
+			return fmt.Sprintf("%s#%d", label, i)
+		}
+	}
+	return label
+}
+
+// gen returns the generation currently serving requests
+func (s *Server) gen() *generation {
+	return s.current.Load()
 }
 
 // setupMiddleware configures all middleware in the correct order
-func (s *Server) setupMiddleware() error {
+func (s *Server) setupMiddleware(gen *generation) error {
 	// 1. Recovery middleware (should be first to catch all panics)
-	s.router.Use(middleware.RecoveryMiddleware())
+	gen.router.Use(middleware.RecoveryMiddleware())
+
+	// 2. Tracing middleware (if enabled), early so the server span covers everything else
+	if s.tracer != nil {
+		gen.router.Use(middleware.TracingMiddleware(s.tracer))
+	}
 
-	// 2. CORS middleware (if enabled)
-	if s.config.CORS.Enabled {
+	// 3. CORS middleware (if enabled)
+	if gen.config.CORS.Enabled {
 		corsConfig := middleware.CORSConfig{
-			AllowedOrigins: s.config.CORS.AllowedOrigins,
-			AllowedMethods: s.config.CORS.AllowedMethods,
-			AllowedHeaders: s.config.CORS.AllowedHeaders,
+			AllowedOrigins: gen.config.CORS.AllowedOrigins,
+			AllowedMethods: gen.config.CORS.AllowedMethods,
+			AllowedHeaders: gen.config.CORS.AllowedHeaders,
 		}
-		s.router.Use(middleware.CORSMiddleware(corsConfig))
+		gen.router.Use(middleware.CORSMiddleware(corsConfig))
 	}
 
-	// 3. Logging middleware
-	s.router.Use(middleware.LoggingMiddleware(s.storage))
+	// 4. Metrics middleware (if enabled)
+	if s.metrics != nil {
+		gen.router.Use(middleware.MetricsMiddleware(s.metrics))
+	}
 
-	// 4. Global rate limiting (if enabled)
-	if s.config.RateLimiting.Enabled {
-		limiter := middleware.NewRateLimiter(
-			s.config.RateLimiting.RequestsPerSecond,
-			s.config.RateLimiting.Burst,
-		)
-		s.router.Use(middleware.RateLimitMiddleware(limiter))
+	// 5. Logging middleware
+	gen.router.Use(middleware.LoggingMiddleware(s.storage))
+
+	// 6. Global rate limiting (if enabled)
+	if err := s.setupRateLimitStore(gen); err != nil {
+		return fmt.Errorf("failed to set up the rate limit store: %w", err)
+	}
+	if gen.config.RateLimiting.Enabled && gen.config.RateLimiting.RequestsPerSecond > 0 {
+		if gen.sharedLimits != nil {
+			limiter, err := middleware.NewSharedRateLimiter(
+				gen.sharedLimits,
+				gen.config.RateLimiting.RequestsPerSecond,
+				gen.config.RateLimiting.Burst,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to set up rate limiting: %w", err)
+			}
+			gen.rateLimiter = limiter
+		} else {
+			gen.rateLimiter = middleware.NewRateLimiter(
+				gen.config.RateLimiting.RequestsPerSecond,
+				gen.config.RateLimiting.Burst,
+			)
+		}
+		gen.router.Use(middleware.RateLimitMiddleware(gen.rateLimiter, gen.config.RateLimiting.Response.middlewareConfig()))
+	}
+
+	// Per-client policies need the client identity, so routes run them after authentication
+	if gen.config.RateLimiting.Enabled {
+		for _, policy := range gen.config.RateLimiting.Clients {
+			config := policy.middlewareConfig()
+			config.Shared = gen.sharedLimits
+			limiter, err := middleware.NewClientRateLimiter(config)
+			if err != nil {
+				return fmt.Errorf("failed to set up client rate limiting: %w", err)
+			}
+			gen.clientLimits = append(gen.clientLimits, limiter)
+		}
+	}
+
+	// Quotas, like client policies, run in the routes after authentication
+	if len(gen.config.Quotas.Policies) > 0 {
+		gen.quotaTracker = middleware.NewQuotaTracker(s.quotaStore, gen.config.Quotas.FlushInterval)
+		for _, policy := range gen.config.Quotas.Policies {
+			quota, err := middleware.NewQuota(policy.middlewareConfig(), gen.quotaTracker)
+			if err != nil {
+				return fmt.Errorf("failed to set up quota %s: %w", policy.Name, err)
+			}
+			gen.quotas = append(gen.quotas, quota)
+		}
 	}
 
 	return nil
 }
 
+// routeRateLimiter creates the limiter of route i, or nil if no limit applies to it.
+// Route client policies replace global policies of the same name.
+func (s *Server) routeRateLimiter(gen *generation, i int) (*middleware.RouteRateLimiter, error) {
+	config := gen.config.Routes[i].RateLimit
+	if !config.enabled() && len(gen.clientLimits) == 0 {
+		return nil, nil
+	}
+
+	var own []*middleware.ClientRateLimiter
+	replaced := make(map[string]bool)
+	for _, policy := range config.Clients {
+		clientConfig := policy.middlewareConfig()
+		clientConfig.Shared = gen.sharedLimits
+		clientConfig.SharedScope = gen.routeLabel(i)
+		limiter, err := middleware.NewClientRateLimiter(clientConfig)
+		if err != nil {
+			return nil, err
+		}
+		own = append(own, limiter)
+		replaced[limiter.Name()] = true
+	}
+	var clients []*middleware.ClientRateLimiter
+	for _, limiter := range gen.clientLimits {
+		if !replaced[limiter.Name()] {
+			clients = append(clients, limiter)
+		}
+	}
+	clients = append(clients, own...)
+
+	routeConfig := config.middlewareConfig(gen.routeLabel(i), clients)
+	routeConfig.Shared = gen.sharedLimits
+	return middleware.NewRouteRateLimiter(routeConfig)
+}
+
+// setupRateLimitStore creates the shared counters of the generation when limits
+// are counted in a RateLimitStore
+func (s *Server) setupRateLimitStore(gen *generation) error {
+	config := gen.config.RateLimiting.Store
+	if !config.shared() {
+		return nil
+	}
+
+	var store storage.RateLimitStore
+	switch config.Type {
+	case rateLimitStoreSQLite:
+		store = s.counterStore
+	case rateLimitStoreRedis:
+		respStore, err := storage.NewRESPRateLimitStore(config.respConfig())
+		if err != nil {
+			return err
+		}
+		gen.respStore = respStore
+		store = respStore
+	default:
+		store = storage.NewMemoryRateLimitStore()
+	}
+	gen.sharedLimits = middleware.NewSharedRateLimits(store, config.SyncInterval)
+	return nil
+}
+
+// clientPolicy is a per-client limiter with its metrics label; policies
+// defined on a route are labelled route/name
+type clientPolicy struct {
+	label   string
+	limiter *middleware.ClientRateLimiter
+}
+
+// clientPolicies returns the global and route-level per-client limiters
+func (g *generation) clientPolicies() []clientPolicy {
+	global := make(map[*middleware.ClientRateLimiter]bool)
+	var policies []clientPolicy
+	for _, limiter := range g.clientLimits {
+		global[limiter] = true
+		policies = append(policies, clientPolicy{label: limiter.Name(), limiter: limiter})
+	}
+	for _, routeLimit := range g.routeLimits {
+		for _, limiter := range routeLimit.Clients() {
+			if !global[limiter] {
+				policies = append(policies, clientPolicy{label: routeLimit.Name() + "/" + limiter.Name(), limiter: limiter})
+			}
+		}
+	}
+	return policies
+}
+
 // setupRoutes configures all routes from the configuration
-func (s *Server) setupRoutes() error {
+func (s *Server) setupRoutes(gen *generation) error {
 	// Health check endpoint
-	s.router.GET("/health", func(c *gin.Context) {
+	gen.router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"status": "healthy",
 			"time":   time.Now().Format(time.RFC3339),
 		})
 	})
 
-	// Admin endpoint to view backend status
-	s.router.GET("/admin/backends", func(c *gin.Context) {
-		backends := make(map[string]interface{})
-		for i, rp := range s.routeProxies {
-			routeBackends := []map[string]interface{}{}
-			for _, backend := range rp.GetPool().GetAllBackends() {
-				routeBackends = append(routeBackends, map[string]interface{}{
-					"url":     backend.GetURL().String(),
-					"healthy": backend.IsHealthy(),
-					"weight":  backend.Weight,
-				})
-			}
-			backends[s.config.Routes[i].Path] = routeBackends
-		}
-		c.JSON(http.StatusOK, gin.H{
-			"backends": backends,
-		})
-	})
+	// Prometheus scrape endpoint
+	if s.metrics != nil {
+		gen.router.GET(gen.config.Metrics.Path, gin.WrapH(s.metrics.Registry.Handler()))
+	}
 
-	// Configure proxy routes
-	for _, routeConfig := range s.config.Routes {
-		// Extract backend URLs and weights
-		var backendURLs []string
-		var weights []int
-		for _, backend := range routeConfig.Backends {
-			backendURLs = append(backendURLs, backend.URL)
-			weights = append(weights, backend.Weight)
-		}
+	// Admin endpoints
+	s.setupAdminRoutes(gen, gen.router.Group("/admin", middleware.AdminAuth(gen.config.Admin.Token)))
+
+	// Routes sharing a method and path are served by one dispatcher
+	dispatchers := make(map[string]*routeDispatcher)
 
+	// Configure proxy routes
+	for i, routeConfig := range gen.config.Routes {
 		// Create route proxy
+		groups := routeConfig.backendGroups()
+		cb := routeConfig.CircuitBreaker
 		routeProxy, err := proxy.NewRouteProxy(
-			backendURLs,
-			weights,
-			s.config.Server.WriteTimeout,
+			groups,
+			gen.config.Server.WriteTimeout,
+			proxy.RouteOptions{
+				LoadBalancer: routeConfig.LoadBalancer,
+				HashKey: proxy.HashKeyConfig{
+					Source:  routeConfig.HashKey.Source,
+					Name:    routeConfig.HashKey.Name,
+					Segment: routeConfig.HashKey.Segment,
+				},
+				StickySession: routeConfig.StickySession.proxyConfig(gen.routeLabel(i)),
+				CircuitBreaker: proxy.CircuitBreakerConfig{
+					Enabled:        cb.Enabled,
+					FailureRatio:   cb.FailureRatio,
+					MinRequests:    cb.MinRequests,
+					Window:         cb.Window,
+					OpenDuration:   cb.OpenDuration,
+					HalfOpenProbes: cb.HalfOpenProbes,
+				},
+				HealthCheck:      routeConfig.HealthCheck.proxyConfig(),
+				OutlierDetection: routeConfig.Outlier.proxyConfig(),
+				SlowStart: proxy.SlowStartConfig{
+					Window:           routeConfig.SlowStart.Window,
+					MinWeightPercent: routeConfig.SlowStart.MinWeightPercent,
+				},
+				Discovery: routeConfig.Discovery.proxyConfig(),
+				Retry:     routeConfig.Retry.proxyConfig(),
+				Rewrite:   routeConfig.Rewrite.proxyConfig(),
+				Mirror:    routeConfig.Mirror.proxyConfig(gen.routeLabel(i)),
+				WebSocket: proxy.WebSocketConfig{
+					IdleTimeout:    routeConfig.WebSocket.IdleTimeout,
+					MaxLifetime:    routeConfig.WebSocket.MaxLifetime,
+					MaxConnections: routeConfig.WebSocket.MaxConnections,
+				},
+				GroupOverride: proxy.GroupOverrideConfig{
+					Header: routeConfig.GroupOverride.Header,
+					Cookie: routeConfig.GroupOverride.Cookie,
+				},
+				MirrorStore: s.mirrorStore,
+			},
 		)
 		if err != nil {
 			return fmt.Errorf("failed to create proxy for route %s: %w", routeConfig.Path, err)
 		}
 
-		// Start health checks for this route
-		routeProxy.Start()
-		s.routeProxies = append(s.routeProxies, routeProxy)
+		// Health checks are started once the whole generation has been built
+		gen.routeProxies = append(gen.routeProxies, routeProxy)
 
 		// Register route handlers for each method
 		if len(routeConfig.Methods) == 0 {
@@ -150,23 +507,267 @@ func (s *Server) setupRoutes() error {
 			routeConfig.Methods = []string{"GET", "POST", "PUT", "DELETE", "PATCH"}
 		}
 
+		// Route-level middleware runs before the proxy handler
+		var handlers []gin.HandlerFunc
+		switch routeConfig.Auth.Type {
+		case "jwt":
+			handlers = append(handlers, middleware.JWTAuth(gen.jwtValidator, middleware.JWTPolicy{
+				RequiredClaims: routeConfig.Auth.RequiredClaims,
+				Scopes:         routeConfig.Auth.Scopes,
+				ForwardClaims:  routeConfig.Auth.ForwardClaims,
+			}))
+		case "api_key":
+			handlers = append(handlers, middleware.APIKeyAuth(s.apiKeys, routeConfig.Path, routeConfig.Auth.APIKeyHeader))
+		}
+		if routeLimit, err := s.routeRateLimiter(gen, i); err != nil {
+			return fmt.Errorf("failed to set up rate limiting for route %s: %w", routeConfig.Path, err)
+		} else if routeLimit != nil {
+			gen.routeLimits = append(gen.routeLimits, routeLimit)
+			handlers = append(handlers, middleware.RouteRateLimitMiddleware(routeLimit, gen.config.RateLimiting.Response.middlewareConfig()))
+		}
+		var quotas []*middleware.Quota
+		for j, policy := range gen.config.Quotas.Policies {
+			if policy.appliesTo(routeConfig) {
+				quotas = append(quotas, gen.quotas[j])
+			}
+		}
+		if len(quotas) > 0 {
+			handlers = append(handlers, middleware.QuotaMiddleware(quotas, gen.config.RateLimiting.Response.middlewareConfig()))
+		}
+		handlers = append(handlers, routeProxy.Handler())
+
+		candidate := &routeCandidate{
+			matcher:  newRouteMatcher(routeConfig.Match),
+			priority: routeConfig.Priority,
+			order:    i,
+			handlers: handlers,
+		}
 		for _, method := range routeConfig.Methods {
-			s.router.Handle(method, routeConfig.Path, routeProxy.Handler())
+			key := method + " " + routeConfig.Path
+			dispatcher, ok := dispatchers[key]
+			if !ok {
+				dispatcher = &routeDispatcher{}
+				dispatchers[key] = dispatcher
+				gen.router.Handle(method, routeConfig.Path, dispatcher.handle)
+			}
+			dispatcher.add(candidate)
 		}
 
-		log.Printf("Registered route: %s -> %v", routeConfig.Path, backendURLs)
+		if len(routeConfig.Match.Hosts) > 0 {
+			log.Printf("Registered route: %s (hosts %v) -> %s", routeConfig.Path, routeConfig.Match.Hosts, describeGroups(groups))
+		} else {
+			log.Printf("Registered route: %s -> %s", routeConfig.Path, describeGroups(groups))
+		}
 	}
 
 	return nil
 }
 
+// describeGroups formats a route's backends for the startup log
+func describeGroups(groups []proxy.BackendGroupConfig) string {
+	if len(groups) == 1 {
+		return fmt.Sprint(groups[0].URLs)
+	}
+	var parts []string
+	for _, group := range groups {
+		parts = append(parts, fmt.Sprintf("%s %d%% %v", group.Name, group.Weight, group.URLs))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// newTracer creates a tracer with the configured exporter
+func newTracer(cfg TracingConfig) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch cfg.Exporter {
+	case "file":
+		fileExporter, err := tracing.NewFileExporter(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		exporter = fileExporter
+	default:
+		exporter = tracing.NewOTLPHTTPExporter(cfg.Endpoint, cfg.Headers, 10*time.Second)
+	}
+
+	return tracing.NewTracer(tracing.Config{
+		ServiceName:   cfg.ServiceName,
+		SampleRatio:   cfg.SampleRatio,
+		BatchSize:     cfg.BatchSize,
+		FlushInterval: cfg.FlushInterval,
+	}, exporter), nil
+}
+
+// newJWTValidator creates a JWT validator from the gateway configuration
+func newJWTValidator(cfg JWTConfig) (*middleware.JWTValidator, error) {
+	var keys []middleware.JWTKeyConfig
+	for _, key := range cfg.PublicKeys {
+		keys = append(keys, middleware.JWTKeyConfig{
+			KeyID: key.KeyID,
+			File:  key.File,
+			PEM:   key.PEM,
+		})
+	}
+
+	return middleware.NewJWTValidator(middleware.JWTConfig{
+		Issuer:              cfg.Issuer,
+		Audience:            cfg.Audience,
+		Algorithms:          cfg.Algorithms,
+		HMACSecret:          cfg.HMACSecret,
+		PublicKeys:          keys,
+		JWKSURL:             cfg.JWKSURL,
+		JWKSFile:            cfg.JWKSFile,
+		JWKSRefreshInterval: cfg.JWKSRefreshInterval,
+		ClockSkew:           cfg.ClockSkew,
+	})
+}
+
+// registerBackendMetrics registers gauges that are computed from the backend pools at scrape time
+func (s *Server) registerBackendMetrics() {
+	s.metrics.Registry.Register(metrics.NewGaugeFunc(
+		"gateway_backend_healthy",
+		"Whether a backend is currently healthy (1) or not (0).",
+		[]string{"route", "backend"},
+		func() []metrics.Sample {
+			var samples []metrics.Sample
+			gen := s.gen()
+			for i, rp := range gen.routeProxies {
+				for _, group := range rp.Groups() {
+					for _, backend := range group.Pool().GetAllBackends() {
+						value := 0.0
+						if backend.IsHealthy() {
+							value = 1
+						}
+						samples = append(samples, metrics.Sample{
+							LabelValues: []string{gen.config.Routes[i].Path, backend.GetURL().String()},
+							Value:       value,
+						})
+					}
+				}
+			}
+			return samples
+		},
+	))
+
+	s.metrics.Registry.Register(metrics.NewGaugeFunc(
+		"gateway_backend_pool_size",
+		"Number of backends in a route's pool, by health.",
+		[]string{"route", "state"},
+		func() []metrics.Sample {
+			var samples []metrics.Sample
+			gen := s.gen()
+			for i, rp := range gen.routeProxies {
+				total, healthy := 0, 0
+				for _, group := range rp.Groups() {
+					total += len(group.Pool().GetAllBackends())
+					healthy += len(group.Pool().GetHealthyBackends())
+				}
+				path := gen.config.Routes[i].Path
+				samples = append(samples,
+					metrics.Sample{LabelValues: []string{path, "healthy"}, Value: float64(healthy)},
+					metrics.Sample{LabelValues: []string{path, "unhealthy"}, Value: float64(total - healthy)},
+				)
+			}
+			return samples
+		},
+	))
+
+	s.metrics.Registry.Register(metrics.NewGaugeFunc(
+		"gateway_upgraded_connections",
+		"Number of open WebSocket and other upgraded connections.",
+		[]string{"route"},
+		func() []metrics.Sample {
+			var samples []metrics.Sample
+			gen := s.gen()
+			for i, rp := range gen.routeProxies {
+				samples = append(samples, metrics.Sample{
+					LabelValues: []string{gen.config.Routes[i].Path},
+					Value:       float64(rp.UpgradedConnections()),
+				})
+			}
+			return samples
+		},
+	))
+
+	s.metrics.Registry.Register(metrics.NewCounterFunc(
+		"gateway_ratelimit_rejections_total",
+		"Total number of requests rejected by rate limiting.",
+		[]string{"scope"},
+		func() []metrics.Sample {
+			gen := s.gen()
+			var samples []metrics.Sample
+			if gen.rateLimiter != nil {
+				samples = append(samples, metrics.Sample{LabelValues: []string{"global"}, Value: float64(gen.rateLimiter.Rejected())})
+			}
+			for _, routeLimit := range gen.routeLimits {
+				samples = append(samples, metrics.Sample{LabelValues: []string{"route:" + routeLimit.Name()}, Value: float64(routeLimit.Rejected())})
+			}
+			for _, policy := range gen.clientPolicies() {
+				samples = append(samples, metrics.Sample{LabelValues: []string{"client:" + policy.label}, Value: float64(policy.limiter.Rejected())})
+			}
+			return samples
+		},
+	))
+
+	s.metrics.Registry.Register(metrics.NewCounterFunc(
+		"gateway_ratelimit_store_sync_errors_total",
+		"Total number of failed syncs with the shared rate limit store.",
+		nil,
+		func() []metrics.Sample {
+			shared := s.gen().sharedLimits
+			if shared == nil {
+				return nil
+			}
+			return []metrics.Sample{{Value: float64(shared.SyncErrors())}}
+		},
+	))
+
+	s.metrics.Registry.Register(metrics.NewCounterFunc(
+		"gateway_quota_rejections_total",
+		"Total number of requests rejected because a quota was used up.",
+		[]string{"policy"},
+		func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, quota := range s.gen().quotas {
+				samples = append(samples, metrics.Sample{LabelValues: []string{quota.Name()}, Value: float64(quota.Rejected())})
+			}
+			return samples
+		},
+	))
+
+	s.metrics.Registry.Register(metrics.NewGaugeFunc(
+		"gateway_ratelimit_clients",
+		"Number of clients with a token bucket or shared window counters in memory, per client policy.",
+		[]string{"policy"},
+		func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, policy := range s.gen().clientPolicies() {
+				samples = append(samples, metrics.Sample{LabelValues: []string{policy.label}, Value: float64(policy.limiter.Clients())})
+			}
+			return samples
+		},
+	))
+
+	s.metrics.Registry.Register(metrics.NewCounterFunc(
+		"gateway_ratelimit_client_evictions_total",
+		"Total number of client buckets dropped because max_clients was reached.",
+		[]string{"policy"},
+		func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, policy := range s.gen().clientPolicies() {
+				samples = append(samples, metrics.Sample{LabelValues: []string{policy.label}, Value: float64(policy.limiter.Evicted())})
+			}
+			return samples
+		},
+	))
+}
+
 // Start starts the HTTP server
 func (s *Server) Start() error {
 	addr := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.Port)
 
 	s.httpServer = &http.Server{
 		Addr:         addr,
-		Handler:      s.router,
+		Handler:      s,
 		ReadTimeout:  s.config.Server.ReadTimeout,
 		WriteTimeout: s.config.Server.WriteTimeout,
 	}
@@ -183,16 +784,34 @@ func (s *Server) Start() error {
 func (s *Server) Shutdown(ctx context.Context) error {
 	log.Println("Shutting down API Gateway...")
 
-	// Stop health checks for all route proxies
-	for _, rp := range s.routeProxies {
-		rp.Stop()
+	// Stop watching the configuration file
+	if s.stopWatch != nil {
+		s.stopWatch()
 	}
 
 	// Shutdown HTTP server
-	if err := s.httpServer.Shutdown(ctx); err != nil {
+	err := s.httpServer.Shutdown(ctx)
+
+	// Stop health checks and flush quota and rate limit counts once requests
+	// have drained. Generations still draining after a reload close their
+	// tunnels here too.
+	s.gen().stop()
+	s.retiringMu.Lock()
+	for gen := range s.retiring {
+		gen.stop()
+	}
+	s.retiringMu.Unlock()
+	if err != nil {
 		return fmt.Errorf("failed to shutdown server: %w", err)
 	}
 
+	// Flush remaining spans once no more requests can start new ones
+	if s.tracer != nil {
+		if err := s.tracer.Shutdown(ctx); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}
+
 	log.Println("API Gateway stopped gracefully")
 	return nil
 }
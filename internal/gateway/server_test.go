@@ -0,0 +1,78 @@
+/*
+internal/gateway/server_test.go
+Package gateway tests the metrics endpoint of the assembled gateway.
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// get fetches url and returns the status and body
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer backend.Close()
+
+	_, ts := newTestServer(t, fmt.Sprintf(`
+logging:
+  database: "gateway.db"
+metrics:
+  enabled: true
+  path: "/metrics"
+routes:
+  - path: "/api/*filepath"
+    backends:
+      - url: %q
+`, backend.URL))
+
+	for _, path := range []string{"/api/users", "/api/users", "/api/missing", "/unknown"} {
+		get(t, ts.URL+path)
+	}
+
+	status, body := get(t, ts.URL+"/metrics")
+	if status != http.StatusOK {
+		t.Fatalf("GET /metrics: status %d", status)
+	}
+
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"requests by route pattern", fmt.Sprintf(`gateway_requests_total{route="/api/*filepath",method="GET",status_class="2xx",backend=%q} 2`, backend.URL)},
+		{"backend errors", fmt.Sprintf(`gateway_requests_total{route="/api/*filepath",method="GET",status_class="4xx",backend=%q} 1`, backend.URL)},
+		{"unmatched paths share one label", `gateway_requests_total{route="unmatched",method="GET",status_class="4xx",backend=""} 1`},
+		{"latency histogram", fmt.Sprintf(`gateway_request_duration_seconds_count{route="/api/*filepath",method="GET",status_class="2xx",backend=%q} 2`, backend.URL)},
+		{"backend health", fmt.Sprintf(`gateway_backend_healthy{route="/api/*filepath",backend=%q} 1`, backend.URL)},
+		{"pool size", `gateway_backend_pool_size{route="/api/*filepath",state="healthy"} 1`},
+		{"upgraded connections", `gateway_upgraded_connections{route="/api/*filepath"} 0`},
+	}
+	for _, tt := range tests {
+		if !strings.Contains(body, tt.line+"\n") {
+			t.Errorf("%s: /metrics lacks %s", tt.name, tt.line)
+		}
+	}
+}
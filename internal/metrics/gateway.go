@@ -0,0 +1,50 @@
+/*
+internal/metrics/gateway.go
+Package metrics provides the request metrics exported by the API Gateway.
+*/
+
+package metrics
+
+// GatewayMetrics groups the metric families updated on the request path
+type GatewayMetrics struct {
+	Registry        *Registry
+	RequestsTotal   *CounterVec
+	RequestDuration *HistogramVec
+	InFlight        *GaugeVec
+}
+
+// NewGatewayMetrics creates the gateway request metrics and registers them in a new registry
+func NewGatewayMetrics() *GatewayMetrics {
+	gm := &GatewayMetrics{
+		Registry: NewRegistry(),
+		RequestsTotal: NewCounterVec(
+			"gateway_requests_total",
+			"Total number of HTTP requests handled by the gateway.",
+			"route", "method", "status_class", "backend",
+		),
+		RequestDuration: NewHistogramVec(
+			"gateway_request_duration_seconds",
+			"HTTP request latency in seconds.",
+			DefaultBuckets,
+			"route", "method", "status_class", "backend",
+		),
+		InFlight: NewGaugeVec(
+			"gateway_requests_in_flight",
+			"Number of HTTP requests currently being served.",
+		),
+	}
+
+	gm.Registry.Register(gm.RequestsTotal)
+	gm.Registry.Register(gm.RequestDuration)
+	gm.Registry.Register(gm.InFlight)
+
+	return gm
+}
+
+// StatusClass maps a status code to its class label ("2xx", "5xx", ...)
+func StatusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "unknown"
+	}
+	return string(rune('0'+statusCode/100)) + "xx"
+}
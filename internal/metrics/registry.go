@@ -0,0 +1,477 @@
+/*
+internal/metrics/registry.go
+Package metrics provides a minimal Prometheus text exposition registry.
+*/
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default latency histogram buckets in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Collector is implemented by every metric family the registry can expose
+type Collector interface {
+	// Write writes the family in Prometheus text format
+	Write(w io.Writer) error
+}
+
+// Registry holds metric families and renders them for scraping
+type Registry struct {
+	collectors []Collector
+	mu         sync.RWMutex
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a collector to the registry
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText writes all registered families in Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range r.collectors {
+		if err := c.Write(bw); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler returns an HTTP handler serving the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.WriteText(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Sample is a single labelled value returned by function-backed metrics
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// series holds the label values of one time series
+type series struct {
+	labelValues []string
+}
+
+// metricFamily holds the metadata shared by all metric types
+type metricFamily struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+}
+
+// writeHeader writes the HELP and TYPE lines
+func (f *metricFamily) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n",
+		f.name, escapeHelp(f.help), f.name, f.metricType)
+	return err
+}
+
+// seriesKey joins label values into a map key
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// checkLabels panics on label cardinality mismatch, which is always a programming error
+func (f *metricFamily) checkLabels(values []string) {
+	if len(values) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", f.name, len(f.labelNames), len(values)))
+	}
+}
+
+// CounterVec is a family of counters partitioned by labels
+type CounterVec struct {
+	metricFamily
+	values map[string]*counterSeries
+	mu     sync.RWMutex
+}
+
+type counterSeries struct {
+	series
+	value float64
+	mu    sync.Mutex
+}
+
+// NewCounterVec creates a new counter family
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{
+		metricFamily: metricFamily{name: name, help: help, metricType: "counter", labelNames: labelNames},
+		values:       make(map[string]*counterSeries),
+	}
+}
+
+// Inc increments the counter for the given label values by one
+func (cv *CounterVec) Inc(labelValues ...string) {
+	cv.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values (negative deltas are ignored)
+func (cv *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	s := cv.get(labelValues)
+	s.mu.Lock()
+	s.value += delta
+	s.mu.Unlock()
+}
+
+func (cv *CounterVec) get(labelValues []string) *counterSeries {
+	cv.checkLabels(labelValues)
+	key := seriesKey(labelValues)
+
+	cv.mu.RLock()
+	s, ok := cv.values[key]
+	cv.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	if s, ok = cv.values[key]; !ok {
+		s = &counterSeries{series: series{labelValues: append([]string(nil), labelValues...)}}
+		cv.values[key] = s
+	}
+	return s
+}
+
+// Write implements Collector
+func (cv *CounterVec) Write(w io.Writer) error {
+	if err := cv.writeHeader(w); err != nil {
+		return err
+	}
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+	for _, key := range sortedKeys(cv.values) {
+		s := cv.values[key]
+		s.mu.Lock()
+		value := s.value
+		s.mu.Unlock()
+		if err := writeSample(w, cv.name, cv.labelNames, s.labelValues, nil, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GaugeVec is a family of gauges partitioned by labels
+type GaugeVec struct {
+	metricFamily
+	values map[string]*gaugeSeries
+	mu     sync.RWMutex
+}
+
+type gaugeSeries struct {
+	series
+	value float64
+	mu    sync.Mutex
+}
+
+// NewGaugeVec creates a new gauge family
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{
+		metricFamily: metricFamily{name: name, help: help, metricType: "gauge", labelNames: labelNames},
+		values:       make(map[string]*gaugeSeries),
+	}
+}
+
+// Set sets the gauge for the given label values
+func (gv *GaugeVec) Set(value float64, labelValues ...string) {
+	s := gv.get(labelValues)
+	s.mu.Lock()
+	s.value = value
+	s.mu.Unlock()
+}
+
+// Add adds delta (which may be negative) to the gauge for the given label values
+func (gv *GaugeVec) Add(delta float64, labelValues ...string) {
+	s := gv.get(labelValues)
+	s.mu.Lock()
+	s.value += delta
+	s.mu.Unlock()
+}
+
+// Inc increments the gauge by one
+func (gv *GaugeVec) Inc(labelValues ...string) {
+	gv.Add(1, labelValues...)
+}
+
+// Dec decrements the gauge by one
+func (gv *GaugeVec) Dec(labelValues ...string) {
+	gv.Add(-1, labelValues...)
+}
+
+func (gv *GaugeVec) get(labelValues []string) *gaugeSeries {
+	gv.checkLabels(labelValues)
+	key := seriesKey(labelValues)
+
+	gv.mu.RLock()
+	s, ok := gv.values[key]
+	gv.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	gv.mu.Lock()
+	defer gv.mu.Unlock()
+	if s, ok = gv.values[key]; !ok {
+		s = &gaugeSeries{series: series{labelValues: append([]string(nil), labelValues...)}}
+		gv.values[key] = s
+	}
+	return s
+}
+
+// Write implements Collector
+func (gv *GaugeVec) Write(w io.Writer) error {
+	if err := gv.writeHeader(w); err != nil {
+		return err
+	}
+	gv.mu.RLock()
+	defer gv.mu.RUnlock()
+	for _, key := range sortedKeys(gv.values) {
+		s := gv.values[key]
+		s.mu.Lock()
+		value := s.value
+		s.mu.Unlock()
+		if err := writeSample(w, gv.name, gv.labelNames, s.labelValues, nil, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistogramVec is a family of histograms partitioned by labels
+type HistogramVec struct {
+	metricFamily
+	buckets []float64
+	values  map[string]*histogramSeries
+	mu      sync.RWMutex
+}
+
+type histogramSeries struct {
+	series
+	counts []uint64 // Non-cumulative count per bucket, last entry is +Inf
+	sum    float64
+	count  uint64
+	mu     sync.Mutex
+}
+
+// NewHistogramVec creates a new histogram family (nil buckets means DefaultBuckets)
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	return &HistogramVec{
+		metricFamily: metricFamily{name: name, help: help, metricType: "histogram", labelNames: labelNames},
+		buckets:      sorted,
+		values:       make(map[string]*histogramSeries),
+	}
+}
+
+// Observe records a value for the given label values
+func (hv *HistogramVec) Observe(value float64, labelValues ...string) {
+	s := hv.get(labelValues)
+	idx := sort.SearchFloat64s(hv.buckets, value)
+
+	s.mu.Lock()
+	s.counts[idx]++
+	s.sum += value
+	s.count++
+	s.mu.Unlock()
+}
+
+func (hv *HistogramVec) get(labelValues []string) *histogramSeries {
+	hv.checkLabels(labelValues)
+	key := seriesKey(labelValues)
+
+	hv.mu.RLock()
+	s, ok := hv.values[key]
+	hv.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	if s, ok = hv.values[key]; !ok {
+		s = &histogramSeries{
+			series: series{labelValues: append([]string(nil), labelValues...)},
+			counts: make([]uint64, len(hv.buckets)+1),
+		}
+		hv.values[key] = s
+	}
+	return s
+}
+
+// Write implements Collector
+func (hv *HistogramVec) Write(w io.Writer) error {
+	if err := hv.writeHeader(w); err != nil {
+		return err
+	}
+	hv.mu.RLock()
+	defer hv.mu.RUnlock()
+	for _, key := range sortedKeys(hv.values) {
+		s := hv.values[key]
+		s.mu.Lock()
+		counts := append([]uint64(nil), s.counts...)
+		sum, count := s.sum, s.count
+		s.mu.Unlock()
+
+		var cumulative uint64
+		for i, upper := range hv.buckets {
+			cumulative += counts[i]
+			le := []string{"le", formatFloat(upper)}
+			if err := writeSample(w, hv.name+"_bucket", hv.labelNames, s.labelValues, le, float64(cumulative)); err != nil {
+				return err
+			}
+		}
+		if err := writeSample(w, hv.name+"_bucket", hv.labelNames, s.labelValues, []string{"le", "+Inf"}, float64(count)); err != nil {
+			return err
+		}
+		if err := writeSample(w, hv.name+"_sum", hv.labelNames, s.labelValues, nil, sum); err != nil {
+			return err
+		}
+		if err := writeSample(w, hv.name+"_count", hv.labelNames, s.labelValues, nil, float64(count)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FuncVec is a family whose samples are computed at scrape time
+type FuncVec struct {
+	metricFamily
+	collect func() []Sample
+}
+
+// NewGaugeFunc creates a gauge family computed by collect on every scrape
+func NewGaugeFunc(name, help string, labelNames []string, collect func() []Sample) *FuncVec {
+	return &FuncVec{
+		metricFamily: metricFamily{name: name, help: help, metricType: "gauge", labelNames: labelNames},
+		collect:      collect,
+	}
+}
+
+// NewCounterFunc creates a counter family computed by collect on every scrape
+func NewCounterFunc(name, help string, labelNames []string, collect func() []Sample) *FuncVec {
+	return &FuncVec{
+		metricFamily: metricFamily{name: name, help: help, metricType: "counter", labelNames: labelNames},
+		collect:      collect,
+	}
+}
+
+// Write implements Collector
+func (fv *FuncVec) Write(w io.Writer) error {
+	if err := fv.writeHeader(w); err != nil {
+		return err
+	}
+	for _, sample := range fv.collect() {
+		fv.checkLabels(sample.LabelValues)
+		if err := writeSample(w, fv.name, fv.labelNames, sample.LabelValues, nil, sample.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSample writes one sample line, with an optional extra label pair (used for "le")
+func writeSample(w io.Writer, name string, labelNames, labelValues, extra []string, value float64) error {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labelNames) > 0 || len(extra) > 0 {
+		b.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labelName)
+			b.WriteString(`="`)
+			b.WriteString(escapeLabelValue(labelValues[i]))
+			b.WriteByte('"')
+		}
+		if len(extra) == 2 {
+			if len(labelNames) > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(extra[0])
+			b.WriteString(`="`)
+			b.WriteString(extra[1])
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatFloat formats a value the way Prometheus expects
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// escapeLabelValue escapes backslashes, quotes and newlines in label values
+func escapeLabelValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return strings.ReplaceAll(v, "\n", `\n`)
+}
+
+// escapeHelp escapes backslashes and newlines in help text
+func escapeHelp(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	return strings.ReplaceAll(v, "\n", `\n`)
+}
+
+// sortedKeys returns map keys in a stable order so scrapes are deterministic
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
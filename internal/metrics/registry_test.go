@@ -0,0 +1,133 @@
+/*
+internal/metrics/registry_test.go
+Package metrics tests the Prometheus text exposition of the registry.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	tests := []struct {
+		name      string
+		collector func() Collector
+		want      string
+	}{
+		{
+			"counter",
+			func() Collector {
+				cv := NewCounterVec("requests_total", "Requests.", "method")
+				cv.Inc("GET")
+				cv.Add(2, "POST")
+				cv.Add(-5, "POST") // Ignored, counters only go up
+				cv.Inc("GET")
+				return cv
+			},
+			"# HELP requests_total Requests.\n# TYPE requests_total counter\n" +
+				"requests_total{method=\"GET\"} 2\n" +
+				"requests_total{method=\"POST\"} 2\n",
+		},
+		{
+			"gauge without labels",
+			func() Collector {
+				gv := NewGaugeVec("in_flight", "In flight.")
+				gv.Inc()
+				gv.Inc()
+				gv.Dec()
+				return gv
+			},
+			"# HELP in_flight In flight.\n# TYPE in_flight gauge\nin_flight 1\n",
+		},
+		{
+			"histogram buckets are cumulative and inclusive",
+			func() Collector {
+				hv := NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1}, "route")
+				hv.Observe(0.05, "/a")
+				hv.Observe(0.1, "/a")
+				hv.Observe(3, "/a")
+				return hv
+			},
+			"# HELP latency_seconds Latency.\n# TYPE latency_seconds histogram\n" +
+				"latency_seconds_bucket{route=\"/a\",le=\"0.1\"} 2\n" +
+				"latency_seconds_bucket{route=\"/a\",le=\"1\"} 2\n" +
+				"latency_seconds_bucket{route=\"/a\",le=\"+Inf\"} 3\n" +
+				"latency_seconds_sum{route=\"/a\"} 3.15\n" +
+				"latency_seconds_count{route=\"/a\"} 3\n",
+		},
+		{
+			"label values and help are escaped",
+			func() Collector {
+				cv := NewCounterVec("odd_total", "Line one\nline two \\ end.", "path")
+				cv.Inc("a\"b\\c\nd")
+				return cv
+			},
+			"# HELP odd_total Line one\\nline two \\\\ end.\n# TYPE odd_total counter\n" +
+				"odd_total{path=\"a\\\"b\\\\c\\nd\"} 1\n",
+		},
+		{
+			"function computed at scrape time",
+			func() Collector {
+				return NewGaugeFunc("pool_size", "Pool size.", []string{"state"}, func() []Sample {
+					return []Sample{{LabelValues: []string{"healthy"}, Value: 2}, {LabelValues: []string{"unhealthy"}, Value: 0}}
+				})
+			},
+			"# HELP pool_size Pool size.\n# TYPE pool_size gauge\n" +
+				"pool_size{state=\"healthy\"} 2\n" +
+				"pool_size{state=\"unhealthy\"} 0\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewRegistry()
+			registry.Register(tt.collector())
+
+			w := httptest.NewRecorder()
+			registry.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+				t.Fatalf("Content-Type = %q", ct)
+			}
+			if got := w.Body.String(); got != tt.want {
+				t.Fatalf("exposition:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{101, "1xx"},
+		{200, "2xx"},
+		{304, "3xx"},
+		{429, "4xx"},
+		{503, "5xx"},
+		{0, "unknown"},
+		{600, "unknown"},
+	}
+
+	for _, tt := range tests {
+		if got := StatusClass(tt.status); got != tt.want {
+			t.Errorf("StatusClass(%d) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestLabelCardinalityMismatchPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Inc() with the wrong number of label values did not panic")
+		}
+	}()
+	NewCounterVec("requests_total", "Requests.", "method", "route").Inc("GET")
+}
@@ -0,0 +1,261 @@
+/*
+internal/middleware/apikey.go
+Package middleware provides API key authentication backed by the SQLite store.
+*/
+
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultAPIKeyHeader is the header API keys are read from when none is configured
+	DefaultAPIKeyHeader = "X-API-Key"
+
+	apiKeyPrefix = "gk_"
+
+	// apiKeyCacheTTL bounds how long a key changed by another replica is served
+	// from memory; changes made through the cache apply at once
+	apiKeyCacheTTL = 10 * time.Second
+	// apiKeyCacheSize bounds the number of cached keys
+	apiKeyCacheSize = 10000
+)
+
+// GenerateAPIKey creates a new random key and returns it with its display prefix and hash
+func GenerateAPIKey() (plain string, prefix string, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	plain = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return plain, plain[:len(apiKeyPrefix)+6], HashAPIKey(plain), nil
+}
+
+// GenerateAPIKeyID creates a random identifier for a new key
+func GenerateAPIKeyID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// HashAPIKey returns the SHA-256 hex digest stored in place of the key.
+// Keys carry 256 bits of entropy, so a fast hash is sufficient.
+func HashAPIKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyCache is an APIKeyStore that keeps keys looked up by hash in memory for
+// apiKeyCacheTTL, so authenticated requests don't query the database each time.
+// Rotating or revoking a key through the cache drops it immediately.
+type APIKeyCache struct {
+	storage.APIKeyStore
+	mu      sync.Mutex
+	entries map[string]cachedAPIKey // By key hash
+	epoch   uint64                  // Incremented on invalidation
+}
+
+// cachedAPIKey is a key with the time its cache entry expires
+type cachedAPIKey struct {
+	key     storage.APIKey
+	expires time.Time
+}
+
+// NewAPIKeyCache creates a cache in front of store
+func NewAPIKeyCache(store storage.APIKeyStore) *APIKeyCache {
+	return &APIKeyCache{
+		APIKeyStore: store,
+		entries:     make(map[string]cachedAPIKey),
+	}
+}
+
+// GetAPIKeyByHash returns the key from memory, or from the store if it is not
+// cached or its entry expired. Unknown keys are not cached.
+func (kc *APIKeyCache) GetAPIKeyByHash(hash string) (*storage.APIKey, error) {
+	now := time.Now()
+	kc.mu.Lock()
+	entry, ok := kc.entries[hash]
+	epoch := kc.epoch
+	kc.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		key := entry.key
+		return &key, nil
+	}
+
+	key, err := kc.APIKeyStore.GetAPIKeyByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	// A key invalidated while it was being read may be stale already
+	if kc.epoch != epoch {
+		return key, nil
+	}
+	if len(kc.entries) >= apiKeyCacheSize {
+		for h, e := range kc.entries {
+			if !now.Before(e.expires) {
+				delete(kc.entries, h)
+			}
+		}
+		if len(kc.entries) >= apiKeyCacheSize {
+			clear(kc.entries)
+		}
+	}
+	kc.entries[hash] = cachedAPIKey{key: *key, expires: now.Add(apiKeyCacheTTL)}
+	return key, nil
+}
+
+// RotateAPIKey replaces the key's hash in the store and drops the old one from memory
+func (kc *APIKeyCache) RotateAPIKey(id string, prefix string, hash string) error {
+	err := kc.APIKeyStore.RotateAPIKey(id, prefix, hash)
+	kc.invalidate(id)
+	return err
+}
+
+// SetAPIKeyEnabled enables or revokes the key in the store and drops it from memory
+func (kc *APIKeyCache) SetAPIKeyEnabled(id string, enabled bool) error {
+	err := kc.APIKeyStore.SetAPIKeyEnabled(id, enabled)
+	kc.invalidate(id)
+	return err
+}
+
+// invalidate drops the cached entries of key id
+func (kc *APIKeyCache) invalidate(id string) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	kc.epoch++
+	for hash, entry := range kc.entries {
+		if entry.key.ID == id {
+			delete(kc.entries, hash)
+		}
+	}
+}
+
+// APIKeyAuth creates a middleware that requires a valid, enabled, unexpired API key
+// allowed on the given route path
+func APIKeyAuth(store storage.APIKeyStore, routePath string, header string) gin.HandlerFunc {
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+
+	return func(c *gin.Context) {
+		plain := c.GetHeader(header)
+		// The key must not leak to the backend
+		c.Request.Header.Del(header)
+
+		if plain == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Missing API key",
+			})
+			return
+		}
+
+		key, err := store.GetAPIKeyByHash(HashAPIKey(plain))
+		if err != nil {
+			if !errors.Is(err, storage.ErrAPIKeyNotFound) {
+				log.Printf("API key lookup failed: %v", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to verify API key",
+				})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid API key",
+			})
+			return
+		}
+
+		if !key.Enabled {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "API key revoked",
+			})
+			return
+		}
+		if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "API key expired",
+			})
+			return
+		}
+		if !apiKeyAllowsRoute(key, routePath) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "API key not allowed on this route",
+			})
+			return
+		}
+
+		// Store key identity in context for logging and downstream middleware
+		c.Set("api_key_id", key.ID)
+		c.Set("api_key_owner", key.Owner)
+
+		c.Next()
+	}
+}
+
+// apiKeyAllowsRoute checks the key's route allow-list (empty allows all routes)
+func apiKeyAllowsRoute(key *storage.APIKey, routePath string) bool {
+	if len(key.Routes) == 0 {
+		return true
+	}
+	for _, allowed := range key.Routes {
+		if allowed == "*" || allowed == routePath {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminAuth creates a middleware protecting admin endpoints with a static bearer token.
+// Without a token the endpoints only answer clients on the gateway's own machine,
+// and endpoints that change the gateway must not be registered at all.
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			// Both the connection and the client behind a trusted proxy must be local
+			if !isLoopback(c.RemoteIP()) || !isLoopback(c.ClientIP()) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error": "Admin endpoints are only available locally without admin.token",
+				})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		provided, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Admin authentication required",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// isLoopback reports whether ip is a loopback address
+func isLoopback(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.IsLoopback()
+}
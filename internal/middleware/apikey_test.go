@@ -0,0 +1,317 @@
+/*
+internal/middleware/apikey_test.go
+Package middleware tests API key and admin authentication and the key cache.
+*/
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// countingKeyStore counts lookups by hash reaching the store
+type countingKeyStore struct {
+	storage.APIKeyStore
+	lookups atomic.Int64
+}
+
+func (cs *countingKeyStore) GetAPIKeyByHash(hash string) (*storage.APIKey, error) {
+	cs.lookups.Add(1)
+	return cs.APIKeyStore.GetAPIKeyByHash(hash)
+}
+
+// newKeyStore opens a SQLite store in the test's temporary directory
+func newKeyStore(t *testing.T) *storage.SQLiteStorage {
+	t.Helper()
+	store, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// createKey issues an enabled key and returns its ID and plain secret
+func createKey(t *testing.T, store storage.APIKeyStore, key storage.APIKey) (string, string) {
+	t.Helper()
+	id, err := GenerateAPIKeyID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, prefix, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.ID, key.Prefix, key.CreatedAt = id, prefix, time.Now()
+	if err := store.CreateAPIKey(key, hash); err != nil {
+		t.Fatal(err)
+	}
+	return id, plain
+}
+
+func TestAPIKeyCacheServesRepeatedLookups(t *testing.T) {
+	store := &countingKeyStore{APIKeyStore: newKeyStore(t)}
+	cache := NewAPIKeyCache(store)
+	_, plain := createKey(t, store, storage.APIKey{Owner: "billing", Enabled: true})
+
+	for i := 0; i < 5; i++ {
+		key, err := cache.GetAPIKeyByHash(HashAPIKey(plain))
+		if err != nil || key.Owner != "billing" {
+			t.Fatalf("GetAPIKeyByHash() = %+v, %v", key, err)
+		}
+	}
+	if got := store.lookups.Load(); got != 1 {
+		t.Fatalf("store queried %d times, want 1", got)
+	}
+
+	// Unknown keys are not cached
+	for i := 0; i < 2; i++ {
+		if _, err := cache.GetAPIKeyByHash(HashAPIKey("gk_unknown")); err != storage.ErrAPIKeyNotFound {
+			t.Fatalf("GetAPIKeyByHash() error = %v, want ErrAPIKeyNotFound", err)
+		}
+	}
+	if got := store.lookups.Load(); got != 3 {
+		t.Fatalf("store queried %d times, want 3", got)
+	}
+
+	// Expired entries are read again
+	cache.mu.Lock()
+	for hash, entry := range cache.entries {
+		entry.expires = time.Now()
+		cache.entries[hash] = entry
+	}
+	cache.mu.Unlock()
+	cache.GetAPIKeyByHash(HashAPIKey(plain))
+	if got := store.lookups.Load(); got != 4 {
+		t.Fatalf("store queried %d times after the entry expired, want 4", got)
+	}
+}
+
+func TestAPIKeyCacheInvalidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cache *APIKeyCache, id string) error
+		// oldWorks reports whether the previous secret still authenticates
+		oldWorks    bool
+		wantEnabled bool
+	}{
+		{"revoke", func(cache *APIKeyCache, id string) error { return cache.SetAPIKeyEnabled(id, false) }, true, false},
+		{"rotate", func(cache *APIKeyCache, id string) error {
+			_, prefix, hash, _ := GenerateAPIKey()
+			return cache.RotateAPIKey(id, prefix, hash)
+		}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewAPIKeyCache(newKeyStore(t))
+			id, plain := createKey(t, cache, storage.APIKey{Owner: "billing", Enabled: true})
+			if _, err := cache.GetAPIKeyByHash(HashAPIKey(plain)); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.change(cache, id); err != nil {
+				t.Fatal(err)
+			}
+			key, err := cache.GetAPIKeyByHash(HashAPIKey(plain))
+			if !tt.oldWorks {
+				if err != storage.ErrAPIKeyNotFound {
+					t.Fatalf("old secret after %s: %+v, %v, want ErrAPIKeyNotFound", tt.name, key, err)
+				}
+				return
+			}
+			if err != nil || key.Enabled != tt.wantEnabled {
+				t.Fatalf("after %s: %+v, %v, want enabled %v", tt.name, key, err, tt.wantEnabled)
+			}
+		})
+	}
+}
+
+func TestAPIKeyCacheIsBounded(t *testing.T) {
+	store := newKeyStore(t)
+	cache := NewAPIKeyCache(store)
+	_, plain := createKey(t, store, storage.APIKey{Owner: "billing", Enabled: true})
+
+	cache.mu.Lock()
+	for i := 0; i < apiKeyCacheSize; i++ {
+		cache.entries[HashAPIKey(strconv.Itoa(i))] = cachedAPIKey{expires: time.Now().Add(time.Hour)}
+	}
+	cache.mu.Unlock()
+
+	if _, err := cache.GetAPIKeyByHash(HashAPIKey(plain)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(cache.entries); n > apiKeyCacheSize {
+		t.Fatalf("cache holds %d keys, want at most %d", n, apiKeyCacheSize)
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name   string
+		key    *storage.APIKey // nil sends no key
+		header string          // Header configured on the route ("" = default)
+		send   string          // Overrides the key sent
+		want   int
+	}{
+		{"valid key", &storage.APIKey{Owner: "billing", Enabled: true}, "", "", http.StatusOK},
+		{"custom header", &storage.APIKey{Owner: "billing", Enabled: true}, "X-Token", "", http.StatusOK},
+		{"not yet expired", &storage.APIKey{Owner: "billing", Enabled: true, ExpiresAt: &future}, "", "", http.StatusOK},
+		{"allowed route", &storage.APIKey{Owner: "billing", Enabled: true, Routes: []string{"/api/*filepath"}}, "", "", http.StatusOK},
+		{"wildcard route", &storage.APIKey{Owner: "billing", Enabled: true, Routes: []string{"*"}}, "", "", http.StatusOK},
+		{"missing key", nil, "", "", http.StatusUnauthorized},
+		{"unknown key", &storage.APIKey{Owner: "billing", Enabled: true}, "", "gk_unknown", http.StatusUnauthorized},
+		{"revoked key", &storage.APIKey{Owner: "billing", Enabled: false}, "", "", http.StatusUnauthorized},
+		{"expired key", &storage.APIKey{Owner: "billing", Enabled: true, ExpiresAt: &past}, "", "", http.StatusUnauthorized},
+		{"other route", &storage.APIKey{Owner: "billing", Enabled: true, Routes: []string{"/admin/*filepath"}}, "", "", http.StatusForbidden},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewAPIKeyCache(newKeyStore(t))
+			header := tt.header
+			if header == "" {
+				header = DefaultAPIKeyHeader
+			}
+
+			var owner, forwarded string
+			router := gin.New()
+			router.GET("/api/*filepath", APIKeyAuth(store, "/api/*filepath", tt.header), func(c *gin.Context) {
+				owner = c.GetString("api_key_owner")
+				forwarded = c.Request.Header.Get(header)
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+			if tt.key != nil {
+				_, plain := createKey(t, store, *tt.key)
+				if tt.send != "" {
+					plain = tt.send
+				}
+				req.Header.Set(header, plain)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want == http.StatusOK {
+				if owner != tt.key.Owner {
+					t.Fatalf("api_key_owner = %q, want %q", owner, tt.key.Owner)
+				}
+				if forwarded != "" {
+					t.Fatal("the API key header reached the handler")
+				}
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthRevokeTakesEffect(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cache *APIKeyCache, id string) error
+		want   int
+	}{
+		{"revoke", func(cache *APIKeyCache, id string) error { return cache.SetAPIKeyEnabled(id, false) }, http.StatusUnauthorized},
+		{"rotate", func(cache *APIKeyCache, id string) error {
+			_, prefix, hash, _ := GenerateAPIKey()
+			return cache.RotateAPIKey(id, prefix, hash)
+		}, http.StatusUnauthorized},
+		{"revoke and enable again", func(cache *APIKeyCache, id string) error {
+			if err := cache.SetAPIKeyEnabled(id, false); err != nil {
+				return err
+			}
+			return cache.SetAPIKeyEnabled(id, true)
+		}, http.StatusOK},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewAPIKeyCache(newKeyStore(t))
+			id, plain := createKey(t, cache, storage.APIKey{Owner: "billing", Enabled: true})
+
+			router := gin.New()
+			router.GET("/api/*filepath", APIKeyAuth(cache, "/api/*filepath", ""), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			request := func() int {
+				req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+				req.Header.Set(DefaultAPIKeyHeader, plain)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				return w.Code
+			}
+
+			// The first request caches the key
+			if code := request(); code != http.StatusOK {
+				t.Fatalf("before %s: status %d, want 200", tt.name, code)
+			}
+			if err := tt.change(cache, id); err != nil {
+				t.Fatal(err)
+			}
+			if code := request(); code != tt.want {
+				t.Fatalf("after %s: status %d, want %d", tt.name, code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		remoteAddr    string
+		forwardedFor  string
+		authorization string
+		want          int
+	}{
+		{"no token, local client", "", "127.0.0.1:5000", "", "", http.StatusOK},
+		{"no token, local IPv6 client", "", "[::1]:5000", "", "", http.StatusOK},
+		{"no token, remote client", "", "192.0.2.1:5000", "", "", http.StatusForbidden},
+		{"no token, remote client claiming to be local", "", "192.0.2.1:5000", "127.0.0.1", "", http.StatusForbidden},
+		{"no token, remote client behind a local proxy", "", "127.0.0.1:5000", "192.0.2.1", "", http.StatusForbidden},
+		{"token, remote client", "secret", "192.0.2.1:5000", "", "Bearer secret", http.StatusOK},
+		{"wrong token", "secret", "127.0.0.1:5000", "", "Bearer guess", http.StatusUnauthorized},
+		{"missing token", "secret", "127.0.0.1:5000", "", "", http.StatusUnauthorized},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.SetTrustedProxies([]string{"127.0.0.1"})
+			router.GET("/admin/backends", AdminAuth(tt.token), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/backends", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+/*
+internal/middleware/clientlimit.go
+Package middleware provides per-client rate limiting with one token bucket per client key.
+*/
+
+package middleware
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// Client key sources for per-client rate limiting
+const (
+	ClientKeyIP         = "ip"          // Client IP as seen by gin (X-Forwarded-For only from trusted proxies)
+	ClientKeyCIDR       = "cidr"        // Client IP network, e.g. one bucket per /24
+	ClientKeyHeader     = "header"      // Value of a request header
+	ClientKeyAPIKey     = "api_key"     // ID of the authenticated API key
+	ClientKeyJWTSubject = "jwt_subject" // Subject of the validated JWT
+)
+
+// ClientRateLimitConfig describes one per-client limiting policy
+type ClientRateLimitConfig struct {
+	Name              string // Identifies the policy in metrics (default: the key)
+	Key               string // One of the ClientKey* sources
+	Header            string // Header name for the header key
+	IPv4Prefix        int    // Network size for the cidr key (default 24)
+	IPv6Prefix        int    // Network size for the cidr key (default 64)
+	RequestsPerSecond float64
+	Burst             int               // Default: requests_per_second rounded up
+	MaxClients        int               // Buckets kept at most, least recently used go first (default 10000)
+	IdleTimeout       time.Duration     // Buckets unused for this long are dropped (default 10m)
+	Shared            *SharedRateLimits // Count in a shared store instead of in-memory buckets
+	SharedScope       string            // Tells apart policies of the same name in the shared store, e.g. the route
+}
+
+// clientBucket is the token bucket of one client
+type clientBucket struct {
+	key      string
+	limiter  *rate.Limiter // nil when counting in a shared store
+	lastSeen time.Time
+}
+
+// ClientRateLimiter holds one token bucket per client in a bounded LRU, so a
+// flood of distinct keys costs at most MaxClients buckets. With a shared store
+// the LRU bounds the clients whose window counters are kept in memory instead.
+type ClientRateLimiter struct {
+	config   ClientRateLimitConfig
+	mu       sync.Mutex
+	buckets  map[string]*list.Element
+	lru      *list.List   // Most recently used at the front
+	window   *windowLimit // Set when counting in a shared store
+	rejected atomic.Int64
+	evicted  atomic.Int64
+}
+
+// NewClientRateLimiter validates a policy and creates its limiter
+func NewClientRateLimiter(config ClientRateLimitConfig) (*ClientRateLimiter, error) {
+	switch config.Key {
+	case ClientKeyIP, ClientKeyAPIKey, ClientKeyJWTSubject:
+	case ClientKeyCIDR:
+		if config.IPv4Prefix == 0 {
+			config.IPv4Prefix = 24
+		}
+		if config.IPv6Prefix == 0 {
+			config.IPv6Prefix = 64
+		}
+		if config.IPv4Prefix < 0 || config.IPv4Prefix > 32 || config.IPv6Prefix < 0 || config.IPv6Prefix > 128 {
+			return nil, fmt.Errorf("invalid cidr prefix lengths /%d and /%d", config.IPv4Prefix, config.IPv6Prefix)
+		}
+	case ClientKeyHeader:
+		if config.Header == "" {
+			return nil, fmt.Errorf("the header key requires a header name")
+		}
+	default:
+		return nil, fmt.Errorf("unknown client key %q", config.Key)
+	}
+	if config.RequestsPerSecond <= 0 {
+		return nil, fmt.Errorf("requests_per_second must be positive")
+	}
+	if config.Burst < 0 || config.MaxClients < 0 || config.IdleTimeout < 0 {
+		return nil, fmt.Errorf("burst, max_clients and idle_timeout must not be negative")
+	}
+	if config.Burst == 0 {
+		config.Burst = int(config.RequestsPerSecond + 0.999)
+	}
+	if config.MaxClients == 0 {
+		config.MaxClients = 10000
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = 10 * time.Minute
+	}
+	if config.Name == "" {
+		config.Name = config.Key
+		if config.Key == ClientKeyHeader {
+			config.Name += ":" + config.Header
+		}
+	}
+
+	cl := &ClientRateLimiter{
+		config:  config,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	if config.Shared != nil {
+		name := "client:" + config.Name
+		if config.SharedScope != "" {
+			name = "client:" + config.SharedScope + "/" + config.Name
+		}
+		window, err := config.Shared.window(name, config.RequestsPerSecond, config.Burst)
+		if err != nil {
+			return nil, err
+		}
+		cl.window = window
+	}
+	return cl, nil
+}
+
+// Name returns the policy name
+func (cl *ClientRateLimiter) Name() string {
+	return cl.config.Name
+}
+
+// Rejected returns the number of requests rejected by this policy
+func (cl *ClientRateLimiter) Rejected() int64 {
+	return cl.rejected.Load()
+}
+
+// Evicted returns the number of buckets dropped to stay within MaxClients
+func (cl *ClientRateLimiter) Evicted() int64 {
+	return cl.evicted.Load()
+}
+
+// Clients returns the number of buckets currently held in memory
+func (cl *ClientRateLimiter) Clients() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.lru.Len()
+}
+
+// clientKey returns the key of the request's client, or false if the request
+// has none (e.g. no API key on a public route); such requests are not limited
+func (cl *ClientRateLimiter) clientKey(c *gin.Context) (string, bool) {
+	return clientIdentity(c, cl.config.Key, cl.config.Header, cl.config.IPv4Prefix, cl.config.IPv6Prefix)
+}
+
+// clientIdentity returns the client of a request according to one of the ClientKey* sources
+func clientIdentity(c *gin.Context, source, header string, ipv4Prefix, ipv6Prefix int) (string, bool) {
+	switch source {
+	case ClientKeyIP:
+		return c.ClientIP(), true
+	case ClientKeyCIDR:
+		ip := net.ParseIP(c.ClientIP())
+		if ip == nil {
+			return "", false
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(ipv4Prefix, 32)).String() + "/" + strconv.Itoa(ipv4Prefix), true
+		}
+		return ip.Mask(net.CIDRMask(ipv6Prefix, 128)).String() + "/" + strconv.Itoa(ipv6Prefix), true
+	case ClientKeyHeader:
+		value := c.GetHeader(header)
+		return value, value != ""
+	case ClientKeyAPIKey:
+		id := c.GetString("api_key_id")
+		return id, id != ""
+	case ClientKeyJWTSubject:
+		subject := c.GetString("jwt_subject")
+		return subject, subject != ""
+	}
+	return "", false
+}
+
+// take counts a request of the client with the given key
+func (cl *ClientRateLimiter) take(key string, now time.Time) (func(), limitState, bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	// Idle buckets sit at the back; dropping them costs nothing for the client
+	// since an idle bucket is full again anyway
+	for back := cl.lru.Back(); back != nil; back = cl.lru.Back() {
+		bucket := back.Value.(*clientBucket)
+		if now.Sub(bucket.lastSeen) < cl.config.IdleTimeout {
+			break
+		}
+		cl.drop(back)
+	}
+
+	element, ok := cl.buckets[key]
+	if ok {
+		cl.lru.MoveToFront(element)
+	} else {
+		if cl.lru.Len() >= cl.config.MaxClients {
+			cl.drop(cl.lru.Back())
+			cl.evicted.Add(1)
+		}
+		bucket := &clientBucket{key: key}
+		if cl.window == nil {
+			bucket.limiter = rate.NewLimiter(rate.Limit(cl.config.RequestsPerSecond), cl.config.Burst)
+		}
+		element = cl.lru.PushFront(bucket)
+		cl.buckets[key] = element
+	}
+
+	bucket := element.Value.(*clientBucket)
+	bucket.lastSeen = now
+	if cl.window != nil {
+		return cl.window.take(key, now)
+	}
+	return tokenBucket{limiter: bucket.limiter}.take(key, now)
+}
+
+// drop removes a client from the LRU, and its counters from the shared windows
+// when counting in a shared store. cl.mu must be held.
+func (cl *ClientRateLimiter) drop(element *list.Element) {
+	bucket := element.Value.(*clientBucket)
+	cl.lru.Remove(element)
+	delete(cl.buckets, bucket.key)
+	if cl.window != nil {
+		cl.window.forget(bucket.key, bucket.lastSeen)
+	}
+}
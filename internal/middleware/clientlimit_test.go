@@ -0,0 +1,222 @@
+/*
+internal/middleware/clientlimit_test.go
+Package middleware tests per-client rate limiting: client keys, separate buckets and their bounds.
+*/
+
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientRequest describes who sends a test request
+type clientRequest struct {
+	remoteAddr string
+	header     map[string]string
+	apiKeyID   string // Set as if APIKeyAuth ran
+	jwtSubject string // Set as if JWTAuth ran
+}
+
+// clientRouter serves a route limited per client, behind a proxy trusted for
+// 10.0.0.0/8, and returns a function sending it one request
+func clientRouter(t *testing.T, limiter *ClientRateLimiter) func(clientRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	rl, err := NewRouteRateLimiter(RouteRateLimitConfig{Name: "users", Clients: []*ClientRateLimiter{limiter}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	if err := router.SetTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+	router.GET("/api/*filepath", func(c *gin.Context) {
+		if id := c.GetHeader("Test-API-Key-ID"); id != "" {
+			c.Set("api_key_id", id)
+		}
+		if subject := c.GetHeader("Test-JWT-Subject"); subject != "" {
+			c.Set("jwt_subject", subject)
+		}
+	}, RouteRateLimitMiddleware(rl, RateLimitResponse{}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	do := func(client clientRequest) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+		req.RemoteAddr = client.remoteAddr
+		for name, value := range client.header {
+			req.Header.Set(name, value)
+		}
+		if client.apiKeyID != "" {
+			req.Header.Set("Test-API-Key-ID", client.apiKeyID)
+		}
+		if client.jwtSubject != "" {
+			req.Header.Set("Test-JWT-Subject", client.jwtSubject)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	return do
+}
+
+func TestClientRateLimitKeys(t *testing.T) {
+	direct := func(addr string) clientRequest { return clientRequest{remoteAddr: addr + ":40000"} }
+	forwarded := func(proxy, client string) clientRequest {
+		return clientRequest{remoteAddr: proxy + ":40000", header: map[string]string{"X-Forwarded-For": client}}
+	}
+
+	tests := []struct {
+		name   string
+		config ClientRateLimitConfig
+		first  clientRequest
+		same   clientRequest // Shares the first client's bucket
+		other  clientRequest // Has a bucket of its own
+	}{
+		{"ip", ClientRateLimitConfig{Key: ClientKeyIP},
+			direct("198.51.100.1"), direct("198.51.100.1"), direct("198.51.100.2")},
+		{"ip behind a trusted proxy", ClientRateLimitConfig{Key: ClientKeyIP},
+			forwarded("10.0.0.1", "203.0.113.7"), forwarded("10.0.0.2", "203.0.113.7"), forwarded("10.0.0.1", "203.0.113.8")},
+		{"forwarded header from an untrusted peer", ClientRateLimitConfig{Key: ClientKeyIP},
+			forwarded("198.51.100.1", "203.0.113.7"), forwarded("198.51.100.1", "203.0.113.8"), direct("198.51.100.2")},
+		{"cidr", ClientRateLimitConfig{Key: ClientKeyCIDR},
+			direct("198.51.100.1"), direct("198.51.100.200"), direct("198.51.101.1")},
+		{"cidr ipv6", ClientRateLimitConfig{Key: ClientKeyCIDR, IPv6Prefix: 48},
+			direct("[2001:db8:1:2::1]"), direct("[2001:db8:1:3::1]"), direct("[2001:db8:2::1]")},
+		{"header", ClientRateLimitConfig{Key: ClientKeyHeader, Header: "X-Tenant"},
+			clientRequest{remoteAddr: "198.51.100.1:1", header: map[string]string{"X-Tenant": "acme"}},
+			clientRequest{remoteAddr: "198.51.100.2:1", header: map[string]string{"X-Tenant": "acme"}},
+			clientRequest{remoteAddr: "198.51.100.1:1", header: map[string]string{"X-Tenant": "globex"}}},
+		{"api key", ClientRateLimitConfig{Key: ClientKeyAPIKey},
+			clientRequest{remoteAddr: "198.51.100.1:1", apiKeyID: "key-1"},
+			clientRequest{remoteAddr: "198.51.100.2:1", apiKeyID: "key-1"},
+			clientRequest{remoteAddr: "198.51.100.1:1", apiKeyID: "key-2"}},
+		{"jwt subject", ClientRateLimitConfig{Key: ClientKeyJWTSubject},
+			clientRequest{remoteAddr: "198.51.100.1:1", jwtSubject: "alice"},
+			clientRequest{remoteAddr: "198.51.100.2:1", jwtSubject: "alice"},
+			clientRequest{remoteAddr: "198.51.100.1:1", jwtSubject: "bob"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.RequestsPerSecond = 0.01
+			tt.config.Burst = 1
+			limiter, err := NewClientRateLimiter(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			do := clientRouter(t, limiter)
+
+			if rec := do(tt.first); rec.Code != http.StatusOK {
+				t.Fatalf("first request: status %d, want 200", rec.Code)
+			}
+			if rec := do(tt.same); rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("same client: status %d, want 429", rec.Code)
+			}
+			if rec := do(tt.other); rec.Code != http.StatusOK {
+				t.Fatalf("other client: status %d, want 200", rec.Code)
+			}
+			if limiter.Rejected() != 1 || limiter.Clients() != 2 {
+				t.Fatalf("rejected %d, clients %d, want 1 and 2", limiter.Rejected(), limiter.Clients())
+			}
+		})
+	}
+}
+
+func TestClientRateLimitWithoutKey(t *testing.T) {
+	tests := []ClientRateLimitConfig{
+		{Key: ClientKeyHeader, Header: "X-Tenant"},
+		{Key: ClientKeyAPIKey},
+		{Key: ClientKeyJWTSubject},
+	}
+
+	for _, config := range tests {
+		t.Run(config.Key, func(t *testing.T) {
+			config.RequestsPerSecond = 0.01
+			config.Burst = 1
+			limiter, _ := NewClientRateLimiter(config)
+			do := clientRouter(t, limiter)
+			// Requests without the key are not limited by the policy
+			for i := 0; i < 3; i++ {
+				if rec := do(clientRequest{remoteAddr: "198.51.100.1:1"}); rec.Code != http.StatusOK {
+					t.Fatalf("request %d: status %d, want 200", i+1, rec.Code)
+				}
+			}
+			if limiter.Clients() != 0 {
+				t.Fatalf("%d buckets for requests without a key", limiter.Clients())
+			}
+		})
+	}
+}
+
+func TestClientRateLimitBuckets(t *testing.T) {
+	limiter, _ := NewClientRateLimiter(ClientRateLimitConfig{
+		Key:               ClientKeyHeader,
+		Header:            "X-Tenant",
+		RequestsPerSecond: 0.01,
+		Burst:             1,
+		MaxClients:        2,
+		IdleTimeout:       time.Minute,
+	})
+	now := time.Now()
+	take := func(key string, at time.Time) bool {
+		_, _, ok := limiter.take(key, at)
+		return ok
+	}
+
+	for i := 0; i < 3; i++ {
+		take(fmt.Sprintf("tenant-%d", i), now)
+	}
+	if limiter.Clients() != 2 || limiter.Evicted() != 1 {
+		t.Fatalf("clients %d, evicted %d, want 2 and 1", limiter.Clients(), limiter.Evicted())
+	}
+	// The least recently used client lost its bucket and starts over with a full one
+	if !take("tenant-0", now) {
+		t.Fatal("evicted client was limited by its old bucket")
+	}
+	if take("tenant-2", now) {
+		t.Fatal("recent client got a second request through a burst of 1")
+	}
+
+	// Idle buckets are dropped without counting as evictions
+	take("tenant-3", now.Add(2*time.Minute))
+	if limiter.Clients() != 1 || limiter.Evicted() != 2 {
+		t.Fatalf("after the idle timeout: clients %d, evicted %d, want 1 and 2", limiter.Clients(), limiter.Evicted())
+	}
+}
+
+func TestNewClientRateLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   ClientRateLimitConfig
+		wantName string
+		wantErr  bool
+	}{
+		{"ip", ClientRateLimitConfig{Key: ClientKeyIP, RequestsPerSecond: 1}, "ip", false},
+		{"header name", ClientRateLimitConfig{Key: ClientKeyHeader, Header: "X-Tenant", RequestsPerSecond: 1}, "header:X-Tenant", false},
+		{"named", ClientRateLimitConfig{Name: "tenants", Key: ClientKeyAPIKey, RequestsPerSecond: 1}, "tenants", false},
+		{"unknown key", ClientRateLimitConfig{Key: "cookie", RequestsPerSecond: 1}, "", true},
+		{"header key without header", ClientRateLimitConfig{Key: ClientKeyHeader, RequestsPerSecond: 1}, "", true},
+		{"cidr prefix too long", ClientRateLimitConfig{Key: ClientKeyCIDR, IPv4Prefix: 33, RequestsPerSecond: 1}, "", true},
+		{"zero rate", ClientRateLimitConfig{Key: ClientKeyIP}, "", true},
+		{"negative burst", ClientRateLimitConfig{Key: ClientKeyIP, RequestsPerSecond: 1, Burst: -1}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, err := NewClientRateLimiter(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClientRateLimiter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && limiter.Name() != tt.wantName {
+				t.Fatalf("Name() = %q, want %q", limiter.Name(), tt.wantName)
+			}
+		})
+	}
+}
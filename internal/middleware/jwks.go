@@ -0,0 +1,304 @@
+/*
+internal/middleware/jwks.go
+Package middleware provides JWT verification key loading from static config and JWKS documents.
+*/
+
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// minJWKSRefreshInterval limits refreshes triggered by unknown key IDs
+const minJWKSRefreshInterval = 30 * time.Second
+
+// JWTKeyConfig describes a static verification key
+type JWTKeyConfig struct {
+	KeyID string // Matched against the token's "kid" header (empty matches any)
+	File  string // Path to a PEM public key or certificate
+	PEM   string // Inline PEM public key or certificate
+}
+
+// keySet resolves verification keys by key ID from static config and an optional JWKS source
+type keySet struct {
+	hmacSecret []byte
+	static     map[string]interface{}
+
+	jwksURL     string
+	jwksFile    string
+	jwksTTL     time.Duration
+	client      *http.Client
+	jwks        map[string]interface{} // Replaced on refresh, never modified
+	lastFetch   time.Time
+	lastAttempt time.Time
+	mu          sync.RWMutex
+	refreshes   singleflight.Group // Requests waiting for a refresh share one fetch
+}
+
+// newKeySet loads static keys and performs the initial JWKS fetch
+func newKeySet(config JWTConfig) (*keySet, error) {
+	ks := &keySet{
+		static:   make(map[string]interface{}),
+		jwksURL:  config.JWKSURL,
+		jwksFile: config.JWKSFile,
+		jwksTTL:  config.JWKSRefreshInterval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if ks.jwksTTL <= 0 {
+		ks.jwksTTL = 15 * time.Minute
+	}
+	if config.HMACSecret != "" {
+		ks.hmacSecret = []byte(config.HMACSecret)
+	}
+
+	for i, keyConfig := range config.PublicKeys {
+		data := []byte(keyConfig.PEM)
+		if keyConfig.File != "" {
+			fileData, err := os.ReadFile(keyConfig.File)
+			if err != nil {
+				return nil, fmt.Errorf("public key %d: %w", i, err)
+			}
+			data = fileData
+		}
+		key, err := parsePEMPublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("public key %d: %w", i, err)
+		}
+		ks.static[keyConfig.KeyID] = key
+	}
+
+	if ks.jwksURL != "" || ks.jwksFile != "" {
+		if err := ks.refresh(); err != nil {
+			return nil, fmt.Errorf("failed to load JWKS: %w", err)
+		}
+	}
+
+	if ks.hmacSecret == nil && len(ks.static) == 0 && ks.jwks == nil {
+		return nil, fmt.Errorf("no JWT verification keys configured")
+	}
+	return ks, nil
+}
+
+// lookup returns the verification key for a token header
+func (ks *keySet) lookup(kid, alg string) (interface{}, error) {
+	if alg == "HS256" {
+		if ks.hmacSecret == nil {
+			return nil, fmt.Errorf("no HMAC secret configured")
+		}
+		return ks.hmacSecret, nil
+	}
+
+	if key, ok := ks.static[kid]; ok {
+		return key, nil
+	}
+
+	if ks.jwksURL == "" && ks.jwksFile == "" {
+		// A single static key without a kid matches any token
+		if key, ok := ks.static[""]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	// The fetch happens outside the lock, so a slow JWKS endpoint never holds up
+	// requests whose key is cached
+	ks.mu.RLock()
+	key, found := ks.jwks[kid]
+	stale := time.Since(ks.lastFetch) >= ks.jwksTTL
+	due := time.Since(ks.lastAttempt) >= minJWKSRefreshInterval
+	ks.mu.RUnlock()
+
+	switch {
+	case found && stale && due:
+		// Keep serving the cached key while the keys are refreshed
+		go ks.refreshShared()
+	case !found && due:
+		// The issuer may have rotated: wait for the refresh, shared by every
+		// request that needs it. Unknown key IDs trigger at most one refresh per
+		// minJWKSRefreshInterval, so forged kids cannot hammer the endpoint.
+		ks.refreshShared()
+		ks.mu.RLock()
+		key, found = ks.jwks[kid]
+		ks.mu.RUnlock()
+	}
+
+	if !found {
+		ks.mu.RLock()
+		defer ks.mu.RUnlock()
+		if kid == "" && len(ks.jwks) == 1 {
+			for _, only := range ks.jwks {
+				return only, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// refreshShared refreshes the JWKS unless a refresh was attempted within
+// minJWKSRefreshInterval. Concurrent callers wait for the same fetch.
+func (ks *keySet) refreshShared() {
+	ks.refreshes.Do("jwks", func() (interface{}, error) {
+		ks.mu.Lock()
+		due := time.Since(ks.lastAttempt) >= minJWKSRefreshInterval
+		if due {
+			ks.lastAttempt = time.Now()
+		}
+		ks.mu.Unlock()
+		if !due {
+			return nil, nil
+		}
+
+		if err := ks.refresh(); err != nil {
+			// Keep serving the cached keys; the issuer may be briefly unreachable
+			log.Printf("JWKS refresh failed: %v", err)
+			return nil, err
+		}
+		return nil, nil
+	})
+}
+
+// refresh fetches the JWKS document and replaces the cached keys
+func (ks *keySet) refresh() error {
+	ks.mu.Lock()
+	ks.lastAttempt = time.Now()
+	ks.mu.Unlock()
+
+	keys, err := ks.fetch()
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.jwks = keys
+	ks.lastFetch = time.Now()
+	return nil
+}
+
+// fetch reads and parses the JWKS document from its file or URL
+func (ks *keySet) fetch() (map[string]interface{}, error) {
+	var data []byte
+	if ks.jwksFile != "" {
+		fileData, err := os.ReadFile(ks.jwksFile)
+		if err != nil {
+			return nil, err
+		}
+		data = fileData
+	} else {
+		resp, err := ks.client.Get(ks.jwksURL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+		}
+		data, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parseJWKS(data)
+}
+
+// jwk is a single JSON Web Key (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses a JWKS document into public keys by key ID.
+// Keys of unsupported types are skipped.
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JWKS document: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid modulus", k.Kid)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid exponent", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid x coordinate", k.Kid)
+			}
+			y, err := base64.RawURLEncoding.DecodeString(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid y coordinate", k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+	return keys, nil
+}
+
+// parsePEMPublicKey parses a PEM-encoded PKIX public key or X.509 certificate
+func parsePEMPublicKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
@@ -0,0 +1,346 @@
+/*
+internal/middleware/jwt.go
+Package middleware provides JWT bearer token authentication with per-route policies.
+*/
+
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWTConfig holds token validation settings shared by all routes
+type JWTConfig struct {
+	Issuer              string
+	Audience            []string
+	Algorithms          []string // Allowed algorithms (defaults to HS256, RS256, ES256)
+	HMACSecret          string
+	PublicKeys          []JWTKeyConfig
+	JWKSURL             string
+	JWKSFile            string
+	JWKSRefreshInterval time.Duration
+	ClockSkew           time.Duration // Leeway applied to exp/nbf checks
+}
+
+// JWTPolicy holds the per-route authorization requirements
+type JWTPolicy struct {
+	RequiredClaims map[string]string // Claim name -> required value (arrays must contain it)
+	Scopes         []string          // All listed scopes must be granted
+	ForwardClaims  map[string]string // Claim name -> upstream header name
+}
+
+// JWTClaims are the validated claims of a token
+type JWTClaims map[string]interface{}
+
+// Subject returns the "sub" claim
+func (c JWTClaims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// JWTValidator verifies token signatures and registered claims
+type JWTValidator struct {
+	config     JWTConfig
+	keys       *keySet
+	algorithms map[string]bool
+}
+
+// NewJWTValidator creates a validator and loads its verification keys
+func NewJWTValidator(config JWTConfig) (*JWTValidator, error) {
+	if len(config.Algorithms) == 0 {
+		config.Algorithms = []string{"HS256", "RS256", "ES256"}
+	}
+	if config.ClockSkew == 0 {
+		config.ClockSkew = 30 * time.Second
+	}
+
+	algorithms := make(map[string]bool)
+	for _, alg := range config.Algorithms {
+		switch alg {
+		case "HS256", "RS256", "ES256":
+			algorithms[alg] = true
+		default:
+			return nil, fmt.Errorf("unsupported JWT algorithm %q", alg)
+		}
+	}
+
+	keys, err := newKeySet(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JWTValidator{
+		config:     config,
+		keys:       keys,
+		algorithms: algorithms,
+	}, nil
+}
+
+// Validate verifies a compact-serialized token and returns its claims
+func (v *JWTValidator) Validate(token string) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	if !v.algorithms[header.Alg] {
+		return nil, fmt.Errorf("algorithm %q not allowed", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+
+	key, err := v.keys.lookup(header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims JWTClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// validateClaims checks exp, nbf, iss and aud
+func (v *JWTValidator) validateClaims(claims JWTClaims) error {
+	now := time.Now()
+
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return fmt.Errorf("token has no exp claim")
+	}
+	if now.After(time.Unix(exp, 0).Add(v.config.ClockSkew)) {
+		return fmt.Errorf("token expired")
+	}
+
+	if nbf, ok := numericClaim(claims, "nbf"); ok {
+		if now.Add(v.config.ClockSkew).Before(time.Unix(nbf, 0)) {
+			return fmt.Errorf("token not valid yet")
+		}
+	}
+
+	if v.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+			return fmt.Errorf("unexpected issuer %q", iss)
+		}
+	}
+
+	if len(v.config.Audience) > 0 {
+		matched := false
+		for _, aud := range claimStrings(claims["aud"]) {
+			for _, expected := range v.config.Audience {
+				if aud == expected {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return fmt.Errorf("token audience not accepted")
+		}
+	}
+
+	return nil
+}
+
+// JWTAuth creates a middleware that requires a valid bearer token satisfying policy
+func JWTAuth(validator *JWTValidator, policy JWTPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Never trust claim headers supplied by the client
+		for _, header := range policy.ForwardClaims {
+			c.Request.Header.Del(header)
+		}
+
+		authHeader := c.GetHeader("Authorization")
+		token, found := strings.CutPrefix(authHeader, "Bearer ")
+		if !found || token == "" {
+			c.Header("WWW-Authenticate", `Bearer`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Missing bearer token",
+			})
+			return
+		}
+
+		claims, err := validator.Validate(strings.TrimSpace(token))
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid token",
+			})
+			return
+		}
+
+		if err := policy.authorize(claims); err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		for claim, header := range policy.ForwardClaims {
+			if values := claimStrings(claims[claim]); len(values) > 0 {
+				c.Request.Header.Set(header, strings.Join(values, ","))
+			}
+		}
+
+		// Store claims in context for downstream middleware
+		c.Set("jwt_claims", claims)
+		c.Set("jwt_subject", claims.Subject())
+
+		c.Next()
+	}
+}
+
+// authorize checks required claims and scopes
+func (p JWTPolicy) authorize(claims JWTClaims) error {
+	for name, expected := range p.RequiredClaims {
+		matched := false
+		for _, value := range claimStrings(claims[name]) {
+			if value == expected {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("missing required claim %q", name)
+		}
+	}
+
+	if len(p.Scopes) > 0 {
+		granted := make(map[string]bool)
+		// "scope" is a space-separated string (RFC 8693), "scp" is commonly an array
+		if scope, ok := claims["scope"].(string); ok {
+			for _, s := range strings.Fields(scope) {
+				granted[s] = true
+			}
+		}
+		for _, s := range claimStrings(claims["scp"]) {
+			granted[s] = true
+		}
+		for _, required := range p.Scopes {
+			if !granted[required] {
+				return fmt.Errorf("missing required scope %q", required)
+			}
+		}
+	}
+
+	return nil
+}
+
+// verifySignature checks the signature with a key of the type the algorithm demands
+func verifySignature(alg string, key interface{}, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("invalid signature")
+		}
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		// JWS encodes ECDSA signatures as fixed-size r||s, not ASN.1
+		if len(signature) != 64 {
+			return fmt.Errorf("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url JSON segment
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// numericClaim reads a NumericDate claim
+func numericClaim(claims JWTClaims, name string) (int64, bool) {
+	switch value := claims[name].(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i, true
+		}
+		if f, err := value.Float64(); err == nil {
+			return int64(f), true
+		}
+	case float64:
+		return int64(value), true
+	}
+	return 0, false
+}
+
+// claimStrings returns a claim as a list of strings (single values become one-element lists)
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case json.Number:
+		return []string{v.String()}
+	case bool:
+		return []string{fmt.Sprint(v)}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			values = append(values, claimStrings(item)...)
+		}
+		return values
+	}
+	return nil
+}
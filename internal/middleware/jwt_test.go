@@ -0,0 +1,421 @@
+/*
+internal/middleware/jwt_test.go
+Package middleware tests JWT algorithm and key ID handling.
+*/
+
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signToken builds a compact JWT with the given header and claims, signed with key
+func signToken(t *testing.T, header map[string]interface{}, claims map[string]interface{}, key interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signingInput := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = sig
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// publicPEM encodes a public key as a PKIX PEM block
+func publicPEM(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// jwksDocument encodes the EC public keys by key ID as a JWKS document
+func jwksDocument(t *testing.T, keys map[string]*ecdsa.PrivateKey) []byte {
+	t.Helper()
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range keys {
+		doc.Keys = append(doc.Keys, jwk{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// writeJWKS writes a JWKS document holding the EC public keys by key ID
+func writeJWKS(t *testing.T, keys map[string]*ecdsa.PrivateKey) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwksDocument(t, keys), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJWTValidatorAlgorithmsAndKeyIDs(t *testing.T) {
+	secret := []byte("test-secret")
+	rsaA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaB, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecA, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecB, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	// Static keys by kid; "ec-static" lets an RS256 header point at an EC key
+	static := JWTConfig{
+		HMACSecret: string(secret),
+		PublicKeys: []JWTKeyConfig{
+			{KeyID: "rsa-a", PEM: publicPEM(t, &rsaA.PublicKey)},
+			{KeyID: "rsa-b", PEM: publicPEM(t, &rsaB.PublicKey)},
+			{KeyID: "ec-static", PEM: publicPEM(t, &ecA.PublicKey)},
+		},
+	}
+	rsaOnly := JWTConfig{
+		Algorithms: []string{"RS256"},
+		PublicKeys: []JWTKeyConfig{{PEM: publicPEM(t, &rsaA.PublicKey)}},
+	}
+	jwks := JWTConfig{
+		Algorithms: []string{"ES256"},
+		JWKSFile:   writeJWKS(t, map[string]*ecdsa.PrivateKey{"ec-a": ecA, "ec-b": ecB}),
+	}
+	singleJWKS := JWTConfig{
+		Algorithms: []string{"ES256"},
+		JWKSFile:   writeJWKS(t, map[string]*ecdsa.PrivateKey{"only": ecA}),
+	}
+
+	claims := map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+	header := func(alg, kid string) map[string]interface{} {
+		h := map[string]interface{}{"alg": alg, "typ": "JWT"}
+		if kid != "" {
+			h["kid"] = kid
+		}
+		return h
+	}
+
+	tests := []struct {
+		name    string
+		config  JWTConfig
+		token   string
+		wantErr string
+	}{
+		{"HS256 with the shared secret", static, signToken(t, header("HS256", ""), claims, secret), ""},
+		{"HS256 with the wrong secret", static, signToken(t, header("HS256", ""), claims, []byte("other")), "invalid signature"},
+		{"HS256 ignores kid", static, signToken(t, header("HS256", "rsa-a"), claims, secret), ""},
+		{"alg none", static, signToken(t, header("none", ""), claims, []byte{}), `algorithm "none" not allowed`},
+		{"alg outside the allow list", rsaOnly, signToken(t, header("HS256", ""), claims, secret), `algorithm "HS256" not allowed`},
+		{"unsupported alg", static, signToken(t, header("HS512", ""), claims, secret), `algorithm "HS512" not allowed`},
+		{"RS256 selects key by kid", static, signToken(t, header("RS256", "rsa-b"), claims, rsaB), ""},
+		{"RS256 kid names another key", static, signToken(t, header("RS256", "rsa-a"), claims, rsaB), "invalid signature"},
+		{"RS256 unknown kid", static, signToken(t, header("RS256", "rsa-c"), claims, rsaA), `unknown key id "rsa-c"`},
+		{"RS256 without kid and several keys", static, signToken(t, header("RS256", ""), claims, rsaA), `unknown key id ""`},
+		{"RS256 against an EC key", static, signToken(t, header("RS256", "ec-static"), claims, rsaA), "key type does not match algorithm RS256"},
+		{"single static key matches any kid", rsaOnly, signToken(t, header("RS256", "anything"), claims, rsaA), ""},
+		{"ES256 selects JWKS key by kid", jwks, signToken(t, header("ES256", "ec-b"), claims, ecB), ""},
+		{"ES256 kid names another JWKS key", jwks, signToken(t, header("ES256", "ec-a"), claims, ecB), "invalid signature"},
+		{"ES256 without kid and several JWKS keys", jwks, signToken(t, header("ES256", ""), claims, ecA), `unknown key id ""`},
+		{"ES256 without kid and a single JWKS key", singleJWKS, signToken(t, header("ES256", ""), claims, ecA), ""},
+		{"HS256 without a configured secret", JWTConfig{Algorithms: []string{"HS256", "RS256"}, PublicKeys: rsaOnly.PublicKeys}, signToken(t, header("HS256", ""), claims, []byte{}), "no HMAC secret configured"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator, err := NewJWTValidator(tt.config)
+			if err != nil {
+				t.Fatalf("NewJWTValidator() error = %v", err)
+			}
+			got, err := validator.Validate(tt.token)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				if got.Subject() != "alice" {
+					t.Fatalf("Subject() = %q, want alice", got.Subject())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTValidatorClaims(t *testing.T) {
+	secret := []byte("test-secret")
+	validator, err := NewJWTValidator(JWTConfig{
+		Issuer:     "https://issuer.example",
+		Audience:   []string{"gateway"},
+		HMACSecret: string(secret),
+		ClockSkew:  time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	valid := func(overrides map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{
+			"iss": "https://issuer.example",
+			"aud": []string{"other", "gateway"},
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		wantErr string
+	}{
+		{"valid", valid(nil), ""},
+		{"audience as a string", valid(map[string]interface{}{"aud": "gateway"}), ""},
+		{"missing exp", valid(map[string]interface{}{"exp": nil}), "token has no exp claim"},
+		{"expired", valid(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()}), "token expired"},
+		{"expired within skew", valid(map[string]interface{}{"exp": now.Unix()}), ""},
+		{"not valid yet", valid(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()}), "token not valid yet"},
+		{"wrong issuer", valid(map[string]interface{}{"iss": "https://evil.example"}), "unexpected issuer"},
+		{"wrong audience", valid(map[string]interface{}{"aud": "other"}), "token audience not accepted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validator.Validate(signToken(t, map[string]interface{}{"alg": "HS256"}, tt.claims, secret))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewJWTValidatorRejectsUnsupportedAlgorithms(t *testing.T) {
+	for _, alg := range []string{"none", "HS512", "PS256"} {
+		if _, err := NewJWTValidator(JWTConfig{Algorithms: []string{alg}, HMACSecret: "s"}); err == nil {
+			t.Errorf("NewJWTValidator(%q) accepted an unsupported algorithm", alg)
+		}
+	}
+}
+
+// jwksServer serves a JWKS document and counts fetches; fetches after the first
+// block while hold is set
+type jwksServer struct {
+	mu      sync.Mutex
+	doc     []byte
+	fetches atomic.Int64
+	hold    chan struct{} // nil lets fetches through
+}
+
+func newJWKSServer(t *testing.T, doc []byte) (*jwksServer, *httptest.Server) {
+	js := &jwksServer{doc: doc}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		js.mu.Lock()
+		doc, hold := js.doc, js.hold
+		js.mu.Unlock()
+		if js.fetches.Add(1) > 1 && hold != nil {
+			<-hold
+		}
+		w.Write(doc)
+	}))
+	t.Cleanup(server.Close)
+	return js, server
+}
+
+// set replaces the served document and whether fetches hang
+func (js *jwksServer) set(doc []byte, hold chan struct{}) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.doc, js.hold = doc, hold
+}
+
+// expire makes the cached keys stale and allows another refresh
+func expire(ks *keySet) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.lastFetch = ks.lastFetch.Add(-ks.jwksTTL)
+	ks.lastAttempt = ks.lastAttempt.Add(-minJWKSRefreshInterval)
+}
+
+func TestKeySetServesCachedKeysWhileRefreshing(t *testing.T) {
+	ecA, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	js, server := newJWKSServer(t, jwksDocument(t, map[string]*ecdsa.PrivateKey{"ec-a": ecA}))
+	ks, err := newKeySet(JWTConfig{JWKSURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The endpoint hangs; stale keys trigger a refresh that never finishes
+	hold := make(chan struct{})
+	defer close(hold)
+	js.set(js.doc, hold)
+	expire(ks)
+
+	for i := 0; i < 10; i++ {
+		done := make(chan error, 1)
+		go func() {
+			_, err := ks.lookup("ec-a", "ES256")
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("lookup() error = %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("lookup() of a cached key waited for the JWKS endpoint")
+		}
+	}
+
+	// The lookups started one background refresh between them
+	deadline := time.Now().Add(time.Second)
+	for js.fetches.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := js.fetches.Load(); got != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2 (initial load and one refresh)", got)
+	}
+
+	// Unknown key IDs don't wait either: a refresh was attempted just now
+	done := make(chan error, 1)
+	go func() {
+		_, err := ks.lookup("ec-unknown", "ES256")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("lookup() found an unknown key id")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lookup() of an unknown key id waited for the JWKS endpoint")
+	}
+}
+
+func TestKeySetUnknownKeyIDRefreshes(t *testing.T) {
+	ecA, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecB, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	js, server := newJWKSServer(t, jwksDocument(t, map[string]*ecdsa.PrivateKey{"ec-a": ecA}))
+	ks, err := newKeySet(JWTConfig{JWKSURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Right after a fetch an unknown kid doesn't trigger another one
+	if _, err := ks.lookup("ec-b", "ES256"); err == nil {
+		t.Fatal("lookup() found a key that was not published yet")
+	}
+	if got := js.fetches.Load(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", got)
+	}
+
+	// The issuer rotates in a new key; concurrent requests share one refresh
+	hold := make(chan struct{})
+	js.set(jwksDocument(t, map[string]*ecdsa.PrivateKey{"ec-a": ecA, "ec-b": ecB}), hold)
+	ks.mu.Lock()
+	ks.lastAttempt = ks.lastAttempt.Add(-minJWKSRefreshInterval)
+	ks.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ks.lookup("ec-b", "ES256")
+			errs <- err
+		}()
+	}
+	// Let the waiting requests pile up behind the one fetch
+	time.Sleep(50 * time.Millisecond)
+	close(hold)
+	wg.Wait()
+	close(errs)
+
+	failed := 0
+	for err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	// Requests arriving after the refresh started may have found it not due yet
+	if failed == 20 {
+		t.Fatal("no lookup found the rotated key")
+	}
+	if got := js.fetches.Load(); got != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2 (initial load and one shared refresh)", got)
+	}
+	if _, err := ks.lookup("ec-b", "ES256"); err != nil {
+		t.Fatalf("lookup() after the refresh error = %v", err)
+	}
+}
@@ -33,19 +33,33 @@ func LoggingMiddleware(store storage.LogStorage) gin.HandlerFunc {
 			backendStr = backend.(string)
 		}
 
+		// Get backend group from context (set by route proxy when the route is split)
+		backendGroup := c.GetString("backend_group")
+
+		// Get trace context from context (set by tracing middleware)
+		traceID := c.GetString("trace_id")
+		spanID := c.GetString("span_id")
+
+		// Get API key owner from context (set by API key middleware)
+		apiKeyOwner := c.GetString("api_key_owner")
+
 		// Create log entry
 		entry := collector.LogEntry{
-			Source:     "apigateway",
-			Level:      getLogLevel(c.Writer.Status()),
-			Message:    buildLogMessage(c, latency),
-			Time:       startTime,
-			Method:     c.Request.Method,
-			Path:       c.Request.URL.Path,
-			StatusCode: c.Writer.Status(),
-			Latency:    latency,
-			ClientIP:   c.ClientIP(),
-			UserAgent:  c.Request.UserAgent(),
-			Backend:    backendStr,
+			Source:       "apigateway",
+			Level:        getLogLevel(c.Writer.Status()),
+			Message:      buildLogMessage(c, latency),
+			Time:         startTime,
+			Method:       c.Request.Method,
+			Path:         c.Request.URL.Path,
+			StatusCode:   c.Writer.Status(),
+			Latency:      latency,
+			ClientIP:     c.ClientIP(),
+			UserAgent:    c.Request.UserAgent(),
+			Backend:      backendStr,
+			TraceID:      traceID,
+			SpanID:       spanID,
+			APIKeyOwner:  apiKeyOwner,
+			BackendGroup: backendGroup,
 		}
 
 		// Save to storage asynchronously to avoid blocking
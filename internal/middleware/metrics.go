@@ -0,0 +1,40 @@
+/*
+internal/middleware/metrics.go
+Package middleware provides Prometheus request metrics middleware.
+*/
+
+package middleware
+
+import (
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware creates a middleware that records request counts, latencies and in-flight requests
+func MetricsMiddleware(gm *metrics.GatewayMetrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startTime := time.Now()
+		gm.InFlight.Inc()
+		defer gm.InFlight.Dec()
+
+		c.Next()
+
+		// Use the route pattern rather than the raw path to keep label cardinality bounded
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		// Get backend from context (set by proxy handler)
+		backendStr := ""
+		if backend, ok := c.Get("backend"); ok {
+			backendStr, _ = backend.(string)
+		}
+
+		statusClass := metrics.StatusClass(c.Writer.Status())
+		gm.RequestsTotal.Inc(route, c.Request.Method, statusClass, backendStr)
+		gm.RequestDuration.Observe(time.Since(startTime).Seconds(), route, c.Request.Method, statusClass, backendStr)
+	}
+}
@@ -0,0 +1,370 @@
+/*
+internal/middleware/quota.go
+Package middleware provides daily and monthly usage quotas persisted in a QuotaStore.
+*/
+
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Quota periods, calendar days and months in UTC
+const (
+	QuotaPeriodDaily   = "daily"
+	QuotaPeriodMonthly = "monthly"
+)
+
+// QuotaConfig describes one quota policy
+type QuotaConfig struct {
+	Name      string           // Identifies the policy in storage, metrics and the admin API
+	Key       string           // One of the ClientKey* sources (default api_key)
+	Header    string           // Header name for the header key
+	Period    string           // QuotaPeriodDaily or QuotaPeriodMonthly
+	Limit     int64            // Requests per period
+	Overrides map[string]int64 // Client -> limit, e.g. per partner contract
+	Status    int              // Response status when exceeded (default 429)
+}
+
+// Quota enforces one quota policy
+type Quota struct {
+	config   QuotaConfig
+	tracker  *QuotaTracker
+	rejected atomic.Int64
+}
+
+// NewQuota validates a policy and creates its quota, counting in tracker
+func NewQuota(config QuotaConfig, tracker *QuotaTracker) (*Quota, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("quota name is required")
+	}
+	if config.Key == "" {
+		config.Key = ClientKeyAPIKey
+	}
+	switch config.Key {
+	case ClientKeyIP, ClientKeyAPIKey, ClientKeyJWTSubject:
+	case ClientKeyHeader:
+		if config.Header == "" {
+			return nil, fmt.Errorf("the header key requires a header name")
+		}
+	default:
+		return nil, fmt.Errorf("unknown client key %q", config.Key)
+	}
+	if config.Period != QuotaPeriodDaily && config.Period != QuotaPeriodMonthly {
+		return nil, fmt.Errorf("unknown period %q (use %q or %q)", config.Period, QuotaPeriodDaily, QuotaPeriodMonthly)
+	}
+	if config.Limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+	for client, limit := range config.Overrides {
+		if limit < 0 {
+			return nil, fmt.Errorf("override for %q must not be negative", client)
+		}
+	}
+	switch config.Status {
+	case 0:
+		config.Status = http.StatusTooManyRequests
+	case http.StatusTooManyRequests, http.StatusForbidden:
+	default:
+		return nil, fmt.Errorf("status must be 429 or 403")
+	}
+	return &Quota{config: config, tracker: tracker}, nil
+}
+
+// Name returns the policy name
+func (q *Quota) Name() string {
+	return q.config.Name
+}
+
+// Rejected returns the number of requests rejected by this quota
+func (q *Quota) Rejected() int64 {
+	return q.rejected.Load()
+}
+
+// LimitFor returns the limit of a client
+func (q *Quota) LimitFor(client string) int64 {
+	if limit, ok := q.config.Overrides[client]; ok {
+		return limit
+	}
+	return q.config.Limit
+}
+
+// Period returns the start and end of the period containing now
+func (q *Quota) Period(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	if q.config.Period == QuotaPeriodDaily {
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1)
+	}
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// quotaKey identifies the count of one client in one period
+type quotaKey struct {
+	policy string
+	client string
+	period time.Time
+}
+
+// quotaCount is the cached count of one client
+type quotaCount struct {
+	used    int64 // Stored count at the last load or flush
+	pending int64 // Requests not yet written to the store
+}
+
+// QuotaTracker caches quota counts: a client's count is loaded from the store on
+// its first request, and new requests are written every flush interval. Counts
+// without new requests are dropped at each flush and loaded again when needed,
+// so every replica sharing the store sees other replicas' requests and resets
+// within one interval.
+type QuotaTracker struct {
+	store    storage.QuotaStore
+	interval time.Duration
+	flushMu  sync.Mutex // Serializes flushes and resets
+	mu       sync.Mutex
+	counts   map[quotaKey]*quotaCount
+	resets   uint64 // Incremented by Reset, so loads that raced with it are retried
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewQuotaTracker creates a tracker flushing to store every interval (default 5s)
+func NewQuotaTracker(store storage.QuotaStore, interval time.Duration) *QuotaTracker {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &QuotaTracker{
+		store:    store,
+		interval: interval,
+		counts:   make(map[quotaKey]*quotaCount),
+	}
+}
+
+// Start begins flushing counts
+func (t *QuotaTracker) Start() {
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	go t.run()
+}
+
+// Stop stops flushing after writing the remaining counts
+func (t *QuotaTracker) Stop() {
+	if t.stop == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+	t.stop = nil
+}
+
+// run flushes every interval until stopped
+func (t *QuotaTracker) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			t.Flush()
+			return
+		case <-ticker.C:
+			t.Flush()
+		}
+	}
+}
+
+// Flush writes pending requests to the store, updating their counts with the
+// totals of all replicas, and drops the counts that had no new requests
+func (t *QuotaTracker) Flush() error {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+
+	t.mu.Lock()
+	var keys []quotaKey
+	var deltas []storage.QuotaUsage
+	for key, count := range t.counts {
+		if count.pending != 0 {
+			keys = append(keys, key)
+			deltas = append(deltas, storage.QuotaUsage{Policy: key.policy, Client: key.client, PeriodStart: key.period, Used: count.pending})
+		} else {
+			delete(t.counts, key)
+		}
+	}
+	t.mu.Unlock()
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	totals, err := t.store.AddQuotaUsage(deltas)
+	if err != nil {
+		log.Printf("Failed to save quota usage: %v", err)
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, key := range keys {
+		if count, ok := t.counts[key]; ok {
+			count.used = totals[i]
+			count.pending -= deltas[i].Used
+		}
+	}
+	return nil
+}
+
+// Reset deletes the count of a client (all clients when empty) in a period from
+// the store and the cache, including requests not yet written. It waits for a
+// flush in progress, so that flush cannot write the old count back.
+func (t *QuotaTracker) Reset(policy, client string, period time.Time) (int64, error) {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+
+	reset, err := t.store.ResetQuotaUsage(policy, client, period)
+	if err != nil {
+		return 0, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.resets++
+	for key := range t.counts {
+		if key.policy == policy && key.period.Equal(period) && (client == "" || key.client == client) {
+			delete(t.counts, key)
+		}
+	}
+	return reset, nil
+}
+
+// load returns the cached count of key, loading it from the store if needed
+func (t *QuotaTracker) load(key quotaKey) (*quotaCount, error) {
+	for {
+		t.mu.Lock()
+		count, ok := t.counts[key]
+		resets := t.resets
+		t.mu.Unlock()
+		if ok {
+			return count, nil
+		}
+
+		used, err := t.store.GetQuotaUsage(key.policy, key.client, key.period)
+		if err != nil {
+			return nil, err
+		}
+
+		t.mu.Lock()
+		if t.resets != resets {
+			// A reset may have deleted the count after it was read
+			t.mu.Unlock()
+			continue
+		}
+		// Another request may have loaded it meanwhile
+		if count, ok = t.counts[key]; !ok {
+			count = &quotaCount{used: used}
+			t.counts[key] = count
+		}
+		t.mu.Unlock()
+		return count, nil
+	}
+}
+
+// take counts a request if the client is within limit
+func (t *QuotaTracker) take(key quotaKey, limit int64) (func(), int64, bool, error) {
+	for {
+		count, err := t.load(key)
+		if err != nil {
+			return nil, 0, false, err
+		}
+
+		t.mu.Lock()
+		if t.counts[key] != count {
+			// Dropped by a flush or reset since it was loaded
+			t.mu.Unlock()
+			continue
+		}
+		used := count.used + count.pending
+		if used >= limit {
+			t.mu.Unlock()
+			return nil, used, false, nil
+		}
+		count.pending++
+		t.mu.Unlock()
+
+		undo := func() {
+			t.mu.Lock()
+			count.pending--
+			t.mu.Unlock()
+		}
+		return undo, used + 1, true, nil
+	}
+}
+
+// setQuotaHeaders describes a quota; the reset is a Unix time like X-RateLimit-Reset
+func setQuotaHeaders(c *gin.Context, limit, remaining int64, end time.Time) {
+	c.Header("X-Quota-Limit", strconv.FormatInt(limit, 10))
+	c.Header("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+	c.Header("X-Quota-Reset", strconv.FormatInt(end.Unix(), 10))
+}
+
+// QuotaMiddleware enforces quota policies; a request must be within all of them.
+// A rejected request gives back the rate limit tokens it took. It belongs after
+// authentication and rate limiting, so only requests that reach a backend count.
+// If a count cannot be loaded the request is let through.
+func QuotaMiddleware(quotas []*Quota, response RateLimitResponse) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		var undos []func()
+		var tightest *Quota
+		var tightLimit, tightRemaining int64
+		var tightEnd time.Time
+
+		for _, quota := range quotas {
+			client, ok := clientIdentity(c, quota.config.Key, quota.config.Header, 0, 0)
+			if !ok {
+				continue
+			}
+			start, end := quota.Period(now)
+			limit := quota.LimitFor(client)
+
+			undo, used, ok, err := quota.tracker.take(quotaKey{policy: quota.config.Name, client: client, period: start}, limit)
+			if err != nil {
+				log.Printf("Failed to load quota usage of %s for %s: %v", client, quota.config.Name, err)
+				continue
+			}
+			if !ok {
+				quota.rejected.Add(1)
+				for _, undo := range undos {
+					undo()
+				}
+				if value, ok := c.Get(rateLimitProgressKey); ok {
+					value.(*rateLimitProgress).refund()
+				}
+				setQuotaHeaders(c, limit, 0, end)
+				c.Header("X-Quota-Policy", quota.config.Name)
+				response.write(c, quota.config.Status, "Quota exceeded", end.Sub(now))
+				return
+			}
+			undos = append(undos, undo)
+
+			if remaining := limit - used; tightest == nil || remaining < tightRemaining {
+				tightest, tightLimit, tightRemaining, tightEnd = quota, limit, remaining, end
+			}
+		}
+
+		if tightest != nil {
+			setQuotaHeaders(c, tightLimit, tightRemaining, tightEnd)
+			c.Header("X-Quota-Policy", tightest.config.Name)
+		}
+		c.Next()
+	}
+}
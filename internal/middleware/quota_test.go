@@ -0,0 +1,283 @@
+/*
+internal/middleware/quota_test.go
+Package middleware tests quota periods, rollover and resets on a SQLite store.
+*/
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// newQuotaStore opens a SQLite store in the test's temporary directory.
+// Stores opened on the same path share their counts, like replicas do.
+func newQuotaStore(t *testing.T, path string) *storage.SQLiteStorage {
+	t.Helper()
+	store, err := storage.NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// takeQuota counts up to n requests and returns how many were admitted
+func takeQuota(t *testing.T, tracker *QuotaTracker, key quotaKey, limit int64, n int) int {
+	t.Helper()
+	admitted := 0
+	for i := 0; i < n; i++ {
+		_, _, ok, err := tracker.take(key, limit)
+		if err != nil {
+			t.Fatalf("take() error = %v", err)
+		}
+		if ok {
+			admitted++
+		}
+	}
+	return admitted
+}
+
+func TestQuotaPeriod(t *testing.T) {
+	daily, _ := NewQuota(QuotaConfig{Name: "daily", Period: QuotaPeriodDaily, Limit: 1}, nil)
+	monthly, _ := NewQuota(QuotaConfig{Name: "monthly", Period: QuotaPeriodMonthly, Limit: 1}, nil)
+	berlin := time.FixedZone("CET", 3600)
+
+	tests := []struct {
+		name      string
+		quota     *Quota
+		now       time.Time
+		wantStart string
+		wantEnd   string
+	}{
+		{"daily midday", daily, time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC), "2026-03-14", "2026-03-15"},
+		{"daily last nanosecond", daily, time.Date(2026, 3, 14, 23, 59, 59, 999999999, time.UTC), "2026-03-14", "2026-03-15"},
+		{"daily midnight starts the next day", daily, time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC), "2026-03-15", "2026-03-16"},
+		{"daily uses UTC days", daily, time.Date(2026, 3, 15, 0, 30, 0, 0, berlin), "2026-03-14", "2026-03-15"},
+		{"daily across years", daily, time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC), "2026-12-31", "2027-01-01"},
+		{"monthly", monthly, time.Date(2026, 1, 31, 23, 59, 59, 0, time.UTC), "2026-01-01", "2026-02-01"},
+		{"monthly uses UTC months", monthly, time.Date(2026, 3, 1, 0, 30, 0, 0, berlin), "2026-02-01", "2026-03-01"},
+		{"monthly leap February", monthly, time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC), "2028-02-01", "2028-03-01"},
+		{"monthly across years", monthly, time.Date(2026, 12, 15, 0, 0, 0, 0, time.UTC), "2026-12-01", "2027-01-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.quota.Period(tt.now)
+			if got := start.Format(time.DateOnly); got != tt.wantStart || start.Location() != time.UTC || start.Hour() != 0 {
+				t.Errorf("start = %s, want midnight UTC of %s", start, tt.wantStart)
+			}
+			if got := end.Format(time.DateOnly); got != tt.wantEnd || end.Hour() != 0 {
+				t.Errorf("end = %s, want midnight UTC of %s", end, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestQuotaRollover(t *testing.T) {
+	store := newQuotaStore(t, filepath.Join(t.TempDir(), "gateway.db"))
+	tracker := NewQuotaTracker(store, time.Hour)
+	quota, _ := NewQuota(QuotaConfig{Name: "monthly", Period: QuotaPeriodMonthly, Limit: 3}, tracker)
+
+	january, _ := quota.Period(time.Date(2026, 1, 31, 23, 59, 0, 0, time.UTC))
+	february, _ := quota.Period(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC))
+	jan := quotaKey{policy: "monthly", client: "acme", period: january}
+	feb := quotaKey{policy: "monthly", client: "acme", period: february}
+
+	if got := takeQuota(t, tracker, jan, 3, 5); got != 3 {
+		t.Fatalf("January admitted %d, want 3", got)
+	}
+	if err := tracker.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := takeQuota(t, tracker, feb, 3, 5); got != 3 {
+		t.Fatalf("February admitted %d after a full January, want 3", got)
+	}
+	tracker.Flush()
+
+	// Each period keeps its own count in the store
+	for _, key := range []quotaKey{jan, feb} {
+		used, err := store.GetQuotaUsage(key.policy, key.client, key.period)
+		if err != nil || used != 3 {
+			t.Fatalf("stored usage for %s = %d, %v, want 3", key.period.Format("2006-01"), used, err)
+		}
+	}
+
+	// A new tracker, e.g. after a restart, continues from the stored count
+	restarted := NewQuotaTracker(store, time.Hour)
+	if got := takeQuota(t, restarted, feb, 3, 1); got != 0 {
+		t.Fatal("a restarted tracker forgot the stored usage")
+	}
+}
+
+func TestQuotaTrackerReset(t *testing.T) {
+	tests := []struct {
+		name   string
+		client string // Client reset, "" for all
+	}{
+		{"one client", "acme"},
+		{"all clients", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "gateway.db")
+			replicaA := NewQuotaTracker(newQuotaStore(t, path), time.Hour)
+			replicaB := NewQuotaTracker(newQuotaStore(t, path), time.Hour)
+			period := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+			acme := quotaKey{policy: "daily", client: "acme", period: period}
+			other := quotaKey{policy: "daily", client: "other", period: period}
+
+			takeQuota(t, replicaA, acme, 2, 2)
+			takeQuota(t, replicaA, other, 2, 2)
+			replicaA.Flush()
+			if got := takeQuota(t, replicaB, acme, 2, 1); got != 0 {
+				t.Fatal("replica B admitted a client at its limit")
+			}
+
+			// Requests not yet flushed are reset too
+			takeQuota(t, replicaA, quotaKey{policy: "daily", client: "new", period: period}, 2, 1)
+			if _, err := replicaA.Reset("daily", tt.client, period); err != nil {
+				t.Fatal(err)
+			}
+			if got := takeQuota(t, replicaA, acme, 2, 3); got != 2 {
+				t.Fatalf("replica A admitted %d after the reset, want 2", got)
+			}
+			replicaA.Flush()
+
+			// Replica B sees the reset once its cached count is dropped by a flush
+			replicaB.Flush()
+			if got := takeQuota(t, replicaB, acme, 2, 1); got != 0 {
+				t.Fatal("replica B admitted more than the limit after the reset")
+			}
+			used, _ := replicaB.store.GetQuotaUsage("daily", "acme", period)
+			if used != 2 {
+				t.Fatalf("stored usage = %d after the reset and two requests, want 2", used)
+			}
+
+			otherUsed, _ := replicaA.store.GetQuotaUsage("daily", "other", period)
+			if wantOther := map[string]int64{"acme": 2, "": 0}[tt.client]; otherUsed != wantOther {
+				t.Fatalf("other client usage = %d, want %d", otherUsed, wantOther)
+			}
+			newUsed, _ := replicaA.store.GetQuotaUsage("daily", "new", period)
+			if tt.client == "" && newUsed != 0 {
+				t.Fatalf("unflushed usage of a reset client was written back: %d", newUsed)
+			}
+		})
+	}
+}
+
+func TestQuotaTrackerResetSeenByOtherReplica(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.db")
+	replicaA := NewQuotaTracker(newQuotaStore(t, path), time.Hour)
+	replicaB := NewQuotaTracker(newQuotaStore(t, path), time.Hour)
+	key := quotaKey{policy: "daily", client: "acme", period: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)}
+
+	takeQuota(t, replicaB, key, 2, 2)
+	replicaB.Flush()
+	if got := takeQuota(t, replicaB, key, 2, 1); got != 0 {
+		t.Fatal("replica B admitted a client at its limit")
+	}
+
+	// The reset happens on A; B's cached count at the limit must not outlive a flush
+	if _, err := replicaA.Reset("daily", "acme", key.period); err != nil {
+		t.Fatal(err)
+	}
+	replicaB.Flush()
+	if got := takeQuota(t, replicaB, key, 2, 1); got != 1 {
+		t.Fatal("replica B still rejects the client after the reset and a flush")
+	}
+}
+
+func TestQuotaMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tracker := NewQuotaTracker(newQuotaStore(t, filepath.Join(t.TempDir(), "gateway.db")), time.Hour)
+	daily, _ := NewQuota(QuotaConfig{Name: "daily", Key: ClientKeyHeader, Header: "X-Client", Period: QuotaPeriodDaily, Limit: 2, Overrides: map[string]int64{"blocked": 0}}, tracker)
+	monthly, _ := NewQuota(QuotaConfig{Name: "monthly", Key: ClientKeyHeader, Header: "X-Client", Period: QuotaPeriodMonthly, Limit: 100, Status: http.StatusForbidden}, tracker)
+
+	router := gin.New()
+	router.Use(QuotaMiddleware([]*Quota{daily, monthly}, RateLimitResponse{}))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if client != "" {
+			req.Header.Set("X-Client", client)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name          string
+		client        string
+		wantStatus    int
+		wantPolicy    string
+		wantRemaining string
+	}{
+		{"first request", "acme", http.StatusOK, "daily", "1"},
+		{"last request", "acme", http.StatusOK, "daily", "0"},
+		{"over the daily limit", "acme", http.StatusTooManyRequests, "daily", "0"},
+		{"override of zero", "blocked", http.StatusTooManyRequests, "daily", "0"},
+		{"no client key", "", http.StatusOK, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.client)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("X-Quota-Policy"); got != tt.wantPolicy {
+				t.Errorf("X-Quota-Policy = %q, want %q", got, tt.wantPolicy)
+			}
+			if got := rec.Header().Get("X-Quota-Remaining"); got != tt.wantRemaining {
+				t.Errorf("X-Quota-Remaining = %q, want %q", got, tt.wantRemaining)
+			}
+			if tt.wantStatus != http.StatusOK && rec.Header().Get("Retry-After") == "" {
+				t.Error("rejected request has no Retry-After")
+			}
+		})
+	}
+
+	// A request rejected by one quota does not count against the others
+	tracker.Flush()
+	start, _ := monthly.Period(time.Now())
+	used, _ := tracker.store.GetQuotaUsage("monthly", "acme", start)
+	if used != 2 {
+		t.Fatalf("monthly usage = %d, want 2 (the rejected request is not counted)", used)
+	}
+}
+
+func TestNewQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  QuotaConfig
+		wantErr bool
+	}{
+		{"defaults", QuotaConfig{Name: "q", Period: QuotaPeriodDaily, Limit: 1}, false},
+		{"no name", QuotaConfig{Period: QuotaPeriodDaily, Limit: 1}, true},
+		{"unknown period", QuotaConfig{Name: "q", Period: "weekly", Limit: 1}, true},
+		{"zero limit", QuotaConfig{Name: "q", Period: QuotaPeriodDaily}, true},
+		{"negative override", QuotaConfig{Name: "q", Period: QuotaPeriodDaily, Limit: 1, Overrides: map[string]int64{"a": -1}}, true},
+		{"header key without header", QuotaConfig{Name: "q", Key: ClientKeyHeader, Period: QuotaPeriodDaily, Limit: 1}, true},
+		{"cidr key", QuotaConfig{Name: "q", Key: ClientKeyCIDR, Period: QuotaPeriodDaily, Limit: 1}, true},
+		{"status 403", QuotaConfig{Name: "q", Period: QuotaPeriodDaily, Limit: 1, Status: http.StatusForbidden}, false},
+		{"status 503", QuotaConfig{Name: "q", Period: QuotaPeriodDaily, Limit: 1, Status: http.StatusServiceUnavailable}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewQuota(tt.config, nil); (err != nil) != tt.wantErr {
+				t.Fatalf("NewQuota() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
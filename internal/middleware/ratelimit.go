@@ -1,106 +1,350 @@
 /*
 internal/middleware/ratelimit.go
-Package middleware provides rate limiting middleware using token bucket algorithm.
+Package middleware provides rate limiting middleware using token buckets, or sliding windows in a shared store.
 */
 
 package middleware
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
-	"sync"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/time/rate"
 )
 
+// rateLimitProgressKey holds what the limits checked so far recorded for a request
+const rateLimitProgressKey = "rate_limit_progress"
+
+// Rate limit response body formats
+const (
+	RateLimitFormatJSON    = "json"    // {"error": "...", "retry_after": 3}
+	RateLimitFormatProblem = "problem" // RFC 7807 application/problem+json
+	RateLimitFormatText    = "text"    // The message as plain text
+)
+
+// RateLimitResponse configures the body of 429 responses
+type RateLimitResponse struct {
+	Format  string // One of the RateLimitFormat* constants (default json)
+	Message string // Replaces the default messages
+}
+
+// Validate checks the response format
+func (r RateLimitResponse) Validate() error {
+	switch r.Format {
+	case "", RateLimitFormatJSON, RateLimitFormatProblem, RateLimitFormatText:
+		return nil
+	}
+	return fmt.Errorf("unknown response format %q (use %q, %q or %q)", r.Format, RateLimitFormatJSON, RateLimitFormatProblem, RateLimitFormatText)
+}
+
+// reject writes a 429 for the limit that failed
+func (r RateLimitResponse) reject(c *gin.Context, message string, state limitState) {
+	if r.Message != "" {
+		message = r.Message
+	}
+	setRateLimitHeaders(c, state)
+	r.write(c, http.StatusTooManyRequests, message, state.retryAfter)
+}
+
+// write aborts the request with status and a body in the configured format
+func (r RateLimitResponse) write(c *gin.Context, status int, message string, retryAfter time.Duration) {
+	seconds := max(int64(math.Ceil(retryAfter.Seconds())), 1)
+	c.Header("Retry-After", strconv.FormatInt(seconds, 10))
+	switch r.Format {
+	case RateLimitFormatProblem:
+		body, _ := json.Marshal(gin.H{
+			"type":        "about:blank",
+			"title":       http.StatusText(status),
+			"status":      status,
+			"detail":      message,
+			"retry_after": seconds,
+		})
+		c.Data(status, "application/problem+json", body)
+	case RateLimitFormatText:
+		c.String(status, message)
+	default:
+		c.JSON(status, gin.H{
+			"error":       message,
+			"retry_after": seconds,
+		})
+	}
+	c.Abort()
+}
+
+// limitState is the state of one limit after a request was checked against it
+type limitState struct {
+	limit      int           // Bucket size
+	remaining  int           // Requests allowed right now
+	reset      time.Duration // Until the bucket is full again
+	retryAfter time.Duration // Until the next request is allowed, for rejected requests
+}
+
+// limit is one rate limit: an in-memory token bucket or a sliding window in a shared store
+type limit interface {
+	// take counts a request of the client key ("" for limits shared by all
+	// clients) if the limit allows it, and returns how to undo that
+	take(key string, now time.Time) (undo func(), state limitState, ok bool)
+}
+
+// tokenBucket is a limit backed by one in-memory token bucket
+type tokenBucket struct {
+	limiter *rate.Limiter
+}
+
+// take takes a token and reports the bucket's state afterwards. Cancelling a
+// reservation only refunds it at the time it was taken, so undo cancels at that time.
+func (b tokenBucket) take(key string, now time.Time) (func(), limitState, bool) {
+	reservation := reserve(b.limiter, now)
+	tokens := b.limiter.TokensAt(now)
+	burst := b.limiter.Burst()
+	perSecond := float64(b.limiter.Limit())
+
+	state := limitState{
+		limit:     burst,
+		remaining: max(int(tokens), 0),
+		reset:     time.Duration((float64(burst) - tokens) / perSecond * float64(time.Second)),
+	}
+	if reservation == nil {
+		state.retryAfter = time.Duration((1 - tokens) / perSecond * float64(time.Second))
+		return nil, state, false
+	}
+	return func() { reservation.CancelAt(now) }, state, true
+}
+
+// rateLimitProgress is what the limits checked so far recorded for a request
+type rateLimitProgress struct {
+	taken    []func()   // Undoes the requests counted so far
+	tightest limitState // The limit with the fewest requests left, reported in headers
+}
+
+// record adds a passed limit and keeps the tightest one
+func (p *rateLimitProgress) record(undo func(), state limitState) {
+	if len(p.taken) == 0 || state.remaining < p.tightest.remaining {
+		p.tightest = state
+	}
+	p.taken = append(p.taken, undo)
+}
+
+// refund gives back the tokens of a request rejected by a later limit
+func (p *rateLimitProgress) refund() {
+	for _, undo := range p.taken {
+		undo()
+	}
+}
+
+// setRateLimitHeaders describes a limit in the IETF draft RateLimit-* headers
+// and the legacy X-RateLimit-* ones (whose reset is a Unix time)
+func setRateLimitHeaders(c *gin.Context, state limitState) {
+	reset := int64(math.Ceil(state.reset.Seconds()))
+	c.Header("RateLimit-Limit", strconv.Itoa(state.limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(state.remaining))
+	c.Header("RateLimit-Reset", strconv.FormatInt(reset, 10))
+	c.Header("X-RateLimit-Limit", strconv.Itoa(state.limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(state.remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(time.Now().Unix()+reset, 10))
+}
+
 // RateLimiter manages rate limiting for the gateway
 type RateLimiter struct {
-	limiter *rate.Limiter
-	mu      sync.RWMutex
+	limit    limit
+	rejected atomic.Int64
 }
 
 // NewRateLimiter creates a new rate limiter
 func NewRateLimiter(requestsPerSecond int, burst int) *RateLimiter {
 	return &RateLimiter{
-		limiter: rate.NewLimiter(rate.Limit(requestsPerSecond), burst),
+		limit: tokenBucket{limiter: rate.NewLimiter(rate.Limit(requestsPerSecond), burst)},
 	}
 }
 
-// RateLimitMiddleware creates a middleware that enforces rate limiting
-func RateLimitMiddleware(limiter *RateLimiter) gin.HandlerFunc {
+// NewSharedRateLimiter creates a rate limiter counting in shared's store, so the
+// limit holds across all gateway replicas using it
+func NewSharedRateLimiter(shared *SharedRateLimits, requestsPerSecond int, burst int) (*RateLimiter, error) {
+	window, err := shared.window("global", float64(requestsPerSecond), burst)
+	if err != nil {
+		return nil, err
+	}
+	return &RateLimiter{limit: window}, nil
+}
+
+// Rejected returns the number of requests rejected by this limiter
+func (rl *RateLimiter) Rejected() int64 {
+	return rl.rejected.Load()
+}
+
+// RateLimitMiddleware creates a middleware that enforces rate limiting.
+// Route limits checked later give the token back if they reject the request.
+func RateLimitMiddleware(limiter *RateLimiter, response RateLimitResponse) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if limiter == nil {
 			c.Next()
 			return
 		}
 
-		limiter.mu.RLock()
-		allowed := limiter.limiter.Allow()
-		limiter.mu.RUnlock()
-
-		if !allowed {
-			c.Header("X-RateLimit-Limit", "100")
-			c.Header("X-RateLimit-Remaining", "0")
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded",
-			})
-			c.Abort()
+		undo, state, ok := limiter.limit.take("", time.Now())
+		if !ok {
+			limiter.rejected.Add(1)
+			response.reject(c, "Rate limit exceeded", state)
 			return
 		}
+		progress := &rateLimitProgress{}
+		progress.record(undo, state)
+		c.Set(rateLimitProgressKey, progress)
+		setRateLimitHeaders(c, state)
 
 		c.Next()
 	}
 }
 
-// PerRouteRateLimiter manages rate limiters for individual routes
-type PerRouteRateLimiter struct {
-	limiters map[string]*RateLimiter
-	mu       sync.RWMutex
+// reserve takes a token from limiter if one is available now, otherwise it
+// takes nothing and returns nil
+func reserve(limiter *rate.Limiter, now time.Time) *rate.Reservation {
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return nil
+	}
+	if reservation.DelayFrom(now) > 0 {
+		reservation.CancelAt(now)
+		return nil
+	}
+	return reservation
 }
 
-// NewPerRouteRateLimiter creates a new per-route rate limiter
-func NewPerRouteRateLimiter() *PerRouteRateLimiter {
-	return &PerRouteRateLimiter{
-		limiters: make(map[string]*RateLimiter),
-	}
+// RouteRateLimitConfig describes the limits of one route
+type RouteRateLimitConfig struct {
+	Name              string  // Route label, for metrics
+	RequestsPerSecond float64 // 0 = no route-wide limit
+	Burst             int     // Default: requests_per_second rounded up
+	Methods           map[string]MethodRateLimit
+	Clients           []*ClientRateLimiter // Per-client policies applying to the route
+	Shared            *SharedRateLimits    // Count in a shared store instead of in memory
 }
 
-// AddRoute adds a rate limiter for a specific route
-func (prl *PerRouteRateLimiter) AddRoute(path string, requestsPerSecond int, burst int) {
-	prl.mu.Lock()
-	defer prl.mu.Unlock()
-	prl.limiters[path] = NewRateLimiter(requestsPerSecond, burst)
+// MethodRateLimit replaces the route limit for one method; a zero rate exempts the method
+type MethodRateLimit struct {
+	RequestsPerSecond float64
+	Burst             int
 }
 
-// PerRouteRateLimitMiddleware creates a middleware that enforces per-route rate limiting
-func PerRouteRateLimitMiddleware(prl *PerRouteRateLimiter) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		if prl == nil {
-			c.Next()
-			return
+// RouteRateLimiter evaluates a route's own limit and its per-client policies
+// together with the tokens taken by the global limit
+type RouteRateLimiter struct {
+	name     string
+	route    limit            // nil when the route has no route-wide limit
+	methods  map[string]limit // nil values exempt the method
+	clients  []*ClientRateLimiter
+	rejected atomic.Int64
+}
+
+// NewRouteRateLimiter validates a route's limits and creates its limiter
+func NewRouteRateLimiter(config RouteRateLimitConfig) (*RouteRateLimiter, error) {
+	rl := &RouteRateLimiter{
+		name:    config.Name,
+		methods: make(map[string]limit, len(config.Methods)),
+		clients: config.Clients,
+	}
+
+	route, err := newLimit(config.Shared, "route:"+config.Name, config.RequestsPerSecond, config.Burst)
+	if err != nil {
+		return nil, err
+	}
+	rl.route = route
+	for method, methodLimit := range config.Methods {
+		method = strings.ToUpper(method)
+		limit, err := newLimit(config.Shared, "route:"+config.Name+":"+method, methodLimit.RequestsPerSecond, methodLimit.Burst)
+		if err != nil {
+			return nil, fmt.Errorf("method %s: %w", method, err)
 		}
+		rl.methods[method] = limit
+	}
+	return rl, nil
+}
 
-		prl.mu.RLock()
-		limiter, exists := prl.limiters[c.FullPath()]
-		prl.mu.RUnlock()
+// newLimit creates a token bucket, or a sliding window named name when shared
+// is set. It returns nil for a zero rate.
+func newLimit(shared *SharedRateLimits, name string, requestsPerSecond float64, burst int) (limit, error) {
+	if requestsPerSecond < 0 || burst < 0 {
+		return nil, fmt.Errorf("requests_per_second and burst must not be negative")
+	}
+	if requestsPerSecond == 0 {
+		return nil, nil
+	}
+	if burst == 0 {
+		burst = int(requestsPerSecond + 0.999)
+	}
+	if shared != nil {
+		return shared.window(name, requestsPerSecond, burst)
+	}
+	return tokenBucket{limiter: rate.NewLimiter(rate.Limit(requestsPerSecond), burst)}, nil
+}
 
-		if !exists {
-			c.Next()
-			return
+// Name returns the route label
+func (rl *RouteRateLimiter) Name() string {
+	return rl.name
+}
+
+// Rejected returns the number of requests rejected by the route or method limit
+func (rl *RouteRateLimiter) Rejected() int64 {
+	return rl.rejected.Load()
+}
+
+// Clients returns the per-client policies applying to the route
+func (rl *RouteRateLimiter) Clients() []*ClientRateLimiter {
+	return rl.clients
+}
+
+// RouteRateLimitMiddleware enforces a route's limits. Limits are checked in the
+// order global, route (or method), per-client; a request must pass all of them and
+// only takes tokens if it does. The headers describe the limit with the fewest
+// requests left. It belongs after authentication so API key and JWT keys are known.
+func RouteRateLimitMiddleware(rl *RouteRateLimiter, response RateLimitResponse) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		progress := &rateLimitProgress{}
+		if value, ok := c.Get(rateLimitProgressKey); ok {
+			progress = value.(*rateLimitProgress)
 		}
 
-		limiter.mu.RLock()
-		allowed := limiter.limiter.Allow()
-		limiter.mu.RUnlock()
+		limit, ok := rl.methods[c.Request.Method]
+		if !ok {
+			limit = rl.route
+		}
+		if limit != nil {
+			undo, state, ok := limit.take("", now)
+			if !ok {
+				rl.rejected.Add(1)
+				progress.refund()
+				response.reject(c, "Rate limit exceeded for this route", state)
+				return
+			}
+			progress.record(undo, state)
+		}
 
-		if !allowed {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded for this route",
-			})
-			c.Abort()
-			return
+		for _, client := range rl.clients {
+			key, ok := client.clientKey(c)
+			if !ok {
+				continue
+			}
+			undo, state, ok := client.take(key, now)
+			if !ok {
+				client.rejected.Add(1)
+				progress.refund()
+				response.reject(c, "Rate limit exceeded", state)
+				return
+			}
+			progress.record(undo, state)
 		}
 
+		if len(progress.taken) > 0 {
+			setRateLimitHeaders(c, progress.tightest)
+		}
 		c.Next()
 	}
 }
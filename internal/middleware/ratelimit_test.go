@@ -0,0 +1,315 @@
+/*
+internal/middleware/ratelimit_test.go
+Package middleware tests the global and per-route rate limits, the order they are
+checked in and the RateLimit headers and 429 responses they produce.
+*/
+
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limitedRouter serves /api/*filepath behind the global limiter (nil for none) and a route limiter
+func limitedRouter(t *testing.T, global *RateLimiter, config RouteRateLimitConfig) (*gin.Engine, *RouteRateLimiter) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	rl, err := NewRouteRateLimiter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.Use(RateLimitMiddleware(global, RateLimitResponse{}))
+	router.Any("/api/*filepath", RouteRateLimitMiddleware(rl, RateLimitResponse{}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router, rl
+}
+
+// send makes a request from a client and returns the response
+func send(router *gin.Engine, method, client string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/users", nil)
+	req.RemoteAddr = "198.51.100.1:40000"
+	if client != "" {
+		req.Header.Set("X-Tenant", client)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRouteRateLimit(t *testing.T) {
+	type step struct {
+		method     string
+		wantStatus int
+	}
+	get := func(status int) step { return step{http.MethodGet, status} }
+	post := func(status int) step { return step{http.MethodPost, status} }
+
+	tests := []struct {
+		name         string
+		config       RouteRateLimitConfig
+		steps        []step
+		wantRejected int64
+	}{
+		{"route limit", RouteRateLimitConfig{RequestsPerSecond: 0.01, Burst: 2},
+			[]step{get(200), post(200), get(429)}, 1},
+		{"burst defaults to the rate", RouteRateLimitConfig{RequestsPerSecond: 1.5},
+			[]step{get(200), get(200), get(429)}, 1},
+		{"method limit replaces the route limit", RouteRateLimitConfig{
+			RequestsPerSecond: 0.01, Burst: 5,
+			Methods: map[string]MethodRateLimit{"post": {RequestsPerSecond: 0.01, Burst: 1}},
+		}, []step{post(200), post(429), get(200), get(200)}, 1},
+		{"zero rate exempts a method", RouteRateLimitConfig{
+			RequestsPerSecond: 0.01, Burst: 1,
+			Methods: map[string]MethodRateLimit{http.MethodGet: {}},
+		}, []step{get(200), get(200), get(200), post(200), post(429)}, 1},
+		{"method limit without a route limit", RouteRateLimitConfig{
+			Methods: map[string]MethodRateLimit{http.MethodPost: {RequestsPerSecond: 0.01, Burst: 1}},
+		}, []step{post(200), post(429), get(200), get(200)}, 1},
+		{"no limits", RouteRateLimitConfig{}, []step{get(200), get(200), post(200)}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Name = "users"
+			router, rl := limitedRouter(t, nil, tt.config)
+			for i, step := range tt.steps {
+				if rec := send(router, step.method, ""); rec.Code != step.wantStatus {
+					t.Fatalf("request %d (%s): status %d, want %d", i+1, step.method, rec.Code, step.wantStatus)
+				}
+			}
+			if rl.Rejected() != tt.wantRejected {
+				t.Fatalf("Rejected() = %d, want %d", rl.Rejected(), tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestRouteRateLimitsAreSeparate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	for _, path := range []string{"/api/users", "/api/orders"} {
+		rl, _ := NewRouteRateLimiter(RouteRateLimitConfig{Name: path, RequestsPerSecond: 0.01, Burst: 1})
+		router.GET(path, RouteRateLimitMiddleware(rl, RateLimitResponse{}), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+	}
+
+	for i, tt := range []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/api/users", http.StatusOK},
+		{"/api/orders", http.StatusOK},
+		{"/api/users", http.StatusTooManyRequests},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("request %d to %s: status %d, want %d", i+1, tt.path, rec.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestRateLimitsRefundRejectedRequests(t *testing.T) {
+	// A request rejected by a later limit gives back what the earlier ones counted
+	client, _ := NewClientRateLimiter(ClientRateLimitConfig{Key: ClientKeyHeader, Header: "X-Tenant", RequestsPerSecond: 0.01, Burst: 1})
+	global := NewRateLimiter(1, 3)
+	router, rl := limitedRouter(t, global, RouteRateLimitConfig{
+		Name:              "users",
+		RequestsPerSecond: 0.01,
+		Burst:             3,
+		Clients:           []*ClientRateLimiter{client},
+	})
+
+	steps := []struct {
+		client     string
+		wantStatus int
+	}{
+		{"acme", http.StatusOK},
+		{"acme", http.StatusTooManyRequests}, // Client limit; global and route tokens refunded
+		{"acme", http.StatusTooManyRequests},
+		{"globex", http.StatusOK},
+		{"initech", http.StatusOK}, // Only passes if the rejected requests were refunded
+		{"umbrella", http.StatusTooManyRequests},
+	}
+	for i, step := range steps {
+		if rec := send(router, http.MethodGet, step.client); rec.Code != step.wantStatus {
+			t.Fatalf("request %d from %s: status %d, want %d", i+1, step.client, rec.Code, step.wantStatus)
+		}
+	}
+
+	// The last request was turned away by the global limit, before the route was checked
+	if global.Rejected() != 1 || rl.Rejected() != 0 || client.Rejected() != 2 {
+		t.Fatalf("rejected: global %d, route %d, client %d, want 1, 0 and 2", global.Rejected(), rl.Rejected(), client.Rejected())
+	}
+}
+
+func TestNewRouteRateLimiterValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RouteRateLimitConfig
+	}{
+		{"negative rate", RouteRateLimitConfig{RequestsPerSecond: -1}},
+		{"negative burst", RouteRateLimitConfig{RequestsPerSecond: 1, Burst: -1}},
+		{"negative method rate", RouteRateLimitConfig{Methods: map[string]MethodRateLimit{http.MethodPost: {RequestsPerSecond: -1}}}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewRouteRateLimiter(tt.config); err == nil {
+			t.Errorf("%s: NewRouteRateLimiter() accepted %+v", tt.name, tt.config)
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	// The route limit is the tighter one, so the headers describe it
+	router, _ := limitedRouter(t, NewRateLimiter(1, 5), RouteRateLimitConfig{Name: "users", RequestsPerSecond: 0.5, Burst: 2})
+
+	tests := []struct {
+		wantStatus    int
+		wantRemaining string
+	}{
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, "0"},
+	}
+	for i, tt := range tests {
+		start := time.Now().Unix()
+		rec := send(router, http.MethodGet, "")
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("request %d: status %d, want %d", i+1, rec.Code, tt.wantStatus)
+		}
+		header := rec.Header()
+		for _, name := range []string{"RateLimit-Limit", "X-RateLimit-Limit"} {
+			if got := header.Get(name); got != "2" {
+				t.Fatalf("request %d: %s = %q, want 2", i+1, name, got)
+			}
+		}
+		for _, name := range []string{"RateLimit-Remaining", "X-RateLimit-Remaining"} {
+			if got := header.Get(name); got != tt.wantRemaining {
+				t.Fatalf("request %d: %s = %q, want %s", i+1, name, got, tt.wantRemaining)
+			}
+		}
+
+		// Two tokens refill at 0.5/s, so the bucket is full again within 4 seconds
+		reset, err := strconv.Atoi(header.Get("RateLimit-Reset"))
+		if err != nil || reset < 1 || reset > 4 {
+			t.Fatalf("request %d: RateLimit-Reset = %q, want 1 to 4 seconds", i+1, header.Get("RateLimit-Reset"))
+		}
+		legacy, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil || legacy < start+int64(reset)-1 || legacy > start+int64(reset)+1 {
+			t.Fatalf("request %d: X-RateLimit-Reset = %q, want the Unix time %d seconds from now", i+1, header.Get("X-RateLimit-Reset"), reset)
+		}
+
+		retryAfter := header.Get("Retry-After")
+		if (retryAfter != "") != (tt.wantStatus == http.StatusTooManyRequests) {
+			t.Fatalf("request %d: Retry-After = %q on a %d", i+1, retryAfter, rec.Code)
+		}
+	}
+}
+
+func TestGlobalRateLimitRejection(t *testing.T) {
+	global := NewRateLimiter(1, 3)
+	router, _ := limitedRouter(t, global, RouteRateLimitConfig{Name: "users"})
+	for i := 0; i < 3; i++ {
+		send(router, http.MethodGet, "")
+	}
+
+	rec := send(router, http.MethodGet, "")
+	if rec.Code != http.StatusTooManyRequests || global.Rejected() != 1 {
+		t.Fatalf("status %d, rejected %d, want 429 and 1", rec.Code, global.Rejected())
+	}
+	// The configured burst, not a fixed number
+	if rec.Header().Get("X-RateLimit-Limit") != "3" || rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("X-RateLimit-Limit %q, RateLimit-Remaining %q, want 3 and 0",
+			rec.Header().Get("X-RateLimit-Limit"), rec.Header().Get("RateLimit-Remaining"))
+	}
+	if rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Retry-After = %q, want 1", rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimitHeadersWithoutRouteLimit(t *testing.T) {
+	router, _ := limitedRouter(t, NewRateLimiter(1, 5), RouteRateLimitConfig{Name: "users"})
+	rec := send(router, http.MethodGet, "")
+	if rec.Header().Get("RateLimit-Limit") != "5" || rec.Header().Get("RateLimit-Remaining") != "4" {
+		t.Fatalf("RateLimit-Limit %q, RateLimit-Remaining %q, want the global limit's 5 and 4",
+			rec.Header().Get("RateLimit-Limit"), rec.Header().Get("RateLimit-Remaining"))
+	}
+
+	// Without any limit there is nothing to describe
+	router, _ = limitedRouter(t, nil, RouteRateLimitConfig{Name: "users"})
+	if rec := send(router, http.MethodGet, ""); rec.Header().Get("RateLimit-Limit") != "" {
+		t.Fatalf("RateLimit-Limit = %q on an unlimited route", rec.Header().Get("RateLimit-Limit"))
+	}
+}
+
+func TestRateLimitResponse(t *testing.T) {
+	tests := []struct {
+		name            string
+		response        RateLimitResponse
+		rate            float64
+		wantContentType string
+		wantRetryAfter  string
+		wantBody        string // Expected message, or the whole body for text
+	}{
+		{"json", RateLimitResponse{}, 0.5, "application/json; charset=utf-8", "2", "Rate limit exceeded for this route"},
+		{"problem", RateLimitResponse{Format: RateLimitFormatProblem}, 0.25, "application/problem+json", "4", "Rate limit exceeded for this route"},
+		{"text", RateLimitResponse{Format: RateLimitFormatText}, 0.5, "text/plain; charset=utf-8", "2", "Rate limit exceeded for this route"},
+		{"custom message", RateLimitResponse{Message: "Slow down"}, 0.5, "application/json; charset=utf-8", "2", "Slow down"},
+		{"retry after at least a second", RateLimitResponse{}, 50, "application/json; charset=utf-8", "1", "Rate limit exceeded for this route"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			rl, _ := NewRouteRateLimiter(RouteRateLimitConfig{Name: "users", RequestsPerSecond: tt.rate, Burst: 1})
+			router := gin.New()
+			router.GET("/api/*filepath", RouteRateLimitMiddleware(rl, tt.response), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			send(router, http.MethodGet, "")
+
+			rec := send(router, http.MethodGet, "")
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("status %d, want 429", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Fatalf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Fatalf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+
+			if tt.response.Format == RateLimitFormatText {
+				if rec.Body.String() != tt.wantBody {
+					t.Fatalf("body %q, want %q", rec.Body.String(), tt.wantBody)
+				}
+				return
+			}
+			var body map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q is not JSON: %v", rec.Body.String(), err)
+			}
+			message := body["error"]
+			if tt.response.Format == RateLimitFormatProblem {
+				message = body["detail"]
+				if body["status"] != float64(http.StatusTooManyRequests) || body["title"] != "Too Many Requests" {
+					t.Fatalf("problem body %v lacks the status and title", body)
+				}
+			}
+			if message != tt.wantBody || strconv.Itoa(int(body["retry_after"].(float64))) != tt.wantRetryAfter {
+				t.Fatalf("body %v, want message %q and retry_after %s", body, tt.wantBody, tt.wantRetryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+/*
+internal/middleware/sharedlimit.go
+Package middleware provides sliding window rate limits counted in a store shared by gateway replicas.
+*/
+
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/storage"
+)
+
+// sharedSyncTimeout bounds one exchange with the store
+const sharedSyncTimeout = 5 * time.Second
+
+// SharedRateLimits counts requests in sliding windows kept in a RateLimitStore.
+// Requests are decided on locally cached counts; every sync interval the local
+// increments are pushed to the store and the totals of all replicas read back.
+// Between syncs each replica only sees its own new requests, so replicas together
+// can exceed a limit by what they admit in one interval.
+type SharedRateLimits struct {
+	store      storage.RateLimitStore
+	interval   time.Duration
+	mu         sync.Mutex
+	counters   map[string]*windowCounter
+	syncErrors atomic.Int64
+	failing    bool // Last sync failed, logged once until it recovers
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// windowCounter is the count of one client in one window
+type windowCounter struct {
+	synced    int64     // Total of all replicas at the last sync
+	pending   int64     // Local changes not yet pushed to the store
+	expires   time.Time // Once the window no longer counts
+	forgotten bool      // Client evicted; dropped once pending changes are pushed
+}
+
+// NewSharedRateLimits creates shared limits on store, synced every interval (default 100ms)
+func NewSharedRateLimits(store storage.RateLimitStore, interval time.Duration) *SharedRateLimits {
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	return &SharedRateLimits{
+		store:    store,
+		interval: interval,
+		counters: make(map[string]*windowCounter),
+	}
+}
+
+// SyncErrors returns the number of failed syncs with the store
+func (s *SharedRateLimits) SyncErrors() int64 {
+	return s.syncErrors.Load()
+}
+
+// Start begins syncing with the store
+func (s *SharedRateLimits) Start() {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run()
+}
+
+// Stop stops syncing after pushing the remaining local counts
+func (s *SharedRateLimits) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+// run syncs every interval until stopped
+func (s *SharedRateLimits) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			s.sync()
+			return
+		case <-ticker.C:
+			s.sync()
+		}
+	}
+}
+
+// sync pushes local changes and reads the totals of every live window.
+// While the store is unreachable requests are limited on local counts only.
+func (s *SharedRateLimits) sync() {
+	now := time.Now()
+	s.mu.Lock()
+	deltas := make(map[string]int64, len(s.counters))
+	var ttl time.Duration
+	for key, counter := range s.counters {
+		if now.After(counter.expires) {
+			delete(s.counters, key)
+			continue
+		}
+		deltas[key] = counter.pending
+		ttl = max(ttl, counter.expires.Sub(now))
+	}
+	s.mu.Unlock()
+	if len(deltas) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sharedSyncTimeout)
+	values, err := s.store.AddRateLimitCounters(ctx, deltas, ttl+s.interval)
+	cancel()
+	if err != nil {
+		s.syncErrors.Add(1)
+		if !s.failing {
+			log.Printf("Rate limit store sync failed, limiting on local counts: %v", err)
+			s.failing = true
+		}
+		return
+	}
+	if s.failing {
+		log.Printf("Rate limit store sync recovered")
+		s.failing = false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, delta := range deltas {
+		if counter, ok := s.counters[key]; ok {
+			counter.synced = values[key]
+			counter.pending -= delta
+			if counter.forgotten && counter.pending == 0 {
+				delete(s.counters, key)
+			}
+		}
+	}
+}
+
+// counter returns the counter of key, creating it if needed. s.mu must be held.
+func (s *SharedRateLimits) counter(key string, expires time.Time) *windowCounter {
+	counter, ok := s.counters[key]
+	if !ok {
+		counter = &windowCounter{expires: expires}
+		s.counters[key] = counter
+	}
+	counter.forgotten = false
+	return counter
+}
+
+// window creates a sliding window limit admitting burst requests per
+// burst/requestsPerSecond, the same average rate and burst as a token bucket
+func (s *SharedRateLimits) window(name string, requestsPerSecond float64, burst int) (*windowLimit, error) {
+	length := time.Duration(float64(burst) / requestsPerSecond * float64(time.Second))
+	if length < time.Millisecond {
+		return nil, fmt.Errorf("burst %d at %g requests per second is below the 1ms window resolution", burst, requestsPerSecond)
+	}
+	return &windowLimit{
+		shared: s,
+		name:   name,
+		limit:  burst,
+		length: length.Truncate(time.Millisecond),
+	}, nil
+}
+
+// windowLimit is a sliding window limit: the count of the current window plus
+// the previous window's count weighted by how much of it still overlaps
+type windowLimit struct {
+	shared *SharedRateLimits
+	name   string // Identifies the limit in the store, the same on every replica
+	limit  int
+	length time.Duration
+}
+
+// prefix returns the start of the counter keys of a client
+func (w *windowLimit) prefix(key string) string {
+	return w.name + ":" + key + ":" + strconv.FormatInt(w.length.Milliseconds(), 10) + ":"
+}
+
+// forget drops the counters a client last used at lastSeen, so memory is bounded
+// by the clients a ClientRateLimiter keeps. Counters with changes not yet pushed
+// stay until the next sync; older windows have expired and are dropped by sync.
+func (w *windowLimit) forget(key string, lastSeen time.Time) {
+	index := lastSeen.UnixMilli() / w.length.Milliseconds()
+	prefix := w.prefix(key)
+
+	s := w.shared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, i := range []int64{index, index - 1} {
+		name := prefix + strconv.FormatInt(i, 10)
+		if counter, ok := s.counters[name]; ok {
+			if counter.pending == 0 {
+				delete(s.counters, name)
+			} else {
+				counter.forgotten = true
+			}
+		}
+	}
+}
+
+// take counts a request of key if the window has room for it
+func (w *windowLimit) take(key string, now time.Time) (func(), limitState, bool) {
+	ms := w.length.Milliseconds()
+	index := now.UnixMilli() / ms
+	elapsed := float64(now.UnixMilli()%ms) / float64(ms)
+	prefix := w.prefix(key)
+	ends := time.UnixMilli((index + 1) * ms)
+
+	s := w.shared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A window counts until the end of the window after it
+	current := s.counter(prefix+strconv.FormatInt(index, 10), ends.Add(w.length))
+	previous := s.counter(prefix+strconv.FormatInt(index-1, 10), ends)
+	prev := float64(previous.synced + previous.pending)
+	cur := float64(current.synced + current.pending)
+	used := prev*(1-elapsed) + cur
+	untilEnd := ends.Sub(now)
+
+	state := limitState{limit: w.limit, reset: untilEnd}
+	if cur > 0 {
+		state.reset += w.length
+	}
+	if excess := used + 1 - float64(w.limit); excess > 0 {
+		switch {
+		case prev > 0 && excess <= prev*(1-elapsed):
+			// The previous window's weight fades enough before this one ends
+			state.retryAfter = time.Duration(excess / prev * float64(w.length))
+		case cur > 0:
+			// This window becomes the previous one and has to fade
+			fade := min((cur+1-float64(w.limit))/cur, 1)
+			state.retryAfter = untilEnd + time.Duration(fade*float64(w.length))
+		default:
+			state.retryAfter = untilEnd
+		}
+		return nil, state, false
+	}
+
+	current.pending++
+	state.remaining = int(float64(w.limit) - used - 1)
+	undo := func() {
+		s.mu.Lock()
+		current.pending--
+		s.mu.Unlock()
+	}
+	return undo, state, true
+}
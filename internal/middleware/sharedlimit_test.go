@@ -0,0 +1,449 @@
+/*
+internal/middleware/sharedlimit_test.go
+Package middleware tests the shared sliding window limits against the in-memory,
+SQLite and Redis-protocol stores.
+*/
+
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/storage"
+)
+
+// respServer is an in-process stand-in for a Redis server implementing the
+// commands RESPRateLimitStore sends: AUTH, SELECT, INCRBY, PEXPIRE and GET
+type respServer struct {
+	listener net.Listener
+	password string
+	mu       sync.Mutex
+	values   map[string]int64
+	expires  map[string]time.Time
+	selected []string // Databases selected by connections
+	conns    []net.Conn
+}
+
+// startRESPServer listens on a local port until the test ends
+func startRESPServer(t *testing.T, password string) *respServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &respServer{
+		listener: listener,
+		password: password,
+		values:   make(map[string]int64),
+		expires:  make(map[string]time.Time),
+	}
+	t.Cleanup(rs.close)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			rs.mu.Lock()
+			rs.conns = append(rs.conns, conn)
+			rs.mu.Unlock()
+			go rs.serve(conn)
+		}
+	}()
+	return rs
+}
+
+// addr returns the address to connect to
+func (rs *respServer) addr() string {
+	return rs.listener.Addr().String()
+}
+
+// close stops accepting and drops every connection
+func (rs *respServer) close() {
+	rs.listener.Close()
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, conn := range rs.conns {
+		conn.Close()
+	}
+}
+
+// serve answers the commands of one connection
+func (rs *respServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := rs.password == ""
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		var reply string
+		switch command := strings.ToUpper(args[0]); {
+		case command == "AUTH":
+			if len(args) == 2 && args[1] == rs.password {
+				authenticated = true
+				reply = "+OK"
+			} else {
+				reply = "-WRONGPASS invalid username-password pair"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required."
+		default:
+			reply = rs.execute(command, args[1:])
+		}
+		if _, err := io.WriteString(conn, reply+"\r\n"); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs a data command and returns its encoded reply
+func (rs *respServer) execute(command string, args []string) string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := time.Now()
+	if len(args) > 0 {
+		if expires, ok := rs.expires[args[0]]; ok && now.After(expires) {
+			delete(rs.values, args[0])
+			delete(rs.expires, args[0])
+		}
+	}
+
+	switch {
+	case command == "SELECT" && len(args) == 1:
+		rs.selected = append(rs.selected, args[0])
+		return "+OK"
+	case command == "INCRBY" && len(args) == 2:
+		delta, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		rs.values[args[0]] += delta
+		return ":" + strconv.FormatInt(rs.values[args[0]], 10)
+	case command == "PEXPIRE" && len(args) == 2:
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer or out of range"
+		}
+		if _, ok := rs.values[args[0]]; !ok {
+			return ":0"
+		}
+		rs.expires[args[0]] = now.Add(time.Duration(ms) * time.Millisecond)
+		return ":1"
+	case command == "GET" && len(args) == 1:
+		value, ok := rs.values[args[0]]
+		if !ok {
+			return "$-1"
+		}
+		s := strconv.FormatInt(value, 10)
+		return fmt.Sprintf("$%d\r\n%s", len(s), s)
+	}
+	return "-ERR unknown command '" + command + "'"
+}
+
+// readCommand reads one command sent as an array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("malformed command %q", line)
+	}
+	args := make([]string, count)
+	for i := range args {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("malformed bulk string %q", header)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+// sharedStores returns a constructor per store kind; each call to the
+// constructor returns a new client of the same underlying store
+func sharedStores(t *testing.T) map[string]func() storage.RateLimitStore {
+	memory := storage.NewMemoryRateLimitStore()
+	dbPath := filepath.Join(t.TempDir(), "gateway.db")
+	server := startRESPServer(t, "")
+
+	return map[string]func() storage.RateLimitStore{
+		"memory": func() storage.RateLimitStore { return memory },
+		"sqlite": func() storage.RateLimitStore {
+			store, err := storage.NewSQLiteStorage(dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+		"resp": func() storage.RateLimitStore {
+			store, err := storage.NewRESPRateLimitStore(storage.RESPConfig{Address: server.addr()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+}
+
+// windowStart returns the start of the current window of the given length
+func windowStart(length time.Duration) time.Time {
+	ms := length.Milliseconds()
+	return time.UnixMilli(time.Now().UnixMilli() / ms * ms)
+}
+
+// takeN counts up to n requests and returns how many were admitted
+func takeN(w *windowLimit, key string, now time.Time, n int) int {
+	admitted := 0
+	for i := 0; i < n; i++ {
+		if _, _, ok := w.take(key, now); ok {
+			admitted++
+		}
+	}
+	return admitted
+}
+
+func TestSharedWindowAcrossReplicas(t *testing.T) {
+	for name, newStore := range sharedStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Two replicas with their own connection to the same store
+			replicaA := NewSharedRateLimits(newStore(), time.Hour)
+			replicaB := NewSharedRateLimits(newStore(), time.Hour)
+			windowA, err := replicaA.window("test:"+name, 1, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			windowB, _ := replicaB.window("test:"+name, 1, 10)
+			now := windowStart(windowA.length)
+
+			if got := takeN(windowA, "client", now, 6); got != 6 {
+				t.Fatalf("replica A admitted %d of 6", got)
+			}
+			replicaA.sync()
+
+			// B only learns about A's requests once it syncs
+			if got := takeN(windowB, "client", now, 1); got != 1 {
+				t.Fatal("replica B rejected its first request")
+			}
+			replicaB.sync()
+			if got := takeN(windowB, "client", now, 5); got != 3 {
+				t.Fatalf("replica B admitted %d after syncing, want 3 (limit 10, 7 counted)", got)
+			}
+			replicaB.sync()
+
+			replicaA.sync()
+			if _, state, ok := windowA.take("client", now); ok || state.retryAfter <= 0 {
+				t.Fatalf("replica A admitted a request over the shared limit (state %+v)", state)
+			}
+			// Other clients have their own counters
+			if got := takeN(windowA, "other", now, 10); got != 10 {
+				t.Fatalf("another client got %d of 10", got)
+			}
+			if replicaA.SyncErrors() != 0 || replicaB.SyncErrors() != 0 {
+				t.Fatalf("sync errors: %d, %d", replicaA.SyncErrors(), replicaB.SyncErrors())
+			}
+		})
+	}
+}
+
+func TestSharedWindowSlides(t *testing.T) {
+	tests := []struct {
+		name       string
+		previous   int     // Requests admitted in the previous window
+		elapsed    float64 // Share of the current window that has passed
+		current    int     // Requests already admitted in the current window
+		wantAdmits int
+	}{
+		{"empty", 0, 0, 0, 10},
+		{"current window full", 0, 0.5, 10, 0},
+		{"previous window fully weighted", 10, 0, 0, 0},
+		{"previous window half weighted", 10, 0.5, 0, 5},
+		{"previous window mostly faded", 10, 0.9, 0, 9},
+		{"both windows", 8, 0.5, 3, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shared := NewSharedRateLimits(storage.NewMemoryRateLimitStore(), time.Hour)
+			w, _ := shared.window("slide", 1, 10)
+			start := windowStart(w.length)
+			now := start.Add(time.Duration(tt.elapsed * float64(w.length)))
+
+			if takeN(w, "client", start.Add(-w.length), tt.previous) != tt.previous {
+				t.Fatal("could not fill the previous window")
+			}
+			if takeN(w, "client", now, tt.current) != tt.current {
+				t.Fatal("could not fill the current window")
+			}
+			if got := takeN(w, "client", now, 20); got != tt.wantAdmits {
+				t.Fatalf("admitted %d, want %d", got, tt.wantAdmits)
+			}
+		})
+	}
+}
+
+func TestSharedWindowRetryAfter(t *testing.T) {
+	shared := NewSharedRateLimits(storage.NewMemoryRateLimitStore(), time.Hour)
+	w, _ := shared.window("retry", 1, 10)
+	start := windowStart(w.length)
+
+	// Full previous window at the middle of the current one: room opens as it fades
+	takeN(w, "faded", start.Add(-w.length), 10)
+	takeN(w, "faded", start.Add(w.length/2), 5)
+	_, state, ok := w.take("faded", start.Add(w.length/2))
+	if ok || state.retryAfter <= 0 || state.retryAfter > w.length/2 {
+		t.Fatalf("take() = %v, retry after %s, want a rejection clearing within the window", ok, state.retryAfter)
+	}
+
+	// Full current window: it has to end and fade out again
+	takeN(w, "full", start, 10)
+	_, state, ok = w.take("full", start)
+	if ok || state.retryAfter <= w.length || state.retryAfter > 2*w.length {
+		t.Fatalf("take() = %v, retry after %s, want between one and two windows", ok, state.retryAfter)
+	}
+}
+
+func TestSharedWindowUndo(t *testing.T) {
+	shared := NewSharedRateLimits(storage.NewMemoryRateLimitStore(), time.Hour)
+	w, _ := shared.window("undo", 1, 2)
+	now := windowStart(w.length)
+
+	undo, _, _ := w.take("client", now)
+	w.take("client", now)
+	if _, _, ok := w.take("client", now); ok {
+		t.Fatal("third request admitted with a limit of 2")
+	}
+	undo()
+	if _, _, ok := w.take("client", now); !ok {
+		t.Fatal("an undone request still counts")
+	}
+}
+
+func TestSharedClientCountersAreBounded(t *testing.T) {
+	shared := NewSharedRateLimits(storage.NewMemoryRateLimitStore(), time.Hour)
+	cl, err := NewClientRateLimiter(ClientRateLimitConfig{
+		Key:               ClientKeyHeader,
+		Header:            "X-Client",
+		RequestsPerSecond: 1,
+		Burst:             10,
+		MaxClients:        10,
+		IdleTimeout:       time.Minute,
+		Shared:            shared,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := windowStart(cl.window.length)
+	for i := 0; i < 1000; i++ {
+		cl.take(fmt.Sprintf("client-%d", i), now)
+	}
+	shared.sync()
+
+	// The 10 clients in the LRU keep a current and a previous window each
+	shared.mu.Lock()
+	counters := len(shared.counters)
+	shared.mu.Unlock()
+	if counters > 2*10 {
+		t.Fatalf("%d counters kept for 10 clients", counters)
+	}
+	if cl.Clients() != 10 || cl.Evicted() != 990 {
+		t.Fatalf("Clients() = %d, Evicted() = %d, want 10 and 990", cl.Clients(), cl.Evicted())
+	}
+
+	// Idle clients are dropped with their counters
+	cl.take("late", now.Add(time.Minute))
+	shared.sync()
+	shared.mu.Lock()
+	counters = len(shared.counters)
+	shared.mu.Unlock()
+	if cl.Clients() != 1 || counters != 2 {
+		t.Fatalf("after the idle timeout Clients() = %d with %d counters, want 1 and 2", cl.Clients(), counters)
+	}
+}
+
+func TestSharedWindowStoreFailure(t *testing.T) {
+	server := startRESPServer(t, "")
+	store, _ := storage.NewRESPRateLimitStore(storage.RESPConfig{Address: server.addr(), Timeout: time.Second})
+	defer store.Close()
+	shared := NewSharedRateLimits(store, time.Hour)
+	w, _ := shared.window("failure", 1, 10)
+	now := windowStart(w.length)
+
+	takeN(w, "client", now, 4)
+	shared.sync()
+	server.close()
+
+	// Requests are still limited on local counts while the store is down
+	takeN(w, "client", now, 2)
+	shared.sync()
+	if shared.SyncErrors() != 1 {
+		t.Fatalf("SyncErrors() = %d, want 1", shared.SyncErrors())
+	}
+	if got := takeN(w, "client", now, 10); got != 4 {
+		t.Fatalf("admitted %d while the store is down, want 4", got)
+	}
+}
+
+func TestRESPStoreConnectionSetup(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{"password accepted", "secret", false},
+		{"wrong password", "wrong", true},
+		{"missing password", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startRESPServer(t, "secret")
+			store, err := storage.NewRESPRateLimitStore(storage.RESPConfig{Address: server.addr(), Password: tt.password, DB: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			shared := NewSharedRateLimits(store, time.Hour)
+			w, _ := shared.window("auth", 1, 10)
+			w.take("client", windowStart(w.length))
+			shared.sync()
+
+			if failed := shared.SyncErrors() > 0; failed != tt.wantErr {
+				t.Fatalf("sync failed = %v, want %v", failed, tt.wantErr)
+			}
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if !tt.wantErr && (len(server.selected) != 1 || server.selected[0] != "2") {
+				t.Fatalf("selected databases %v, want [2]", server.selected)
+			}
+			if !tt.wantErr && len(server.values) != 1 {
+				t.Fatalf("server holds %d counters, want 1 (read-only counters are not created)", len(server.values))
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+/*
+internal/middleware/tracing.go
+Package middleware provides distributed tracing middleware with W3C Trace Context propagation.
+*/
+
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/AndreaBozzo/go-lab/internal/tracing"
+	"github.com/gin-gonic/gin"
+)
+
+// TracingMiddleware creates a middleware that starts a server span for every request,
+// continuing the caller's trace when a valid traceparent header is present
+func TracingMiddleware(tracer *tracing.Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+
+		ctx, span := tracer.StartServerSpan(c.Request, c.Request.Method+" "+route)
+		defer span.End()
+
+		span.SetAttribute("http.request.method", c.Request.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("url.path", c.Request.URL.Path)
+		span.SetAttribute("client.address", c.ClientIP())
+		span.SetAttribute("user_agent.original", c.Request.UserAgent())
+
+		// Make the span available to the proxy handler and the logging middleware
+		c.Request = c.Request.WithContext(ctx)
+		sc := span.SpanContext()
+		c.Set("trace_id", sc.TraceID.String())
+		c.Set("span_id", sc.SpanID.String())
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttribute("http.response.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,287 @@
+/*
+internal/proxy/affinity.go
+Package proxy provides consistent-hash load balancing and cookie-based sticky sessions.
+*/
+
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StrategyConsistentHash selects the consistent-hash balancer
+const StrategyConsistentHash = "consistent_hash"
+
+// Hash key sources for the consistent-hash balancer
+const (
+	HashSourceClientIP    = "client_ip"
+	HashSourceHeader      = "header"
+	HashSourceCookie      = "cookie"
+	HashSourcePathSegment = "path_segment"
+)
+
+// virtualNodesPerWeight is the number of ring points per unit of backend weight
+const virtualNodesPerWeight = 100
+
+// RequestBalancer is implemented by load balancers that need the request to pick a backend
+type RequestBalancer interface {
+	LoadBalancer
+	NextBackendForRequest(req *http.Request) (*Backend, error)
+}
+
+// HashKeyConfig describes which part of the request is hashed
+type HashKeyConfig struct {
+	Source  string // client_ip (default), header, cookie or path_segment
+	Name    string // Header or cookie name
+	Segment int    // Zero-based path segment index for path_segment
+}
+
+// Validate checks the hash key configuration
+func (hk HashKeyConfig) Validate() error {
+	switch hk.Source {
+	case "", HashSourceClientIP:
+	case HashSourceHeader, HashSourceCookie:
+		if hk.Name == "" {
+			return fmt.Errorf("hash key source %q requires a name", hk.Source)
+		}
+	case HashSourcePathSegment:
+		if hk.Segment < 0 {
+			return fmt.Errorf("hash key segment must not be negative")
+		}
+	default:
+		return fmt.Errorf("unknown hash key source %q", hk.Source)
+	}
+	return nil
+}
+
+// key extracts the hash key from a request, falling back to the client IP
+func (hk HashKeyConfig) key(req *http.Request) string {
+	var key string
+	switch hk.Source {
+	case HashSourceHeader:
+		key = req.Header.Get(hk.Name)
+	case HashSourceCookie:
+		if cookie, err := req.Cookie(hk.Name); err == nil {
+			key = cookie.Value
+		}
+	case HashSourcePathSegment:
+		segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		if hk.Segment < len(segments) {
+			key = segments[hk.Segment]
+		}
+	}
+	if key == "" {
+		key = getClientIP(req)
+	}
+	return key
+}
+
+// ringPoint is a virtual node on the hash ring
+type ringPoint struct {
+	hash    uint64
+	backend *Backend
+}
+
+// ConsistentHashBalancer maps request keys onto a hash ring of all backends in the pool.
+// The ring contains unhealthy backends too and lookups skip them, so a health change
+// only remaps the keys owned by that backend.
+type ConsistentHashBalancer struct {
+	pool    *BackendPool
+	hashKey HashKeyConfig
+	ring    []ringPoint
+	members []*Backend // Backends the ring was built from
+	weights []int      // Their weights at the time
+	mu      sync.Mutex
+}
+
+// NewConsistentHashBalancer creates a new consistent-hash balancer
+func NewConsistentHashBalancer(pool *BackendPool, hashKey HashKeyConfig) *ConsistentHashBalancer {
+	return &ConsistentHashBalancer{
+		pool:    pool,
+		hashKey: hashKey,
+	}
+}
+
+// NextBackend picks a backend for a random key (used when no request is available)
+func (ch *ConsistentHashBalancer) NextBackend() (*Backend, error) {
+	return ch.lookup(strconv.FormatUint(rand.Uint64(), 16))
+}
+
+// NextBackendForRequest picks the backend owning the request's hash key
+func (ch *ConsistentHashBalancer) NextBackendForRequest(req *http.Request) (*Backend, error) {
+	return ch.lookup(ch.hashKey.key(req))
+}
+
+// lookup walks the ring clockwise from the key's hash to the first admitted backend
+func (ch *ConsistentHashBalancer) lookup(key string) (*Backend, error) {
+	ring := ch.currentRing()
+	if len(ring) == 0 {
+		return nil, fmt.Errorf("no healthy backends available")
+	}
+
+	h := hashString(key)
+	start := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+
+	tried := make(map[*Backend]bool)
+	for i := 0; i < len(ring); i++ {
+		backend := ring[(start+i)%len(ring)].backend
+		if tried[backend] {
+			continue
+		}
+		tried[backend] = true
+		if backend.Available() {
+			return backend, nil
+		}
+	}
+	return nil, fmt.Errorf("no healthy backends available")
+}
+
+// currentRing returns the ring, rebuilding it when pool membership or weights have changed
+func (ch *ConsistentHashBalancer) currentRing() []ringPoint {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	backends := ch.pool.GetAllBackends()
+	weights := make([]int, len(backends))
+	for i, backend := range backends {
+		weights[i] = backend.configuredWeight()
+	}
+	if sameMembers(backends, ch.members) && slices.Equal(weights, ch.weights) {
+		return ch.ring
+	}
+
+	ring := make([]ringPoint, 0, len(backends)*virtualNodesPerWeight)
+	for j, backend := range backends {
+		id := backend.ID()
+		for i := 0; i < weights[j]*virtualNodesPerWeight; i++ {
+			ring = append(ring, ringPoint{hash: hashString(id + "#" + strconv.Itoa(i)), backend: backend})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	ch.ring = ring
+	ch.members = append([]*Backend(nil), backends...)
+	ch.weights = weights
+	return ring
+}
+
+// sameMembers reports whether two backend lists hold the same backends in the same order
+func sameMembers(a, b []*Backend) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hashString hashes s with 64-bit FNV-1a followed by the murmur3 finalizer.
+// FNV alone spreads similar strings ("a#1", "a#2") poorly around the ring.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// backendID derives the opaque identifier used in sticky cookies and on the hash ring
+func backendID(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return hex.EncodeToString(sum[:8])
+}
+
+// StickySessionConfig holds cookie-based session affinity settings
+type StickySessionConfig struct {
+	Enabled    bool
+	CookieName string
+	TTL        time.Duration // Cookie lifetime (0 means a session cookie)
+	Secure     bool
+}
+
+// StickySessions pins clients to a backend with a cookie naming it opaquely
+type StickySessions struct {
+	pool   *BackendPool
+	config StickySessionConfig
+}
+
+// NewStickySessions creates sticky session handling for a pool
+func NewStickySessions(pool *BackendPool, config StickySessionConfig) *StickySessions {
+	if config.CookieName == "" {
+		config.CookieName = "GW_BACKEND"
+	}
+	return &StickySessions{
+		pool:   pool,
+		config: config,
+	}
+}
+
+// lookup returns the pinned backend if the cookie names one that is still available
+func (ss *StickySessions) lookup(req *http.Request) *Backend {
+	cookie, err := req.Cookie(ss.config.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+
+	for _, backend := range ss.pool.GetAllBackends() {
+		if backend.ID() == cookie.Value {
+			if backend.Available() {
+				return backend
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// pinned reports whether the sticky cookie names a backend of this pool, available or not
+func (ss *StickySessions) pinned(req *http.Request) bool {
+	cookie, err := req.Cookie(ss.config.CookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	for _, backend := range ss.pool.GetAllBackends() {
+		if backend.ID() == cookie.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// pin sets the cookie for backend unless the request already carries it
+func (ss *StickySessions) pin(w http.ResponseWriter, req *http.Request, backend *Backend) {
+	if cookie, err := req.Cookie(ss.config.CookieName); err == nil && cookie.Value == backend.ID() {
+		return
+	}
+
+	cookie := &http.Cookie{
+		Name:     ss.config.CookieName,
+		Value:    backend.ID(),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   ss.config.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if ss.config.TTL > 0 {
+		cookie.MaxAge = int(ss.config.TTL.Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
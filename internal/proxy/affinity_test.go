@@ -0,0 +1,247 @@
+/*
+internal/proxy/affinity_test.go
+Package proxy tests the consistent-hash ring and sticky sessions.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ringOwners maps each key to the backend the balancer picks for it
+func ringOwners(t *testing.T, ch *ConsistentHashBalancer, keys []string) map[string]*Backend {
+	t.Helper()
+	owners := make(map[string]*Backend, len(keys))
+	for _, key := range keys {
+		backend, err := ch.lookup(key)
+		if err != nil {
+			t.Fatalf("lookup(%q) error = %v", key, err)
+		}
+		owners[key] = backend
+	}
+	return owners
+}
+
+// testKeys returns n distinct hash keys
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user-%d", i)
+	}
+	return keys
+}
+
+func TestConsistentHashIsStable(t *testing.T) {
+	pool, _ := newTestPool(t, 1, 1, 1)
+	keys := testKeys(1000)
+	first := ringOwners(t, NewConsistentHashBalancer(pool, HashKeyConfig{}), keys)
+
+	// A fresh balancer over the same backends builds the same ring
+	second := ringOwners(t, NewConsistentHashBalancer(pool, HashKeyConfig{}), keys)
+	for _, key := range keys {
+		if first[key] != second[key] {
+			t.Fatalf("key %q moved from %s to %s between identical rings", key, first[key].URL, second[key].URL)
+		}
+	}
+}
+
+func TestConsistentHashMinimalMovement(t *testing.T) {
+	keys := testKeys(5000)
+
+	tests := []struct {
+		name   string
+		change func(t *testing.T, pool *BackendPool, backends []*Backend)
+		// moved is the expected share of keys changing owner, changed is the backend that lost or gained them
+		moved   float64
+		changed int
+	}{
+		{"backend unhealthy", func(t *testing.T, pool *BackendPool, backends []*Backend) {
+			backends[3].mu.Lock()
+			backends[3].markUnhealthy(1)
+			backends[3].mu.Unlock()
+		}, 0.25, 3},
+		{"backend removed", func(t *testing.T, pool *BackendPool, backends []*Backend) {
+			if err := pool.RemoveBackend(backends[3]); err != nil {
+				t.Fatal(err)
+			}
+		}, 0.25, 3},
+		{"backend added", func(t *testing.T, pool *BackendPool, backends []*Backend) {
+			added, _ := NewBackend("http://backend-new:8080", 1)
+			if err := pool.AddBackend(added); err != nil {
+				t.Fatal(err)
+			}
+		}, 0.20, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, backends := newTestPool(t, 1, 1, 1, 1)
+			pool.Stop() // Added backends are not health checked
+			ch := NewConsistentHashBalancer(pool, HashKeyConfig{})
+			before := ringOwners(t, ch, keys)
+
+			tt.change(t, pool, backends)
+			after := ringOwners(t, ch, keys)
+
+			moved := 0
+			for _, key := range keys {
+				if before[key] == after[key] {
+					continue
+				}
+				moved++
+				// Only keys of a removed backend, or keys taken by a new one, may move
+				if tt.changed >= 0 && before[key] != backends[tt.changed] {
+					t.Fatalf("key %q moved off %s, which did not change", key, before[key].URL)
+				}
+				if tt.changed < 0 && after[key].URL.Host != "backend-new:8080" {
+					t.Fatalf("key %q moved to existing backend %s", key, after[key].URL)
+				}
+			}
+			if share := float64(moved) / float64(len(keys)); math.Abs(share-tt.moved) > 0.05 {
+				t.Fatalf("%.3f of keys moved, want about %.2f", share, tt.moved)
+			}
+		})
+	}
+}
+
+func TestConsistentHashRecoveryRestoresOwners(t *testing.T) {
+	pool, backends := newTestPool(t, 1, 1, 1)
+	ch := NewConsistentHashBalancer(pool, HashKeyConfig{})
+	keys := testKeys(1000)
+	before := ringOwners(t, ch, keys)
+
+	backends[0].SetDisabled(true)
+	ringOwners(t, ch, keys)
+	backends[0].SetDisabled(false)
+
+	after := ringOwners(t, ch, keys)
+	for _, key := range keys {
+		if before[key] != after[key] {
+			t.Fatalf("key %q did not return to %s after it recovered", key, before[key].URL)
+		}
+	}
+}
+
+func TestConsistentHashWeights(t *testing.T) {
+	pool, backends := newTestPool(t, 1, 3)
+	ch := NewConsistentHashBalancer(pool, HashKeyConfig{})
+	keys := testKeys(10000)
+
+	// 100 virtual nodes per unit of weight keep shares within about 10% of the ideal
+	counts := make(map[*Backend]int)
+	for _, owner := range ringOwners(t, ch, keys) {
+		counts[owner]++
+	}
+	if share := float64(counts[backends[1]]) / float64(len(keys)); math.Abs(share-0.75) > 0.1 {
+		t.Fatalf("weight 3 backend owns %.3f of keys, want about 0.75", share)
+	}
+
+	// Changing a weight rebuilds the ring
+	backends[1].SetWeight(1)
+	counts = make(map[*Backend]int)
+	for _, owner := range ringOwners(t, ch, keys) {
+		counts[owner]++
+	}
+	if share := float64(counts[backends[1]]) / float64(len(keys)); math.Abs(share-0.5) > 0.1 {
+		t.Fatalf("after reweighting the backend owns %.3f of keys, want about 0.5", share)
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  HashKeyConfig
+		path    string
+		headers map[string]string
+		want    string
+	}{
+		{"client ip", HashKeyConfig{}, "/", nil, "192.0.2.1"},
+		{"forwarded headers are not trusted", HashKeyConfig{}, "/", map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Real-IP": "203.0.113.9"}, "192.0.2.1"},
+		{"header", HashKeyConfig{Source: HashSourceHeader, Name: "X-Tenant"}, "/", map[string]string{"X-Tenant": "acme"}, "acme"},
+		{"missing header falls back to client ip", HashKeyConfig{Source: HashSourceHeader, Name: "X-Tenant"}, "/", nil, "192.0.2.1"},
+		{"cookie", HashKeyConfig{Source: HashSourceCookie, Name: "session"}, "/", map[string]string{"Cookie": "session=abc"}, "abc"},
+		{"path segment", HashKeyConfig{Source: HashSourcePathSegment, Segment: 1}, "/users/42/orders", nil, "42"},
+		{"path segment out of range", HashKeyConfig{Source: HashSourcePathSegment, Segment: 5}, "/users/42", nil, "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if got := tt.config.key(req); got != tt.want {
+				t.Fatalf("key() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashKeyConfigValidate(t *testing.T) {
+	tests := []struct {
+		config  HashKeyConfig
+		wantErr bool
+	}{
+		{HashKeyConfig{}, false},
+		{HashKeyConfig{Source: HashSourceClientIP}, false},
+		{HashKeyConfig{Source: HashSourceHeader, Name: "X-Tenant"}, false},
+		{HashKeyConfig{Source: HashSourceHeader}, true},
+		{HashKeyConfig{Source: HashSourceCookie}, true},
+		{HashKeyConfig{Source: HashSourcePathSegment, Segment: -1}, true},
+		{HashKeyConfig{Source: "query"}, true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) error = %v, wantErr %v", tt.config, err, tt.wantErr)
+		}
+	}
+}
+
+func TestStickySessions(t *testing.T) {
+	pool, backends := newTestPool(t, 1, 1)
+	ss := NewStickySessions(pool, StickySessionConfig{Enabled: true, CookieName: "GW_BACKEND_api"})
+
+	request := func(cookie string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "GW_BACKEND_api", Value: cookie})
+		}
+		return req
+	}
+
+	// Pinning sets the cookie once
+	rec := httptest.NewRecorder()
+	ss.pin(rec, request(""), backends[1])
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != backends[1].ID() || !cookies[0].HttpOnly {
+		t.Fatalf("pin() set cookies %v, want one HttpOnly cookie naming the backend", cookies)
+	}
+	rec = httptest.NewRecorder()
+	ss.pin(rec, request(backends[1].ID()), backends[1])
+	if len(rec.Result().Cookies()) != 0 {
+		t.Fatal("pin() reset a cookie the request already carries")
+	}
+
+	if got := ss.lookup(request(backends[1].ID())); got != backends[1] {
+		t.Fatalf("lookup() = %v, want the pinned backend", got)
+	}
+	if got := ss.lookup(request("unknown")); got != nil || ss.pinned(request("unknown")) {
+		t.Fatal("a cookie naming no backend of this pool was honoured")
+	}
+
+	// An unavailable pinned backend is not used but still counts as pinned
+	backends[1].SetDisabled(true)
+	if got := ss.lookup(request(backends[1].ID())); got != nil {
+		t.Fatalf("lookup() = %s, want nil for a disabled backend", got.URL)
+	}
+	if !ss.pinned(request(backends[1].ID())) {
+		t.Fatal("pinned() = false for a disabled backend of this pool")
+	}
+}
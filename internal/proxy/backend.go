@@ -9,33 +9,63 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/http"
+	"math"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ewmaDecay is the time constant of the latency moving average
+const ewmaDecay = 10 * time.Second
+
 // Backend represents a backend server
 type Backend struct {
-	URL         *url.URL
-	Weight      int
-	Healthy     bool
-	FailCount   int
-	mu          sync.RWMutex
-	healthURL   string
-	lastCheck   time.Time
-	client      *http.Client
+	URL       *url.URL
+	weight    atomic.Int64 // Configured weight, changeable through the admin API
+	id        string       // Opaque identifier (sticky cookies, hash ring)
+	Healthy   bool
+	FailCount int
+	mu        sync.RWMutex
+	passCount int // Consecutive successful checks while unhealthy
+	lastCheck time.Time
+	lastError string           // Why the last health check failed, empty if it passed
+	breaker   *CircuitBreaker  // nil when the circuit breaker is disabled
+	outlier   *OutlierDetector // nil when outlier detection is disabled
+	source    *discoverySource // Discovery entry the backend comes from, nil if configured
+
+	ejectedUntil atomic.Int64 // UnixNano until which the outlier detector keeps the backend out
+	draining     atomic.Bool  // Set by the admin API: no new requests, in-flight ones finish
+	disabled     atomic.Bool  // Set by the admin API or config: out of rotation until enabled
+	slowStart    SlowStartConfig
+	warmingSince atomic.Int64 // UnixNano of the last recovery, 0 when at full weight
+
+	// Traffic statistics reported by the proxy handler, used by load balancers
+	inFlight    atomic.Int64
+	statsMu     sync.Mutex
+	ewmaLatency float64 // Peak-EWMA of response latency in seconds
+	lastSample  time.Time
+}
+
+// SlowStartConfig holds the weight ramp applied to backends coming back from unhealthy
+type SlowStartConfig struct {
+	Window           time.Duration // Time to reach full weight (0 disables slow start)
+	MinWeightPercent int           // Share of its weight a backend starts at (default 10)
 }
 
 // BackendPool manages a pool of backend servers
 type BackendPool struct {
-	backends        []*Backend
-	mu              sync.RWMutex
-	healthCheckPath string
-	healthInterval  time.Duration
-	maxFails        int
-	ctx             context.Context
-	cancel          context.CancelFunc
+	backends []*Backend
+	mu       sync.RWMutex
+	checker  *HealthChecker
+	outlier  *OutlierDetector // nil when outlier detection is disabled
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// Service discovery, when some backend entries are resolved at runtime
+	sources    []*discoverySource
+	discovery  DiscoveryConfig
+	newBackend func(url string, weight int) (*Backend, error)
 }
 
 // NewBackend creates a new backend instance
@@ -45,29 +75,25 @@ func NewBackend(urlStr string, weight int) (*Backend, error) {
 		return nil, fmt.Errorf("invalid backend URL %s: %w", urlStr, err)
 	}
 
-	return &Backend{
+	backend := &Backend{
 		URL:       parsedURL,
-		Weight:    weight,
+		id:        backendID(parsedURL.String()),
 		Healthy:   true, // Start as healthy
 		FailCount: 0,
-		healthURL: urlStr + "/health",
-		client: &http.Client{
-			Timeout: 5 * time.Second,
-		},
-	}, nil
+	}
+	backend.SetWeight(weight)
+	return backend, nil
 }
 
-// NewBackendPool creates a new backend pool
-func NewBackendPool(backends []*Backend, healthCheckInterval time.Duration) *BackendPool {
+// NewBackendPool creates a new backend pool checked by checker
+func NewBackendPool(backends []*Backend, checker *HealthChecker) *BackendPool {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	pool := &BackendPool{
-		backends:        backends,
-		healthCheckPath: "/health",
-		healthInterval:  healthCheckInterval,
-		maxFails:        3, // Mark unhealthy after 3 consecutive failures
-		ctx:             ctx,
-		cancel:          cancel,
+		backends: backends,
+		checker:  checker,
+		ctx:      ctx,
+		cancel:   cancel,
 	}
 
 	return pool
@@ -75,11 +101,17 @@ func NewBackendPool(backends []*Backend, healthCheckInterval time.Duration) *Bac
 
 // Start begins health checking for all backends
 func (bp *BackendPool) Start() {
+	// Discovered backends are known before the first check
+	if len(bp.sources) > 0 {
+		bp.refreshDiscovery()
+		go bp.runDiscovery(bp.ctx)
+	}
+
 	// Initial health check
 	bp.checkAllBackends()
 
 	// Periodic health checks
-	ticker := time.NewTicker(bp.healthInterval)
+	ticker := time.NewTicker(bp.checker.Interval())
 	go func() {
 		defer ticker.Stop()
 		for {
@@ -91,6 +123,10 @@ func (bp *BackendPool) Start() {
 			}
 		}
 	}()
+
+	if bp.outlier != nil {
+		go bp.outlier.run(bp.ctx)
+	}
 }
 
 // Stop stops the health checking
@@ -101,7 +137,7 @@ func (bp *BackendPool) Stop() {
 // checkAllBackends performs health checks on all backends
 func (bp *BackendPool) checkAllBackends() {
 	var wg sync.WaitGroup
-	for _, backend := range bp.backends {
+	for _, backend := range bp.GetAllBackends() {
 		wg.Add(1)
 		go func(b *Backend) {
 			defer wg.Done()
@@ -113,49 +149,49 @@ func (bp *BackendPool) checkAllBackends() {
 
 // checkBackend performs a health check on a single backend
 func (bp *BackendPool) checkBackend(backend *Backend) {
+	// The probe runs without the lock so request routing is never blocked on it
+	err := bp.checker.check(bp.ctx, backend)
+	if bp.ctx.Err() != nil {
+		return
+	}
+
 	backend.mu.Lock()
 	defer backend.mu.Unlock()
 
-	ctx, cancel := context.WithTimeout(bp.ctx, 3*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "GET", backend.healthURL, nil)
+	backend.lastCheck = time.Now()
 	if err != nil {
-		backend.markUnhealthy()
+		backend.lastError = err.Error()
+		log.Printf("Health check failed for %s: %v", backend.URL.String(), err)
+		backend.markUnhealthy(bp.checker.config.UnhealthyThreshold)
 		return
 	}
+	backend.lastError = ""
+	backend.markHealthy(bp.checker.config.HealthyThreshold)
+}
 
-	resp, err := backend.client.Do(req)
-	if err != nil {
-		backend.markUnhealthy()
-		log.Printf("Health check failed for %s: %v", backend.URL.String(), err)
+// markHealthy counts a passed check and marks the backend healthy once the
+// threshold is reached (must be called with lock held)
+func (b *Backend) markHealthy(threshold int) {
+	b.FailCount = 0
+	if b.Healthy {
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		backend.markHealthy()
-		if backend.FailCount > 0 {
-			log.Printf("Backend %s recovered", backend.URL.String())
+	b.passCount++
+	if b.passCount >= threshold {
+		log.Printf("Backend %s recovered after %d successful checks", b.URL.String(), b.passCount)
+		b.Healthy = true
+		b.passCount = 0
+		if b.slowStart.Window > 0 {
+			b.warmingSince.Store(time.Now().UnixNano())
 		}
-	} else {
-		backend.markUnhealthy()
-		log.Printf("Health check failed for %s: status %d", backend.URL.String(), resp.StatusCode)
 	}
-
-	backend.lastCheck = time.Now()
-}
-
-// markHealthy marks the backend as healthy (must be called with lock held)
-func (b *Backend) markHealthy() {
-	b.Healthy = true
-	b.FailCount = 0
 }
 
 // markUnhealthy increments fail count and marks unhealthy if threshold reached (must be called with lock held)
-func (b *Backend) markUnhealthy() {
+func (b *Backend) markUnhealthy(threshold int) {
+	b.passCount = 0
 	b.FailCount++
-	if b.FailCount >= 3 {
+	if b.FailCount >= threshold {
 		if b.Healthy {
 			log.Printf("Backend %s marked unhealthy after %d failures", b.URL.String(), b.FailCount)
 		}
@@ -170,6 +206,214 @@ func (b *Backend) IsHealthy() bool {
 	return b.Healthy
 }
 
+// HealthStatus returns when the backend was last checked and why that check failed, if it did
+func (b *Backend) HealthStatus() (lastCheck time.Time, lastError string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.lastCheck, b.lastError
+}
+
+// Available returns whether the backend is healthy, not ejected or draining, and its circuit breaker admits traffic
+func (b *Backend) Available() bool {
+	if !b.IsHealthy() || b.Ejected() || b.Draining() || b.Disabled() {
+		return false
+	}
+	return b.breaker == nil || b.breaker.Ready()
+}
+
+// admits reports whether the backend's circuit breaker would let a request through
+func (b *Backend) admits() bool {
+	return b.breaker == nil || b.breaker.Ready()
+}
+
+// acquire reserves a request slot on the backend's circuit breaker; the ticket
+// goes to ReportResult or CancelRequest
+func (b *Backend) acquire() (CircuitTicket, bool) {
+	if b.breaker == nil {
+		return CircuitTicket{}, true
+	}
+	return b.breaker.Allow()
+}
+
+// Ejected returns whether the outlier detector currently keeps the backend out of rotation
+func (b *Backend) Ejected() bool {
+	return time.Now().UnixNano() < b.ejectedUntil.Load()
+}
+
+// EjectedUntil returns when the current outlier ejection ends (zero if not ejected)
+func (b *Backend) EjectedUntil() time.Time {
+	if !b.Ejected() {
+		return time.Time{}
+	}
+	return time.Unix(0, b.ejectedUntil.Load())
+}
+
+// ReportResult records the outcome of a proxied request admitted with ticket on
+// the circuit breaker and the outlier detector
+func (b *Backend) ReportResult(ticket CircuitTicket, success bool) {
+	if b.outlier != nil {
+		b.outlier.record(b, success)
+	}
+	if b.breaker == nil {
+		return
+	}
+	before := b.breaker.State()
+	b.breaker.Record(ticket, success)
+	if after := b.breaker.State(); after != before {
+		log.Printf("Circuit breaker for %s changed from %s to %s", b.URL.String(), before, after)
+	}
+}
+
+// CancelRequest releases a request slot admitted with ticket without recording an outcome
+func (b *Backend) CancelRequest(ticket CircuitTicket) {
+	if b.breaker != nil {
+		b.breaker.Cancel(ticket)
+	}
+}
+
+// CircuitState returns the circuit breaker state ("disabled" when no breaker is attached)
+func (b *Backend) CircuitState() string {
+	if b.breaker == nil {
+		return "disabled"
+	}
+	return b.breaker.State().String()
+}
+
+// beginRequest marks a request to the backend as in flight
+func (b *Backend) beginRequest() {
+	b.inFlight.Add(1)
+}
+
+// endRequest marks an in-flight request as finished
+func (b *Backend) endRequest() {
+	b.inFlight.Add(-1)
+}
+
+// InFlight returns the number of requests currently being proxied to the backend
+func (b *Backend) InFlight() int64 {
+	return b.inFlight.Load()
+}
+
+// observeLatency feeds a response latency into the peak-EWMA estimate.
+// Spikes are adopted immediately, improvements decay in over ewmaDecay.
+func (b *Backend) observeLatency(latency time.Duration) {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	now := time.Now()
+	sample := latency.Seconds()
+	if b.lastSample.IsZero() || sample > b.ewmaLatency {
+		b.ewmaLatency = sample
+	} else {
+		w := math.Exp(-float64(now.Sub(b.lastSample)) / float64(ewmaDecay))
+		b.ewmaLatency = b.ewmaLatency*w + sample*(1-w)
+	}
+	b.lastSample = now
+}
+
+// EWMALatency returns the current latency estimate. It decays towards zero
+// while no responses arrive, so a backend that spiked and then stopped being
+// picked is tried again instead of keeping its peak forever.
+func (b *Backend) EWMALatency() time.Duration {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+	if b.lastSample.IsZero() {
+		return 0
+	}
+	w := math.Exp(-float64(time.Since(b.lastSample)) / float64(ewmaDecay))
+	return time.Duration(b.ewmaLatency * w * float64(time.Second))
+}
+
+// Weight returns the backend's configured weight
+func (b *Backend) Weight() int {
+	return int(b.weight.Load())
+}
+
+// SetWeight changes the backend's weight
+func (b *Backend) SetWeight(weight int) {
+	b.weight.Store(int64(weight))
+}
+
+// configuredWeight returns the backend's weight from the configuration (at least 1)
+func (b *Backend) configuredWeight() int {
+	if weight := b.Weight(); weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// effectiveWeight returns the weight used for balancing: the configured weight,
+// reduced while the backend is warming up after a recovery
+func (b *Backend) effectiveWeight() float64 {
+	return float64(b.configuredWeight()) * b.slowStartFactor()
+}
+
+// slowStartFactor returns the share of its weight a recovering backend gets,
+// rising linearly from the configured minimum to 1 over the slow start window
+func (b *Backend) slowStartFactor() float64 {
+	since := b.warmingSince.Load()
+	if since == 0 {
+		return 1
+	}
+	elapsed := time.Since(time.Unix(0, since))
+	if elapsed >= b.slowStart.Window {
+		b.warmingSince.CompareAndSwap(since, 0)
+		return 1
+	}
+	return max(float64(elapsed)/float64(b.slowStart.Window), float64(b.slowStart.MinWeightPercent)/100)
+}
+
+// Warming returns whether the backend is still in its slow start window
+func (b *Backend) Warming() bool {
+	return b.slowStartFactor() < 1
+}
+
+// SetDraining stops (or resumes) sending new requests to the backend.
+// Requests already in flight are not affected.
+func (b *Backend) SetDraining(draining bool) {
+	if b.draining.Swap(draining) != draining {
+		if draining {
+			log.Printf("Backend %s draining, %d requests in flight", b.URL.String(), b.InFlight())
+		} else {
+			log.Printf("Backend %s no longer draining", b.URL.String())
+		}
+	}
+}
+
+// Draining returns whether the backend is draining
+func (b *Backend) Draining() bool {
+	return b.draining.Load()
+}
+
+// SetDisabled takes the backend out of rotation (or puts it back)
+func (b *Backend) SetDisabled(disabled bool) {
+	if b.disabled.Swap(disabled) != disabled {
+		if disabled {
+			log.Printf("Backend %s disabled", b.URL.String())
+		} else {
+			log.Printf("Backend %s enabled", b.URL.String())
+		}
+	}
+}
+
+// Disabled returns whether the backend is disabled
+func (b *Backend) Disabled() bool {
+	return b.disabled.Load()
+}
+
+// Source returns the discovery entry the backend comes from, or "" if it was configured
+func (b *Backend) Source() string {
+	if b.source == nil {
+		return ""
+	}
+	return b.source.name
+}
+
+// ID returns the backend's opaque identifier
+func (b *Backend) ID() string {
+	return b.id
+}
+
 // GetURL returns the backend URL (thread-safe)
 func (b *Backend) GetURL() *url.URL {
 	b.mu.RLock()
@@ -191,9 +435,73 @@ func (bp *BackendPool) GetHealthyBackends() []*Backend {
 	return healthy
 }
 
-// GetAllBackends returns all backends (for admin/debug purposes)
+// GetAvailableBackends returns all healthy, non-ejected, non-draining backends whose circuit breaker admits traffic
+func (bp *BackendPool) GetAvailableBackends() []*Backend {
+	bp.mu.RLock()
+	defer bp.mu.RUnlock()
+
+	var available []*Backend
+	for _, backend := range bp.backends {
+		if backend.Available() {
+			available = append(available, backend)
+		}
+	}
+	return available
+}
+
+// GetAllBackends returns all backends. The slice is never modified in place
+// and may be kept by the caller.
 func (bp *BackendPool) GetAllBackends() []*Backend {
 	bp.mu.RLock()
 	defer bp.mu.RUnlock()
 	return bp.backends
 }
+
+// AddBackend adds a backend to the running pool. It is checked right away
+// and starts out healthy, like backends from the configuration.
+func (bp *BackendPool) AddBackend(backend *Backend) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	for _, existing := range bp.backends {
+		if existing.URL.String() == backend.URL.String() {
+			return fmt.Errorf("backend %s already exists", backend.URL.String())
+		}
+	}
+	// Copy on write, readers may still hold the old slice
+	backends := make([]*Backend, 0, len(bp.backends)+1)
+	backends = append(backends, bp.backends...)
+	bp.backends = append(backends, backend)
+	backend.outlier = bp.outlier
+
+	if bp.ctx.Err() == nil {
+		go bp.checkBackend(backend)
+	}
+	return nil
+}
+
+// RemoveBackend removes a backend from the pool. It gets no new requests;
+// requests already in flight complete. The last backend cannot be removed.
+func (bp *BackendPool) RemoveBackend(backend *Backend) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	backends := make([]*Backend, 0, len(bp.backends))
+	for _, existing := range bp.backends {
+		if existing != backend {
+			backends = append(backends, existing)
+		}
+	}
+	if len(backends) == len(bp.backends) {
+		return fmt.Errorf("backend %s is not in the pool", backend.URL.String())
+	}
+	if len(backends) == 0 {
+		return fmt.Errorf("cannot remove the last backend of a pool")
+	}
+	bp.backends = backends
+
+	if bp.outlier != nil {
+		bp.outlier.forget(backend)
+	}
+	return nil
+}
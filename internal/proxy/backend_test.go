@@ -0,0 +1,143 @@
+/*
+internal/proxy/backend_test.go
+Package proxy tests slow start and draining of backends.
+*/
+
+package proxy
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// warmFor puts a backend elapsed into its slow start window
+func warmFor(backend *Backend, elapsed time.Duration) {
+	backend.warmingSince.Store(time.Now().Add(-elapsed).UnixNano())
+}
+
+func TestSlowStartFactor(t *testing.T) {
+	tests := []struct {
+		name        string
+		minPercent  int
+		elapsed     time.Duration // < 0: not warming
+		want        float64
+		wantWarming bool
+	}{
+		{"not warming", 10, -1, 1, false},
+		{"just recovered", 10, 0, 0.1, true},
+		{"below the minimum", 25, time.Second, 0.25, true},
+		{"half way", 10, 5 * time.Second, 0.5, true},
+		{"almost done", 10, 9 * time.Second, 0.9, true},
+		{"window over", 10, 10 * time.Second, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, _ := NewBackend("http://backend:8080", 4)
+			backend.slowStart = SlowStartConfig{Window: 10 * time.Second, MinWeightPercent: tt.minPercent}
+			if tt.elapsed >= 0 {
+				warmFor(backend, tt.elapsed)
+			}
+			if got := backend.slowStartFactor(); math.Abs(got-tt.want) > 0.01 {
+				t.Fatalf("slowStartFactor() = %.3f, want %.3f", got, tt.want)
+			}
+			if got := backend.effectiveWeight(); math.Abs(got-4*tt.want) > 0.05 {
+				t.Fatalf("effectiveWeight() = %.3f, want %.3f", got, 4*tt.want)
+			}
+			if backend.Warming() != tt.wantWarming {
+				t.Fatalf("Warming() = %v, want %v", backend.Warming(), tt.wantWarming)
+			}
+		})
+	}
+}
+
+func TestSlowStartBeginsOnRecovery(t *testing.T) {
+	tests := []struct {
+		name        string
+		window      time.Duration
+		wantWarming bool
+	}{
+		{"slow start", time.Minute, true},
+		{"disabled", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, _ := NewBackend("http://backend:8080", 1)
+			backend.slowStart = SlowStartConfig{Window: tt.window, MinWeightPercent: 10}
+			if backend.Warming() {
+				t.Fatal("a backend is warming before it ever failed")
+			}
+
+			backend.mu.Lock()
+			backend.markUnhealthy(1)
+			backend.markHealthy(1)
+			backend.mu.Unlock()
+			if backend.Warming() != tt.wantWarming {
+				t.Fatalf("Warming() after recovery = %v, want %v", backend.Warming(), tt.wantWarming)
+			}
+		})
+	}
+}
+
+func TestSlowStartShare(t *testing.T) {
+	// Two backends of equal weight, one at half its weight: it should get about a third
+	strategies := []string{StrategyRoundRobin, StrategySmoothWeighted, StrategyRandom}
+
+	for _, strategy := range strategies {
+		t.Run(strategy, func(t *testing.T) {
+			pool, backends := newTestPool(t, 1, 1)
+			backends[1].slowStart = SlowStartConfig{Window: 10 * time.Second, MinWeightPercent: 10}
+			warmFor(backends[1], 5*time.Second)
+
+			lb, _ := NewLoadBalancer(strategy, pool, HashKeyConfig{})
+			warming := 0
+			const n = 6000
+			for _, backend := range pick(t, lb, n) {
+				if backend == backends[1] {
+					warming++
+				}
+			}
+			if share := float64(warming) / n; share < 0.2 || share > 0.42 {
+				t.Fatalf("warming backend got %.3f of requests, want about 0.33", share)
+			}
+		})
+	}
+}
+
+func TestDrainingBackendGetsNoNewRequests(t *testing.T) {
+	strategies := []string{
+		StrategyRoundRobin, StrategySmoothWeighted, StrategyLeastConnections,
+		StrategyPowerOfTwo, StrategyRandom, StrategyEWMA, StrategyConsistentHash,
+	}
+
+	for _, strategy := range strategies {
+		t.Run(strategy, func(t *testing.T) {
+			pool, backends := newTestPool(t, 1, 1, 1)
+			backends[0].beginRequest()
+			backends[0].SetDraining(true)
+
+			lb, _ := NewLoadBalancer(strategy, pool, HashKeyConfig{})
+			for _, backend := range pick(t, lb, 100) {
+				if backend == backends[0] {
+					t.Fatal("picked a draining backend")
+				}
+			}
+			// Requests already in flight are left alone
+			if backends[0].InFlight() != 1 {
+				t.Fatalf("in flight = %d, want 1", backends[0].InFlight())
+			}
+
+			backends[0].endRequest()
+			backends[0].SetDraining(false)
+			seen := false
+			for _, backend := range pick(t, lb, 300) {
+				seen = seen || backend == backends[0]
+			}
+			if !seen {
+				t.Fatal("backend got no requests after draining ended")
+			}
+		})
+	}
+}
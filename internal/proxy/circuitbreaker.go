@@ -0,0 +1,221 @@
+/*
+internal/proxy/circuitbreaker.go
+Package proxy provides a per-backend circuit breaker driven by real traffic.
+*/
+
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitState represents the state of a circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed lets all traffic through while counting failures
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all traffic until the open duration elapses
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through
+	CircuitHalfOpen
+)
+
+// String returns the human-readable name of the state
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerConfig holds circuit breaker settings
+type CircuitBreakerConfig struct {
+	Enabled        bool
+	FailureRatio   float64       // Failure ratio (0-1) that trips the breaker
+	MinRequests    int           // Minimum requests in the window before the ratio is evaluated
+	Window         time.Duration // Length of the counting window while closed
+	OpenDuration   time.Duration // How long the breaker stays open before probing
+	HalfOpenProbes int           // Successful probes required to close again
+}
+
+// CircuitTicket is handed out by Allow and identifies the state a request was
+// admitted in, so its outcome only counts towards that state
+type CircuitTicket struct {
+	generation uint64 // State changes the breaker had gone through at admission
+	probe      bool   // Admitted as a half-open probe
+}
+
+// CircuitBreaker tracks request outcomes for a single backend
+type CircuitBreaker struct {
+	config CircuitBreakerConfig
+	mu     sync.Mutex
+
+	state       CircuitState
+	generation  uint64 // Incremented on every state change
+	openedAt    time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+
+	// Half-open bookkeeping
+	probesInFlight int
+	probeSuccesses int
+}
+
+// NewCircuitBreaker creates a new circuit breaker, filling in defaults for unset values
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	if config.FailureRatio <= 0 || config.FailureRatio > 1 {
+		config.FailureRatio = 0.5
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = 20
+	}
+	if config.Window <= 0 {
+		config.Window = 10 * time.Second
+	}
+	if config.OpenDuration <= 0 {
+		config.OpenDuration = 30 * time.Second
+	}
+	if config.HalfOpenProbes <= 0 {
+		config.HalfOpenProbes = 1
+	}
+
+	return &CircuitBreaker{
+		config:      config,
+		state:       CircuitClosed,
+		windowStart: time.Now(),
+	}
+}
+
+// Ready reports whether the breaker would admit a request right now without reserving it
+func (cb *CircuitBreaker) Ready() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		return time.Since(cb.openedAt) >= cb.config.OpenDuration
+	case CircuitHalfOpen:
+		return cb.probesInFlight < cb.config.HalfOpenProbes-cb.probeSuccesses
+	default:
+		return true
+	}
+}
+
+// Allow reserves a slot for a request, moving an expired open breaker to half-open.
+// The ticket must be passed to Record or Cancel.
+func (cb *CircuitBreaker) Allow() (CircuitTicket, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < cb.config.OpenDuration {
+			return CircuitTicket{}, false
+		}
+		cb.setState(CircuitHalfOpen)
+		cb.probesInFlight++
+		return CircuitTicket{generation: cb.generation, probe: true}, true
+	case CircuitHalfOpen:
+		if cb.probesInFlight >= cb.config.HalfOpenProbes-cb.probeSuccesses {
+			return CircuitTicket{}, false
+		}
+		cb.probesInFlight++
+		return CircuitTicket{generation: cb.generation, probe: true}, true
+	default:
+		return CircuitTicket{generation: cb.generation}, true
+	}
+}
+
+// Record reports the outcome of a request admitted by Allow with ticket
+func (cb *CircuitBreaker) Record(ticket CircuitTicket, success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	// Outcomes of requests admitted before the last state change say nothing
+	// about the current state: a request admitted while closed that finishes
+	// after the breaker went half-open is not a probe
+	if ticket.generation != cb.generation {
+		return
+	}
+
+	switch cb.state {
+	case CircuitHalfOpen:
+		// Every ticket of the half-open generation is a probe
+		if cb.probesInFlight > 0 {
+			cb.probesInFlight--
+		}
+		if !success {
+			cb.setState(CircuitOpen)
+			return
+		}
+		cb.probeSuccesses++
+		if cb.probeSuccesses >= cb.config.HalfOpenProbes {
+			cb.setState(CircuitClosed)
+		}
+	case CircuitClosed:
+		now := time.Now()
+		if now.Sub(cb.windowStart) >= cb.config.Window {
+			cb.windowStart = now
+			cb.requests = 0
+			cb.failures = 0
+		}
+		cb.requests++
+		if !success {
+			cb.failures++
+		}
+		if cb.requests >= cb.config.MinRequests &&
+			float64(cb.failures)/float64(cb.requests) >= cb.config.FailureRatio {
+			cb.setState(CircuitOpen)
+		}
+	}
+}
+
+// Cancel releases a slot admitted by Allow with ticket without recording an
+// outcome, e.g. when the client went away before the backend answered
+func (cb *CircuitBreaker) Cancel(ticket CircuitTicket) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if ticket.probe && ticket.generation == cb.generation && cb.probesInFlight > 0 {
+		cb.probesInFlight--
+	}
+}
+
+// State returns the current state, reporting an expired open breaker as half-open
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == CircuitOpen && time.Since(cb.openedAt) >= cb.config.OpenDuration {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// Stats returns the counters of the current window (for admin/debug purposes)
+func (cb *CircuitBreaker) Stats() (requests int, failures int) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.requests, cb.failures
+}
+
+// setState transitions the breaker and resets counters (must be called with lock held)
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	cb.state = state
+	cb.generation++
+	cb.requests = 0
+	cb.failures = 0
+	cb.probesInFlight = 0
+	cb.probeSuccesses = 0
+	cb.windowStart = time.Now()
+	if state == CircuitOpen {
+		cb.openedAt = time.Now()
+	}
+}
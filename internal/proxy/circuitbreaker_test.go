@@ -0,0 +1,203 @@
+/*
+internal/proxy/circuitbreaker_test.go
+Package proxy tests the circuit breaker state machine.
+*/
+
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// expireOpen makes an open breaker's open duration elapse
+func expireOpen(cb *CircuitBreaker) {
+	cb.mu.Lock()
+	cb.openedAt = cb.openedAt.Add(-cb.config.OpenDuration)
+	cb.mu.Unlock()
+}
+
+// trip opens a breaker that opens on its first failure
+func trip(cb *CircuitBreaker) {
+	ticket, _ := cb.Allow()
+	cb.Record(ticket, false)
+}
+
+func TestCircuitBreakerStateMachine(t *testing.T) {
+	config := CircuitBreakerConfig{
+		Enabled:        true,
+		FailureRatio:   0.5,
+		MinRequests:    4,
+		Window:         time.Minute,
+		OpenDuration:   time.Minute,
+		HalfOpenProbes: 2,
+	}
+
+	// Steps: "ok"/"fail" admit and record a request, "deny" expects Allow to
+	// refuse, "expire" lets the open duration elapse, "cancel" admits and cancels
+	tests := []struct {
+		name  string
+		steps []string
+		want  CircuitState
+	}{
+		{"stays closed below min requests", []string{"fail", "fail", "fail"}, CircuitClosed},
+		{"stays closed below the ratio", []string{"ok", "ok", "ok", "fail", "ok", "fail"}, CircuitClosed},
+		{"opens at the ratio", []string{"ok", "fail", "ok", "fail"}, CircuitOpen},
+		{"rejects while open", []string{"fail", "fail", "fail", "fail", "deny"}, CircuitOpen},
+		{"half-open after the open duration", []string{"fail", "fail", "fail", "fail", "expire"}, CircuitHalfOpen},
+		{"closes after enough probes", []string{"fail", "fail", "fail", "fail", "expire", "ok", "ok"}, CircuitClosed},
+		{"one probe is not enough", []string{"fail", "fail", "fail", "fail", "expire", "ok"}, CircuitHalfOpen},
+		{"failed probe reopens", []string{"fail", "fail", "fail", "fail", "expire", "ok", "fail"}, CircuitOpen},
+		{"cancelled probe frees its slot", []string{"fail", "fail", "fail", "fail", "expire", "cancel", "ok", "ok"}, CircuitClosed},
+		{"counters restart after closing", []string{"fail", "fail", "fail", "fail", "expire", "ok", "ok", "fail", "fail", "fail"}, CircuitClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := NewCircuitBreaker(config)
+			for i, step := range tt.steps {
+				switch step {
+				case "ok", "fail":
+					ticket, ok := cb.Allow()
+					if !ok {
+						t.Fatalf("step %d: Allow() = false in state %s", i, cb.State())
+					}
+					cb.Record(ticket, step == "ok")
+				case "deny":
+					if _, ok := cb.Allow(); ok {
+						t.Fatalf("step %d: Allow() = true in state %s", i, cb.State())
+					}
+				case "expire":
+					expireOpen(cb)
+				case "cancel":
+					ticket, ok := cb.Allow()
+					if !ok {
+						t.Fatalf("step %d: Allow() = false in state %s", i, cb.State())
+					}
+					cb.Cancel(ticket)
+				}
+			}
+			if got := cb.State(); got != tt.want {
+				t.Errorf("State() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpenLimitsProbes(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 1, OpenDuration: time.Minute, HalfOpenProbes: 2})
+	trip(cb)
+	expireOpen(cb)
+
+	first, ok1 := cb.Allow()
+	second, ok2 := cb.Allow()
+	if !ok1 || !ok2 {
+		t.Fatal("expected two probes to be admitted")
+	}
+	if _, ok := cb.Allow(); cb.Ready() || ok {
+		t.Fatal("a third concurrent probe was admitted")
+	}
+	cb.Record(first, true)
+	// One success leaves one probe to go, which is still in flight
+	if _, ok := cb.Allow(); ok {
+		t.Fatal("admitted a probe beyond the successes still needed")
+	}
+	cb.Record(second, true)
+	if got := cb.State(); got != CircuitClosed {
+		t.Fatalf("State() = %s, want closed", got)
+	}
+}
+
+func TestCircuitBreakerWindowResets(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{FailureRatio: 0.5, MinRequests: 4, Window: time.Minute})
+	for i := 0; i < 3; i++ {
+		ticket, _ := cb.Allow()
+		cb.Record(ticket, false)
+	}
+
+	// Failures from an old window don't count towards the next one
+	cb.mu.Lock()
+	cb.windowStart = cb.windowStart.Add(-time.Minute)
+	cb.mu.Unlock()
+	ticket, _ := cb.Allow()
+	cb.Record(ticket, false)
+	if requests, failures := cb.Stats(); requests != 1 || failures != 1 {
+		t.Fatalf("Stats() = %d, %d, want 1, 1", requests, failures)
+	}
+	if got := cb.State(); got != CircuitClosed {
+		t.Fatalf("State() = %s, want closed", got)
+	}
+}
+
+func TestCircuitBreakerIgnoresOutcomesFromEarlierStates(t *testing.T) {
+	tests := []struct {
+		name string
+		// admitted is the state the late request is admitted in
+		admitted CircuitState
+		success  bool
+	}{
+		{"success admitted while closed", CircuitClosed, true},
+		{"failure admitted while closed", CircuitClosed, false},
+		{"success of a probe from an earlier half-open state", CircuitHalfOpen, true},
+		{"failure of a probe from an earlier half-open state", CircuitHalfOpen, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 1, OpenDuration: time.Minute, HalfOpenProbes: 2})
+			var late CircuitTicket
+			if tt.admitted == CircuitClosed {
+				late, _ = cb.Allow()
+				trip(cb)
+			} else {
+				trip(cb)
+				expireOpen(cb)
+				late, _ = cb.Allow()
+				// Another probe fails and reopens the breaker while this one is in flight
+				other, _ := cb.Allow()
+				cb.Record(other, false)
+			}
+
+			// The late outcome arrives once the breaker is half-open again
+			expireOpen(cb)
+			first, _ := cb.Allow()
+			second, ok := cb.Allow()
+			if !ok {
+				t.Fatal("Allow() refused the second probe")
+			}
+			cb.Record(late, tt.success)
+			if got := cb.State(); got != CircuitHalfOpen {
+				t.Fatalf("late outcome moved the breaker to %s, want half-open", got)
+			}
+			if _, ok := cb.Allow(); ok {
+				t.Fatal("late outcome freed a probe slot")
+			}
+
+			// Only the two probes decide
+			cb.Record(first, true)
+			if got := cb.State(); got != CircuitHalfOpen {
+				t.Fatalf("State() = %s after one of two probes, want half-open", got)
+			}
+			cb.Record(second, true)
+			if got := cb.State(); got != CircuitClosed {
+				t.Fatalf("State() = %s after both probes succeeded, want closed", got)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerCancelOnlyReleasesCurrentProbes(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 1, OpenDuration: time.Minute, HalfOpenProbes: 1})
+	closed, _ := cb.Allow()
+	trip(cb)
+	expireOpen(cb)
+	if _, ok := cb.Allow(); !ok {
+		t.Fatal("Allow() refused the probe")
+	}
+
+	// Cancelling a request admitted while closed must not free the probe's slot
+	cb.Cancel(closed)
+	if _, ok := cb.Allow(); ok {
+		t.Fatal("a second probe was admitted after cancelling a request from the closed state")
+	}
+}
@@ -0,0 +1,323 @@
+/*
+internal/proxy/discovery.go
+Package proxy provides service discovery: backend entries that stand for a changing set of backends.
+*/
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiscoveryConfig holds per-route service discovery settings
+type DiscoveryConfig struct {
+	Interval time.Duration // Re-resolution period (default 30s)
+	Timeout  time.Duration // Per resolution (default 5s)
+	Resolver string        // DNS server as host:port (default: the system resolver)
+	Scheme   string        // Scheme of discovered backends, http (default) or https
+}
+
+// Validate checks the discovery configuration
+func (c DiscoveryConfig) Validate() error {
+	_, err := c.withDefaults()
+	return err
+}
+
+// withDefaults validates a configuration and fills in defaults
+func (c DiscoveryConfig) withDefaults() (DiscoveryConfig, error) {
+	if c.Interval < 0 || c.Timeout < 0 {
+		return c, fmt.Errorf("discovery interval and timeout must not be negative")
+	}
+	if c.Interval == 0 {
+		c.Interval = 30 * time.Second
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+	switch c.Scheme {
+	case "":
+		c.Scheme = "http"
+	case "http", "https":
+	default:
+		return c, fmt.Errorf("discovery scheme must be http or https, got %q", c.Scheme)
+	}
+	return c, nil
+}
+
+// Target is one backend reported by a discovery provider
+type Target struct {
+	URL    string // Full URL, or host:port to use the route's discovery scheme
+	Weight int    // 0 uses the weight of the backend entry
+}
+
+// Discovery reports the current backends of a backend entry. Discover is
+// called periodically; an error keeps the backends of the last success.
+type Discovery interface {
+	Discover(ctx context.Context) ([]Target, error)
+}
+
+// DiscoveryProviderConfig holds the settings of a discovery backend entry
+type DiscoveryProviderConfig struct {
+	Type     string            // file, http, or a name passed to RegisterDiscovery
+	Path     string            // file: targets file, JSON or YAML in Prometheus file_sd format
+	URL      string            // http: endpoint polled for targets
+	Format   string            // http: file_sd (default) or consul
+	Headers  map[string]string // http: sent with every poll, e.g. X-Consul-Token
+	Interval time.Duration     // Overrides the route's discovery interval (file default 5s)
+	Options  map[string]string // Settings for custom providers
+}
+
+// name identifies the entry as the source of its backends
+func (c DiscoveryProviderConfig) name() string {
+	switch {
+	case c.Path != "":
+		return c.Type + ":" + c.Path
+	case c.URL != "":
+		return c.Type + ":" + c.URL
+	}
+	return c.Type
+}
+
+// DiscoveryFactory creates a provider from its settings. It should not do
+// any I/O, configurations are also built just to be validated.
+type DiscoveryFactory func(config DiscoveryProviderConfig) (Discovery, error)
+
+var (
+	discoveryMu        sync.RWMutex
+	discoveryProviders = map[string]DiscoveryFactory{
+		"file": newFileDiscovery,
+		"http": newHTTPDiscovery,
+	}
+)
+
+// RegisterDiscovery makes a provider available as a discovery type. Call it
+// before the configuration is loaded; registering a type again replaces it.
+func RegisterDiscovery(kind string, factory DiscoveryFactory) {
+	discoveryMu.Lock()
+	defer discoveryMu.Unlock()
+	discoveryProviders[kind] = factory
+}
+
+// NewDiscovery creates the provider for a discovery entry
+func NewDiscovery(config DiscoveryProviderConfig) (Discovery, error) {
+	discoveryMu.RLock()
+	factory, ok := discoveryProviders[config.Type]
+	discoveryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown discovery type %q", config.Type)
+	}
+	if config.Interval < 0 {
+		return nil, fmt.Errorf("discovery %s: interval must not be negative", config.Type)
+	}
+	return factory(config)
+}
+
+// IsDiscoveryURL reports whether a backend URL is resolved through service
+// discovery rather than used as is
+func IsDiscoveryURL(raw string) bool {
+	return strings.HasPrefix(raw, SchemeDNS+"://") || strings.HasPrefix(raw, SchemeDNSSRV+"://")
+}
+
+// discoverySource is a backend entry resolved into backends at runtime
+type discoverySource struct {
+	name     string // The entry as configured, shown as the source of its backends
+	weight   int    // Weight of targets that do not carry their own
+	disabled bool   // Discovered backends start out disabled
+	interval time.Duration
+	provider Discovery
+	weights  map[string]int // Weight last reported for each target
+}
+
+// newDiscoverySource creates the source for a dns:// or dns+srv:// backend URL
+func newDiscoverySource(raw string, weight int, config DiscoveryConfig) (*discoverySource, error) {
+	resolver, err := newDNSResolver(raw, config)
+	if err != nil {
+		return nil, err
+	}
+	return &discoverySource{
+		name:     raw,
+		weight:   weight,
+		interval: config.Interval,
+		provider: resolver,
+		weights:  make(map[string]int),
+	}, nil
+}
+
+// newProviderSource creates the source for a discovery backend entry
+func newProviderSource(provider DiscoveryProviderConfig, weight int, config DiscoveryConfig) (*discoverySource, error) {
+	discovery, err := NewDiscovery(provider)
+	if err != nil {
+		return nil, err
+	}
+	interval := provider.Interval
+	if interval == 0 && provider.Type == "file" {
+		interval = 5 * time.Second // A stat per check, cheap enough to notice edits quickly
+	}
+	if interval == 0 {
+		interval = config.Interval
+	}
+	return &discoverySource{
+		name:     provider.name(),
+		weight:   weight,
+		interval: interval,
+		provider: discovery,
+		weights:  make(map[string]int),
+	}, nil
+}
+
+// runDiscovery refreshes each of the pool's sources at its interval until ctx is done
+func (bp *BackendPool) runDiscovery(ctx context.Context) {
+	for _, source := range bp.sources {
+		go func(source *discoverySource) {
+			ticker := time.NewTicker(source.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					bp.refreshSource(source, true)
+				}
+			}
+		}(source)
+	}
+}
+
+// refreshDiscovery resolves every source once, leaving health checks to the caller
+func (bp *BackendPool) refreshDiscovery() {
+	var wg sync.WaitGroup
+	for _, source := range bp.sources {
+		wg.Add(1)
+		go func(source *discoverySource) {
+			defer wg.Done()
+			bp.refreshSource(source, false)
+		}(source)
+	}
+	wg.Wait()
+}
+
+// refreshSource asks a source for its targets and updates the pool. A source
+// that fails keeps its current backends. New backends are checked right away if check is set.
+func (bp *BackendPool) refreshSource(source *discoverySource, check bool) {
+	ctx, cancel := context.WithTimeout(bp.ctx, bp.discovery.Timeout)
+	targets, err := source.provider.Discover(ctx)
+	cancel()
+	if err != nil {
+		if bp.ctx.Err() == nil {
+			log.Printf("Discovery of %s failed, keeping its current backends: %v", source.name, err)
+		}
+		return
+	}
+	for _, backend := range bp.syncDiscovered(source, targets) {
+		if check {
+			go bp.checkBackend(backend)
+		}
+	}
+}
+
+// syncDiscovered makes the pool's backends from source match targets. Backends
+// still listed keep their health state; it returns the ones that were added.
+func (bp *BackendPool) syncDiscovered(source *discoverySource, targets []Target) []*Backend {
+	var urls []string
+	wanted := make(map[string]int, len(targets))
+	for _, target := range targets {
+		targetURL, err := normalizeTarget(target.URL, bp.discovery.Scheme)
+		if err != nil {
+			log.Printf("Ignoring target of %s: %v", source.name, err)
+			continue
+		}
+		weight := target.Weight
+		if weight <= 0 {
+			weight = source.weight
+		}
+		if _, ok := wanted[targetURL]; !ok {
+			urls = append(urls, targetURL)
+		}
+		wanted[targetURL] = weight
+	}
+
+	bp.mu.Lock()
+	var removed, added []*Backend
+	backends := make([]*Backend, 0, len(bp.backends)+len(urls))
+	present := make(map[string]bool, len(bp.backends))
+	for _, backend := range bp.backends {
+		backendURL := backend.URL.String()
+		if backend.source != source {
+			backends = append(backends, backend)
+			present[backendURL] = true
+			continue
+		}
+		weight, ok := wanted[backendURL]
+		if !ok {
+			removed = append(removed, backend)
+			delete(source.weights, backendURL)
+			continue
+		}
+		// Only follow weight changes from the source, not ones made through the admin API
+		if source.weights[backendURL] != weight {
+			backend.SetWeight(weight)
+			source.weights[backendURL] = weight
+		}
+		backends = append(backends, backend)
+		present[backendURL] = true
+	}
+	for _, targetURL := range urls {
+		if present[targetURL] {
+			continue
+		}
+		backend, err := bp.newBackend(targetURL, wanted[targetURL])
+		if err != nil {
+			log.Printf("Ignoring backend %s discovered by %s: %v", targetURL, source.name, err)
+			continue
+		}
+		backend.source = source
+		backend.outlier = bp.outlier
+		backend.disabled.Store(source.disabled)
+		source.weights[targetURL] = wanted[targetURL]
+		backends = append(backends, backend)
+		present[targetURL] = true
+		added = append(added, backend)
+	}
+	// Copy on write, readers may still hold the old slice
+	bp.backends = backends
+	bp.mu.Unlock()
+
+	for _, backend := range removed {
+		if bp.outlier != nil {
+			bp.outlier.forget(backend)
+		}
+		log.Printf("Backend %s no longer listed by %s, removed (%d requests in flight)", backend.URL.String(), source.name, backend.InFlight())
+	}
+	for _, backend := range added {
+		log.Printf("Backend %s discovered by %s", backend.URL.String(), source.name)
+	}
+	return added
+}
+
+// normalizeTarget turns a target into a backend URL, adding scheme to host:port targets
+func normalizeTarget(target, scheme string) (string, error) {
+	if !strings.Contains(target, "://") {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return "", fmt.Errorf("target %q is neither a URL nor host:port", target)
+		}
+		target = scheme + "://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid target %q", target)
+	}
+	return u.String(), nil
+}
+
+// sortTargets orders targets by URL so pools are built in a stable order
+func sortTargets(targets []Target) {
+	sort.Slice(targets, func(i, j int) bool { return targets[i].URL < targets[j].URL })
+}
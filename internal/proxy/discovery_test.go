@@ -0,0 +1,263 @@
+/*
+internal/proxy/discovery_test.go
+Package proxy tests file and HTTP service discovery and how pools follow discovered targets.
+*/
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeTargets writes a targets file and returns its path
+func writeTargets(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// discover runs one Discover call with a test timeout
+func discover(d Discovery) ([]Target, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return d.Discover(ctx)
+}
+
+func TestFileDiscovery(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []Target
+		wantErr bool
+	}{
+		{"json", "targets.json", `[{"targets": ["10.0.0.1:9001", "10.0.0.2:9001"]}]`, []Target{
+			{URL: "10.0.0.1:9001"},
+			{URL: "10.0.0.2:9001"},
+		}, false},
+		{"yaml with labels", "targets.yaml", `
+- targets: ["10.0.0.1:9001"]
+  labels:
+    weight: "3"
+    __scheme__: https
+- targets: ["http://10.0.0.2:9001/v1"]
+`, []Target{
+			{URL: "https://10.0.0.1:9001", Weight: 3},
+			{URL: "http://10.0.0.2:9001/v1"},
+		}, false},
+		{"empty list", "targets.json", `[]`, []Target{}, false},
+		{"invalid weight", "targets.json", `[{"targets": ["10.0.0.1:9001"], "labels": {"weight": "heavy"}}]`, nil, true},
+		{"negative weight", "targets.json", `[{"targets": ["10.0.0.1:9001"], "labels": {"weight": "-1"}}]`, nil, true},
+		{"not a list", "targets.yaml", `targets: ["10.0.0.1:9001"]`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTargets(t, t.TempDir(), tt.file, tt.content)
+			d, err := NewDiscovery(DiscoveryProviderConfig{Type: "file", Path: path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := discover(d)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Discover() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Discover() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileDiscoveryFollowsEdits(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTargets(t, dir, "targets.json", `[{"targets": ["10.0.0.1:9001"]}]`)
+	d, _ := NewDiscovery(DiscoveryProviderConfig{Type: "file", Path: path})
+
+	if got, err := discover(d); err != nil || len(got) != 1 {
+		t.Fatalf("Discover() = %v, %v, want one target", got, err)
+	}
+
+	writeTargets(t, dir, "targets.json", `[{"targets": ["10.0.0.1:9001", "10.0.0.2:9001"]}]`)
+	if got, err := discover(d); err != nil || len(got) != 2 {
+		t.Fatalf("after an edit Discover() = %v, %v, want two targets", got, err)
+	}
+
+	// A missing file is an error, so the pool keeps its backends
+	os.Remove(path)
+	if _, err := discover(d); err == nil {
+		t.Fatal("Discover() succeeded without the file")
+	}
+}
+
+func TestHTTPDiscovery(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		status  int
+		body    string
+		want    []Target
+		wantErr bool
+	}{
+		{"file_sd", "", http.StatusOK, `[{"targets": ["10.0.0.1:9001"], "labels": {"weight": "2"}}]`, []Target{
+			{URL: "10.0.0.1:9001", Weight: 2},
+		}, false},
+		{"consul", DiscoveryFormatConsul, http.StatusOK, `[
+			{"Address": "10.0.0.9", "ServiceAddress": "10.0.0.1", "ServicePort": 9001, "ServiceWeights": {"Passing": 5}},
+			{"Address": "10.0.0.2", "ServiceAddress": "", "ServicePort": 9002}
+		]`, []Target{
+			{URL: "10.0.0.1:9001", Weight: 5},
+			{URL: "10.0.0.2:9002"},
+		}, false},
+		{"empty catalog", DiscoveryFormatConsul, http.StatusOK, `[]`, []Target{}, false},
+		{"error status", "", http.StatusServiceUnavailable, `[]`, nil, true},
+		{"invalid json", "", http.StatusOK, `{"targets": `, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var token string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				token = r.Header.Get("X-Consul-Token")
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			d, err := NewDiscovery(DiscoveryProviderConfig{
+				Type:    "http",
+				URL:     server.URL + "/v1/catalog/service/users",
+				Format:  tt.format,
+				Headers: map[string]string{"X-Consul-Token": "secret"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := discover(d)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Discover() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if token != "secret" {
+				t.Fatalf("poll sent token %q, want the configured header", token)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Discover() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDiscoveryRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config DiscoveryProviderConfig
+	}{
+		{"unknown type", DiscoveryProviderConfig{Type: "etcd"}},
+		{"file without path", DiscoveryProviderConfig{Type: "file"}},
+		{"negative interval", DiscoveryProviderConfig{Type: "file", Path: "targets.json", Interval: -time.Second}},
+		{"http without scheme", DiscoveryProviderConfig{Type: "http", URL: "consul:8500/v1/catalog/service/users"}},
+		{"http unknown format", DiscoveryProviderConfig{Type: "http", URL: "http://consul:8500", Format: "eureka"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDiscovery(tt.config); err == nil {
+				t.Fatalf("NewDiscovery(%+v) accepted an invalid configuration", tt.config)
+			}
+		})
+	}
+}
+
+// fakeDiscovery reports targets set by the test, or fails
+type fakeDiscovery struct {
+	mu      sync.Mutex
+	targets []Target
+	err     error
+}
+
+func (fd *fakeDiscovery) Discover(ctx context.Context) ([]Target, error) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return fd.targets, fd.err
+}
+
+// set changes what the next Discover call reports
+func (fd *fakeDiscovery) set(targets []Target, err error) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.targets, fd.err = targets, err
+}
+
+// poolURLs returns the URLs of a pool's backends
+func poolURLs(pool *BackendPool) map[string]*Backend {
+	urls := make(map[string]*Backend)
+	for _, backend := range pool.GetAllBackends() {
+		urls[backend.GetURL().String()] = backend
+	}
+	return urls
+}
+
+func TestPoolFollowsDiscoveredTargets(t *testing.T) {
+	configured, _ := NewBackend("http://static:8080", 1)
+	pool := NewBackendPool([]*Backend{configured}, nil)
+	pool.discovery, _ = DiscoveryConfig{}.withDefaults()
+	pool.newBackend = NewBackend
+	fake := &fakeDiscovery{}
+	source := &discoverySource{name: "fake", weight: 2, provider: fake, weights: make(map[string]int)}
+	pool.sources = []*discoverySource{source}
+
+	fake.set([]Target{{URL: "10.0.0.1:9001"}, {URL: "10.0.0.2:9001", Weight: 5}, {URL: "not a target"}}, nil)
+	pool.refreshSource(source, false)
+	backends := poolURLs(pool)
+	if len(backends) != 3 {
+		t.Fatalf("pool has %v, want the configured backend and two discovered ones", backends)
+	}
+	first, second := backends["http://10.0.0.1:9001"], backends["http://10.0.0.2:9001"]
+	if first == nil || second == nil || first.Weight() != 2 || second.Weight() != 5 {
+		t.Fatalf("discovered backends %v: want weight 2 (the entry's) and 5 (the target's)", backends)
+	}
+	if first.Source() != "fake" || configured.Source() != "" {
+		t.Fatalf("sources %q and %q, want fake and none", first.Source(), configured.Source())
+	}
+
+	// Backends still listed keep their state, the others leave
+	first.mu.Lock()
+	first.markUnhealthy(1)
+	first.mu.Unlock()
+	fake.set([]Target{{URL: "10.0.0.1:9001", Weight: 4}, {URL: "10.0.0.3:9001"}}, nil)
+	pool.refreshSource(source, false)
+	backends = poolURLs(pool)
+	if backends["http://10.0.0.1:9001"] != first || first.IsHealthy() || first.Weight() != 4 {
+		t.Fatal("listed backend was replaced, lost its health state or missed its new weight")
+	}
+	if backends["http://10.0.0.2:9001"] != nil || backends["http://10.0.0.3:9001"] == nil || backends["http://static:8080"] != configured {
+		t.Fatalf("pool has %v after the targets changed", backends)
+	}
+
+	// A failing source keeps what it found last
+	fake.set(nil, errors.New("unavailable"))
+	pool.refreshSource(source, false)
+	if len(pool.GetAllBackends()) != 3 {
+		t.Fatalf("pool has %d backends after a failed discovery, want 3", len(pool.GetAllBackends()))
+	}
+
+	// An empty list is an answer, not a failure
+	fake.set([]Target{}, nil)
+	pool.refreshSource(source, false)
+	if backends := poolURLs(pool); len(backends) != 1 || backends["http://static:8080"] != configured {
+		t.Fatalf("pool has %v after the source emptied, want the configured backend only", backends)
+	}
+}
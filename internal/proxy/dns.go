@@ -0,0 +1,126 @@
+/*
+internal/proxy/dns.go
+Package proxy provides DNS-based service discovery using A/AAAA and SRV records.
+*/
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// DNS discovery URL schemes
+const (
+	SchemeDNS    = "dns"     // dns://users.internal:9001 resolves A/AAAA records
+	SchemeDNSSRV = "dns+srv" // dns+srv://_users._tcp.service.internal resolves SRV records
+)
+
+// dnsResolver turns a dns:// or dns+srv:// backend entry into backend URLs
+type dnsResolver struct {
+	srv      bool
+	name     string // Host name, or SRV name including _service._proto
+	port     string // A/AAAA only
+	path     string // Base path of the discovered backends
+	scheme   string
+	resolver *net.Resolver
+}
+
+// newDNSResolver parses a discovery URL
+func newDNSResolver(raw string, config DiscoveryConfig) (*dnsResolver, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid discovery URL %q", raw)
+	}
+	if u.RawQuery != "" || u.User != nil {
+		return nil, fmt.Errorf("discovery URL %q must not have a query or user info", raw)
+	}
+
+	d := &dnsResolver{
+		srv:      u.Scheme == SchemeDNSSRV,
+		name:     u.Hostname(),
+		port:     u.Port(),
+		path:     strings.TrimSuffix(u.Path, "/"),
+		scheme:   config.Scheme,
+		resolver: net.DefaultResolver,
+	}
+	switch {
+	case u.Scheme != SchemeDNS && u.Scheme != SchemeDNSSRV:
+		return nil, fmt.Errorf("unknown discovery scheme in %q", raw)
+	case d.srv && d.port != "":
+		return nil, fmt.Errorf("discovery URL %q: SRV records carry the port, remove it", raw)
+	case !d.srv && d.port == "" && d.scheme == "https":
+		d.port = "443"
+	case !d.srv && d.port == "":
+		d.port = "80"
+	}
+
+	if config.Resolver != "" {
+		if _, _, err := net.SplitHostPort(config.Resolver); err != nil {
+			return nil, fmt.Errorf("invalid discovery resolver %q, use host:port", config.Resolver)
+		}
+		// Every query goes to the configured server instead of the ones in resolv.conf
+		d.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, config.Resolver)
+			},
+		}
+	}
+	return d, nil
+}
+
+// Discover looks up the current backends. For SRV records only the lowest
+// priority is used, and each record's weight becomes the backend weight.
+func (d *dnsResolver) Discover(ctx context.Context) ([]Target, error) {
+	if !d.srv {
+		return d.lookupHost(ctx, d.name, d.port, 0)
+	}
+
+	_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no SRV records for %s", d.name)
+	}
+	priority := records[0].Priority
+	for _, record := range records {
+		priority = min(priority, record.Priority)
+	}
+
+	var targets []Target
+	for _, record := range records {
+		if record.Priority != priority {
+			continue
+		}
+		// Targets are resolved with the same resolver, they may only exist there
+		resolved, err := d.lookupHost(ctx, strings.TrimSuffix(record.Target, "."), fmt.Sprint(record.Port), int(record.Weight))
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, resolved...)
+	}
+	return targets, nil
+}
+
+// lookupHost resolves a host to one target per address, sorted for stable ordering
+func (d *dnsResolver) lookupHost(ctx context.Context, host, port string, weight int) ([]Target, error) {
+	addrs, err := d.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]Target, 0, len(addrs))
+	for _, addr := range addrs {
+		targets = append(targets, Target{
+			URL:    d.scheme + "://" + net.JoinHostPort(addr.IP.String(), port) + d.path,
+			Weight: weight,
+		})
+	}
+	sortTargets(targets)
+	return targets, nil
+}
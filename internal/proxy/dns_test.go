@@ -0,0 +1,203 @@
+/*
+internal/proxy/dns_test.go
+Package proxy tests DNS service discovery against an in-process DNS server.
+*/
+
+package proxy
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsRecords are the records served for one name
+type dnsRecords struct {
+	a    []string
+	aaaa []string
+	srv  []net.SRV
+}
+
+// startDNSServer serves records over UDP on a local port and returns its address.
+// Unknown names get NXDOMAIN.
+func startDNSServer(t *testing.T, zone map[string]dnsRecords) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp, err := answerDNS(buf[:n], zone); err == nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// answerDNS builds the response to a query
+func answerDNS(query []byte, zone map[string]dnsRecords) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	records, found := zone[strings.ToLower(question.Name.String())]
+	respHeader := dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true, RecursionAvailable: true}
+	if !found {
+		respHeader.RCode = dnsmessage.RCodeNameError
+	}
+	builder := dnsmessage.NewBuilder(nil, respHeader)
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(question); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	rh := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 30}
+	switch question.Type {
+	case dnsmessage.TypeA:
+		for _, ip := range records.a {
+			var a dnsmessage.AResource
+			copy(a.A[:], net.ParseIP(ip).To4())
+			if err := builder.AResource(rh, a); err != nil {
+				return nil, err
+			}
+		}
+	case dnsmessage.TypeAAAA:
+		for _, ip := range records.aaaa {
+			var aaaa dnsmessage.AAAAResource
+			copy(aaaa.AAAA[:], net.ParseIP(ip).To16())
+			if err := builder.AAAAResource(rh, aaaa); err != nil {
+				return nil, err
+			}
+		}
+	case dnsmessage.TypeSRV:
+		for _, srv := range records.srv {
+			target, err := dnsmessage.NewName(srv.Target)
+			if err != nil {
+				return nil, err
+			}
+			resource := dnsmessage.SRVResource{Priority: srv.Priority, Weight: srv.Weight, Port: srv.Port, Target: target}
+			if err := builder.SRVResource(rh, resource); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return builder.Finish()
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	resolver := startDNSServer(t, map[string]dnsRecords{
+		"users.internal.": {a: []string{"10.0.0.2", "10.0.0.1"}, aaaa: []string{"fd00::1"}},
+		"web.internal.":   {a: []string{"10.0.1.1"}},
+		"_users._tcp.service.internal.": {srv: []net.SRV{
+			{Target: "a.service.internal.", Port: 8001, Priority: 10, Weight: 5},
+			{Target: "b.service.internal.", Port: 8002, Priority: 10, Weight: 1},
+			{Target: "backup.service.internal.", Port: 8003, Priority: 20, Weight: 1},
+		}},
+		"a.service.internal.":      {a: []string{"10.0.2.1"}},
+		"b.service.internal.":      {a: []string{"10.0.2.2", "10.0.2.3"}},
+		"backup.service.internal.": {a: []string{"10.0.2.9"}},
+		"_dangling._tcp.internal.": {srv: []net.SRV{{Target: "missing.internal.", Port: 80, Priority: 1}}},
+		"_empty._tcp.internal.":    {},
+	})
+
+	tests := []struct {
+		name    string
+		raw     string
+		scheme  string
+		want    []Target
+		wantErr bool
+	}{
+		{"A and AAAA records", "dns://users.internal:9001", "http", []Target{
+			{URL: "http://10.0.0.1:9001"},
+			{URL: "http://10.0.0.2:9001"},
+			{URL: "http://[fd00::1]:9001"},
+		}, false},
+		{"default https port and base path", "dns://web.internal/v1/", "https", []Target{
+			{URL: "https://10.0.1.1:443/v1"},
+		}, false},
+		{"default http port", "dns://web.internal", "http", []Target{
+			{URL: "http://10.0.1.1:80"},
+		}, false},
+		{"SRV uses the lowest priority and record weights", "dns+srv://_users._tcp.service.internal", "http", []Target{
+			{URL: "http://10.0.2.1:8001", Weight: 5},
+			{URL: "http://10.0.2.2:8002", Weight: 1},
+			{URL: "http://10.0.2.3:8002", Weight: 1},
+		}, false},
+		{"unknown name", "dns://missing.internal:80", "http", nil, true},
+		{"SRV target without addresses", "dns+srv://_dangling._tcp.internal", "http", nil, true},
+		{"no SRV records", "dns+srv://_empty._tcp.internal", "http", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newDNSResolver(tt.raw, DiscoveryConfig{Resolver: resolver, Scheme: tt.scheme})
+			if err != nil {
+				t.Fatalf("newDNSResolver() error = %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			got, err := d.Discover(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Discover() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			// SRV targets come back in shuffled order within a priority
+			sortTargets(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Discover() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDNSResolverRejectsInvalidURLs(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		resolver string
+	}{
+		{"unknown scheme", "dnss://users.internal", ""},
+		{"no host", "dns://:80", ""},
+		{"SRV with port", "dns+srv://_users._tcp.internal:80", ""},
+		{"query", "dns://users.internal?x=1", ""},
+		{"user info", "dns://user@users.internal", ""},
+		{"resolver without port", "dns://users.internal", "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newDNSResolver(tt.raw, DiscoveryConfig{Resolver: tt.resolver, Scheme: "http"}); err == nil {
+				t.Fatalf("newDNSResolver(%q) accepted an invalid entry", tt.raw)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+/*
+internal/proxy/filediscovery.go
+Package proxy provides file-based service discovery in the Prometheus file_sd format.
+*/
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// targetGroup is one entry of a file_sd document: addresses sharing labels.
+// The weight label sets the backend weight, __scheme__ the scheme of host:port targets.
+type targetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// targetsFromGroups flattens target groups into targets
+func targetsFromGroups(groups []targetGroup) ([]Target, error) {
+	var targets []Target
+	for _, group := range groups {
+		weight := 0
+		if value, ok := group.Labels["weight"]; ok {
+			w, err := strconv.Atoi(value)
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid weight label %q", value)
+			}
+			weight = w
+		}
+		for _, address := range group.Targets {
+			if scheme := group.Labels["__scheme__"]; scheme != "" {
+				address = scheme + "://" + address
+			}
+			targets = append(targets, Target{URL: address, Weight: weight})
+		}
+	}
+	return targets, nil
+}
+
+// fileDiscovery reads targets from a JSON or YAML file. The file is only
+// parsed again when its size or modification time changes.
+type fileDiscovery struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	targets []Target
+}
+
+// newFileDiscovery creates a file provider
+func newFileDiscovery(config DiscoveryProviderConfig) (Discovery, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("file discovery requires a path")
+	}
+	return &fileDiscovery{path: config.Path}, nil
+}
+
+// Discover returns the targets listed in the file
+func (fd *fileDiscovery) Discover(ctx context.Context) ([]Target, error) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	info, err := os.Stat(fd.path)
+	if err != nil {
+		return nil, err
+	}
+	if fd.targets != nil && info.ModTime().Equal(fd.modTime) && info.Size() == fd.size {
+		return fd.targets, nil
+	}
+
+	data, err := os.ReadFile(fd.path)
+	if err != nil {
+		return nil, err
+	}
+	// YAML is a superset of JSON, one parser reads both
+	var groups []targetGroup
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", fd.path, err)
+	}
+	targets, err := targetsFromGroups(groups)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", fd.path, err)
+	}
+	if targets == nil {
+		targets = []Target{}
+	}
+
+	fd.modTime, fd.size, fd.targets = info.ModTime(), info.Size(), targets
+	return targets, nil
+}
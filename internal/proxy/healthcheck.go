@@ -0,0 +1,250 @@
+/*
+internal/proxy/healthcheck.go
+Package proxy provides configurable active health checks for backends.
+*/
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Health check types
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+)
+
+// maxHealthBodyBytes caps how much of a health response body is inspected
+const maxHealthBodyBytes = 64 << 10
+
+// HealthCheckConfig holds per-route active health check settings
+type HealthCheckConfig struct {
+	Type               string            // http (default) or tcp
+	Path               string            // Relative to the backend's base path, may include a query (default /health)
+	Method             string            // Default GET
+	Statuses           []int             // Healthy response codes (default 200)
+	Body               string            // Substring the response body must contain
+	BodyRegex          string            // Pattern the response body must match
+	JSONField          string            // Dotted path of a field in a JSON body, e.g. "status" or "checks.db"
+	JSONValue          string            // Expected value of JSONField, compared as text
+	Headers            map[string]string // Sent with every check, e.g. Host or Authorization
+	Interval           time.Duration     // Default 10s
+	Timeout            time.Duration     // Default 3s
+	UnhealthyThreshold int               // Consecutive failures before a backend is marked unhealthy (default 3)
+	HealthyThreshold   int               // Consecutive successes before it is marked healthy again (default 1)
+}
+
+// HealthChecker probes backends according to a HealthCheckConfig
+type HealthChecker struct {
+	config    HealthCheckConfig
+	path      string
+	query     string
+	bodyRegex *regexp.Regexp
+	client    *http.Client
+}
+
+// NewHealthChecker validates a configuration and fills in defaults
+func NewHealthChecker(config HealthCheckConfig) (*HealthChecker, error) {
+	switch config.Type {
+	case "":
+		config.Type = HealthCheckHTTP
+	case HealthCheckHTTP, HealthCheckTCP:
+	default:
+		return nil, fmt.Errorf("unknown health check type %q", config.Type)
+	}
+	if config.Interval < 0 || config.Timeout < 0 || config.UnhealthyThreshold < 0 || config.HealthyThreshold < 0 {
+		return nil, fmt.Errorf("health check interval, timeout and thresholds must not be negative")
+	}
+	if config.Interval == 0 {
+		config.Interval = 10 * time.Second
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 3 * time.Second
+	}
+	if config.UnhealthyThreshold == 0 {
+		config.UnhealthyThreshold = 3
+	}
+	if config.HealthyThreshold == 0 {
+		config.HealthyThreshold = 1
+	}
+
+	hc := &HealthChecker{config: config}
+	if config.Type == HealthCheckTCP {
+		return hc, nil
+	}
+
+	if config.Path == "" {
+		config.Path = "/health"
+	}
+	if config.Method == "" {
+		config.Method = http.MethodGet
+	}
+	if len(config.Statuses) == 0 {
+		config.Statuses = []int{http.StatusOK}
+	}
+	for _, status := range config.Statuses {
+		if status < 100 || status > 599 {
+			return nil, fmt.Errorf("invalid health check status %d", status)
+		}
+	}
+	if config.JSONValue != "" && config.JSONField == "" {
+		return nil, fmt.Errorf("health check json_value requires json_field")
+	}
+	if config.BodyRegex != "" {
+		re, err := regexp.Compile(config.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid health check body_regex: %w", err)
+		}
+		hc.bodyRegex = re
+	}
+
+	hc.config = config
+	hc.path, hc.query, _ = strings.Cut(config.Path, "?")
+	hc.client = &http.Client{
+		Timeout: config.Timeout,
+		// A redirect is an answer in itself, match it against the expected statuses
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return hc, nil
+}
+
+// Interval returns the time between checks
+func (hc *HealthChecker) Interval() time.Duration {
+	return hc.config.Interval
+}
+
+// check probes a backend once and returns why it is unhealthy, or nil
+func (hc *HealthChecker) check(ctx context.Context, backend *Backend) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.config.Timeout)
+	defer cancel()
+
+	if hc.config.Type == HealthCheckTCP {
+		return hc.checkTCP(ctx, backend)
+	}
+	return hc.checkHTTP(ctx, backend)
+}
+
+// checkTCP only verifies that the backend accepts connections
+func (hc *HealthChecker) checkTCP(ctx context.Context, backend *Backend) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", backendAddress(backend.GetURL()))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkHTTP sends the configured request and checks status and body
+func (hc *HealthChecker) checkHTTP(ctx context.Context, backend *Backend) error {
+	req, err := http.NewRequestWithContext(ctx, hc.config.Method, upstreamURL(backend.GetURL(), hc.path, hc.query), nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range hc.config.Headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !containsStatus(hc.config.Statuses, resp.StatusCode) {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if hc.config.Body == "" && hc.bodyRegex == nil && hc.config.JSONField == "" {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBodyBytes))
+	if err != nil {
+		return fmt.Errorf("reading body: %w", err)
+	}
+	if hc.config.Body != "" && !strings.Contains(string(body), hc.config.Body) {
+		return fmt.Errorf("body does not contain %q", hc.config.Body)
+	}
+	if hc.bodyRegex != nil && !hc.bodyRegex.Match(body) {
+		return fmt.Errorf("body does not match %q", hc.config.BodyRegex)
+	}
+	if hc.config.JSONField != "" {
+		return matchJSONField(body, hc.config.JSONField, hc.config.JSONValue)
+	}
+	return nil
+}
+
+// matchJSONField checks that a dotted field exists in a JSON body and, if
+// expected is set, that its value reads as expected
+func matchJSONField(body []byte, field, expected string) error {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("body is not JSON: %w", err)
+	}
+	for _, key := range strings.Split(field, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("JSON field %q not found", field)
+		}
+		if value, ok = object[key]; !ok {
+			return fmt.Errorf("JSON field %q not found", field)
+		}
+	}
+	if expected == "" {
+		return nil
+	}
+
+	var actual string
+	switch v := value.(type) {
+	case string:
+		actual = v
+	case float64:
+		actual = strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		actual = "null"
+	default:
+		encoded, _ := json.Marshal(v)
+		actual = string(encoded)
+	}
+	if actual != expected {
+		return fmt.Errorf("JSON field %q is %q, want %q", field, actual, expected)
+	}
+	return nil
+}
+
+// containsStatus reports whether statuses contains status
+func containsStatus(statuses []int, status int) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// backendAddress returns host:port of a backend URL, using the scheme's default port
+func backendAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
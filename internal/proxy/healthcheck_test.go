@@ -0,0 +1,190 @@
+/*
+internal/proxy/healthcheck_test.go
+Package proxy tests active health check probes and thresholds.
+*/
+
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// healthServer answers health checks and records the last request
+type healthServer struct {
+	*httptest.Server
+	last atomic.Pointer[http.Request]
+}
+
+// newHealthServer serves handler and records each request it gets
+func newHealthServer(t *testing.T, handler http.HandlerFunc) *healthServer {
+	t.Helper()
+	hs := &healthServer{}
+	hs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hs.last.Store(r)
+		handler(w, r)
+	}))
+	t.Cleanup(hs.Close)
+	return hs
+}
+
+func TestHealthCheckProbe(t *testing.T) {
+	respond := func(status int, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			io.WriteString(w, body)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		config  HealthCheckConfig
+		handler http.HandlerFunc
+		healthy bool
+	}{
+		{"default 200", HealthCheckConfig{}, respond(200, ""), true},
+		{"default rejects 503", HealthCheckConfig{}, respond(503, ""), false},
+		{"accepted statuses", HealthCheckConfig{Statuses: []int{200, 204}}, respond(204, ""), true},
+		{"redirect is not followed", HealthCheckConfig{}, func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+		}, false},
+		{"body contains", HealthCheckConfig{Body: "ok"}, respond(200, "status: ok"), true},
+		{"body missing", HealthCheckConfig{Body: "ok"}, respond(200, "status: degraded"), false},
+		{"body regex", HealthCheckConfig{BodyRegex: `^up \d+s$`}, respond(200, "up 42s"), true},
+		{"body regex mismatch", HealthCheckConfig{BodyRegex: `^up \d+s$`}, respond(200, "down"), false},
+		{"json field", HealthCheckConfig{JSONField: "checks.db", JSONValue: "up"}, respond(200, `{"checks":{"db":"up"}}`), true},
+		{"json field wrong value", HealthCheckConfig{JSONField: "checks.db", JSONValue: "up"}, respond(200, `{"checks":{"db":"down"}}`), false},
+		{"json number", HealthCheckConfig{JSONField: "replicas", JSONValue: "3"}, respond(200, `{"replicas":3}`), true},
+		{"json field present", HealthCheckConfig{JSONField: "status"}, respond(200, `{"status":null}`), true},
+		{"json field missing", HealthCheckConfig{JSONField: "status"}, respond(200, `{}`), false},
+		{"not json", HealthCheckConfig{JSONField: "status"}, respond(200, "ok"), false},
+		{"tcp", HealthCheckConfig{Type: HealthCheckTCP}, respond(500, ""), true},
+		{"timeout", HealthCheckConfig{Timeout: 50 * time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newHealthServer(t, tt.handler)
+			checker, err := NewHealthChecker(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			backend, _ := NewBackend(server.URL, 1)
+			err = checker.check(context.Background(), backend)
+			if (err == nil) != tt.healthy {
+				t.Fatalf("check() = %v, want healthy %v", err, tt.healthy)
+			}
+		})
+	}
+}
+
+func TestHealthCheckRequest(t *testing.T) {
+	server := newHealthServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	checker, err := NewHealthChecker(HealthCheckConfig{
+		Path:    "/status?deep=1",
+		Method:  http.MethodHead,
+		Headers: map[string]string{"Host": "users.internal", "Authorization": "Bearer probe"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The path is relative to the backend's base path
+	backend, _ := NewBackend(server.URL+"/users", 1)
+	if err := checker.check(context.Background(), backend); err != nil {
+		t.Fatal(err)
+	}
+
+	req := server.last.Load()
+	if req.Method != http.MethodHead || req.URL.Path != "/users/status" || req.URL.RawQuery != "deep=1" {
+		t.Fatalf("probe was %s %s, want HEAD /users/status?deep=1", req.Method, req.URL)
+	}
+	if req.Host != "users.internal" || req.Header.Get("Authorization") != "Bearer probe" {
+		t.Fatalf("probe headers: Host %q, Authorization %q", req.Host, req.Header.Get("Authorization"))
+	}
+}
+
+func TestHealthCheckTCPClosedPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	checker, _ := NewHealthChecker(HealthCheckConfig{Type: HealthCheckTCP, Timeout: time.Second})
+	backend, _ := NewBackend("http://"+addr, 1)
+	if err := checker.check(context.Background(), backend); err == nil {
+		t.Fatal("check() passed for a closed port")
+	}
+}
+
+func TestHealthCheckThresholds(t *testing.T) {
+	var failing atomic.Bool
+	server := newHealthServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	checker, err := NewHealthChecker(HealthCheckConfig{UnhealthyThreshold: 3, HealthyThreshold: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, _ := NewBackend(server.URL, 1)
+	pool := NewBackendPool([]*Backend{backend}, checker)
+	defer pool.Stop()
+
+	steps := []struct {
+		failing bool
+		healthy bool
+	}{
+		{true, true},   // 1 failure
+		{true, true},   // 2 failures
+		{false, true},  // A pass resets the count
+		{true, true},   // 1 failure
+		{true, true},   // 2 failures
+		{true, false},  // 3 failures: unhealthy
+		{false, false}, // 1 pass
+		{false, true},  // 2 passes: healthy
+	}
+	for i, step := range steps {
+		failing.Store(step.failing)
+		pool.checkBackend(backend)
+		if backend.IsHealthy() != step.healthy {
+			t.Fatalf("check %d (failing %v): healthy = %v, want %v", i+1, step.failing, backend.IsHealthy(), step.healthy)
+		}
+	}
+	if len(pool.GetHealthyBackends()) != 1 {
+		t.Fatal("recovered backend is not back in rotation")
+	}
+}
+
+func TestNewHealthCheckerValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config HealthCheckConfig
+	}{
+		{"unknown type", HealthCheckConfig{Type: "udp"}},
+		{"negative interval", HealthCheckConfig{Interval: -time.Second}},
+		{"negative threshold", HealthCheckConfig{UnhealthyThreshold: -1}},
+		{"invalid status", HealthCheckConfig{Statuses: []int{42}}},
+		{"json value without field", HealthCheckConfig{JSONValue: "up"}},
+		{"invalid regex", HealthCheckConfig{BodyRegex: "("}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewHealthChecker(tt.config); err == nil {
+			t.Errorf("%s: NewHealthChecker() accepted %+v", tt.name, tt.config)
+		}
+	}
+}
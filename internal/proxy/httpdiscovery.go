@@ -0,0 +1,122 @@
+/*
+internal/proxy/httpdiscovery.go
+Package proxy provides service discovery by polling an HTTP endpoint (file_sd JSON or the Consul catalog).
+*/
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// HTTP discovery response formats
+const (
+	DiscoveryFormatFileSD = "file_sd" // [{"targets": ["10.0.0.1:9001"], "labels": {"weight": "2"}}]
+	DiscoveryFormatConsul = "consul"  // Response of Consul's /v1/catalog/service/<name>
+)
+
+// maxDiscoveryBodyBytes caps the size of a discovery response
+const maxDiscoveryBodyBytes = 10 << 20
+
+// consulCatalogService is the part of a Consul catalog entry that locates a service instance
+type consulCatalogService struct {
+	Address        string // Node address, used when the service has none
+	ServiceAddress string
+	ServicePort    int
+	ServiceWeights struct {
+		Passing int
+	}
+}
+
+// httpDiscovery polls an endpoint returning the current targets
+type httpDiscovery struct {
+	url     string
+	format  string
+	headers map[string]string
+	client  *http.Client
+}
+
+// newHTTPDiscovery creates an HTTP provider
+func newHTTPDiscovery(config DiscoveryProviderConfig) (Discovery, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid http discovery URL %q", config.URL)
+	}
+	switch config.Format {
+	case "":
+		config.Format = DiscoveryFormatFileSD
+	case DiscoveryFormatFileSD, DiscoveryFormatConsul:
+	default:
+		return nil, fmt.Errorf("unknown http discovery format %q (use %q or %q)", config.Format, DiscoveryFormatFileSD, DiscoveryFormatConsul)
+	}
+	return &httpDiscovery{
+		url:     config.URL,
+		format:  config.Format,
+		headers: config.Headers,
+		client:  &http.Client{}, // The poll context carries the timeout
+	}, nil
+}
+
+// Discover fetches and decodes the endpoint's current targets
+func (hd *httpDiscovery) Discover(ctx context.Context) ([]Target, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hd.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range hd.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := hd.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDiscoveryBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	if hd.format == DiscoveryFormatConsul {
+		var services []consulCatalogService
+		if err := json.Unmarshal(body, &services); err != nil {
+			return nil, fmt.Errorf("decoding Consul catalog: %w", err)
+		}
+		targets := make([]Target, 0, len(services))
+		for _, service := range services {
+			address := service.ServiceAddress
+			if address == "" {
+				address = service.Address
+			}
+			targets = append(targets, Target{
+				URL:    net.JoinHostPort(address, strconv.Itoa(service.ServicePort)),
+				Weight: service.ServiceWeights.Passing,
+			})
+		}
+		return targets, nil
+	}
+
+	var groups []targetGroup
+	if err := json.Unmarshal(body, &groups); err != nil {
+		return nil, fmt.Errorf("decoding targets: %w", err)
+	}
+	targets, err := targetsFromGroups(groups)
+	if err != nil {
+		return nil, err
+	}
+	if targets == nil {
+		targets = []Target{}
+	}
+	return targets, nil
+}
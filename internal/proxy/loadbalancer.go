@@ -7,14 +7,81 @@ package proxy
 
 import (
 	"fmt"
+	"math/rand/v2"
 	"sync"
 )
 
+// Load balancing strategy names accepted by NewLoadBalancer
+const (
+	StrategyRoundRobin         = "round_robin"
+	StrategySmoothWeighted     = "smooth_weighted_round_robin"
+	StrategyLeastConnections   = "least_connections"
+	StrategyPowerOfTwo         = "power_of_two"
+	StrategyRandom             = "random"
+	StrategyEWMA               = "ewma"
+	DefaultLoadBalanceStrategy = StrategyRoundRobin
+)
+
 // LoadBalancer defines the interface for load balancing algorithms
 type LoadBalancer interface {
 	NextBackend() (*Backend, error)
 }
 
+// NewLoadBalancer creates a load balancer for the named strategy ("" selects the default).
+// hashKey is only used by the consistent-hash strategy.
+func NewLoadBalancer(strategy string, pool *BackendPool, hashKey HashKeyConfig) (LoadBalancer, error) {
+	switch strategy {
+	case "", StrategyRoundRobin:
+		return NewRoundRobinBalancer(pool), nil
+	case StrategySmoothWeighted:
+		return NewSmoothWeightedBalancer(pool), nil
+	case StrategyLeastConnections:
+		return NewLeastConnectionsBalancer(pool), nil
+	case StrategyPowerOfTwo:
+		return NewPowerOfTwoBalancer(pool), nil
+	case StrategyRandom:
+		return NewRandomBalancer(pool), nil
+	case StrategyEWMA:
+		return NewEWMABalancer(pool), nil
+	case StrategyConsistentHash:
+		return NewConsistentHashBalancer(pool, hashKey), nil
+	default:
+		return nil, fmt.Errorf("unknown load balancer %q", strategy)
+	}
+}
+
+// ValidLoadBalancer reports whether strategy names a known load balancer
+func ValidLoadBalancer(strategy string) bool {
+	_, err := NewLoadBalancer(strategy, nil, HashKeyConfig{})
+	return err == nil
+}
+
+// pickAvailable repeatedly lets choose pick among the available backends until one's
+// circuit breaker still admits traffic (a half-open breaker may have no probe slots
+// left). The proxy reserves the slot once a backend is picked.
+func pickAvailable(pool *BackendPool, choose func([]*Backend) *Backend) (*Backend, error) {
+	candidates := pool.GetAvailableBackends()
+	for len(candidates) > 0 {
+		backend := choose(candidates)
+		if backend.admits() {
+			return backend, nil
+		}
+		candidates = removeBackend(candidates, backend)
+	}
+	return nil, fmt.Errorf("no healthy backends available")
+}
+
+// removeBackend returns candidates without b
+func removeBackend(candidates []*Backend, b *Backend) []*Backend {
+	remaining := make([]*Backend, 0, len(candidates))
+	for _, c := range candidates {
+		if c != b {
+			remaining = append(remaining, c)
+		}
+	}
+	return remaining
+}
+
 // RoundRobinBalancer implements round-robin load balancing with weight support
 type RoundRobinBalancer struct {
 	pool    *BackendPool
@@ -30,39 +97,55 @@ func NewRoundRobinBalancer(pool *BackendPool) *RoundRobinBalancer {
 	}
 }
 
-// NextBackend returns the next healthy backend using round-robin algorithm
+// NextBackend returns the next available backend using round-robin algorithm.
+// Backends whose circuit breaker is open are skipped.
 func (rr *RoundRobinBalancer) NextBackend() (*Backend, error) {
 	rr.mu.Lock()
 	defer rr.mu.Unlock()
 
-	healthyBackends := rr.pool.GetHealthyBackends()
-	if len(healthyBackends) == 0 {
+	availableBackends := rr.pool.GetAvailableBackends()
+	if len(availableBackends) == 0 {
 		return nil, fmt.Errorf("no healthy backends available")
 	}
 
 	// Expand backends based on weight for weighted round-robin
 	// For example: backend with weight 2 appears twice in the list
 	var weightedBackends []*Backend
-	for _, backend := range healthyBackends {
-		weight := backend.Weight
-		if weight <= 0 {
-			weight = 1
-		}
-		for i := 0; i < weight; i++ {
+	for _, backend := range availableBackends {
+		for i := 0; i < backend.configuredWeight(); i++ {
 			weightedBackends = append(weightedBackends, backend)
 		}
 	}
 
-	// Select next backend in round-robin fashion
-	backend := weightedBackends[rr.current%len(weightedBackends)]
-	rr.current++
+	// Select next backend in round-robin fashion, moving on if a half-open
+	// breaker has already handed out all of its probe slots. A backend in slow
+	// start only takes its turn with a probability matching its ramp.
+	var warming *Backend
+	for attempt := 0; attempt < len(weightedBackends); attempt++ {
+		backend := weightedBackends[rr.current%len(weightedBackends)]
+		rr.current++
 
-	// Prevent overflow by resetting counter
-	if rr.current >= len(weightedBackends)*1000 {
-		rr.current = 0
+		// Prevent overflow by resetting counter
+		if rr.current >= len(weightedBackends)*1000 {
+			rr.current = 0
+		}
+
+		if factor := backend.slowStartFactor(); factor < 1 && rand.Float64() >= factor {
+			if warming == nil {
+				warming = backend
+			}
+			continue
+		}
+		if backend.admits() {
+			return backend, nil
+		}
 	}
 
-	return backend, nil
+	// Only warming backends left: better a cold backend than none
+	if warming != nil && warming.admits() {
+		return warming, nil
+	}
+	return nil, fmt.Errorf("no healthy backends available")
 }
 
 // Reset resets the round-robin counter
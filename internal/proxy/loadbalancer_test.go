@@ -0,0 +1,232 @@
+/*
+internal/proxy/loadbalancer_test.go
+Package proxy tests the load balancing strategies.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// newTestPool creates a pool with one backend per weight
+func newTestPool(t *testing.T, weights ...int) (*BackendPool, []*Backend) {
+	t.Helper()
+	backends := make([]*Backend, len(weights))
+	for i, weight := range weights {
+		backend, err := NewBackend(fmt.Sprintf("http://backend-%d:8080", i), weight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backends[i] = backend
+	}
+	return NewBackendPool(backends, nil), backends
+}
+
+// pick calls NextBackend n times and returns the picks
+func pick(t *testing.T, lb LoadBalancer, n int) []*Backend {
+	t.Helper()
+	picks := make([]*Backend, n)
+	for i := range picks {
+		backend, err := lb.NextBackend()
+		if err != nil {
+			t.Fatalf("NextBackend() error = %v", err)
+		}
+		picks[i] = backend
+	}
+	return picks
+}
+
+func TestNewLoadBalancer(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     string
+	}{
+		{"", "*proxy.RoundRobinBalancer"},
+		{StrategyRoundRobin, "*proxy.RoundRobinBalancer"},
+		{StrategySmoothWeighted, "*proxy.SmoothWeightedBalancer"},
+		{StrategyLeastConnections, "*proxy.LeastConnectionsBalancer"},
+		{StrategyPowerOfTwo, "*proxy.PowerOfTwoBalancer"},
+		{StrategyRandom, "*proxy.RandomBalancer"},
+		{StrategyEWMA, "*proxy.EWMABalancer"},
+		{StrategyConsistentHash, "*proxy.ConsistentHashBalancer"},
+		{"fastest", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			lb, err := NewLoadBalancer(tt.strategy, nil, HashKeyConfig{})
+			if tt.want == "" {
+				if err == nil || ValidLoadBalancer(tt.strategy) {
+					t.Fatalf("NewLoadBalancer(%q) accepted an unknown strategy", tt.strategy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewLoadBalancer(%q) error = %v", tt.strategy, err)
+			}
+			if got := fmt.Sprintf("%T", lb); got != tt.want {
+				t.Fatalf("NewLoadBalancer(%q) = %s, want %s", tt.strategy, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeightedSequences(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		weights  []int
+		want     []int
+	}{
+		{"round robin expands weights", StrategyRoundRobin, []int{2, 1}, []int{0, 0, 1, 0, 0, 1}},
+		{"round robin treats weight 0 as 1", StrategyRoundRobin, []int{0, 1}, []int{0, 1, 0, 1}},
+		{"smooth weighted interleaves", StrategySmoothWeighted, []int{5, 1, 1}, []int{0, 0, 1, 0, 2, 0, 0, 0, 0, 1, 0, 2, 0, 0}},
+		{"smooth weighted equal weights", StrategySmoothWeighted, []int{1, 1, 1}, []int{0, 1, 2, 0, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, backends := newTestPool(t, tt.weights...)
+			lb, _ := NewLoadBalancer(tt.strategy, pool, HashKeyConfig{})
+			for i, backend := range pick(t, lb, len(tt.want)) {
+				if backend != backends[tt.want[i]] {
+					t.Fatalf("pick %d = %s, want backend %d", i, backend.URL, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedDistribution(t *testing.T) {
+	const picks = 8000
+	for _, strategy := range []string{StrategyRoundRobin, StrategySmoothWeighted, StrategyRandom, StrategyLeastConnections} {
+		t.Run(strategy, func(t *testing.T) {
+			pool, backends := newTestPool(t, 1, 3)
+			lb, _ := NewLoadBalancer(strategy, pool, HashKeyConfig{})
+			counts := make(map[*Backend]int)
+			for _, backend := range pick(t, lb, picks) {
+				counts[backend]++
+			}
+			share := float64(counts[backends[1]]) / picks
+			if math.Abs(share-0.75) > 0.03 {
+				t.Fatalf("weight 3 backend got %.3f of picks, want about 0.75", share)
+			}
+		})
+	}
+}
+
+func TestBalancersSkipUnavailableBackends(t *testing.T) {
+	unavailable := map[string]func(*Backend){
+		"unhealthy": func(b *Backend) { b.mu.Lock(); b.markUnhealthy(1); b.mu.Unlock() },
+		"draining":  func(b *Backend) { b.SetDraining(true) },
+		"disabled":  func(b *Backend) { b.SetDisabled(true) },
+		"ejected":   func(b *Backend) { b.ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano()) },
+		"circuit open": func(b *Backend) {
+			b.breaker = NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 1, OpenDuration: time.Minute})
+			ticket, _ := b.breaker.Allow()
+			b.breaker.Record(ticket, false)
+		},
+	}
+	strategies := []string{
+		StrategyRoundRobin, StrategySmoothWeighted, StrategyLeastConnections,
+		StrategyPowerOfTwo, StrategyRandom, StrategyEWMA, StrategyConsistentHash,
+	}
+
+	for reason, disable := range unavailable {
+		for _, strategy := range strategies {
+			t.Run(reason+"/"+strategy, func(t *testing.T) {
+				pool, backends := newTestPool(t, 1, 1, 1)
+				lb, _ := NewLoadBalancer(strategy, pool, HashKeyConfig{})
+				disable(backends[1])
+				for _, backend := range pick(t, lb, 200) {
+					if backend == backends[1] {
+						t.Fatalf("picked the %s backend", reason)
+					}
+				}
+
+				disable(backends[0])
+				disable(backends[2])
+				if backend, err := lb.NextBackend(); err == nil {
+					t.Fatalf("NextBackend() = %s with every backend %s, want an error", backend.URL, reason)
+				}
+			})
+		}
+	}
+}
+
+func TestLoadAwareBalancers(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		weights  []int
+		inFlight []int64
+		latency  []time.Duration
+		want     int
+	}{
+		{"least connections prefers idle", StrategyLeastConnections, []int{1, 1}, []int64{3, 1}, nil, 1},
+		{"least connections divides by weight", StrategyLeastConnections, []int{4, 1}, []int64{3, 1}, nil, 0},
+		{"power of two prefers idle", StrategyPowerOfTwo, []int{1, 1}, []int64{5, 0}, nil, 1},
+		{"ewma prefers fast", StrategyEWMA, []int{1, 1}, []int64{0, 0}, []time.Duration{100 * time.Millisecond, 10 * time.Millisecond}, 1},
+		{"ewma avoids a queued fast backend", StrategyEWMA, []int{1, 1}, []int64{0, 20}, []time.Duration{100 * time.Millisecond, 10 * time.Millisecond}, 0},
+		{"ewma probes backends without samples", StrategyEWMA, []int{1, 1}, []int64{0, 0}, []time.Duration{10 * time.Millisecond, 0}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, backends := newTestPool(t, tt.weights...)
+			for i, backend := range backends {
+				backend.inFlight.Store(tt.inFlight[i])
+				if tt.latency != nil && tt.latency[i] > 0 {
+					backend.observeLatency(tt.latency[i])
+				}
+			}
+			lb, _ := NewLoadBalancer(tt.strategy, pool, HashKeyConfig{})
+			for _, backend := range pick(t, lb, 50) {
+				if backend != backends[tt.want] {
+					t.Fatalf("picked %s, want backend %d", backend.URL, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEWMARecoversAfterIdle(t *testing.T) {
+	pool, backends := newTestPool(t, 1, 1)
+	lb := NewEWMABalancer(pool)
+	backends[0].observeLatency(2 * time.Second)
+	backends[1].observeLatency(100 * time.Millisecond)
+	for _, backend := range pick(t, lb, 10) {
+		if backend != backends[1] {
+			t.Fatalf("picked the spiked backend %s right after the spike", backend.URL)
+		}
+	}
+
+	// The spiked backend got no traffic for a while, the other kept answering
+	backends[0].statsMu.Lock()
+	backends[0].lastSample = backends[0].lastSample.Add(-6 * ewmaDecay)
+	backends[0].statsMu.Unlock()
+	backends[1].observeLatency(100 * time.Millisecond)
+
+	if got := backends[0].EWMALatency(); got >= 100*time.Millisecond {
+		t.Fatalf("EWMALatency() after idling = %s, want below the other backend's 100ms", got)
+	}
+	if backend := pick(t, lb, 1)[0]; backend != backends[0] {
+		t.Fatalf("picked %s, want the recovered backend", backend.URL)
+	}
+}
+
+func TestSmoothWeightedForgetsRemovedBackends(t *testing.T) {
+	pool, backends := newTestPool(t, 1, 1)
+	lb := NewSmoothWeightedBalancer(pool)
+	pick(t, lb, 3)
+
+	backends[1].SetDisabled(true)
+	pick(t, lb, 1)
+	if _, ok := lb.current[backends[1]]; ok {
+		t.Fatal("smooth weighted balancer kept state for a backend that left rotation")
+	}
+}
@@ -0,0 +1,200 @@
+/*
+internal/proxy/mirror.go
+Package proxy provides fire-and-forget mirroring of route traffic to a shadow backend.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// MirrorConfig holds per-route traffic mirroring settings
+type MirrorConfig struct {
+	Route            string        // Route label stored with results
+	URL              string        // Shadow backend that receives the copies
+	Percentage       float64       // Share of requests mirrored, 0-100
+	Timeout          time.Duration // Per mirror request (default: the route timeout)
+	MaxBodyBytes     int64         // Larger request bodies are not mirrored (default 1MB)
+	CompareResponses bool          // Hash both response bodies and record whether they differ
+	MaxConcurrent    int           // In-flight mirror requests before copies are dropped (default 100)
+}
+
+// Mirror sends copies of sampled requests to a shadow backend. Responses from the
+// shadow are discarded; only their status, latency and optional diff flag are recorded.
+type Mirror struct {
+	config MirrorConfig
+	target *url.URL
+	client *http.Client
+	store  storage.MirrorStore // nil when results are only logged on error
+	slots  chan struct{}
+}
+
+// NewMirror creates a mirror for the given configuration
+func NewMirror(config MirrorConfig, store storage.MirrorStore) (*Mirror, error) {
+	target, err := url.Parse(config.URL)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("invalid mirror URL %q", config.URL)
+	}
+	if config.Percentage < 0 || config.Percentage > 100 {
+		return nil, fmt.Errorf("mirror percentage must be between 0 and 100")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = 1 << 20
+	}
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = 100
+	}
+
+	// A separate transport keeps a slow shadow from using up the primary's connections
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        config.MaxConcurrent,
+		MaxIdleConnsPerHost: config.MaxConcurrent,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+	}
+
+	return &Mirror{
+		config: config,
+		target: target,
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		store: store,
+		slots: make(chan struct{}, config.MaxConcurrent),
+	}, nil
+}
+
+// sample decides whether a request is mirrored
+func (m *Mirror) sample() bool {
+	return m.config.Percentage > 0 && rand.Float64()*100 < m.config.Percentage
+}
+
+// mirrorCapture holds what the primary request leaves for its mirror copy
+type mirrorCapture struct {
+	body   []byte
+	start  time.Time
+	digest hash.Hash // Primary response body hash, nil unless comparing
+}
+
+// capture starts recording a sampled request
+func (m *Mirror) capture(body []byte) *mirrorCapture {
+	capture := &mirrorCapture{body: body, start: time.Now()}
+	if m.config.CompareResponses {
+		capture.digest = sha256.New()
+	}
+	return capture
+}
+
+// dispatch sends the mirror copy once the primary response has been written.
+// The request is built before returning since the gin context is reused afterwards;
+// it is then sent in the background, or dropped if too many copies are in flight.
+func (m *Mirror) dispatch(ph *ProxyHandler, c *gin.Context, capture *mirrorCapture) {
+	result := storage.MirrorResult{
+		Time:           capture.start,
+		Route:          m.config.Route,
+		Method:         c.Request.Method,
+		Path:           c.Request.URL.Path,
+		PrimaryStatus:  c.Writer.Status(),
+		PrimaryLatency: time.Since(capture.start),
+	}
+	var primaryDigest []byte
+	if capture.digest != nil {
+		primaryDigest = capture.digest.Sum(nil)
+	}
+
+	var body io.Reader
+	if capture.body != nil {
+		body = bytes.NewReader(capture.body)
+	}
+	req, err := ph.createProxyRequest(c.Request, ph.buildTargetURL(m.target, c.Request.URL), body)
+	if err != nil {
+		log.Printf("Failed to create mirror request: %v", err)
+		return
+	}
+	ph.setForwardingHeaders(req, c.Request)
+	req.Header.Set("X-Gateway-Mirror", "true")
+
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		log.Printf("Mirror %s busy, dropping copy of %s %s", m.target.Host, result.Method, result.Path)
+		return
+	}
+
+	go func() {
+		defer func() { <-m.slots }()
+		m.send(req, result, primaryDigest)
+	}()
+}
+
+// send performs the mirror request and records the outcome
+func (m *Mirror) send(req *http.Request, result storage.MirrorResult, primaryDigest []byte) {
+	// Detached from the client request, which has already completed
+	ctx, cancel := context.WithTimeout(context.Background(), m.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		result.MirrorLatency = time.Since(start)
+		result.Error = err.Error()
+		log.Printf("Mirror request to %s failed: %v", m.target.Host, err)
+		m.save(result)
+		return
+	}
+	defer resp.Body.Close()
+
+	var mirrorDigest []byte
+	if primaryDigest != nil {
+		digest := sha256.New()
+		_, err = io.Copy(digest, resp.Body)
+		mirrorDigest = digest.Sum(nil)
+	} else {
+		_, err = io.Copy(io.Discard, resp.Body)
+	}
+	result.MirrorStatus = resp.StatusCode
+	result.MirrorLatency = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+	} else if primaryDigest != nil {
+		diff := result.PrimaryStatus != result.MirrorStatus || !bytes.Equal(primaryDigest, mirrorDigest)
+		result.Diff = &diff
+	}
+	m.save(result)
+}
+
+// save stores a result if a store is configured
+func (m *Mirror) save(result storage.MirrorResult) {
+	if m.store == nil {
+		return
+	}
+	if err := m.store.SaveMirrorResult(result); err != nil {
+		log.Printf("Failed to save mirror result: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+/*
+internal/proxy/mirror_test.go
+Package proxy tests mirroring route traffic to a shadow backend.
+*/
+
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// mirrorResults records saved mirror results on a channel
+type mirrorResults chan storage.MirrorResult
+
+func (mr mirrorResults) SaveMirrorResult(result storage.MirrorResult) error {
+	mr <- result
+	return nil
+}
+
+// shadowRequest is what the shadow backend received
+type shadowRequest struct {
+	method string
+	path   string
+	body   string
+	header http.Header
+}
+
+// routeRouter serves rp on path like the gateway does
+func routeRouter(rp *RouteProxy, path string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Any(path, rp.Handler())
+	return router
+}
+
+func TestMirror(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		shadow     http.HandlerFunc
+		timeout    time.Duration
+		wantStatus int  // Mirror status recorded (0: request failed)
+		wantDiff   bool // Only checked when the mirror answered
+		wantError  bool
+	}{
+		{"same response", http.MethodGet, "", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "users") }, 0, http.StatusOK, false, false},
+		{"request body is copied", http.MethodPost, `{"name":"ada"}`, func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "users") }, 0, http.StatusOK, false, false},
+		{"different body", http.MethodGet, "", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "users v2") }, 0, http.StatusOK, true, false},
+		{"different status", http.MethodGet, "", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "users")
+		}, 0, http.StatusInternalServerError, true, false},
+		{"shadow too slow", http.MethodGet, "", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}, 100 * time.Millisecond, 0, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "users")
+			}))
+			defer primary.Close()
+			received := make(chan shadowRequest, 1)
+			shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				received <- shadowRequest{r.Method, r.URL.Path, string(body), r.Header.Clone()}
+				tt.shadow(w, r)
+			}))
+			defer shadow.Close()
+
+			results := make(mirrorResults, 1)
+			rp, err := NewRouteProxy([]BackendGroupConfig{{URLs: []string{primary.URL}, Weights: []int{1}}}, 5*time.Second, RouteOptions{
+				Mirror:      MirrorConfig{Route: "users", URL: shadow.URL, Percentage: 100, Timeout: tt.timeout, CompareResponses: true},
+				MirrorStore: results,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/api/users", strings.NewReader(tt.body))
+			routeRouter(rp, "/api/*filepath").ServeHTTP(w, req)
+			if w.Code != http.StatusOK || w.Body.String() != "users" {
+				t.Fatalf("primary response %d %q, want the primary's answer", w.Code, w.Body.String())
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("client waited %s for the mirror", elapsed)
+			}
+
+			select {
+			case got := <-received:
+				if got.method != tt.method || got.path != "/api/users" || got.body != tt.body {
+					t.Fatalf("shadow received %s %s %q, want %s /api/users %q", got.method, got.path, got.body, tt.method, tt.body)
+				}
+				if got.header.Get("X-Gateway-Mirror") != "true" {
+					t.Fatal("mirror request lacks X-Gateway-Mirror")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("shadow received no copy")
+			}
+
+			var result storage.MirrorResult
+			select {
+			case result = <-results:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no mirror result recorded")
+			}
+			if result.Route != "users" || result.PrimaryStatus != http.StatusOK || result.MirrorStatus != tt.wantStatus {
+				t.Fatalf("result %+v, want route users, primary 200, mirror %d", result, tt.wantStatus)
+			}
+			if (result.Error != "") != tt.wantError {
+				t.Fatalf("result error %q, want error %v", result.Error, tt.wantError)
+			}
+			if !tt.wantError && (result.Diff == nil || *result.Diff != tt.wantDiff) {
+				t.Fatalf("result diff %v, want %v", result.Diff, tt.wantDiff)
+			}
+		})
+	}
+}
+
+func TestMirrorSampling(t *testing.T) {
+	tests := []struct {
+		percentage float64
+		want       int
+	}{
+		{0, 0},
+		{100, 200},
+		{50, 100},
+	}
+
+	for _, tt := range tests {
+		m, err := NewMirror(MirrorConfig{URL: "http://shadow.invalid", Percentage: tt.percentage}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sampled := 0
+		for i := 0; i < 200; i++ {
+			if m.sample() {
+				sampled++
+			}
+		}
+		if tt.percentage == 50 && (sampled < 70 || sampled > 130) || tt.percentage != 50 && sampled != tt.want {
+			t.Errorf("%v%%: sampled %d of 200 requests, want about %d", tt.percentage, sampled, tt.want)
+		}
+	}
+}
+
+func TestNewMirrorRejectsInvalidConfig(t *testing.T) {
+	tests := []MirrorConfig{
+		{URL: "shadow:8080", Percentage: 10},
+		{URL: "", Percentage: 10},
+		{URL: "http://shadow", Percentage: 120},
+		{URL: "http://shadow", Percentage: -1},
+	}
+
+	for _, config := range tests {
+		if _, err := NewMirror(config, nil); err == nil {
+			t.Errorf("NewMirror(%+v) accepted an invalid configuration", config)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+/*
+internal/proxy/outlier.go
+Package proxy provides passive health checking: ejection of outlier backends based on real traffic.
+*/
+
+package proxy
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// OutlierDetectionConfig holds passive health check settings for a route
+type OutlierDetectionConfig struct {
+	Enabled                bool
+	ConsecutiveErrors      int           // 5xx or gateway errors in a row that eject a backend (default 5)
+	Interval               time.Duration // Success rate analysis period (default 10s)
+	BaseEjectionTime       time.Duration // First ejection length, doubled on every repeat (default 30s)
+	MaxEjectionTime        time.Duration // Ejection length cap (default 5m)
+	MaxEjectionPercent     int           // Share of the pool that may be ejected at once (default 50)
+	SuccessRateMinRequests int           // Requests a backend needs in an interval to be analysed (default 20)
+	SuccessRateMinHosts    int           // Analysed backends needed for the success rate check (default 3)
+	SuccessRateStdevFactor float64       // Eject below mean - factor * stdev of the pool's success rates (default 1.9)
+}
+
+// outlierStats is the traffic record of one backend
+type outlierStats struct {
+	consecutive  int // Failures in a row
+	requests     int // In the current interval
+	successes    int
+	ejectedUntil time.Time
+	ejections    int // Drives the ejection length, decays while the backend behaves
+}
+
+// OutlierDetector ejects backends of a pool that fail on real traffic
+type OutlierDetector struct {
+	config OutlierDetectionConfig
+	pool   *BackendPool
+	mu     sync.Mutex
+	stats  map[*Backend]*outlierStats
+}
+
+// NewOutlierDetector creates a detector for pool, filling in defaults for unset values
+func NewOutlierDetector(config OutlierDetectionConfig, pool *BackendPool) *OutlierDetector {
+	if config.ConsecutiveErrors <= 0 {
+		config.ConsecutiveErrors = 5
+	}
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Second
+	}
+	if config.BaseEjectionTime <= 0 {
+		config.BaseEjectionTime = 30 * time.Second
+	}
+	if config.MaxEjectionTime <= 0 {
+		config.MaxEjectionTime = 5 * time.Minute
+	}
+	if config.MaxEjectionPercent <= 0 || config.MaxEjectionPercent > 100 {
+		config.MaxEjectionPercent = 50
+	}
+	if config.SuccessRateMinRequests <= 0 {
+		config.SuccessRateMinRequests = 20
+	}
+	if config.SuccessRateMinHosts <= 0 {
+		config.SuccessRateMinHosts = 3
+	}
+	if config.SuccessRateStdevFactor <= 0 {
+		config.SuccessRateStdevFactor = 1.9
+	}
+
+	return &OutlierDetector{
+		config: config,
+		pool:   pool,
+		stats:  make(map[*Backend]*outlierStats),
+	}
+}
+
+// run analyses success rates every interval until ctx is done
+func (od *OutlierDetector) run(ctx context.Context) {
+	ticker := time.NewTicker(od.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			od.analyse()
+		}
+	}
+}
+
+// statsFor returns the record of a backend (must be called with lock held)
+func (od *OutlierDetector) statsFor(backend *Backend) *outlierStats {
+	s, ok := od.stats[backend]
+	if !ok {
+		s = &outlierStats{}
+		od.stats[backend] = s
+	}
+	return s
+}
+
+// forget drops the record of a backend removed from the pool
+func (od *OutlierDetector) forget(backend *Backend) {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	delete(od.stats, backend)
+}
+
+// record counts the outcome of a proxied request and ejects the backend after
+// too many consecutive failures
+func (od *OutlierDetector) record(backend *Backend, success bool) {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	s := od.statsFor(backend)
+	s.requests++
+	if success {
+		s.successes++
+		s.consecutive = 0
+		return
+	}
+	s.consecutive++
+	if s.consecutive >= od.config.ConsecutiveErrors {
+		od.eject(backend, s, "consecutive errors")
+	}
+}
+
+// analyse ejects backends whose success rate is well below the pool's average,
+// then starts a new interval
+func (od *OutlierDetector) analyse() {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	now := time.Now()
+	var analysed []*Backend
+	var rates []float64
+	for _, backend := range od.pool.GetAllBackends() {
+		s := od.statsFor(backend)
+		if now.Before(s.ejectedUntil) {
+			continue
+		}
+		if !s.ejectedUntil.IsZero() {
+			log.Printf("Backend %s returned after outlier ejection", backend.URL.String())
+			s.ejectedUntil = time.Time{}
+		} else if s.ejections > 0 {
+			// A quiet interval shortens the next ejection
+			s.ejections--
+		}
+		if s.requests >= od.config.SuccessRateMinRequests {
+			analysed = append(analysed, backend)
+			rates = append(rates, float64(s.successes)/float64(s.requests))
+		}
+	}
+
+	if len(analysed) >= od.config.SuccessRateMinHosts {
+		mean, stdev := meanStdev(rates)
+		threshold := mean - od.config.SuccessRateStdevFactor*stdev
+		for i, backend := range analysed {
+			if rates[i] < threshold {
+				od.eject(backend, od.stats[backend], "low success rate")
+			}
+		}
+	}
+
+	for _, s := range od.stats {
+		s.requests = 0
+		s.successes = 0
+	}
+}
+
+// eject takes a backend out of rotation for an exponentially growing period,
+// unless that would exceed the ejection cap or leave the pool without backends
+// (must be called with lock held)
+func (od *OutlierDetector) eject(backend *Backend, s *outlierStats, reason string) {
+	now := time.Now()
+	if now.Before(s.ejectedUntil) {
+		return
+	}
+
+	backends := od.pool.GetAllBackends()
+	ejected, remaining := 0, 0
+	for _, other := range backends {
+		if other == backend {
+			continue
+		}
+		if otherStats, ok := od.stats[other]; ok && now.Before(otherStats.ejectedUntil) {
+			ejected++
+		} else if other.IsHealthy() {
+			remaining++
+		}
+	}
+	if remaining == 0 || (ejected+1)*100 > od.config.MaxEjectionPercent*len(backends) {
+		log.Printf("Not ejecting backend %s (%s): ejection limit reached", backend.URL.String(), reason)
+		s.consecutive = 0
+		return
+	}
+
+	duration := od.config.BaseEjectionTime << min(s.ejections, 16)
+	if duration > od.config.MaxEjectionTime || duration <= 0 {
+		duration = od.config.MaxEjectionTime
+	}
+	s.ejections++
+	s.consecutive = 0
+	s.ejectedUntil = now.Add(duration)
+	backend.ejectedUntil.Store(s.ejectedUntil.UnixNano())
+	log.Printf("Backend %s ejected for %s: %s", backend.URL.String(), duration, reason)
+}
+
+// meanStdev returns the mean and population standard deviation of values
+func meanStdev(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
@@ -0,0 +1,165 @@
+/*
+internal/proxy/outlier_test.go
+Package proxy tests passive health checking and outlier ejection.
+*/
+
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// newOutlierPool creates a pool of n backends watched by an outlier detector
+func newOutlierPool(t *testing.T, n int, config OutlierDetectionConfig) (*OutlierDetector, []*Backend) {
+	t.Helper()
+	weights := make([]int, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	pool, backends := newTestPool(t, weights...)
+	pool.outlier = NewOutlierDetector(config, pool)
+	for _, backend := range backends {
+		backend.outlier = pool.outlier
+	}
+	return pool.outlier, backends
+}
+
+// endEjection lets a backend's current ejection run out
+func endEjection(od *OutlierDetector, backend *Backend) {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	past := time.Now().Add(-time.Millisecond)
+	od.statsFor(backend).ejectedUntil = past
+	backend.ejectedUntil.Store(past.UnixNano())
+}
+
+// ejectionLength returns roughly how long the backend stays ejected from now
+func ejectionLength(backend *Backend) time.Duration {
+	return time.Until(backend.EjectedUntil()).Round(time.Second)
+}
+
+func TestOutlierConsecutiveErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		outcomes    []bool
+		wantEjected bool
+	}{
+		{"below the threshold", []bool{false, false}, false},
+		{"threshold reached", []bool{false, false, false}, true},
+		{"success resets the count", []bool{false, false, true, false, false}, false},
+		{"failures after a success", []bool{false, true, false, false, false}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, backends := newOutlierPool(t, 3, OutlierDetectionConfig{Enabled: true, ConsecutiveErrors: 3})
+			for _, success := range tt.outcomes {
+				backends[0].ReportResult(CircuitTicket{}, success)
+			}
+			if backends[0].Ejected() != tt.wantEjected {
+				t.Fatalf("ejected = %v, want %v", backends[0].Ejected(), tt.wantEjected)
+			}
+			if backends[0].Available() == tt.wantEjected {
+				t.Fatalf("available = %v while ejected = %v", backends[0].Available(), tt.wantEjected)
+			}
+		})
+	}
+}
+
+func TestOutlierEjectionLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		backends   int
+		maxPercent int
+		wantCount  int // Ejected after every backend failed
+	}{
+		{"half of the pool", 4, 50, 2},
+		{"one of three", 3, 34, 1},
+		{"never the whole pool", 2, 100, 1},
+		{"single backend", 1, 100, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, backends := newOutlierPool(t, tt.backends, OutlierDetectionConfig{Enabled: true, ConsecutiveErrors: 1, MaxEjectionPercent: tt.maxPercent})
+			for _, backend := range backends {
+				backend.ReportResult(CircuitTicket{}, false)
+			}
+			ejected := 0
+			for _, backend := range backends {
+				if backend.Ejected() {
+					ejected++
+				}
+			}
+			if ejected != tt.wantCount {
+				t.Fatalf("%d of %d backends ejected, want %d", ejected, tt.backends, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestOutlierEjectionBackoff(t *testing.T) {
+	od, backends := newOutlierPool(t, 3, OutlierDetectionConfig{
+		Enabled:           true,
+		ConsecutiveErrors: 1,
+		BaseEjectionTime:  10 * time.Second,
+		MaxEjectionTime:   30 * time.Second,
+	})
+	backend := backends[0]
+
+	// Each repeat doubles the ejection, up to the cap
+	for _, want := range []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second} {
+		backend.ReportResult(CircuitTicket{}, false)
+		if got := ejectionLength(backend); got != want {
+			t.Fatalf("ejected for %s, want %s", got, want)
+		}
+		endEjection(od, backend)
+		od.analyse() // Returns the backend to rotation
+		if backend.Ejected() {
+			t.Fatal("backend still ejected after its ejection ended")
+		}
+	}
+
+	// Quiet intervals shorten the next ejection again
+	for i := 0; i < 4; i++ {
+		od.analyse()
+	}
+	backend.ReportResult(CircuitTicket{}, false)
+	if got := ejectionLength(backend); got != 10*time.Second {
+		t.Fatalf("after quiet intervals ejected for %s, want 10s", got)
+	}
+}
+
+func TestOutlierSuccessRate(t *testing.T) {
+	tests := []struct {
+		name        string
+		rates       []int // Successes out of 20 requests per backend
+		wantEjected []bool
+	}{
+		{"one bad backend", []int{20, 20, 20, 20, 10}, []bool{false, false, false, false, true}},
+		{"uniform pool", []int{19, 19, 19, 19, 19}, []bool{false, false, false, false, false}},
+		{"too few analysed backends", []int{20, 10, 0, 0, 0}, []bool{false, false, false, false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			od, backends := newOutlierPool(t, len(tt.rates), OutlierDetectionConfig{Enabled: true, MaxEjectionPercent: 100})
+			for i, successes := range tt.rates {
+				if successes == 0 {
+					continue // No traffic, not analysed
+				}
+				// Alternate outcomes so no backend reaches the consecutive error limit
+				for r := 0; r < 20; r++ {
+					backends[i].ReportResult(CircuitTicket{}, r%2 == 0 || r/2 < successes-10)
+				}
+			}
+			od.analyse()
+			for i, backend := range backends {
+				if backend.Ejected() != tt.wantEjected[i] {
+					t.Fatalf("backend %d ejected = %v, want %v", i, backend.Ejected(), tt.wantEjected[i])
+				}
+			}
+		})
+	}
+}
@@ -6,7 +6,9 @@ Package proxy provides reverse proxy functionality for the API Gateway.
 package proxy
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -16,14 +18,22 @@ import (
 	"strings"
 	"time"
 
+	"github.com/AndreaBozzo/go-lab/internal/storage"
+	"github.com/AndreaBozzo/go-lab/internal/tracing"
 	"github.com/gin-gonic/gin"
 )
 
 // ProxyHandler handles reverse proxy requests
 type ProxyHandler struct {
-	balancer LoadBalancer
-	timeout  time.Duration
-	client   *http.Client
+	balancer  LoadBalancer
+	timeout   time.Duration
+	client    *http.Client
+	sticky    *StickySessions // nil when sticky sessions are disabled
+	retry     *RetryPolicy    // nil when retries are disabled
+	websocket WebSocketConfig
+	tunnels   *tunnelSet
+	rewriter  *PathRewriter // nil when the path is forwarded unchanged
+	mirror    *Mirror       // nil when traffic is not mirrored
 }
 
 // NewProxyHandler creates a new proxy handler
@@ -54,54 +64,212 @@ func NewProxyHandler(balancer LoadBalancer, timeout time.Duration) *ProxyHandler
 		balancer: balancer,
 		timeout:  timeout,
 		client:   client,
+		tunnels:  &tunnelSet{},
 	}
 }
 
-// Handle proxies the request to a backend server
+// errCreateRequest marks failures building the upstream request, which are never retried
+var errCreateRequest = errors.New("failed to create proxy request")
+
+// Handle proxies the request to a backend server, retrying on another backend when allowed
 func (ph *ProxyHandler) Handle(c *gin.Context) {
-	// Select backend using load balancer
-	backend, err := ph.balancer.NextBackend()
-	if err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"error": "No backend servers available",
-		})
+	storeClientIP(c)
+
+	// WebSocket and other upgrades are tunneled, never retried
+	if isUpgradeRequest(c.Request) {
+		ph.handleUpgrade(c)
+		return
+	}
+
+	// Buffer the body so it can be replayed on retries and copied to the mirror
+	mirrored := ph.mirror != nil && ph.mirror.sample()
+	var body []byte
+	var stream io.Reader = c.Request.Body
+	replayable := false
+	if ph.retry != nil || mirrored {
+		var err error
+		body, stream, replayable, err = bufferBody(c.Request, ph.bodyLimit(mirrored))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Failed to read request body",
+			})
+			return
+		}
+	}
+
+	canRetry := false
+	if ph.retry != nil {
+		canRetry = replayable && int64(len(body)) <= ph.retry.config.MaxBodyBytes && ph.retry.allowsMethod(c.Request.Method)
+		ph.retry.budget.recordRequest()
+	}
+
+	// The mirror copy is sent once the client has its response
+	var responseTee io.Writer
+	if mirrored && replayable && int64(len(body)) <= ph.mirror.config.MaxBodyBytes {
+		capture := ph.mirror.capture(body)
+		defer ph.mirror.dispatch(ph, c, capture)
+		if capture.digest != nil {
+			responseTee = capture.digest
+		}
+	}
+
+	tried := make(map[*Backend]bool)
+	for attempt := 0; ; attempt++ {
+		// Select backend using load balancer
+		backend, ticket, err := ph.selectBackend(c, tried)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "No backend servers available",
+			})
+			return
+		}
+		tried[backend] = true
+
+		// Store backend info in context for logging middleware
+		c.Set("backend", backend.GetURL().String())
+
+		if body != nil {
+			stream = bytes.NewReader(body)
+		}
+		resp, finish, err := ph.attempt(c, backend, ticket, stream, attempt)
+
+		retry := canRetry && attempt < ph.retry.config.Attempts
+		if err != nil {
+			if errors.Is(err, errCreateRequest) {
+				log.Printf("Failed to create proxy request: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to create proxy request",
+				})
+				return
+			}
+			if retry && c.Request.Context().Err() == nil && ph.retry.retryableError(err) && ph.retry.budget.withdraw() {
+				log.Printf("Retrying %s %s after backend %s failed: %v", c.Request.Method, c.Request.URL.Path, backend.GetURL().String(), err)
+				if waitBackoff(c.Request.Context(), ph.retry.backoff(attempt+1)) == nil {
+					continue
+				}
+			}
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Backend request failed",
+			})
+			return
+		}
+
+		if retry && ph.retry.retryableStatus(resp.StatusCode) && ph.retry.budget.withdraw() {
+			log.Printf("Retrying %s %s after backend %s returned %d", c.Request.Method, c.Request.URL.Path, backend.GetURL().String(), resp.StatusCode)
+			// Drain a little so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			finish()
+			if waitBackoff(c.Request.Context(), ph.retry.backoff(attempt+1)) == nil {
+				continue
+			}
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Backend request failed",
+			})
+			return
+		}
+
+		ph.writeResponse(c, backend, resp, responseTee)
+		finish()
 		return
 	}
+}
+
+// bodyLimit returns how much of a request body may be buffered
+func (ph *ProxyHandler) bodyLimit(mirrored bool) int64 {
+	var limit int64
+	if ph.retry != nil {
+		limit = ph.retry.config.MaxBodyBytes
+	}
+	if mirrored && ph.mirror.config.MaxBodyBytes > limit {
+		limit = ph.mirror.config.MaxBodyBytes
+	}
+	return limit
+}
 
-	// Store backend info in context for logging middleware
-	c.Set("backend", backend.GetURL().String())
+// attempt sends one try to backend, admitted by its circuit breaker with ticket.
+// On success the caller must call finish once it is done with the response; on
+// error everything has already been released.
+func (ph *ProxyHandler) attempt(c *gin.Context, backend *Backend, ticket CircuitTicket, body io.Reader, resendCount int) (*http.Response, func(), error) {
+	// Track the request for load balancers that look at in-flight counts
+	backend.beginRequest()
+	startTime := time.Now()
 
 	// Build target URL
 	targetURL := ph.buildTargetURL(backend.GetURL(), c.Request.URL)
 
 	// Create proxy request
-	proxyReq, err := ph.createProxyRequest(c.Request, targetURL)
+	proxyReq, err := ph.createProxyRequest(c.Request, targetURL, body)
 	if err != nil {
-		log.Printf("Failed to create proxy request: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create proxy request",
-		})
-		return
+		backend.CancelRequest(ticket)
+		backend.endRequest()
+		return nil, nil, fmt.Errorf("%w: %v", errCreateRequest, err)
 	}
 
 	// Add forwarding headers
 	ph.setForwardingHeaders(proxyReq, c.Request)
 
 	// Execute request with timeout
-	ctx, cancel := context.WithTimeout(c.Request.Context(), ph.timeout)
-	defer cancel()
+	timeout := ph.timeout
+	if ph.retry != nil && ph.retry.config.PerTryTimeout > 0 {
+		timeout = ph.retry.config.PerTryTimeout
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+
+	// Start a client span as a child of the server span and propagate it upstream
+	ctx, span := tracing.StartSpan(ctx, "proxy "+c.Request.Method, tracing.SpanKindClient)
+	if span != nil {
+		tracing.Inject(proxyReq.Header, span.SpanContext())
+		span.SetAttribute("http.request.method", proxyReq.Method)
+		span.SetAttribute("url.full", targetURL)
+		span.SetAttribute("server.address", proxyReq.URL.Host)
+		if resendCount > 0 {
+			span.SetAttribute("http.request.resend_count", resendCount)
+		}
+	}
 	proxyReq = proxyReq.WithContext(ctx)
 
 	// Perform the request
 	resp, err := ph.client.Do(proxyReq)
 	if err != nil {
+		span.SetStatus(tracing.StatusError, err.Error())
+		if c.Request.Context().Err() != nil {
+			// Client went away, say nothing about the backend
+			backend.CancelRequest(ticket)
+		} else {
+			backend.ReportResult(ticket, false)
+		}
 		log.Printf("Proxy request failed for backend %s: %v", backend.GetURL().String(), err)
-		c.JSON(http.StatusBadGateway, gin.H{
-			"error": "Backend request failed",
-		})
-		return
+		span.End()
+		cancel()
+		backend.endRequest()
+		return nil, nil, err
+	}
+
+	// Time to response headers feeds the latency-aware balancers
+	backend.observeLatency(time.Since(startTime))
+
+	// 5xx responses count as failures for the circuit breaker
+	backend.ReportResult(ticket, resp.StatusCode < http.StatusInternalServerError)
+
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(tracing.StatusError, resp.Status)
+	}
+
+	finish := func() {
+		resp.Body.Close()
+		span.End()
+		cancel()
+		backend.endRequest()
+	}
+	return resp, finish, nil
+}
+
+// writeResponse copies the backend response to the client, and to tee if not nil
+func (ph *ProxyHandler) writeResponse(c *gin.Context, backend *Backend, resp *http.Response, tee io.Writer) {
+	if ph.sticky != nil {
+		ph.sticky.pin(c.Writer, c.Request, backend)
 	}
-	defer resp.Body.Close()
 
 	// Copy response headers
 	for key, values := range resp.Header {
@@ -114,27 +282,93 @@ func (ph *ProxyHandler) Handle(c *gin.Context) {
 	c.Status(resp.StatusCode)
 
 	// Stream response body
-	_, err = io.Copy(c.Writer, resp.Body)
+	var dst io.Writer = c.Writer
+	if tee != nil {
+		dst = io.MultiWriter(c.Writer, tee)
+	}
+	_, err := io.Copy(dst, resp.Body)
 	if err != nil {
 		log.Printf("Failed to copy response body: %v", err)
 	}
 }
 
-// buildTargetURL constructs the target backend URL
+// selectBackend picks a backend and reserves a request slot on its circuit breaker.
+// Retries prefer a backend that has not been tried yet.
+func (ph *ProxyHandler) selectBackend(c *gin.Context, tried map[*Backend]bool) (*Backend, CircuitTicket, error) {
+	for i := 0; i < retryPickAttempts; i++ {
+		backend, err := ph.pickBackend(c, tried)
+		if err != nil {
+			return nil, CircuitTicket{}, err
+		}
+		if ticket, ok := backend.acquire(); ok {
+			return backend, ticket, nil
+		}
+		// A concurrent request took the last half-open probe slot; pick again
+		// without request affinity, which would return the same backend
+		skip := make(map[*Backend]bool, len(tried)+1)
+		for b := range tried {
+			skip[b] = true
+		}
+		skip[backend] = true
+		tried = skip
+	}
+	return nil, CircuitTicket{}, fmt.Errorf("no healthy backends available")
+}
+
+// pickBackend honours a sticky session cookie, then asks the load balancer.
+// Retries prefer a backend that has not been tried yet.
+func (ph *ProxyHandler) pickBackend(c *gin.Context, tried map[*Backend]bool) (*Backend, error) {
+	if len(tried) == 0 {
+		if ph.sticky != nil {
+			if backend := ph.sticky.lookup(c.Request); backend != nil {
+				return backend, nil
+			}
+		}
+		if rb, ok := ph.balancer.(RequestBalancer); ok {
+			return rb.NextBackendForRequest(c.Request)
+		}
+		return ph.balancer.NextBackend()
+	}
+
+	// Request-aware balancers would return the same backend again, so retries
+	// always use the plain selection
+	var picked *Backend
+	for i := 0; i < retryPickAttempts; i++ {
+		backend, err := ph.balancer.NextBackend()
+		if err != nil {
+			if picked == nil {
+				return nil, err
+			}
+			break
+		}
+		picked = backend
+		if !tried[backend] {
+			break
+		}
+	}
+	return picked, nil
+}
+
+// buildTargetURL constructs the target backend URL, applying the route's path
+// rewrite and the backend's own base path
 func (ph *ProxyHandler) buildTargetURL(backendURL *url.URL, requestURL *url.URL) string {
-	target := *backendURL
-	target.Path = requestURL.Path
-	target.RawQuery = requestURL.RawQuery
-	return target.String()
+	path := requestURL.Path
+	if ph.rewriter != nil {
+		path = ph.rewriter.Rewrite(path)
+	}
+	return upstreamURL(backendURL, path, requestURL.RawQuery)
 }
 
 // createProxyRequest creates a new HTTP request for the backend
-func (ph *ProxyHandler) createProxyRequest(original *http.Request, targetURL string) (*http.Request, error) {
+func (ph *ProxyHandler) createProxyRequest(original *http.Request, targetURL string, body io.Reader) (*http.Request, error) {
 	// Create new request with same method and body
-	req, err := http.NewRequest(original.Method, targetURL, original.Body)
+	req, err := http.NewRequest(original.Method, targetURL, body)
 	if err != nil {
 		return nil, err
 	}
+	if _, buffered := body.(*bytes.Reader); !buffered {
+		req.ContentLength = original.ContentLength
+	}
 
 	// Copy headers
 	for key, values := range original.Header {
@@ -152,15 +386,17 @@ func (ph *ProxyHandler) createProxyRequest(original *http.Request, targetURL str
 
 // setForwardingHeaders sets X-Forwarded-* headers
 func (ph *ProxyHandler) setForwardingHeaders(proxyReq *http.Request, originalReq *http.Request) {
-	// X-Forwarded-For
-	clientIP := getClientIP(originalReq)
-	if prior, ok := proxyReq.Header["X-Forwarded-For"]; ok {
-		clientIP = strings.Join(prior, ", ") + ", " + clientIP
+	// X-Forwarded-For: the chain so far is only kept when it came from a trusted
+	// proxy (then the client IP was taken from it), the connection's address is appended
+	clientIP, peer := getClientIP(originalReq), remoteIP(originalReq)
+	forwarded := peer
+	if prior, ok := proxyReq.Header["X-Forwarded-For"]; ok && clientIP != peer {
+		forwarded = strings.Join(prior, ", ") + ", " + peer
 	}
-	proxyReq.Header.Set("X-Forwarded-For", clientIP)
+	proxyReq.Header.Set("X-Forwarded-For", forwarded)
 
 	// X-Real-IP
-	proxyReq.Header.Set("X-Real-IP", getClientIP(originalReq))
+	proxyReq.Header.Set("X-Real-IP", clientIP)
 
 	// X-Forwarded-Proto
 	proto := "http"
@@ -171,22 +407,37 @@ func (ph *ProxyHandler) setForwardingHeaders(proxyReq *http.Request, originalReq
 
 	// X-Forwarded-Host
 	proxyReq.Header.Set("X-Forwarded-Host", originalReq.Host)
-}
 
-// getClientIP extracts the client IP from the request
-func getClientIP(req *http.Request) string {
-	// Check X-Forwarded-For header first
-	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
+	// X-Forwarded-Prefix lets backends build links when a prefix was stripped
+	if ph.rewriter != nil {
+		if prefix := ph.rewriter.StrippedPrefix(originalReq.URL.Path); prefix != "" {
+			proxyReq.Header.Set("X-Forwarded-Prefix", prefix)
+		}
 	}
+}
+
+// clientIPKey is the request context key holding the client IP resolved by gin
+type clientIPKey struct{}
 
-	// Check X-Real-IP header
-	if xri := req.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+// storeClientIP puts the client IP as gin resolves it on the request, so code that
+// only sees the request uses the same IP as logging and rate limiting. gin only
+// believes X-Forwarded-For from the router's trusted proxies.
+func storeClientIP(c *gin.Context) {
+	ctx := context.WithValue(c.Request.Context(), clientIPKey{}, c.ClientIP())
+	c.Request = c.Request.WithContext(ctx)
+}
+
+// getClientIP returns the client IP stored by storeClientIP, or the connection's
+// address. Headers are never read here: any client can set them.
+func getClientIP(req *http.Request) string {
+	if ip, ok := req.Context().Value(clientIPKey{}).(string); ok && ip != "" {
+		return ip
 	}
+	return remoteIP(req)
+}
 
-	// Fall back to RemoteAddr
+// remoteIP returns the address of the connection the request arrived on
+func remoteIP(req *http.Request) string {
 	host, _, err := net.SplitHostPort(req.RemoteAddr)
 	if err != nil {
 		return req.RemoteAddr
@@ -212,62 +463,240 @@ func isHopByHopHeader(header string) bool {
 
 // RouteProxy represents a proxy handler for a specific route
 type RouteProxy struct {
-	pool    *BackendPool
-	handler *ProxyHandler
+	groups   []*BackendGroup
+	split    *TrafficSplit // nil when the route has a single group
+	override GroupOverrideConfig
+	tunnels  *tunnelSet // Shared by all groups so connection limits apply per route
+}
+
+// RouteOptions holds optional per-route proxy settings
+type RouteOptions struct {
+	LoadBalancer     string // Strategy name, see NewLoadBalancer
+	HashKey          HashKeyConfig
+	StickySession    StickySessionConfig
+	CircuitBreaker   CircuitBreakerConfig
+	Retry            RetryConfig
+	WebSocket        WebSocketConfig
+	Rewrite          RewriteConfig
+	GroupOverride    GroupOverrideConfig
+	HealthCheck      HealthCheckConfig
+	OutlierDetection OutlierDetectionConfig
+	SlowStart        SlowStartConfig
+	Discovery        DiscoveryConfig
+	Mirror           MirrorConfig
+	MirrorStore      storage.MirrorStore // Where mirror results are recorded (optional)
+}
+
+// NewRouteProxy creates a new route proxy with one backend pool per group
+func NewRouteProxy(groups []BackendGroupConfig, timeout time.Duration, opts RouteOptions) (*RouteProxy, error) {
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("at least one backend group is required")
+	}
+
+	rp := &RouteProxy{
+		override: opts.GroupOverride,
+		tunnels:  &tunnelSet{},
+	}
+	for _, groupConfig := range groups {
+		group, err := newBackendGroup(groupConfig, timeout, opts)
+		if err != nil {
+			return nil, err
+		}
+		group.handler.tunnels = rp.tunnels
+		rp.groups = append(rp.groups, group)
+	}
+
+	// One mirror per route, shared by all groups
+	if opts.Mirror.URL != "" {
+		if opts.Mirror.Timeout <= 0 {
+			opts.Mirror.Timeout = timeout
+		}
+		mirror, err := NewMirror(opts.Mirror, opts.MirrorStore)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range rp.groups {
+			group.handler.mirror = mirror
+		}
+	}
+
+	if len(groups) > 1 {
+		split, err := NewTrafficSplit(groups)
+		if err != nil {
+			return nil, err
+		}
+		rp.split = split
+	}
+
+	return rp, nil
 }
 
-// NewRouteProxy creates a new route proxy with its own backend pool
-func NewRouteProxy(backendURLs []string, weights []int, timeout time.Duration) (*RouteProxy, error) {
-	if len(backendURLs) == 0 {
-		return nil, fmt.Errorf("at least one backend URL is required")
+// newBackendGroup creates the pool, load balancer and proxy handler of one group
+func newBackendGroup(config BackendGroupConfig, timeout time.Duration, opts RouteOptions) (*BackendGroup, error) {
+	if len(config.URLs) == 0 {
+		return nil, fmt.Errorf("group %q: at least one backend URL is required", config.Name)
+	}
+
+	discovery, err := opts.Discovery.withDefaults()
+	if err != nil {
+		return nil, err
 	}
 
-	// Create backends
+	// Create backends; discovery entries are resolved when the pool starts
 	var backends []*Backend
-	for i, urlStr := range backendURLs {
+	var sources []*discoverySource
+	for i, urlStr := range config.URLs {
 		weight := 1
-		if i < len(weights) {
-			weight = weights[i]
+		if i < len(config.Weights) {
+			weight = config.Weights[i]
+		}
+		disabled := i < len(config.Disabled) && config.Disabled[i]
+
+		if i < len(config.Discovery) && config.Discovery[i].Type != "" {
+			source, err := newProviderSource(config.Discovery[i], weight, discovery)
+			if err != nil {
+				return nil, err
+			}
+			source.disabled = disabled
+			sources = append(sources, source)
+			continue
+		}
+		if IsDiscoveryURL(urlStr) {
+			source, err := newDiscoverySource(urlStr, weight, discovery)
+			if err != nil {
+				return nil, err
+			}
+			source.disabled = disabled
+			sources = append(sources, source)
+			continue
 		}
 
-		backend, err := NewBackend(urlStr, weight)
+		backend, err := newRouteBackend(urlStr, weight, opts)
 		if err != nil {
 			return nil, err
 		}
+		backend.disabled.Store(disabled)
 		backends = append(backends, backend)
 	}
 
 	// Create backend pool
-	pool := NewBackendPool(backends, 10*time.Second)
+	checker, err := NewHealthChecker(opts.HealthCheck)
+	if err != nil {
+		return nil, err
+	}
+	pool := NewBackendPool(backends, checker)
+	if opts.OutlierDetection.Enabled {
+		pool.outlier = NewOutlierDetector(opts.OutlierDetection, pool)
+		for _, backend := range backends {
+			backend.outlier = pool.outlier
+		}
+	}
+	pool.sources = sources
+	pool.discovery = discovery
+	pool.newBackend = func(url string, weight int) (*Backend, error) {
+		return newRouteBackend(url, weight, opts)
+	}
 
 	// Create load balancer
-	balancer := NewRoundRobinBalancer(pool)
+	balancer, err := NewLoadBalancer(opts.LoadBalancer, pool, opts.HashKey)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create proxy handler
 	handler := NewProxyHandler(balancer, timeout)
+	if opts.StickySession.Enabled {
+		handler.sticky = NewStickySessions(pool, opts.StickySession)
+	}
+	if opts.Retry.Attempts > 0 {
+		handler.retry = NewRetryPolicy(opts.Retry)
+	}
+	if opts.Rewrite != (RewriteConfig{}) {
+		rewriter, err := NewPathRewriter(opts.Rewrite)
+		if err != nil {
+			return nil, err
+		}
+		handler.rewriter = rewriter
+	}
+	handler.websocket = opts.WebSocket
+	if handler.websocket.IdleTimeout == 0 {
+		handler.websocket.IdleTimeout = 5 * time.Minute
+	}
 
-	return &RouteProxy{
+	return &BackendGroup{
+		name:    config.Name,
 		pool:    pool,
 		handler: handler,
+		opts:    opts,
 	}, nil
 }
 
+// newRouteBackend creates a backend with the route's per-backend settings
+func newRouteBackend(urlStr string, weight int, opts RouteOptions) (*Backend, error) {
+	backend, err := NewBackend(urlStr, weight)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CircuitBreaker.Enabled {
+		backend.breaker = NewCircuitBreaker(opts.CircuitBreaker)
+	}
+	backend.slowStart = opts.SlowStart
+	if backend.slowStart.MinWeightPercent <= 0 {
+		backend.slowStart.MinWeightPercent = 10
+	}
+	return backend, nil
+}
+
 // Start starts health checking for this route's backends
 func (rp *RouteProxy) Start() {
-	rp.pool.Start()
+	for _, group := range rp.groups {
+		group.pool.Start()
+	}
 }
 
 // Stop stops health checking
 func (rp *RouteProxy) Stop() {
-	rp.pool.Stop()
+	for _, group := range rp.groups {
+		group.pool.Stop()
+	}
+	rp.tunnels.closeAll(tunnelShutdown)
 }
 
 // Handler returns the Gin handler function
 func (rp *RouteProxy) Handler() gin.HandlerFunc {
-	return rp.handler.Handle
+	return func(c *gin.Context) {
+		group := rp.selectGroup(c.Request)
+		if rp.split != nil {
+			// Store the group in context for logging middleware
+			c.Set("backend_group", group.name)
+		}
+		group.handler.Handle(c)
+	}
+}
+
+// FindBackend returns the backend with the given URL and the group it belongs to
+func (rp *RouteProxy) FindBackend(rawURL string) (*BackendGroup, *Backend) {
+	for _, group := range rp.groups {
+		for _, backend := range group.pool.GetAllBackends() {
+			if backend.GetURL().String() == rawURL {
+				return group, backend
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Groups returns the backend groups of this route (for admin/metrics)
+func (rp *RouteProxy) Groups() []*BackendGroup {
+	return rp.groups
+}
+
+// Split returns the route's traffic split, or nil when it has a single group
+func (rp *RouteProxy) Split() *TrafficSplit {
+	return rp.split
 }
 
-// GetPool returns the backend pool for this route (for admin/testing)
-func (rp *RouteProxy) GetPool() *BackendPool {
-	return rp.pool
+// UpgradedConnections returns the number of open WebSocket/upgrade tunnels
+func (rp *RouteProxy) UpgradedConnections() int {
+	return rp.tunnels.count()
 }
@@ -0,0 +1,66 @@
+/*
+internal/proxy/proxy_test.go
+Package proxy tests the headers the proxy handler forwards to backends.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestForwardingHeaders(t *testing.T) {
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  string
+		wantForwarded string
+		wantRealIP    string
+	}{
+		{"direct client", "192.0.2.1:1234", "", "192.0.2.1", "192.0.2.1"},
+		{"client spoofing the header", "192.0.2.1:1234", "203.0.113.9", "192.0.2.1", "192.0.2.1"},
+		{"trusted proxy", "10.0.0.5:1234", "203.0.113.9", "203.0.113.9, 10.0.0.5", "203.0.113.9"},
+		{"trusted proxy without the header", "10.0.0.5:1234", "", "10.0.0.5", "10.0.0.5"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			if err := router.SetTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+				t.Fatal(err)
+			}
+			ph := &ProxyHandler{}
+			var proxyReq *http.Request
+			var hashKey string
+			router.GET("/", func(c *gin.Context) {
+				storeClientIP(c)
+				proxyReq = httptest.NewRequest(http.MethodGet, "http://backend/", nil)
+				proxyReq.Header = c.Request.Header.Clone()
+				ph.setForwardingHeaders(proxyReq, c.Request)
+				hashKey = HashKeyConfig{}.key(c.Request)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got := proxyReq.Header.Get("X-Forwarded-For"); got != tt.wantForwarded {
+				t.Errorf("X-Forwarded-For = %q, want %q", got, tt.wantForwarded)
+			}
+			if got := proxyReq.Header.Get("X-Real-IP"); got != tt.wantRealIP {
+				t.Errorf("X-Real-IP = %q, want %q", got, tt.wantRealIP)
+			}
+			if hashKey != tt.wantRealIP {
+				t.Errorf("hash key = %q, want the client IP %q", hashKey, tt.wantRealIP)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+/*
+internal/proxy/retry.go
+Package proxy provides per-route retries with exponential backoff and a retry budget.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Retry conditions for transport errors
+const (
+	RetryOnConnectFailure = "connect_failure" // Backend could not be dialled, nothing was sent
+	RetryOnTimeout        = "timeout"         // The attempt timed out (see PerTryTimeout)
+	RetryOnReset          = "reset"           // Any other transport error (connection reset, EOF, ...)
+)
+
+// Retry budget defaults, applied by the configuration when the settings are omitted
+const (
+	DefaultRetryBudgetPercent  = 20
+	DefaultMinRetriesPerSecond = 3
+)
+
+// retryBudgetBuckets is the number of one-second buckets in the budget window
+const retryBudgetBuckets = 10
+
+// retryPickAttempts bounds how often the balancer is asked for an untried backend
+const retryPickAttempts = 4
+
+// RetryConfig holds per-route retry settings
+type RetryConfig struct {
+	Attempts            int           // Retries after the first try (0 disables retries)
+	RetryOn             []string      // Transport error conditions to retry (default connect_failure, timeout)
+	Statuses            []int         // Response status codes to retry
+	Backoff             time.Duration // Base backoff, doubled per retry
+	MaxBackoff          time.Duration // Backoff cap
+	PerTryTimeout       time.Duration // Timeout for each attempt (default: the route timeout)
+	BudgetPercent       float64       // Retries allowed as a percentage of requests over the last 10s (0 = none)
+	MinRetriesPerSecond int           // Retries always allowed regardless of traffic (0 = none)
+	RetryNonIdempotent  bool          // Also retry POST, PATCH, ...
+	MaxBodyBytes        int64         // Bodies larger than this are streamed and never retried
+}
+
+// Validate checks the retry configuration
+func (rc RetryConfig) Validate() error {
+	if rc.Attempts < 0 {
+		return fmt.Errorf("retry attempts must not be negative")
+	}
+	for _, condition := range rc.RetryOn {
+		switch condition {
+		case RetryOnConnectFailure, RetryOnTimeout, RetryOnReset:
+		default:
+			return fmt.Errorf("unknown retry condition %q", condition)
+		}
+	}
+	for _, status := range rc.Statuses {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid retry status %d", status)
+		}
+	}
+	if rc.BudgetPercent < 0 {
+		return fmt.Errorf("retry budget_percent must not be negative")
+	}
+	if rc.MinRetriesPerSecond < 0 {
+		return fmt.Errorf("retry min_retries_per_second must not be negative")
+	}
+	return nil
+}
+
+// RetryPolicy decides whether a failed attempt is retried
+type RetryPolicy struct {
+	config   RetryConfig
+	retryOn  map[string]bool
+	statuses map[int]bool
+	budget   *retryBudget
+}
+
+// NewRetryPolicy creates a retry policy, filling in defaults. The budget is used
+// as given, so a zero budget caps retries at zero.
+func NewRetryPolicy(config RetryConfig) *RetryPolicy {
+	if len(config.RetryOn) == 0 {
+		config.RetryOn = []string{RetryOnConnectFailure, RetryOnTimeout}
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = 25 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 250 * time.Millisecond
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = 64 << 10
+	}
+
+	rp := &RetryPolicy{
+		config:   config,
+		retryOn:  make(map[string]bool),
+		statuses: make(map[int]bool),
+		budget:   newRetryBudget(config.BudgetPercent/100, config.MinRetriesPerSecond),
+	}
+	for _, condition := range config.RetryOn {
+		rp.retryOn[condition] = true
+	}
+	for _, status := range config.Statuses {
+		rp.statuses[status] = true
+	}
+	return rp
+}
+
+// allowsMethod reports whether requests with this method may be retried
+func (rp *RetryPolicy) allowsMethod(method string) bool {
+	if rp.config.RetryNonIdempotent {
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryableError reports whether a transport error matches a configured condition
+func (rp *RetryPolicy) retryableError(err error) bool {
+	return rp.retryOn[classifyError(err)]
+}
+
+// retryableStatus reports whether a response status matches a configured code
+func (rp *RetryPolicy) retryableStatus(status int) bool {
+	return rp.statuses[status]
+}
+
+// backoff returns the delay before the given retry (1-based), using full jitter
+func (rp *RetryPolicy) backoff(retry int) time.Duration {
+	delay := rp.config.Backoff << (retry - 1)
+	if delay <= 0 || delay > rp.config.MaxBackoff {
+		delay = rp.config.MaxBackoff
+	}
+	return time.Duration(rand.Int64N(int64(delay) + 1))
+}
+
+// bufferBody reads the request body so it can be replayed. If the body exceeds
+// limit the returned reader streams it instead and replayable is false.
+func bufferBody(req *http.Request, limit int64) (body []byte, stream io.Reader, replayable bool, err error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil, true, nil
+	}
+	if req.ContentLength > limit {
+		return nil, req.Body, false, nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if int64(len(buf)) > limit {
+		return nil, io.MultiReader(bytes.NewReader(buf), req.Body), false, nil
+	}
+	return buf, nil, true, nil
+}
+
+// classifyError maps a transport error to a retry condition
+func classifyError(err error) string {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return RetryOnConnectFailure
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return RetryOnTimeout
+	}
+	return RetryOnReset
+}
+
+// waitBackoff sleeps for d unless ctx is cancelled first
+func waitBackoff(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryBudget caps retries to a fraction of recent requests so that retries
+// cannot multiply load on a backend that is already struggling
+type retryBudget struct {
+	ratio      float64
+	minPerSec  int
+	mu         sync.Mutex
+	requests   [retryBudgetBuckets]int
+	retries    [retryBudgetBuckets]int
+	bucketTime [retryBudgetBuckets]int64 // Unix second each bucket belongs to
+}
+
+// newRetryBudget creates a budget allowing ratio retries per request plus minPerSec per second
+func newRetryBudget(ratio float64, minPerSec int) *retryBudget {
+	return &retryBudget{
+		ratio:     ratio,
+		minPerSec: minPerSec,
+	}
+}
+
+// recordRequest counts an original (non-retry) request
+func (rb *retryBudget) recordRequest() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.requests[rb.bucketLocked(time.Now().Unix())]++
+}
+
+// withdraw reserves one retry, returning false when the budget is exhausted
+func (rb *retryBudget) withdraw() bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	now := time.Now().Unix()
+	current := rb.bucketLocked(now)
+
+	requests, retries := 0, 0
+	for i := range rb.bucketTime {
+		if now-rb.bucketTime[i] < retryBudgetBuckets {
+			requests += rb.requests[i]
+			retries += rb.retries[i]
+		}
+	}
+
+	allowed := int(float64(requests)*rb.ratio) + rb.minPerSec*retryBudgetBuckets
+	if retries >= allowed {
+		return false
+	}
+	rb.retries[current]++
+	return true
+}
+
+// bucketLocked returns the bucket for the given second, clearing it if stale
+func (rb *retryBudget) bucketLocked(now int64) int {
+	i := int(now % retryBudgetBuckets)
+	if rb.bucketTime[i] != now {
+		rb.bucketTime[i] = now
+		rb.requests[i] = 0
+		rb.retries[i] = 0
+	}
+	return i
+}
@@ -0,0 +1,220 @@
+/*
+internal/proxy/retry_test.go
+Package proxy tests the retry policy and retry budget.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// timeoutError is a net.Error reporting a timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		percent   float64
+		minPerSec int
+		requests  int
+		want      int
+	}{
+		{"zero budget allows nothing", 0, 0, 100, 0},
+		{"percent of requests", 20, 0, 100, 20},
+		{"percent rounds down", 10, 0, 5, 0},
+		{"minimum over the window", 0, 3, 0, 3 * retryBudgetBuckets},
+		{"percent plus minimum", 20, 3, 100, 20 + 3*retryBudgetBuckets},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := NewRetryPolicy(RetryConfig{Attempts: 1, BudgetPercent: tt.percent, MinRetriesPerSecond: tt.minPerSec})
+			for i := 0; i < tt.requests; i++ {
+				rp.budget.recordRequest()
+			}
+			allowed := 0
+			for rp.budget.withdraw() {
+				allowed++
+				if allowed > tt.want {
+					break
+				}
+			}
+			if allowed != tt.want {
+				t.Fatalf("budget allowed %d retries, want %d", allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetWindowExpires(t *testing.T) {
+	rb := newRetryBudget(0.5, 0)
+	for i := 0; i < 4; i++ {
+		rb.recordRequest()
+	}
+	if !rb.withdraw() || !rb.withdraw() || rb.withdraw() {
+		t.Fatal("expected exactly two retries for four requests at 50%")
+	}
+
+	// Move every bucket out of the window: old requests and retries stop counting
+	rb.mu.Lock()
+	for i := range rb.bucketTime {
+		rb.bucketTime[i] -= retryBudgetBuckets
+	}
+	rb.mu.Unlock()
+	if rb.withdraw() {
+		t.Fatal("requests outside the window still fund retries")
+	}
+	rb.recordRequest()
+	rb.recordRequest()
+	if !rb.withdraw() {
+		t.Fatal("retries outside the window still count against the budget")
+	}
+}
+
+func TestRetryConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  RetryConfig
+		wantErr bool
+	}{
+		{"zero value", RetryConfig{}, false},
+		{"full", RetryConfig{Attempts: 2, RetryOn: []string{RetryOnConnectFailure, RetryOnTimeout, RetryOnReset}, Statuses: []int{502, 503}, BudgetPercent: 20, MinRetriesPerSecond: 3}, false},
+		{"negative attempts", RetryConfig{Attempts: -1}, true},
+		{"unknown condition", RetryConfig{RetryOn: []string{"5xx"}}, true},
+		{"invalid status", RetryConfig{Statuses: []int{600}}, true},
+		{"negative budget", RetryConfig{BudgetPercent: -1}, true},
+		{"negative minimum", RetryConfig{MinRetriesPerSecond: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyMethods(t *testing.T) {
+	tests := []struct {
+		method        string
+		idempotent    bool
+		nonIdempotent bool
+	}{
+		{http.MethodGet, true, true},
+		{http.MethodHead, true, true},
+		{http.MethodOptions, true, true},
+		{http.MethodPut, true, true},
+		{http.MethodDelete, true, true},
+		{http.MethodPost, false, true},
+		{http.MethodPatch, false, true},
+	}
+
+	strict := NewRetryPolicy(RetryConfig{Attempts: 1})
+	lenient := NewRetryPolicy(RetryConfig{Attempts: 1, RetryNonIdempotent: true})
+	for _, tt := range tests {
+		if got := strict.allowsMethod(tt.method); got != tt.idempotent {
+			t.Errorf("allowsMethod(%s) = %v, want %v", tt.method, got, tt.idempotent)
+		}
+		if got := lenient.allowsMethod(tt.method); got != tt.nonIdempotent {
+			t.Errorf("allowsMethod(%s) with retry_non_idempotent = %v, want %v", tt.method, got, tt.nonIdempotent)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"dial refused", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, RetryOnConnectFailure},
+		{"wrapped dial", fmt.Errorf("proxy: %w", &net.OpError{Op: "dial", Err: timeoutError{}}), RetryOnConnectFailure},
+		{"deadline", context.DeadlineExceeded, RetryOnTimeout},
+		{"read timeout", &net.OpError{Op: "read", Err: timeoutError{}}, RetryOnTimeout},
+		{"reset", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, RetryOnReset},
+		{"eof", io.ErrUnexpectedEOF, RetryOnReset},
+	}
+
+	defaults := NewRetryPolicy(RetryConfig{Attempts: 1})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyError(tt.err)
+			if got != tt.want {
+				t.Fatalf("classifyError() = %s, want %s", got, tt.want)
+			}
+			// The defaults retry connect failures and timeouts, not resets
+			if retry := defaults.retryableError(tt.err); retry != (got != RetryOnReset) {
+				t.Fatalf("retryableError() = %v with the default conditions", retry)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	rp := NewRetryPolicy(RetryConfig{Attempts: 5, Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	limits := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond}
+	for retry, limit := range limits {
+		for i := 0; i < 100; i++ {
+			if d := rp.backoff(retry + 1); d < 0 || d > limit {
+				t.Fatalf("backoff(%d) = %s, want within [0, %s]", retry+1, d, limit)
+			}
+		}
+	}
+}
+
+func TestBufferBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		replayable    bool
+	}{
+		{"no body", "", 0, true},
+		{"small body", "hello", 5, true},
+		{"declared too large", "hello world", 11, false},
+		{"chunked too large", "hello world", -1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = http.NoBody
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/", body)
+			req.ContentLength = tt.contentLength
+
+			buf, stream, replayable, err := bufferBody(req, 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replayable != tt.replayable {
+				t.Fatalf("replayable = %v, want %v", replayable, tt.replayable)
+			}
+			// Whichever way the body comes back, it must be complete
+			got := buf
+			if stream != nil {
+				got, _ = io.ReadAll(stream)
+			}
+			if !bytes.Equal(got, []byte(tt.body)) {
+				t.Fatalf("body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
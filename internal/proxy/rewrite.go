@@ -0,0 +1,107 @@
+/*
+internal/proxy/rewrite.go
+Package proxy provides per-route rewriting of the upstream request path.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RewriteConfig holds per-route path rewrite settings.
+// Steps run in order: strip prefix, regex, add prefix, then the backend base path is prepended.
+type RewriteConfig struct {
+	StripPrefix string // Removed from the start of the path (whole segments only)
+	Regex       string // Applied to the path after stripping
+	Replacement string // Replacement for Regex, may reference groups as $1 or ${name}
+	AddPrefix   string // Prepended after the regex
+}
+
+// PathRewriter maps incoming request paths to upstream paths
+type PathRewriter struct {
+	stripPrefix string
+	regex       *regexp.Regexp
+	replacement string
+	addPrefix   string
+}
+
+// NewPathRewriter compiles a rewrite configuration
+func NewPathRewriter(config RewriteConfig) (*PathRewriter, error) {
+	pr := &PathRewriter{
+		stripPrefix: strings.TrimSuffix(config.StripPrefix, "/"),
+		replacement: config.Replacement,
+		addPrefix:   strings.TrimSuffix(config.AddPrefix, "/"),
+	}
+	if config.Regex != "" {
+		re, err := regexp.Compile(config.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite regex: %w", err)
+		}
+		pr.regex = re
+	}
+	return pr, nil
+}
+
+// Rewrite returns the upstream path for a request path
+func (pr *PathRewriter) Rewrite(path string) string {
+	if stripped := pr.StrippedPrefix(path); stripped != "" {
+		path = path[len(stripped):]
+	}
+	if pr.regex != nil {
+		path = pr.regex.ReplaceAllString(path, pr.replacement)
+	}
+	if pr.addPrefix != "" {
+		path = joinPaths(pr.addPrefix, path)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// StrippedPrefix returns the prefix Rewrite removes from path, if any
+func (pr *PathRewriter) StrippedPrefix(path string) string {
+	if pr.stripPrefix != "" && hasPathPrefix(path, pr.stripPrefix) {
+		return pr.stripPrefix
+	}
+	return ""
+}
+
+// hasPathPrefix reports whether prefix matches path on a segment boundary,
+// so "/api" matches "/api" and "/api/users" but not "/apix"
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
+
+// joinPaths joins two path parts with exactly one slash between them
+func joinPaths(a, b string) string {
+	if b == "" || b == "/" {
+		if a == "" {
+			return "/"
+		}
+		return a
+	}
+	return strings.TrimSuffix(a, "/") + "/" + strings.TrimPrefix(b, "/")
+}
+
+// upstreamURL builds the backend URL for a rewritten path, keeping the backend's
+// base path and merging its query string with the request's
+func upstreamURL(backendURL *url.URL, path string, rawQuery string) string {
+	target := *backendURL
+	target.Path = joinPaths(backendURL.Path, path)
+	target.RawPath = ""
+	switch {
+	case backendURL.RawQuery == "":
+		target.RawQuery = rawQuery
+	case rawQuery != "":
+		target.RawQuery = backendURL.RawQuery + "&" + rawQuery
+	}
+	return target.String()
+}
@@ -0,0 +1,100 @@
+/*
+internal/proxy/rewrite_test.go
+Package proxy tests upstream path rewriting.
+*/
+
+package proxy
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestPathRewriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RewriteConfig
+		path   string
+		want   string
+	}{
+		{"no rewrite", RewriteConfig{}, "/api/users", "/api/users"},
+		{"strip prefix", RewriteConfig{StripPrefix: "/api"}, "/api/users/1", "/users/1"},
+		{"strip prefix with trailing slash", RewriteConfig{StripPrefix: "/api/"}, "/api/users", "/users"},
+		{"strip whole path", RewriteConfig{StripPrefix: "/api"}, "/api", "/"},
+		{"strip only whole segments", RewriteConfig{StripPrefix: "/api"}, "/apix/users", "/apix/users"},
+		{"strip prefix not present", RewriteConfig{StripPrefix: "/api"}, "/v1/users", "/v1/users"},
+		{"add prefix", RewriteConfig{AddPrefix: "/internal/"}, "/users", "/internal/users"},
+		{"add prefix to root", RewriteConfig{AddPrefix: "/internal"}, "/", "/internal"},
+		{"strip then add", RewriteConfig{StripPrefix: "/api", AddPrefix: "/v2"}, "/api/users", "/v2/users"},
+		{"regex with numbered group", RewriteConfig{Regex: `^/users/(\d+)$`, Replacement: "/accounts/$1"}, "/users/42", "/accounts/42"},
+		{"regex with named group", RewriteConfig{Regex: `^/(?P<id>\d+)/profile$`, Replacement: "/profiles/${id}"}, "/7/profile", "/profiles/7"},
+		{"regex without match", RewriteConfig{Regex: `^/users/(\d+)$`, Replacement: "/accounts/$1"}, "/users/me", "/users/me"},
+		{"regex result gets a leading slash", RewriteConfig{Regex: `^/`, Replacement: ""}, "/users", "/users"},
+		{"steps run in order", RewriteConfig{StripPrefix: "/api", Regex: `^/v1/`, Replacement: "/", AddPrefix: "/svc"}, "/api/v1/orders", "/svc/orders"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr, err := NewPathRewriter(tt.config)
+			if err != nil {
+				t.Fatalf("NewPathRewriter() error = %v", err)
+			}
+			if got := pr.Rewrite(tt.path); got != tt.want {
+				t.Fatalf("Rewrite(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathRewriterStrippedPrefix(t *testing.T) {
+	pr, _ := NewPathRewriter(RewriteConfig{StripPrefix: "/api/"})
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/users", "/api"},
+		{"/api", "/api"},
+		{"/apix", ""},
+		{"/users", ""},
+	}
+	for _, tt := range tests {
+		if got := pr.StrippedPrefix(tt.path); got != tt.want {
+			t.Errorf("StrippedPrefix(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNewPathRewriterRejectsInvalidRegex(t *testing.T) {
+	if _, err := NewPathRewriter(RewriteConfig{Regex: "("}); err == nil {
+		t.Fatal("NewPathRewriter() accepted an invalid regex")
+	}
+}
+
+func TestUpstreamURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		path    string
+		query   string
+		want    string
+	}{
+		{"plain backend", "http://backend:8080", "/users", "", "http://backend:8080/users"},
+		{"backend base path", "http://backend:8080/v1/", "/users", "", "http://backend:8080/v1/users"},
+		{"root path keeps base path", "http://backend:8080/v1", "/", "", "http://backend:8080/v1"},
+		{"request query", "http://backend:8080", "/users", "page=2", "http://backend:8080/users?page=2"},
+		{"merged query", "http://backend:8080?key=abc", "/users", "page=2", "http://backend:8080/users?key=abc&page=2"},
+		{"backend query only", "http://backend:8080?key=abc", "/users", "", "http://backend:8080/users?key=abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendURL, err := url.Parse(tt.backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := upstreamURL(backendURL, tt.path, tt.query); got != tt.want {
+				t.Fatalf("upstreamURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
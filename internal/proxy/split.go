@@ -0,0 +1,206 @@
+/*
+internal/proxy/split.go
+Package proxy provides named backend groups and weighted traffic splitting between them.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+)
+
+// DefaultGroupName is used for routes that list backends without groups
+const DefaultGroupName = "default"
+
+// BackendGroupConfig describes a named group of backends within a route
+type BackendGroupConfig struct {
+	Name     string
+	Weight   int      // Share of the route's traffic in percent
+	URLs     []string // Backend URLs
+	Weights  []int    // Load balancing weight of each backend within the group
+	Disabled []bool   // Backends kept out of rotation (optional)
+
+	// Discovery entries (optional); an entry with a type has an empty URL
+	Discovery []DiscoveryProviderConfig
+}
+
+// GroupOverrideConfig lets clients such as testers force a group by name
+type GroupOverrideConfig struct {
+	Header string // Request header carrying a group name
+	Cookie string // Cookie carrying a group name
+}
+
+// BackendGroup is a named backend pool with its own load balancer
+type BackendGroup struct {
+	name    string
+	pool    *BackendPool
+	handler *ProxyHandler
+	opts    RouteOptions // For backends added at runtime
+}
+
+// Name returns the group name
+func (g *BackendGroup) Name() string {
+	return g.name
+}
+
+// Pool returns the group's backend pool
+func (g *BackendGroup) Pool() *BackendPool {
+	return g.pool
+}
+
+// AddBackend creates a backend with the route's settings and adds it to the running
+// group. A disabled backend is added disabled and gets no traffic until enabled.
+func (g *BackendGroup) AddBackend(urlStr string, weight int, disabled bool) (*Backend, error) {
+	backend, err := newRouteBackend(urlStr, weight, g.opts)
+	if err != nil {
+		return nil, err
+	}
+	// Disabled before it is published, so no request can reach it
+	backend.SetDisabled(disabled)
+	if err := g.pool.AddBackend(backend); err != nil {
+		return nil, err
+	}
+	log.Printf("Backend %s added to group %s", backend.URL.String(), g.name)
+	return backend, nil
+}
+
+// RemoveBackend takes a backend out of the group; in-flight requests complete
+func (g *BackendGroup) RemoveBackend(backend *Backend) error {
+	if err := g.pool.RemoveBackend(backend); err != nil {
+		return err
+	}
+	log.Printf("Backend %s removed from group %s (%d requests in flight)", backend.URL.String(), g.name, backend.InFlight())
+	return nil
+}
+
+// TrafficSplit holds the percentage of traffic each group receives.
+// It can be changed at runtime.
+type TrafficSplit struct {
+	mu      sync.RWMutex
+	names   []string // Group order, for stable output
+	weights map[string]int
+}
+
+// NewTrafficSplit creates a split over the given groups
+func NewTrafficSplit(groups []BackendGroupConfig) (*TrafficSplit, error) {
+	ts := &TrafficSplit{}
+	weights := make(map[string]int)
+	for _, group := range groups {
+		ts.names = append(ts.names, group.Name)
+		weights[group.Name] = group.Weight
+	}
+	if err := ts.Set(weights); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// Weights returns a copy of the current split
+func (ts *TrafficSplit) Weights() map[string]int {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	weights := make(map[string]int, len(ts.weights))
+	for name, weight := range ts.weights {
+		weights[name] = weight
+	}
+	return weights
+}
+
+// Set replaces the split. Every group must be listed and the weights must add up to 100.
+func (ts *TrafficSplit) Set(weights map[string]int) error {
+	if len(weights) != len(ts.names) {
+		return fmt.Errorf("split must list all %d groups", len(ts.names))
+	}
+	total := 0
+	for _, name := range ts.names {
+		weight, ok := weights[name]
+		if !ok {
+			return fmt.Errorf("split is missing group %q", name)
+		}
+		if weight < 0 || weight > 100 {
+			return fmt.Errorf("weight of group %q must be between 0 and 100", name)
+		}
+		total += weight
+	}
+	if total != 100 {
+		return fmt.Errorf("group weights must add up to 100, got %d", total)
+	}
+
+	copied := make(map[string]int, len(weights))
+	for name, weight := range weights {
+		copied[name] = weight
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.weights = copied
+	return nil
+}
+
+// weight returns the current weight of one group
+func (ts *TrafficSplit) weight(name string) int {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.weights[name]
+}
+
+// pick chooses a group name according to the split
+func (ts *TrafficSplit) pick() string {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	n := rand.IntN(100)
+	for _, name := range ts.names {
+		n -= ts.weights[name]
+		if n < 0 {
+			return name
+		}
+	}
+	return ts.names[len(ts.names)-1]
+}
+
+// selectGroup picks the group for a request: an explicit override first, then the
+// group of the client's sticky backend (if it still gets traffic), then the split
+func (rp *RouteProxy) selectGroup(req *http.Request) *BackendGroup {
+	if rp.split == nil {
+		return rp.groups[0]
+	}
+
+	if rp.override.Header != "" {
+		if group := rp.group(req.Header.Get(rp.override.Header)); group != nil {
+			return group
+		}
+	}
+	if rp.override.Cookie != "" {
+		if cookie, err := req.Cookie(rp.override.Cookie); err == nil {
+			if group := rp.group(cookie.Value); group != nil {
+				return group
+			}
+		}
+	}
+
+	for _, group := range rp.groups {
+		if group.handler.sticky != nil && group.handler.sticky.pinned(req) && rp.split.weight(group.name) > 0 {
+			return group
+		}
+	}
+
+	return rp.group(rp.split.pick())
+}
+
+// group returns the group with the given name, or nil
+func (rp *RouteProxy) group(name string) *BackendGroup {
+	if name == "" {
+		return nil
+	}
+	for _, group := range rp.groups {
+		if group.name == name {
+			return group
+		}
+	}
+	return nil
+}
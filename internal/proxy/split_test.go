@@ -0,0 +1,142 @@
+/*
+internal/proxy/split_test.go
+Package proxy tests traffic splitting between backend groups.
+*/
+
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// splitGroups returns one single-backend group per weight, named g0, g1, ...
+func splitGroups(weights ...int) []BackendGroupConfig {
+	groups := make([]BackendGroupConfig, len(weights))
+	for i, weight := range weights {
+		name := "g" + strconv.Itoa(i)
+		groups[i] = BackendGroupConfig{
+			Name:    name,
+			Weight:  weight,
+			URLs:    []string{"http://" + name + ".invalid"},
+			Weights: []int{1},
+		}
+	}
+	return groups
+}
+
+func TestTrafficSplitRatios(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+	}{
+		{"canary", []int{90, 10}},
+		{"even", []int{50, 50}},
+		{"all stable", []int{100, 0}},
+		{"all canary", []int{0, 100}},
+		{"three groups", []int{70, 20, 10}},
+	}
+
+	const picks = 20000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp, err := NewRouteProxy(splitGroups(tt.weights...), time.Second, RouteOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts := make(map[string]int)
+			for i := 0; i < picks; i++ {
+				counts[rp.selectGroup(httptest.NewRequest(http.MethodGet, "/", nil)).Name()]++
+			}
+			for i, weight := range tt.weights {
+				name := rp.groups[i].Name()
+				share := 100 * float64(counts[name]) / picks
+				if weight == 0 && counts[name] > 0 || math.Abs(share-float64(weight)) > 2 {
+					t.Errorf("group %s got %.1f%% of requests, want %d%%", name, share, weight)
+				}
+			}
+		})
+	}
+}
+
+func TestTrafficSplitSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+		wantErr bool
+	}{
+		{"valid", map[string]int{"g0": 20, "g1": 80}, false},
+		{"all to one group", map[string]int{"g0": 0, "g1": 100}, false},
+		{"missing group", map[string]int{"g0": 100}, true},
+		{"unknown group", map[string]int{"g0": 50, "other": 50}, true},
+		{"not 100", map[string]int{"g0": 50, "g1": 40}, true},
+		{"negative", map[string]int{"g0": -10, "g1": 110}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			split, err := NewTrafficSplit(splitGroups(90, 10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = split.Set(tt.weights)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%v) error = %v, wantErr %v", tt.weights, err, tt.wantErr)
+			}
+			want := map[string]int{"g0": 90, "g1": 10}
+			if !tt.wantErr {
+				want = tt.weights
+			}
+			for name, weight := range want {
+				if got := split.Weights()[name]; got != weight {
+					t.Fatalf("weight of %s = %d, want %d", name, got, weight)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectGroupOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"split", nil, "g0"},
+		{"header", map[string]string{"X-Group": "g1"}, "g1"},
+		{"cookie", map[string]string{"Cookie": "group=g1"}, "g1"},
+		{"header before cookie", map[string]string{"X-Group": "g0", "Cookie": "group=g1"}, "g0"},
+		{"unknown group follows the split", map[string]string{"X-Group": "g7"}, "g0"},
+	}
+
+	// The split sends everything to g0, so only an override reaches g1
+	rp, err := NewRouteProxy(splitGroups(100, 0), time.Second, RouteOptions{
+		GroupOverride: GroupOverrideConfig{Header: "X-Group", Cookie: "group"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if got := rp.selectGroup(req).Name(); got != tt.want {
+				t.Fatalf("selectGroup() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// A runtime change of the split applies to the next request
+	if err := rp.Split().Set(map[string]int{"g0": 0, "g1": 100}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rp.selectGroup(httptest.NewRequest(http.MethodGet, "/", nil)).Name(); got != "g1" {
+		t.Fatalf("after Set: selectGroup() = %s, want g1", got)
+	}
+}
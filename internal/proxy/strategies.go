@@ -0,0 +1,190 @@
+/*
+internal/proxy/strategies.go
+Package proxy provides additional load balancing strategies driven by live backend statistics.
+*/
+
+package proxy
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// SmoothWeightedBalancer implements nginx-style smooth weighted round-robin.
+// Unlike the expanded-slice approach it interleaves picks, so a backend with
+// weight 5 does not receive 5 requests in a row.
+type SmoothWeightedBalancer struct {
+	pool    *BackendPool
+	current map[*Backend]float64
+	mu      sync.Mutex
+}
+
+// NewSmoothWeightedBalancer creates a new smooth weighted round-robin balancer
+func NewSmoothWeightedBalancer(pool *BackendPool) *SmoothWeightedBalancer {
+	return &SmoothWeightedBalancer{
+		pool:    pool,
+		current: make(map[*Backend]float64),
+	}
+}
+
+// NextBackend returns the available backend with the highest current weight
+func (sw *SmoothWeightedBalancer) NextBackend() (*Backend, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	return pickAvailable(sw.pool, func(candidates []*Backend) *Backend {
+		total := 0.0
+		var best *Backend
+		seen := make(map[*Backend]bool, len(candidates))
+		for _, backend := range candidates {
+			weight := backend.effectiveWeight()
+			sw.current[backend] += weight
+			total += weight
+			seen[backend] = true
+			if best == nil || sw.current[backend] > sw.current[best] {
+				best = backend
+			}
+		}
+		sw.current[best] -= total
+
+		// Forget backends that left the pool so they restart from zero
+		for backend := range sw.current {
+			if !seen[backend] {
+				delete(sw.current, backend)
+			}
+		}
+		return best
+	})
+}
+
+// LeastConnectionsBalancer picks the backend with the fewest in-flight requests per unit of weight
+type LeastConnectionsBalancer struct {
+	pool *BackendPool
+}
+
+// NewLeastConnectionsBalancer creates a new least-connections balancer
+func NewLeastConnectionsBalancer(pool *BackendPool) *LeastConnectionsBalancer {
+	return &LeastConnectionsBalancer{pool: pool}
+}
+
+// NextBackend returns the least loaded available backend, breaking ties by weighted random choice
+func (lc *LeastConnectionsBalancer) NextBackend() (*Backend, error) {
+	return pickAvailable(lc.pool, func(candidates []*Backend) *Backend {
+		var best []*Backend
+		bestLoad := 0.0
+		for _, backend := range candidates {
+			load := float64(backend.InFlight()) / backend.effectiveWeight()
+			switch {
+			case best == nil || load < bestLoad:
+				best = []*Backend{backend}
+				bestLoad = load
+			case load == bestLoad:
+				best = append(best, backend)
+			}
+		}
+		return weightedChoice(best)
+	})
+}
+
+// PowerOfTwoBalancer samples two random backends and picks the less loaded one,
+// which approximates least-connections without scanning the whole pool
+type PowerOfTwoBalancer struct {
+	pool *BackendPool
+}
+
+// NewPowerOfTwoBalancer creates a new power-of-two-choices balancer
+func NewPowerOfTwoBalancer(pool *BackendPool) *PowerOfTwoBalancer {
+	return &PowerOfTwoBalancer{pool: pool}
+}
+
+// NextBackend returns the less loaded of two randomly chosen backends
+func (p2 *PowerOfTwoBalancer) NextBackend() (*Backend, error) {
+	return pickAvailable(p2.pool, func(candidates []*Backend) *Backend {
+		if len(candidates) == 1 {
+			return candidates[0]
+		}
+		i := rand.IntN(len(candidates))
+		j := rand.IntN(len(candidates) - 1)
+		if j >= i {
+			j++
+		}
+		a, b := candidates[i], candidates[j]
+		loadA := float64(a.InFlight()) / a.effectiveWeight()
+		loadB := float64(b.InFlight()) / b.effectiveWeight()
+		if loadB < loadA {
+			return b
+		}
+		return a
+	})
+}
+
+// RandomBalancer picks a backend at random with probability proportional to its weight
+type RandomBalancer struct {
+	pool *BackendPool
+}
+
+// NewRandomBalancer creates a new weighted random balancer
+func NewRandomBalancer(pool *BackendPool) *RandomBalancer {
+	return &RandomBalancer{pool: pool}
+}
+
+// NextBackend returns a weighted random available backend
+func (rb *RandomBalancer) NextBackend() (*Backend, error) {
+	return pickAvailable(rb.pool, weightedChoice)
+}
+
+// weightedChoice picks one of candidates at random with probability proportional to its weight
+func weightedChoice(candidates []*Backend) *Backend {
+	total := 0.0
+	for _, backend := range candidates {
+		total += backend.effectiveWeight()
+	}
+	n := rand.Float64() * total
+	for _, backend := range candidates {
+		n -= backend.effectiveWeight()
+		if n < 0 {
+			return backend
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// EWMABalancer picks the backend with the lowest expected latency, estimated as
+// peak-EWMA latency scaled by outstanding requests and divided by weight
+type EWMABalancer struct {
+	pool *BackendPool
+}
+
+// NewEWMABalancer creates a new latency-aware balancer
+func NewEWMABalancer(pool *BackendPool) *EWMABalancer {
+	return &EWMABalancer{pool: pool}
+}
+
+// NextBackend returns the available backend with the lowest cost
+func (eb *EWMABalancer) NextBackend() (*Backend, error) {
+	return pickAvailable(eb.pool, func(candidates []*Backend) *Backend {
+		var best []*Backend
+		bestCost := 0.0
+		for _, backend := range candidates {
+			cost := ewmaCost(backend)
+			switch {
+			case best == nil || cost < bestCost:
+				best = []*Backend{backend}
+				bestCost = cost
+			case cost == bestCost:
+				best = append(best, backend)
+			}
+		}
+		return weightedChoice(best)
+	})
+}
+
+// ewmaCost estimates how long a new request to the backend would take
+func ewmaCost(b *Backend) float64 {
+	latency := b.EWMALatency().Seconds()
+	if latency == 0 {
+		// No samples yet: treat as fast so new backends get probed
+		latency = 1e-6
+	}
+	return latency * float64(b.InFlight()+1) / b.effectiveWeight()
+}
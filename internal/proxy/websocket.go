@@ -0,0 +1,486 @@
+/*
+internal/proxy/websocket.go
+Package proxy provides WebSocket and HTTP Upgrade tunneling to backends.
+*/
+
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AndreaBozzo/go-lab/internal/tracing"
+	"github.com/gin-gonic/gin"
+)
+
+// WebSocketConfig holds limits for upgraded (tunneled) connections
+type WebSocketConfig struct {
+	IdleTimeout    time.Duration // Close the tunnel after no traffic in either direction (default 5m)
+	MaxLifetime    time.Duration // Close the tunnel after this long regardless of traffic (0 = no limit)
+	MaxConnections int           // Concurrent tunnels allowed on the route (0 = no limit)
+}
+
+// Reasons a tunnel ends
+const (
+	tunnelClientClosed  = "client closed"
+	tunnelBackendClosed = "backend closed"
+	tunnelIdleTimeout   = "idle timeout"
+	tunnelMaxLifetime   = "max lifetime"
+	tunnelShutdown      = "gateway shutdown"
+)
+
+// isUpgradeRequest reports whether the request asks to switch protocols
+func isUpgradeRequest(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleUpgrade forwards the handshake to a backend and, if it switches
+// protocols, tunnels bytes between client and backend until either side closes
+func (ph *ProxyHandler) handleUpgrade(c *gin.Context) {
+	// The slot is held from before the dial until the tunnel ends, so concurrent
+	// upgrades cannot all pass the limit
+	if !ph.tunnels.reserve(ph.websocket.MaxConnections) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Too many upgraded connections",
+		})
+		return
+	}
+	defer ph.tunnels.release()
+
+	backend, ticket, err := ph.selectBackend(c, nil)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "No backend servers available",
+		})
+		return
+	}
+	c.Set("backend", backend.GetURL().String())
+
+	backend.beginRequest()
+	defer backend.endRequest()
+	startTime := time.Now()
+
+	targetURL := ph.buildTargetURL(backend.GetURL(), c.Request.URL)
+	proxyReq, err := ph.createProxyRequest(c.Request, targetURL, nil)
+	if err != nil {
+		backend.CancelRequest(ticket)
+		log.Printf("Failed to create proxy request: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create proxy request",
+		})
+		return
+	}
+	ph.setForwardingHeaders(proxyReq, c.Request)
+	// Hop-by-hop headers were stripped; the upgrade must be requested again explicitly
+	proxyReq.Header.Set("Connection", "Upgrade")
+	proxyReq.Header.Set("Upgrade", c.Request.Header.Get("Upgrade"))
+
+	_, span := tracing.StartSpan(c.Request.Context(), "proxy "+c.Request.Method+" upgrade", tracing.SpanKindClient)
+	defer span.End()
+	if span != nil {
+		tracing.Inject(proxyReq.Header, span.SpanContext())
+		span.SetAttribute("http.request.method", proxyReq.Method)
+		span.SetAttribute("url.full", targetURL)
+		span.SetAttribute("server.address", proxyReq.URL.Host)
+	}
+
+	backendConn, err := ph.dialBackend(proxyReq)
+	if err != nil {
+		span.SetStatus(tracing.StatusError, err.Error())
+		backend.ReportResult(ticket, false)
+		log.Printf("Upgrade dial failed for backend %s: %v", backend.GetURL().String(), err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Backend request failed",
+		})
+		return
+	}
+
+	// The handshake gets the route timeout; the tunnel has its own limits
+	backendConn.SetDeadline(time.Now().Add(ph.timeout))
+	backendReader := bufio.NewReader(backendConn)
+	resp, err := writeHandshake(backendConn, backendReader, proxyReq)
+	if err != nil {
+		backendConn.Close()
+		span.SetStatus(tracing.StatusError, err.Error())
+		backend.ReportResult(ticket, false)
+		log.Printf("Upgrade handshake failed for backend %s: %v", backend.GetURL().String(), err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Backend request failed",
+		})
+		return
+	}
+	backendConn.SetDeadline(time.Time{})
+
+	backend.observeLatency(time.Since(startTime))
+	backend.ReportResult(ticket, resp.StatusCode < http.StatusInternalServerError)
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+
+	// The backend refused to switch protocols: relay its answer as a normal response
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer backendConn.Close()
+		defer resp.Body.Close()
+		ph.writeResponse(c, backend, resp, nil)
+		return
+	}
+
+	if ph.sticky != nil {
+		ph.sticky.pin(c.Writer, c.Request, backend)
+	}
+
+	// Record the status for the logging and metrics middleware before taking over the connection
+	c.Writer.WriteHeader(http.StatusSwitchingProtocols)
+	clientConn, clientBuf, err := c.Writer.Hijack()
+	if err != nil {
+		backendConn.Close()
+		log.Printf("Failed to hijack client connection: %v", err)
+		return
+	}
+	// Clear the server's read/write deadlines, they are meant for plain requests
+	clientConn.SetDeadline(time.Time{})
+
+	// Relay the 101 response, including headers set by the gateway (e.g. sticky cookie)
+	header := resp.Header.Clone()
+	for key, values := range c.Writer.Header() {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	fmt.Fprintf(clientBuf, "HTTP/1.1 %s\r\n", resp.Status)
+	header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if err := clientBuf.Flush(); err != nil {
+		clientConn.Close()
+		backendConn.Close()
+		return
+	}
+
+	t := newTunnel(clientConn, clientBuf.Reader, backendConn, backendReader,
+		strings.EqualFold(resp.Header.Get("Upgrade"), "websocket"))
+	ph.tunnels.add(t)
+	defer ph.tunnels.remove(t)
+
+	reason := t.run(ph.websocket.IdleTimeout, ph.websocket.MaxLifetime)
+	log.Printf("Upgraded connection %s via %s closed: %s after %s (client close code %s, backend close code %s, %d bytes up, %d bytes down)",
+		c.Request.URL.Path, backend.GetURL().String(), reason, time.Since(startTime).Round(time.Millisecond),
+		formatCloseCode(t.clientFrames), formatCloseCode(t.backendFrames), t.bytesUp.Load(), t.bytesDown.Load())
+}
+
+// dialBackend opens a raw connection to the backend of the request
+func (ph *ProxyHandler) dialBackend(req *http.Request) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	host := backendAddress(req.URL)
+	if req.URL.Scheme == "https" {
+		return tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: req.URL.Hostname()})
+	}
+	return dialer.Dial("tcp", host)
+}
+
+// writeHandshake sends the upgrade request and reads the backend's response
+func writeHandshake(conn net.Conn, reader *bufio.Reader, req *http.Request) (*http.Response, error) {
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(reader, req)
+}
+
+// tunnel is an established upgraded connection between a client and a backend
+type tunnel struct {
+	client        net.Conn
+	clientReader  io.Reader // Includes bytes the server buffered before the hijack
+	backend       net.Conn
+	backendReader io.Reader // Includes bytes read past the handshake response
+
+	clientFrames  *frameWatcher // nil unless the protocol is WebSocket
+	backendFrames *frameWatcher
+
+	lastActivity atomic.Int64 // UnixNano of the last byte in either direction
+	bytesUp      atomic.Int64
+	bytesDown    atomic.Int64
+
+	closeOnce sync.Once
+	reason    string
+	done      chan struct{}
+}
+
+// newTunnel prepares a tunnel; frames are inspected for close codes when websocket is true
+func newTunnel(client net.Conn, clientReader io.Reader, backend net.Conn, backendReader io.Reader, websocket bool) *tunnel {
+	t := &tunnel{
+		client:        client,
+		clientReader:  clientReader,
+		backend:       backend,
+		backendReader: backendReader,
+		done:          make(chan struct{}),
+	}
+	if websocket {
+		t.clientFrames = &frameWatcher{}
+		t.backendFrames = &frameWatcher{}
+	}
+	t.lastActivity.Store(time.Now().UnixNano())
+	return t
+}
+
+// run copies in both directions until one side closes or a limit is hit, and returns why it ended
+func (t *tunnel) run(idleTimeout, maxLifetime time.Duration) string {
+	go func() {
+		t.pipe(t.backend, t.clientReader, t.clientFrames, &t.bytesUp)
+		t.close(tunnelClientClosed)
+	}()
+	go func() {
+		t.pipe(t.client, t.backendReader, t.backendFrames, &t.bytesDown)
+		t.close(tunnelBackendClosed)
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	started := time.Now()
+	for {
+		select {
+		case <-t.done:
+			return t.reason
+		case now := <-ticker.C:
+			if maxLifetime > 0 && now.Sub(started) >= maxLifetime {
+				t.close(tunnelMaxLifetime)
+			} else if idleTimeout > 0 && now.Sub(time.Unix(0, t.lastActivity.Load())) >= idleTimeout {
+				t.close(tunnelIdleTimeout)
+			}
+		}
+	}
+}
+
+// pipe copies src to dst, recording activity and watching frames
+func (t *tunnel) pipe(dst io.Writer, src io.Reader, frames *frameWatcher, counter *atomic.Int64) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.lastActivity.Store(time.Now().UnixNano())
+			counter.Add(int64(n))
+			if frames != nil {
+				frames.observe(buf[:n])
+			}
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// close tears down both connections; the first caller's reason wins
+func (t *tunnel) close(reason string) {
+	t.closeOnce.Do(func() {
+		t.reason = reason
+		t.client.Close()
+		t.backend.Close()
+		close(t.done)
+	})
+}
+
+// tunnelSet tracks the open tunnels of a route
+type tunnelSet struct {
+	mu      sync.Mutex
+	tunnels map[*tunnel]struct{}
+	slots   int // Upgrades being set up or tunneled, counted against the route limit
+}
+
+// reserve takes a slot unless limit (0 = no limit) slots are taken
+func (ts *tunnelSet) reserve(limit int) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if limit > 0 && ts.slots >= limit {
+		return false
+	}
+	ts.slots++
+	return true
+}
+
+// release gives back a slot taken by reserve
+func (ts *tunnelSet) release() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.slots--
+}
+
+func (ts *tunnelSet) add(t *tunnel) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.tunnels == nil {
+		ts.tunnels = make(map[*tunnel]struct{})
+	}
+	ts.tunnels[t] = struct{}{}
+}
+
+func (ts *tunnelSet) remove(t *tunnel) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	delete(ts.tunnels, t)
+}
+
+func (ts *tunnelSet) count() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return len(ts.tunnels)
+}
+
+// closeAll ends every open tunnel
+func (ts *tunnelSet) closeAll(reason string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for t := range ts.tunnels {
+		t.close(reason)
+	}
+}
+
+// frameWatcher follows the WebSocket frame boundaries of one direction of a
+// tunnel (RFC 6455 section 5.2) to pick up the status code of a close frame
+type frameWatcher struct {
+	mu        sync.Mutex
+	header    [14]byte
+	headerLen int
+	remaining uint64 // Payload bytes left in the current frame
+	inPayload bool
+	opcode    byte
+	masked    bool
+	mask      [4]byte
+	offset    uint64 // Position within the current payload
+	closeData []byte
+	closeCode int // 0 until a close frame is seen; 1005 when it carried no code
+}
+
+// observe feeds bytes seen on the wire
+func (fw *frameWatcher) observe(p []byte) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	for len(p) > 0 {
+		if !fw.inPayload {
+			fw.header[fw.headerLen] = p[0]
+			fw.headerLen++
+			p = p[1:]
+			if fw.headerLen < 2 || fw.headerLen < fw.headerSize() {
+				continue
+			}
+			fw.startPayload()
+			continue
+		}
+
+		n := uint64(len(p))
+		if n > fw.remaining {
+			n = fw.remaining
+		}
+		if fw.opcode == 0x8 {
+			for i := uint64(0); i < n && len(fw.closeData) < 2; i++ {
+				b := p[i]
+				if fw.masked {
+					b ^= fw.mask[(fw.offset+i)%4]
+				}
+				fw.closeData = append(fw.closeData, b)
+			}
+		}
+		fw.offset += n
+		fw.remaining -= n
+		p = p[n:]
+		if fw.remaining == 0 {
+			fw.endFrame()
+		}
+	}
+}
+
+// headerSize is the full header length implied by the first two header bytes
+func (fw *frameWatcher) headerSize() int {
+	size := 2
+	switch fw.header[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if fw.header[1]&0x80 != 0 {
+		size += 4
+	}
+	return size
+}
+
+// startPayload decodes a complete header
+func (fw *frameWatcher) startPayload() {
+	fw.opcode = fw.header[0] & 0x0f
+	fw.masked = fw.header[1]&0x80 != 0
+
+	pos := 2
+	switch length := fw.header[1] & 0x7f; length {
+	case 126:
+		fw.remaining = uint64(binary.BigEndian.Uint16(fw.header[2:4]))
+		pos = 4
+	case 127:
+		fw.remaining = binary.BigEndian.Uint64(fw.header[2:10])
+		pos = 10
+	default:
+		fw.remaining = uint64(length)
+	}
+	if fw.masked {
+		copy(fw.mask[:], fw.header[pos:pos+4])
+	}
+
+	fw.headerLen = 0
+	fw.offset = 0
+	fw.closeData = nil
+	fw.inPayload = true
+	if fw.remaining == 0 {
+		fw.endFrame()
+	}
+}
+
+// endFrame records close codes and waits for the next header
+func (fw *frameWatcher) endFrame() {
+	if fw.opcode == 0x8 {
+		fw.closeCode = 1005 // No status received
+		if len(fw.closeData) == 2 {
+			fw.closeCode = int(binary.BigEndian.Uint16(fw.closeData))
+		}
+	}
+	fw.inPayload = false
+}
+
+// code returns the close code seen so far
+func (fw *frameWatcher) code() int {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.closeCode
+}
+
+// formatCloseCode renders a watcher's close code for logging
+func formatCloseCode(fw *frameWatcher) string {
+	if fw == nil {
+		return "n/a"
+	}
+	if code := fw.code(); code != 0 {
+		return fmt.Sprint(code)
+	}
+	return "none"
+}
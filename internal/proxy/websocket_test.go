@@ -0,0 +1,167 @@
+/*
+internal/proxy/websocket_test.go
+Package proxy tests WebSocket close-code parsing and upgrade handling.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// wsFrame encodes a final WebSocket frame, masking it when mask is set
+func wsFrame(opcode byte, payload []byte, mask []byte) []byte {
+	frame := []byte{0x80 | opcode}
+	maskBit := byte(0)
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if mask == nil {
+		return append(frame, payload...)
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// closePayload encodes a close frame body with a status code and reason
+func closePayload(code uint16, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, code), reason...)
+}
+
+func TestFrameWatcherCloseCode(t *testing.T) {
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+
+	tests := []struct {
+		name   string
+		stream []byte
+		want   int
+	}{
+		{"no frames", nil, 0},
+		{"text only", wsFrame(0x1, []byte("hello"), nil), 0},
+		{"close with code", wsFrame(0x8, closePayload(1000, ""), nil), 1000},
+		{"close with code and reason", wsFrame(0x8, closePayload(1001, "going away"), nil), 1001},
+		{"masked close", wsFrame(0x8, closePayload(4000, "bye"), mask), 4000},
+		{"close without payload", wsFrame(0x8, nil, nil), 1005},
+		{"masked close without payload", wsFrame(0x8, nil, mask), 1005},
+		{"close after text", append(wsFrame(0x1, []byte("hi"), mask), wsFrame(0x8, closePayload(1011, ""), mask)...), 1011},
+		{"close after a 16-bit length frame", append(wsFrame(0x2, bytes.Repeat([]byte{0x88}, 300), nil), wsFrame(0x8, closePayload(1008, ""), nil)...), 1008},
+		{"close after a 64-bit length frame", append(wsFrame(0x2, bytes.Repeat([]byte{0x88}, 70000), mask), wsFrame(0x8, closePayload(1009, ""), mask)...), 1009},
+		{"payload bytes that look like a close header", append(wsFrame(0x1, wsFrame(0x8, closePayload(1002, ""), nil), nil), wsFrame(0x9, nil, nil)...), 0},
+		{"ping after close", append(wsFrame(0x8, closePayload(1000, ""), nil), wsFrame(0x9, []byte("x"), nil)...), 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The code must not depend on how the stream is split into reads
+			for _, chunk := range []int{len(tt.stream) + 1, 1, 3, 7} {
+				fw := &frameWatcher{}
+				for p := tt.stream; len(p) > 0; {
+					n := min(chunk, len(p))
+					fw.observe(p[:n])
+					p = p[n:]
+				}
+				if got := fw.code(); got != tt.want {
+					t.Fatalf("code() = %d with %d-byte reads, want %d", got, chunk, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatCloseCode(t *testing.T) {
+	fw := &frameWatcher{}
+	if got := formatCloseCode(nil); got != "n/a" {
+		t.Errorf("formatCloseCode(nil) = %q, want n/a", got)
+	}
+	if got := formatCloseCode(fw); got != "none" {
+		t.Errorf("formatCloseCode() before a close = %q, want none", got)
+	}
+	fw.observe(wsFrame(0x8, closePayload(1000, ""), nil))
+	if got := formatCloseCode(fw); got != "1000" {
+		t.Errorf("formatCloseCode() = %q, want 1000", got)
+	}
+}
+
+func TestIsUpgradeRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		upgrade    string
+		connection []string
+		want       bool
+	}{
+		{"websocket", "websocket", []string{"Upgrade"}, true},
+		{"token list", "websocket", []string{"keep-alive, upgrade"}, true},
+		{"repeated header", "h2c", []string{"keep-alive", "Upgrade"}, true},
+		{"no connection token", "websocket", []string{"keep-alive"}, false},
+		{"no upgrade header", "", []string{"Upgrade"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			if tt.upgrade != "" {
+				req.Header.Set("Upgrade", tt.upgrade)
+			}
+			for _, value := range tt.connection {
+				req.Header.Add("Connection", value)
+			}
+			if got := isUpgradeRequest(req); got != tt.want {
+				t.Fatalf("isUpgradeRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTunnelSetReserve(t *testing.T) {
+	var ts tunnelSet
+	const limit = 5
+
+	// Concurrent upgrades never take more than limit slots
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ts.reserve(limit) {
+				mu.Lock()
+				granted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if granted != limit {
+		t.Fatalf("%d slots granted, want %d", granted, limit)
+	}
+
+	ts.release()
+	if !ts.reserve(limit) {
+		t.Fatal("a released slot could not be taken again")
+	}
+	if ts.reserve(limit) {
+		t.Fatal("reserve() exceeded the limit after a release")
+	}
+	if !ts.reserve(0) {
+		t.Fatal("reserve(0) refused a slot, want no limit")
+	}
+}
@@ -0,0 +1,163 @@
+/*
+internal/storage/apikeys.go
+Package storage provides SQLite persistence for hashed API keys.
+*/
+
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrAPIKeyNotFound is returned when no API key matches a lookup
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey describes an issued API key. The secret itself is never stored, only its hash.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Owner     string     `json:"owner"`
+	Prefix    string     `json:"prefix"` // First characters of the key, to help owners identify it
+	Routes    []string   `json:"routes"` // Route paths the key may access (empty means all)
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Enabled   bool       `json:"enabled"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+}
+
+// APIKeyStore persists API keys
+type APIKeyStore interface {
+	CreateAPIKey(key APIKey, hash string) error
+	GetAPIKeyByHash(hash string) (*APIKey, error)
+	GetAPIKey(id string) (*APIKey, error)
+	ListAPIKeys() ([]APIKey, error)
+	RotateAPIKey(id string, prefix string, hash string) error
+	SetAPIKeyEnabled(id string, enabled bool) error
+}
+
+var _ APIKeyStore = (*SQLiteStorage)(nil)
+
+// createAPIKeysTable creates the api_keys table if it does not exist
+func createAPIKeysTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		owner TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		routes TEXT,
+		expires_at DATETIME,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME NOT NULL,
+		rotated_at DATETIME
+	)`)
+	return err
+}
+
+func (s *SQLiteStorage) CreateAPIKey(key APIKey, hash string) error {
+	routes, err := json.Marshal(key.Routes)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO api_keys
+		(id, owner, prefix, key_hash, routes, expires_at, enabled, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		key.ID, key.Owner, key.Prefix, hash, string(routes), key.ExpiresAt, key.Enabled, key.CreatedAt)
+	return err
+}
+
+func (s *SQLiteStorage) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	row := s.db.QueryRow(`SELECT id, owner, prefix, routes, expires_at, enabled, created_at, rotated_at
+		FROM api_keys WHERE key_hash = ?`, hash)
+	return scanAPIKey(row)
+}
+
+func (s *SQLiteStorage) GetAPIKey(id string) (*APIKey, error) {
+	row := s.db.QueryRow(`SELECT id, owner, prefix, routes, expires_at, enabled, created_at, rotated_at
+		FROM api_keys WHERE id = ?`, id)
+	return scanAPIKey(row)
+}
+
+func (s *SQLiteStorage) ListAPIKeys() ([]APIKey, error) {
+	rows, err := s.db.Query(`SELECT id, owner, prefix, routes, expires_at, enabled, created_at, rotated_at
+		FROM api_keys ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *key)
+	}
+	return results, rows.Err()
+}
+
+func (s *SQLiteStorage) RotateAPIKey(id string, prefix string, hash string) error {
+	result, err := s.db.Exec(`UPDATE api_keys SET prefix = ?, key_hash = ?, rotated_at = ? WHERE id = ?`,
+		prefix, hash, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(result)
+}
+
+func (s *SQLiteStorage) SetAPIKeyEnabled(id string, enabled bool) error {
+	result, err := s.db.Exec(`UPDATE api_keys SET enabled = ? WHERE id = ?`, enabled, id)
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(result)
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIKey reads one api_keys row
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var (
+		key       APIKey
+		routes    sql.NullString
+		expiresAt sql.NullTime
+		rotatedAt sql.NullTime
+	)
+	err := row.Scan(&key.ID, &key.Owner, &key.Prefix, &routes, &expiresAt, &key.Enabled, &key.CreatedAt, &rotatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if routes.Valid && routes.String != "" {
+		if err := json.Unmarshal([]byte(routes.String), &key.Routes); err != nil {
+			return nil, err
+		}
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if rotatedAt.Valid {
+		key.RotatedAt = &rotatedAt.Time
+	}
+	return &key, nil
+}
+
+// requireRowAffected maps updates that matched nothing to ErrAPIKeyNotFound
+func requireRowAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+internal/storage/backends.go
+Package storage provides SQLite persistence for backend changes made through the admin API.
+*/
+
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// BackendOverride records an admin change to one backend of a route. Overrides
+// are applied on top of the configuration file whenever routes are built.
+type BackendOverride struct {
+	Route     string    `json:"route"` // Route label as shown by /admin/backends
+	URL       string    `json:"url"`
+	Group     string    `json:"group,omitempty"` // Group the backend belongs to, for added backends
+	Weight    int       `json:"weight"`
+	Disabled  bool      `json:"disabled"`
+	Removed   bool      `json:"removed"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BackendStore persists backend overrides
+type BackendStore interface {
+	ListBackendOverrides() ([]BackendOverride, error)
+	SaveBackendOverride(override BackendOverride) error
+}
+
+var _ BackendStore = (*SQLiteStorage)(nil)
+
+// createBackendOverridesTable creates the backend_overrides table if it does not exist
+func createBackendOverridesTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS backend_overrides (
+		route TEXT NOT NULL,
+		url TEXT NOT NULL,
+		backend_group TEXT,
+		weight INTEGER NOT NULL,
+		disabled INTEGER NOT NULL DEFAULT 0,
+		removed INTEGER NOT NULL DEFAULT 0,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (route, url)
+	)`)
+	return err
+}
+
+func (s *SQLiteStorage) ListBackendOverrides() ([]BackendOverride, error) {
+	rows, err := s.db.Query(`SELECT route, url, COALESCE(backend_group, ''), weight, disabled, removed, updated_at
+		FROM backend_overrides ORDER BY updated_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []BackendOverride
+	for rows.Next() {
+		var o BackendOverride
+		if err := rows.Scan(&o.Route, &o.URL, &o.Group, &o.Weight, &o.Disabled, &o.Removed, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+func (s *SQLiteStorage) SaveBackendOverride(override BackendOverride) error {
+	_, err := s.db.Exec(`INSERT INTO backend_overrides (route, url, backend_group, weight, disabled, removed, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (route, url) DO UPDATE SET
+			backend_group = excluded.backend_group,
+			weight = excluded.weight,
+			disabled = excluded.disabled,
+			removed = excluded.removed,
+			updated_at = excluded.updated_at`,
+		override.Route, override.URL, override.Group, override.Weight,
+		override.Disabled, override.Removed, override.UpdatedAt)
+	return err
+}
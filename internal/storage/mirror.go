@@ -0,0 +1,65 @@
+/*
+internal/storage/mirror.go
+Package storage provides SQLite persistence for traffic mirroring results.
+*/
+
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MirrorResult records how a shadow backend answered a mirrored request
+type MirrorResult struct {
+	Time           time.Time     `json:"time"`
+	Route          string        `json:"route"`
+	Method         string        `json:"method"`
+	Path           string        `json:"path"`
+	PrimaryStatus  int           `json:"primary_status"`
+	PrimaryLatency time.Duration `json:"primary_latency"`
+	MirrorStatus   int           `json:"mirror_status"` // 0 when the mirror request failed
+	MirrorLatency  time.Duration `json:"mirror_latency"`
+	Error          string        `json:"error,omitempty"`
+	Diff           *bool         `json:"diff,omitempty"` // nil when responses were not compared
+}
+
+// MirrorStore persists mirror results
+type MirrorStore interface {
+	SaveMirrorResult(result MirrorResult) error
+}
+
+var _ MirrorStore = (*SQLiteStorage)(nil)
+
+// createMirrorResultsTable creates the mirror_results table if it does not exist
+func createMirrorResultsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS mirror_results (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp DATETIME NOT NULL,
+		route TEXT,
+		method TEXT,
+		path TEXT,
+		primary_status INTEGER,
+		primary_latency_ms INTEGER,
+		mirror_status INTEGER,
+		mirror_latency_ms INTEGER,
+		error TEXT,
+		diff INTEGER
+	)`)
+	return err
+}
+
+func (s *SQLiteStorage) SaveMirrorResult(result MirrorResult) error {
+	var diff sql.NullBool
+	if result.Diff != nil {
+		diff = sql.NullBool{Bool: *result.Diff, Valid: true}
+	}
+	_, err := s.db.Exec(`INSERT INTO mirror_results
+		(timestamp, route, method, path, primary_status, primary_latency_ms, mirror_status, mirror_latency_ms, error, diff)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		result.Time, result.Route, result.Method, result.Path,
+		result.PrimaryStatus, result.PrimaryLatency.Milliseconds(),
+		result.MirrorStatus, result.MirrorLatency.Milliseconds(),
+		result.Error, diff)
+	return err
+}
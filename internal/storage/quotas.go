@@ -0,0 +1,122 @@
+/*
+internal/storage/quotas.go
+Package storage provides SQLite persistence for usage quota counts.
+*/
+
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// quotaPeriodLayout formats the start of a quota period in the quota_usage table
+const quotaPeriodLayout = "2006-01-02"
+
+// QuotaUsage is the number of requests a client made in one quota period
+type QuotaUsage struct {
+	Policy      string    `json:"policy"`
+	Client      string    `json:"client"`
+	PeriodStart time.Time `json:"period_start"` // Midnight UTC of the period's first day
+	Used        int64     `json:"used"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// QuotaStore persists quota usage
+type QuotaStore interface {
+	// AddQuotaUsage adds each entry's Used to the stored count and returns the
+	// new counts in the same order
+	AddQuotaUsage(deltas []QuotaUsage) ([]int64, error)
+	GetQuotaUsage(policy, client string, periodStart time.Time) (int64, error)
+	ListQuotaUsage(policy string, periodStart time.Time) ([]QuotaUsage, error)
+	// ResetQuotaUsage deletes the count of one client, or of all clients of the
+	// policy when client is empty, and returns how many counts were deleted
+	ResetQuotaUsage(policy, client string, periodStart time.Time) (int64, error)
+}
+
+var _ QuotaStore = (*SQLiteStorage)(nil)
+
+// createQuotaUsageTable creates the quota_usage table if it does not exist
+func createQuotaUsageTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS quota_usage (
+		policy TEXT NOT NULL,
+		client TEXT NOT NULL,
+		period_start TEXT NOT NULL,
+		used INTEGER NOT NULL,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY (policy, client, period_start)
+	)`)
+	return err
+}
+
+func (s *SQLiteStorage) AddQuotaUsage(deltas []QuotaUsage) ([]int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	totals := make([]int64, len(deltas))
+	for i, delta := range deltas {
+		err := tx.QueryRow(`INSERT INTO quota_usage (policy, client, period_start, used, updated_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (policy, client, period_start) DO UPDATE SET
+				used = quota_usage.used + excluded.used,
+				updated_at = excluded.updated_at
+			RETURNING used`,
+			delta.Policy, delta.Client, delta.PeriodStart.Format(quotaPeriodLayout), delta.Used, now).Scan(&totals[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+func (s *SQLiteStorage) GetQuotaUsage(policy, client string, periodStart time.Time) (int64, error) {
+	var used int64
+	err := s.db.QueryRow(`SELECT used FROM quota_usage WHERE policy = ? AND client = ? AND period_start = ?`,
+		policy, client, periodStart.Format(quotaPeriodLayout)).Scan(&used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return used, err
+}
+
+func (s *SQLiteStorage) ListQuotaUsage(policy string, periodStart time.Time) ([]QuotaUsage, error) {
+	rows, err := s.db.Query(`SELECT client, used, updated_at FROM quota_usage
+		WHERE policy = ? AND period_start = ? ORDER BY used DESC, client`,
+		policy, periodStart.Format(quotaPeriodLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []QuotaUsage
+	for rows.Next() {
+		u := QuotaUsage{Policy: policy, PeriodStart: periodStart}
+		if err := rows.Scan(&u.Client, &u.Used, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+func (s *SQLiteStorage) ResetQuotaUsage(policy, client string, periodStart time.Time) (int64, error) {
+	query := `DELETE FROM quota_usage WHERE policy = ? AND period_start = ?`
+	args := []interface{}{policy, periodStart.Format(quotaPeriodLayout)}
+	if client != "" {
+		query += ` AND client = ?`
+		args = append(args, client)
+	}
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
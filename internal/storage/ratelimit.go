@@ -0,0 +1,128 @@
+/*
+internal/storage/ratelimit.go
+Package storage provides rate limit counters shared by gateway replicas, in memory or in SQLite.
+*/
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// RateLimitStore keeps rate limit counters. Replicas using the same store see
+// each other's counts.
+type RateLimitStore interface {
+	// AddRateLimitCounters adds each delta to its counter and returns the new
+	// values of all given counters; a zero delta only reads. Counters are
+	// dropped once ttl has passed since they were last changed.
+	AddRateLimitCounters(ctx context.Context, deltas map[string]int64, ttl time.Duration) (map[string]int64, error)
+}
+
+var _ RateLimitStore = (*SQLiteStorage)(nil)
+
+// rateLimitCleanupInterval is how often expired counters are deleted from SQLite
+const rateLimitCleanupInterval = time.Minute
+
+// createRateLimitCountersTable creates the rate_limit_counters table if it does not exist
+func createRateLimitCountersTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS rate_limit_counters (
+		key TEXT PRIMARY KEY,
+		value INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	)`)
+	return err
+}
+
+// AddRateLimitCounters updates the counters in one transaction, so replicas
+// sharing the database file never lose increments
+func (s *SQLiteStorage) AddRateLimitCounters(ctx context.Context, deltas map[string]int64, ttl time.Duration) (map[string]int64, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl).UnixMilli()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	values := make(map[string]int64, len(deltas))
+	for key, delta := range deltas {
+		var value int64
+		// An expired row still in the table counts as absent
+		err := tx.QueryRowContext(ctx, `INSERT INTO rate_limit_counters (key, value, expires_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT (key) DO UPDATE SET
+				value = CASE WHEN rate_limit_counters.expires_at < ? THEN excluded.value
+					ELSE rate_limit_counters.value + excluded.value END,
+				expires_at = CASE WHEN excluded.value = 0 AND rate_limit_counters.expires_at >= ?
+					THEN rate_limit_counters.expires_at ELSE excluded.expires_at END
+			RETURNING value`,
+			key, delta, expiresAt, now.UnixMilli(), now.UnixMilli()).Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+
+	if last := s.counterCleanup.Load(); now.UnixMilli()-last >= rateLimitCleanupInterval.Milliseconds() {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM rate_limit_counters WHERE expires_at < ?`, now.UnixMilli()); err != nil {
+			return nil, err
+		}
+		s.counterCleanup.Store(now.UnixMilli())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// MemoryRateLimitStore keeps counters in the gateway process, so limits are
+// per replica. It needs no setup and is what a single gateway uses.
+type MemoryRateLimitStore struct {
+	mu       sync.Mutex
+	counters map[string]*memoryCounter
+}
+
+// memoryCounter is one counter of a MemoryRateLimitStore
+type memoryCounter struct {
+	value     int64
+	expiresAt time.Time
+}
+
+// NewMemoryRateLimitStore creates an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{counters: make(map[string]*memoryCounter)}
+}
+
+// AddRateLimitCounters updates the counters and drops expired ones
+func (m *MemoryRateLimitStore) AddRateLimitCounters(ctx context.Context, deltas map[string]int64, ttl time.Duration) (map[string]int64, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, counter := range m.counters {
+		if now.After(counter.expiresAt) {
+			delete(m.counters, key)
+		}
+	}
+
+	values := make(map[string]int64, len(deltas))
+	for key, delta := range deltas {
+		counter, ok := m.counters[key]
+		if !ok {
+			counter = &memoryCounter{}
+			m.counters[key] = counter
+		}
+		counter.value += delta
+		if delta != 0 || !ok {
+			counter.expiresAt = now.Add(ttl)
+		}
+		values[key] = counter.value
+	}
+	return values, nil
+}
@@ -0,0 +1,267 @@
+/*
+internal/storage/resp.go
+Package storage provides rate limit counters in Redis, or any server speaking its protocol (RESP).
+*/
+
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RESPConfig contains the connection settings of a Redis-protocol server
+type RESPConfig struct {
+	Address   string        // host:port
+	Password  string        // Sent with AUTH when set
+	DB        int           // Selected with SELECT when not 0
+	Timeout   time.Duration // Per batch of commands (default 1s)
+	KeyPrefix string        // Prepended to every counter key (default "gateway:ratelimit:")
+}
+
+// RESPRateLimitStore keeps counters in a Redis-protocol server. Updates are
+// pipelined over one connection, which is dialed on first use and again after errors.
+type RESPRateLimitStore struct {
+	config RESPConfig
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+var _ RateLimitStore = (*RESPRateLimitStore)(nil)
+
+// respError is an error reply from the server
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// NewRESPRateLimitStore validates the settings; the connection is made on first use
+func NewRESPRateLimitStore(config RESPConfig) (*RESPRateLimitStore, error) {
+	if _, _, err := net.SplitHostPort(config.Address); err != nil {
+		return nil, fmt.Errorf("invalid address %q, use host:port", config.Address)
+	}
+	if config.DB < 0 || config.Timeout < 0 {
+		return nil, fmt.Errorf("db and timeout must not be negative")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = "gateway:ratelimit:"
+	}
+	return &RESPRateLimitStore{config: config}, nil
+}
+
+// AddRateLimitCounters increments counters with INCRBY and refreshes their
+// expiry with PEXPIRE; counters only read use GET so no key is created
+func (r *RESPRateLimitStore) AddRateLimitCounters(ctx context.Context, deltas map[string]int64, ttl time.Duration) (map[string]int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]string, 0, len(deltas))
+	var commands [][]string
+	for key, delta := range deltas {
+		keys = append(keys, key)
+		if delta == 0 {
+			commands = append(commands, []string{"GET", r.config.KeyPrefix + key})
+			continue
+		}
+		commands = append(commands,
+			[]string{"INCRBY", r.config.KeyPrefix + key, strconv.FormatInt(delta, 10)},
+			[]string{"PEXPIRE", r.config.KeyPrefix + key, strconv.FormatInt(ttl.Milliseconds(), 10)},
+		)
+	}
+
+	replies, err := r.do(ctx, commands)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]int64, len(keys))
+	reply := 0
+	for _, key := range keys {
+		value, err := respInt(replies[reply])
+		if err != nil {
+			return nil, fmt.Errorf("counter %s: %w", key, err)
+		}
+		values[key] = value
+		reply++
+		if deltas[key] != 0 {
+			reply++ // PEXPIRE
+		}
+	}
+	return values, nil
+}
+
+// Close closes the connection
+func (r *RESPRateLimitStore) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn, r.reader = nil, nil
+	return err
+}
+
+// do sends commands as one pipeline and reads their replies. Error replies are
+// returned as replies; I/O errors drop the connection. r.mu must be held.
+func (r *RESPRateLimitStore) do(ctx context.Context, commands [][]string) ([]interface{}, error) {
+	deadline := time.Now().Add(r.config.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	if r.conn == nil {
+		if err := r.connect(ctx, deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	replies, err := r.roundTrip(deadline, commands)
+	if err != nil {
+		r.conn.Close()
+		r.conn, r.reader = nil, nil
+		return nil, err
+	}
+	return replies, nil
+}
+
+// connect dials the server, authenticates and selects the database
+func (r *RESPRateLimitStore) connect(ctx context.Context, deadline time.Time) error {
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", r.config.Address)
+	if err != nil {
+		return err
+	}
+	r.conn, r.reader = conn, bufio.NewReader(conn)
+
+	var setup [][]string
+	if r.config.Password != "" {
+		setup = append(setup, []string{"AUTH", r.config.Password})
+	}
+	if r.config.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(r.config.DB)})
+	}
+	if len(setup) == 0 {
+		return nil
+	}
+	replies, err := r.roundTrip(deadline, setup)
+	if err == nil {
+		for _, reply := range replies {
+			if replyErr, ok := reply.(respError); ok {
+				err = replyErr
+				break
+			}
+		}
+	}
+	if err != nil {
+		conn.Close()
+		r.conn, r.reader = nil, nil
+		return fmt.Errorf("connection setup: %w", err)
+	}
+	return nil
+}
+
+// roundTrip writes commands and reads one reply per command
+func (r *RESPRateLimitStore) roundTrip(deadline time.Time, commands [][]string) ([]interface{}, error) {
+	if err := r.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(r.conn)
+	for _, command := range commands {
+		fmt.Fprintf(writer, "*%d\r\n", len(command))
+		for _, arg := range command {
+			fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(commands))
+	for i := range commands {
+		reply, err := readRESP(r.reader)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// readRESP reads one reply: a string, respError, int64, nil or []interface{}
+func readRESP(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return respError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("malformed bulk length %q", body)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("malformed array length %q", body)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readRESP(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown reply type %q", kind)
+}
+
+// respInt converts an integer, bulk string or nil reply to a number
+func respInt(reply interface{}) (int64, error) {
+	switch value := reply.(type) {
+	case int64:
+		return value, nil
+	case string:
+		return strconv.ParseInt(value, 10, 64)
+	case nil:
+		return 0, nil
+	case respError:
+		return 0, value
+	}
+	return 0, errors.New("unexpected reply type")
+}
@@ -7,6 +7,9 @@ package storage
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/AndreaBozzo/go-lab/internal/collector"
@@ -14,11 +17,12 @@ import (
 )
 
 type SQLiteStorage struct {
-	db *sql.DB
+	db             *sql.DB
+	counterCleanup atomic.Int64 // Unix milliseconds of the last expired rate limit counter cleanup
 }
 
 func NewSQLiteStorage(dataSourceName string) (*SQLiteStorage, error) {
-	db, err := sql.Open("sqlite", dataSourceName)
+	db, err := sql.Open("sqlite", withPragmas(dataSourceName))
 	if err != nil {
 		return nil, err
 	}
@@ -36,24 +40,119 @@ func NewSQLiteStorage(dataSourceName string) (*SQLiteStorage, error) {
 		latency_ms INTEGER,
 		client_ip TEXT,
 		user_agent TEXT,
-		backend TEXT
+		backend TEXT,
+		trace_id TEXT,
+		span_id TEXT,
+		api_key_owner TEXT,
+		backend_group TEXT
 	)`)
 	if err != nil {
 		return nil, err
 	}
 
+	// Databases created by older versions lack the newer columns
+	if err := ensureColumns(db, "logs", map[string]string{
+		"trace_id":      "TEXT",
+		"span_id":       "TEXT",
+		"api_key_owner": "TEXT",
+		"backend_group": "TEXT",
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := createAPIKeysTable(db); err != nil {
+		return nil, err
+	}
+
+	if err := createMirrorResultsTable(db); err != nil {
+		return nil, err
+	}
+
+	if err := createBackendOverridesTable(db); err != nil {
+		return nil, err
+	}
+
+	if err := createRateLimitCountersTable(db); err != nil {
+		return nil, err
+	}
+
+	if err := createQuotaUsageTable(db); err != nil {
+		return nil, err
+	}
+
 	return &SQLiteStorage{db: db}, nil
 }
 
+// ensureColumns adds any missing columns to an existing table
+func ensureColumns(db *sql.DB, table string, columns map[string]string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for name, colType := range columns {
+		if existing[name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, colType)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", table, name, err)
+		}
+	}
+	return nil
+}
+
 var _ LogStorage = (*SQLiteStorage)(nil)
 
+// withPragmas adds a busy timeout and WAL journaling to the data source unless it
+// sets them. SQLite allows one writer at a time: with the timeout concurrent log
+// writes wait for each other instead of failing with SQLITE_BUSY, and with WAL
+// reads (API key lookups, quota loads) don't wait for writes.
+func withPragmas(dataSourceName string) string {
+	var pragmas []string
+	if !strings.Contains(dataSourceName, "busy_timeout") {
+		pragmas = append(pragmas, "_pragma=busy_timeout(5000)")
+	}
+	if !strings.Contains(dataSourceName, "journal_mode") {
+		pragmas = append(pragmas, "_pragma=journal_mode(WAL)")
+	}
+	if len(pragmas) == 0 {
+		return dataSourceName
+	}
+	separator := "?"
+	if strings.Contains(dataSourceName, "?") {
+		separator = "&"
+	}
+	return dataSourceName + separator + strings.Join(pragmas, "&")
+}
+
 func (s *SQLiteStorage) SaveLog(entry collector.LogEntry) error {
 	_, err := s.db.Exec(`INSERT INTO logs
-		(source, level, message, timestamp, method, path, status_code, latency_ms, client_ip, user_agent, backend)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		(source, level, message, timestamp, method, path, status_code, latency_ms, client_ip, user_agent, backend, trace_id, span_id, api_key_owner, backend_group)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		entry.Source, entry.Level, entry.Message, entry.Time,
 		entry.Method, entry.Path, entry.StatusCode, entry.Latency.Milliseconds(),
-		entry.ClientIP, entry.UserAgent, entry.Backend)
+		entry.ClientIP, entry.UserAgent, entry.Backend, entry.TraceID, entry.SpanID, entry.APIKeyOwner, entry.BackendGroup)
 	return err
 }
 
@@ -67,7 +166,8 @@ func (s *SQLiteStorage) Save(logs []collector.LogEntry) error {
 }
 
 func (s *SQLiteStorage) QueryLogs(limit int) ([]collector.LogEntry, error) {
-	rows, err := s.db.Query(`SELECT source, level, message, timestamp, method, path, status_code, latency_ms, client_ip, user_agent, backend
+	rows, err := s.db.Query(`SELECT source, level, message, timestamp, method, path, status_code, latency_ms, client_ip, user_agent, backend,
+		COALESCE(trace_id, ''), COALESCE(span_id, ''), COALESCE(api_key_owner, ''), COALESCE(backend_group, '')
 		FROM logs ORDER BY timestamp DESC LIMIT ?`, limit)
 	if err != nil {
 		return nil, err
@@ -80,7 +180,8 @@ func (s *SQLiteStorage) QueryLogs(limit int) ([]collector.LogEntry, error) {
 		var latencyMs int64
 		if err := rows.Scan(&entry.Source, &entry.Level, &entry.Message, &entry.Time,
 			&entry.Method, &entry.Path, &entry.StatusCode, &latencyMs,
-			&entry.ClientIP, &entry.UserAgent, &entry.Backend); err != nil {
+			&entry.ClientIP, &entry.UserAgent, &entry.Backend,
+			&entry.TraceID, &entry.SpanID, &entry.APIKeyOwner, &entry.BackendGroup); err != nil {
 			return nil, err
 		}
 		entry.Latency = time.Duration(latencyMs) * time.Millisecond
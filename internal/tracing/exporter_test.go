@@ -0,0 +1,288 @@
+/*
+internal/tracing/exporter_test.go
+Package tracing tests span export against an OTLP/HTTP collector stand-in.
+*/
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is an in-process OTLP/HTTP collector that records every request
+type collector struct {
+	mu       sync.Mutex
+	requests []otlpRequest
+	headers  []http.Header
+	status   int
+}
+
+func newCollector(t *testing.T) (*collector, *httptest.Server) {
+	c := &collector{status: http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+			return
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("collector: invalid body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		c.requests = append(c.requests, req)
+		c.headers = append(c.headers, r.Header.Clone())
+		status := c.status
+		c.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+// spans returns every span received so far
+func (c *collector) spans() []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var spans []otlpSpan
+	for _, req := range c.requests {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+// attribute finds key in attrs
+func attribute(attrs []otlpKeyValue, key string) (otlpValue, bool) {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return otlpValue{}, false
+}
+
+func TestOTLPHTTPExporterPostsSpans(t *testing.T) {
+	c, server := newCollector(t)
+	exporter := NewOTLPHTTPExporter(server.URL+"/v1/traces", map[string]string{"X-Api-Key": "secret"}, time.Second)
+	tracer := NewTracer(Config{ServiceName: "gateway-test", FlushInterval: time.Hour}, exporter)
+	defer tracer.Shutdown(context.Background())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(TracestateHeader, "vendor=1")
+	ctx, serverSpan := tracer.StartServerSpan(req, "GET /api/users")
+	serverSpan.SetAttribute("http.method", "GET")
+	serverSpan.SetAttribute("http.status_code", 502)
+	serverSpan.SetAttribute("retry", true)
+	serverSpan.SetStatus(StatusError, "bad gateway")
+	_, child := StartSpan(ctx, "proxy", SpanKindClient)
+	child.End()
+	serverSpan.End()
+
+	if err := tracer.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush() error = %v", err)
+	}
+
+	c.mu.Lock()
+	if len(c.requests) != 1 {
+		c.mu.Unlock()
+		t.Fatalf("collector received %d requests, want 1", len(c.requests))
+	}
+	headers := c.headers[0]
+	resource := c.requests[0].ResourceSpans[0].Resource
+	c.mu.Unlock()
+
+	if got := headers.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if got := headers.Get("X-Api-Key"); got != "secret" {
+		t.Errorf("X-Api-Key = %q, want secret", got)
+	}
+	if v, ok := attribute(resource.Attributes, "service.name"); !ok || v.StringValue == nil || *v.StringValue != "gateway-test" {
+		t.Errorf("service.name = %+v, want gateway-test", v)
+	}
+
+	spans := c.spans()
+	if len(spans) != 2 {
+		t.Fatalf("collector received %d spans, want 2", len(spans))
+	}
+	byName := make(map[string]otlpSpan)
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	root, proxy := byName["GET /api/users"], byName["proxy"]
+
+	if root.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || root.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("server span trace/parent = %s/%s, want the propagated ids", root.TraceID, root.ParentSpanID)
+	}
+	if root.TraceState != "vendor=1" || root.Flags != 1 || root.Kind != int(SpanKindServer) {
+		t.Errorf("server span state/flags/kind = %q/%d/%d", root.TraceState, root.Flags, root.Kind)
+	}
+	if root.Status.Code != int(StatusError) || root.Status.Message != "bad gateway" {
+		t.Errorf("server span status = %+v", root.Status)
+	}
+	if v, _ := attribute(root.Attributes, "http.status_code"); v.IntValue == nil || *v.IntValue != "502" {
+		t.Errorf("http.status_code = %+v, want intValue \"502\"", v)
+	}
+	if v, _ := attribute(root.Attributes, "retry"); v.BoolValue == nil || !*v.BoolValue {
+		t.Errorf("retry = %+v, want boolValue true", v)
+	}
+	if proxy.TraceID != root.TraceID || proxy.ParentSpanID != root.SpanID || proxy.Kind != int(SpanKindClient) {
+		t.Errorf("child span = %+v, want a client child of %s", proxy, root.SpanID)
+	}
+	if len(root.SpanID) != 16 || root.StartTimeUnixNano == "" || root.EndTimeUnixNano == "" {
+		t.Errorf("server span id/times = %q/%q/%q", root.SpanID, root.StartTimeUnixNano, root.EndTimeUnixNano)
+	}
+}
+
+func TestOTLPHTTPExporterSkipsUnsampledSpans(t *testing.T) {
+	c, server := newCollector(t)
+	tracer := NewTracer(Config{FlushInterval: time.Hour}, NewOTLPHTTPExporter(server.URL+"/v1/traces", nil, time.Second))
+	defer tracer.Shutdown(context.Background())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span := tracer.StartServerSpan(req, "GET /")
+	span.End()
+	tracer.ForceFlush(context.Background())
+
+	if spans := c.spans(); len(spans) != 0 {
+		t.Fatalf("collector received %d spans for an unsampled trace, want 0", len(spans))
+	}
+}
+
+func TestOTLPHTTPExporterStatusErrors(t *testing.T) {
+	tests := []struct {
+		status  int
+		wantErr bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusAccepted, false},
+		{http.StatusBadRequest, true},
+		{http.StatusServiceUnavailable, true},
+	}
+
+	span := SpanData{Name: "op", SpanContext: SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Flags: flagSampled}}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			c, server := newCollector(t)
+			c.status = tt.status
+			exporter := NewOTLPHTTPExporter(server.URL+"/v1/traces", nil, time.Second)
+			err := exporter.Export(context.Background(), "svc", []SpanData{span})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Export() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileExporterWritesOneDocumentPerBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	span := SpanData{Name: "op", SpanContext: SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Flags: flagSampled}}
+	for i := 0; i < 2; i++ {
+		if err := exporter.Export(context.Background(), "svc", []SpanData{span}); err != nil {
+			t.Fatalf("Export() error = %v", err)
+		}
+	}
+	if err := exporter.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("file has %d lines, want 2", len(lines))
+	}
+	for _, line := range lines {
+		var req otlpRequest
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			t.Fatalf("line is not an OTLP document: %v", err)
+		}
+		if got := req.ResourceSpans[0].ScopeSpans[0].Spans[0].TraceID; got != span.SpanContext.TraceID.String() {
+			t.Errorf("traceId = %s, want %s", got, span.SpanContext.TraceID)
+		}
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+		sampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false, false},
+		{"future version with extra field", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, true},
+		{"version 00 with extra field", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, false},
+		{"forbidden version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, false},
+		{"uppercase hex", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", true, false},
+		{"short trace id", "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", true, false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", true, false},
+		{"zero parent id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", true, false},
+		{"missing fields", "00-4bf92f3577b34da6a3ce929d0e0e4736", true, false},
+		{"empty", "", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTraceparent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if sc.IsSampled() != tt.sampled {
+				t.Errorf("IsSampled() = %v, want %v", sc.IsSampled(), tt.sampled)
+			}
+			if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+				t.Errorf("ids = %s/%s", sc.TraceID, sc.SpanID)
+			}
+		})
+	}
+}
+
+func TestInjectRoundTrip(t *testing.T) {
+	sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Flags: flagSampled, TraceState: "a=1"}
+	header := http.Header{}
+	header.Set(TracestateHeader, "stale=1")
+	Inject(header, sc)
+
+	got, ok := Extract(header)
+	if !ok {
+		t.Fatal("Extract() found no context after Inject")
+	}
+	if got.TraceID != sc.TraceID || got.SpanID != sc.SpanID || got.Flags != sc.Flags || got.TraceState != "a=1" || !got.Remote {
+		t.Fatalf("Extract() = %+v, want %+v", got, sc)
+	}
+
+	// An invalid context leaves the headers alone
+	header = http.Header{}
+	Inject(header, SpanContext{})
+	if len(header) != 0 {
+		t.Fatalf("Inject(invalid) set headers %v", header)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+internal/tracing/propagation.go
+Package tracing provides W3C Trace Context parsing and propagation.
+*/
+
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// TraceparentHeader is the W3C Trace Context header carrying trace and parent IDs
+	TraceparentHeader = "traceparent"
+	// TracestateHeader is the W3C Trace Context header carrying vendor-specific state
+	TracestateHeader = "tracestate"
+
+	flagSampled = 0x01
+)
+
+// TraceID is a 16-byte W3C trace identifier
+type TraceID [16]byte
+
+// SpanID is an 8-byte W3C span identifier
+type SpanID [8]byte
+
+// String returns the lowercase hex encoding of the trace ID
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid reports whether the trace ID is non-zero
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// String returns the lowercase hex encoding of the span ID
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid reports whether the span ID is non-zero
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext identifies a span and carries the propagated trace state
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+	Remote     bool // True when the context was extracted from an incoming request
+}
+
+// IsValid reports whether both IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// IsSampled reports whether the sampled flag is set
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&flagSampled != 0
+}
+
+// Traceparent formats the span context as a version 00 traceparent header value
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a traceparent header value
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("malformed traceparent %q", value)
+	}
+
+	version := parts[0]
+	if len(version) != 2 || !isLowerHex(version) || version == "ff" {
+		return sc, fmt.Errorf("invalid traceparent version %q", version)
+	}
+	// Version 00 has exactly four fields; future versions may append more
+	if version == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("malformed traceparent %q", value)
+	}
+
+	if len(parts[1]) != 32 || !isLowerHex(parts[1]) {
+		return sc, fmt.Errorf("invalid trace id %q", parts[1])
+	}
+	if len(parts[2]) != 16 || !isLowerHex(parts[2]) {
+		return sc, fmt.Errorf("invalid parent id %q", parts[2])
+	}
+	if len(parts[3]) != 2 || !isLowerHex(parts[3]) {
+		return sc, fmt.Errorf("invalid trace flags %q", parts[3])
+	}
+
+	hex.Decode(sc.TraceID[:], []byte(parts[1]))
+	hex.Decode(sc.SpanID[:], []byte(parts[2]))
+	var flags [1]byte
+	hex.Decode(flags[:], []byte(parts[3]))
+	sc.Flags = flags[0]
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("traceparent %q has an all-zero id", value)
+	}
+	return sc, nil
+}
+
+// Extract reads the trace context from incoming request headers
+func Extract(header http.Header) (SpanContext, bool) {
+	value := header.Get(TraceparentHeader)
+	if value == "" {
+		return SpanContext{}, false
+	}
+
+	sc, err := ParseTraceparent(value)
+	if err != nil {
+		return SpanContext{}, false
+	}
+
+	// Multiple tracestate headers are combined as a single list
+	sc.TraceState = strings.Join(header.Values(TracestateHeader), ",")
+	sc.Remote = true
+	return sc, true
+}
+
+// Inject writes the trace context into outgoing request headers
+func Inject(header http.Header, sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	header.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set(TracestateHeader, sc.TraceState)
+	} else {
+		header.Del(TracestateHeader)
+	}
+}
+
+// newTraceID generates a random trace ID
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// newSpanID generates a random span ID
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// isLowerHex reports whether s contains only lowercase hex digits
+func isLowerHex(s string) bool {
+	for _, r := range s {
+		if !(r >= '0' && r <= '9') && !(r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,350 @@
+/*
+internal/tracing/tracer.go
+Package tracing provides spans, a sampling tracer and batched span export.
+*/
+
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SpanKind describes the relationship of a span to its remote peers
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// StatusCode is the status of a finished span
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// SpanData is an immutable snapshot of a finished span, handed to exporters
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	ParentSpanID  SpanID
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]interface{}
+	StatusCode    StatusCode
+	StatusMessage string
+}
+
+// Span is an in-progress operation. A nil *Span is a valid no-op span.
+type Span struct {
+	tracer *Tracer
+	data   SpanData
+	ended  bool
+	mu     sync.Mutex
+}
+
+// SpanContext returns the span's identity (zero value for a nil span)
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttribute records a key/value attribute on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+// SetStatus sets the span status
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.StatusCode = code
+		s.data.StatusMessage = message
+	}
+}
+
+// End finishes the span and queues it for export if sampled
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.IsSampled() {
+		s.tracer.enqueue(data)
+	}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a context carrying the span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span stored in ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// StartSpan starts a child of the span stored in ctx using the same tracer.
+// It returns a nil (no-op) span when ctx carries no span.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.start(ctx, name, kind, parent.SpanContext())
+}
+
+// Config holds tracer settings
+type Config struct {
+	ServiceName   string
+	SampleRatio   float64       // Probability (0-1) of sampling root spans
+	BatchSize     int           // Spans per export batch
+	FlushInterval time.Duration // Maximum time a span waits before export
+	QueueSize     int           // Spans buffered before new ones are dropped
+}
+
+// Tracer creates spans and exports them in batches
+type Tracer struct {
+	config   Config
+	exporter Exporter
+	queue    chan SpanData
+	flush    chan chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+	dropped  int64
+	mu       sync.Mutex
+}
+
+// NewTracer creates a tracer and starts its export loop
+func NewTracer(config Config, exporter Exporter) *Tracer {
+	if config.ServiceName == "" {
+		config.ServiceName = "api-gateway"
+	}
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		config.SampleRatio = 1
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 512
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 2048
+	}
+
+	t := &Tracer{
+		config:   config,
+		exporter: exporter,
+		queue:    make(chan SpanData, config.QueueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	t.wg.Add(1)
+	go t.run()
+
+	return t
+}
+
+// ServiceName returns the service name reported with exported spans
+func (t *Tracer) ServiceName() string {
+	return t.config.ServiceName
+}
+
+// StartServerSpan starts a server span for an incoming request, continuing any
+// trace propagated in its traceparent/tracestate headers
+func (t *Tracer) StartServerSpan(req *http.Request, name string) (context.Context, *Span) {
+	parent, _ := Extract(req.Header)
+	return t.start(req.Context(), name, SpanKindServer, parent)
+}
+
+// start creates a span as a child of parent (or a new root if parent is invalid)
+func (t *Tracer) start(ctx context.Context, name string, kind SpanKind, parent SpanContext) (context.Context, *Span) {
+	sc := SpanContext{SpanID: newSpanID()}
+	var parentSpanID SpanID
+
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		sc.TraceState = parent.TraceState
+		parentSpanID = parent.SpanID
+	} else {
+		sc.TraceID = newTraceID()
+		if t.shouldSample(sc.TraceID) {
+			sc.Flags |= flagSampled
+		}
+	}
+
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			SpanContext:  sc,
+			ParentSpanID: parentSpanID,
+			StartTime:    time.Now(),
+			Attributes:   make(map[string]interface{}),
+		},
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// shouldSample decides deterministically from the trace ID, so every
+// service using the same ratio makes the same decision
+func (t *Tracer) shouldSample(traceID TraceID) bool {
+	if t.config.SampleRatio >= 1 {
+		return true
+	}
+	if t.config.SampleRatio <= 0 {
+		return false
+	}
+	bound := uint64(t.config.SampleRatio * (1 << 63))
+	return binary.BigEndian.Uint64(traceID[8:])>>1 < bound
+}
+
+// enqueue hands a finished span to the export loop without blocking the request path
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+
+	select {
+	case t.queue <- data:
+	default:
+		t.mu.Lock()
+		t.dropped++
+		if t.dropped == 1 || t.dropped%1000 == 0 {
+			log.Printf("Trace export queue full, dropped %d spans so far", t.dropped)
+		}
+		t.mu.Unlock()
+	}
+}
+
+// run batches queued spans and exports them on size or interval
+func (t *Tracer) run() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(t.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.config.BatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := t.exporter.Export(ctx, t.config.ServiceName, batch); err != nil {
+			log.Printf("Failed to export %d spans: %v", len(batch), err)
+		}
+		cancel()
+		batch = make([]SpanData, 0, t.config.BatchSize)
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= t.config.BatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case ack := <-t.flush:
+			t.drain(&batch)
+			export()
+			close(ack)
+		case <-t.done:
+			t.drain(&batch)
+			export()
+			return
+		}
+	}
+}
+
+// drain moves everything currently queued into the batch
+func (t *Tracer) drain(batch *[]SpanData) {
+	for {
+		select {
+		case data := <-t.queue:
+			*batch = append(*batch, data)
+		default:
+			return
+		}
+	}
+}
+
+// ForceFlush exports all queued spans and waits for completion
+func (t *Tracer) ForceFlush(ctx context.Context) error {
+	ack := make(chan struct{})
+	select {
+	case t.flush <- ack:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown flushes remaining spans and closes the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.stopOnce.Do(func() { close(t.done) })
+
+	finished := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Shutdown(ctx)
+}